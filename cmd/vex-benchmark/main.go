@@ -15,6 +15,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/tlsconfig"
 )
 
 var (
@@ -36,8 +38,15 @@ var (
 	totalOps    = flag.Int("n", 100000, "Total number of operations")
 	mode        = flag.String("mode", "insert", "Benchmark mode: insert or search")
 	dim         = flag.Int("dim", 128, "Vector dimension")
+	async       = flag.Bool("async", false, "Insert with VSET ASYNC, waiting for the writes with VFLUSH at the end")
+	password    = flag.String("password", "", "Password to AUTH with after connecting")
 	showVer     = flag.Bool("version", false, "Show version and exit")
 
+	tlsFlags = tlsconfig.AddFlags(flag.CommandLine)
+
+	// tlsConfig is built once from the TLS flags and shared by every worker
+	tlsConfig *tls.Config
+
 	// Version is set at build time via ldflags
 	Version = "dev"
 )
@@ -81,9 +90,17 @@ func main() {
 		return
 	}
 
+	cfg, err := tlsFlags.Config(*host)
+	if err != nil {
+		fmt.Printf("Invalid TLS configuration: %s\n", err)
+		os.Exit(1)
+	}
+	tlsConfig = cfg
+
 	fmt.Println("=== Vex Benchmark ===")
 	fmt.Printf("Mode:        %s\n", *mode)
 	fmt.Printf("Host:        %s:%s\n", *host, *port)
+	fmt.Printf("TLS:         %t\n", *tlsFlags.Enabled)
 	fmt.Printf("Concurrency: %d\n", *concurrency)
 	fmt.Printf("Total Ops:   %d\n", *totalOps)
 	fmt.Printf("Dimensions:  %d\n", *dim)
@@ -117,16 +134,13 @@ func runInsertBenchmark() *BenchmarkResult {
 			defer wg.Done()
 
 			// Create connection for this worker
-			conn, err := net.Dial("tcp", net.JoinHostPort(*host, *port))
+			conn, reader, writer, err := dial()
 			if err != nil {
 				errorCount.Add(int64(opsPerWorker))
 				return
 			}
 			defer func() { _ = conn.Close() }()
 
-			writer := protocol.NewRESPWriter(conn)
-			reader := protocol.NewRESPReader(conn)
-
 			for j := 0; j < opsPerWorker; j++ {
 				idx := workerID*opsPerWorker + j
 				key := fmt.Sprintf("vec:%d", idx)
@@ -136,6 +150,9 @@ func runInsertBenchmark() *BenchmarkResult {
 
 				// Send VSET command
 				cmd := []string{"VSET", key, formatVector(vector)}
+				if *async {
+					cmd = append(cmd, "ASYNC")
+				}
 				if err := sendCommand(writer, cmd); err != nil {
 					errorCount.Add(1)
 					continue
@@ -152,6 +169,13 @@ func runInsertBenchmark() *BenchmarkResult {
 				latencies[idx] = latency
 				successCount.Add(1)
 			}
+
+			// The total time includes applying the queued writes
+			if *async {
+				if err := sendCommand(writer, []string{"VFLUSH"}); err == nil {
+					_, _ = reader.ReadCommand()
+				}
+			}
 		}(i)
 	}
 
@@ -179,16 +203,13 @@ func runSearchBenchmark() *BenchmarkResult {
 			defer wg.Done()
 
 			// Create connection for this worker
-			conn, err := net.Dial("tcp", net.JoinHostPort(*host, *port))
+			conn, reader, writer, err := dial()
 			if err != nil {
 				errorCount.Add(int64(opsPerWorker))
 				return
 			}
 			defer func() { _ = conn.Close() }()
 
-			writer := protocol.NewRESPWriter(conn)
-			reader := protocol.NewRESPReader(conn)
-
 			for j := 0; j < opsPerWorker; j++ {
 				idx := workerID*opsPerWorker + j
 				vector := generateRandomVector(*dim)
@@ -223,16 +244,13 @@ func runSearchBenchmark() *BenchmarkResult {
 }
 
 func prepareSearchData() {
-	conn, err := net.Dial("tcp", net.JoinHostPort(*host, *port))
+	conn, reader, writer, err := dial()
 	if err != nil {
 		fmt.Printf("Failed to connect: %s\n", err)
 		return
 	}
 	defer func() { _ = conn.Close() }()
 
-	writer := protocol.NewRESPWriter(conn)
-	reader := protocol.NewRESPReader(conn)
-
 	// Insert 1000 vectors
 	for i := 0; i < 1000; i++ {
 		key := fmt.Sprintf("vec:%d", i)
@@ -248,6 +266,41 @@ func prepareSearchData() {
 	fmt.Println("Data preparation complete.")
 }
 
+// dial opens a connection to the server, using TLS when enabled, and
+// authenticates it if a password is set. tls.Dial completes the handshake
+// before returning, so its cost is paid once per worker and not attributed
+// to the first operation.
+func dial() (net.Conn, *protocol.RESPReader, *protocol.RESPWriter, error) {
+	addr := net.JoinHostPort(*host, *port)
+
+	var conn net.Conn
+	var err error
+	if tlsConfig == nil {
+		conn, err = net.Dial("tcp", addr)
+	} else {
+		conn, err = tls.Dial("tcp", addr, tlsConfig)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	reader := protocol.NewRESPReader(conn)
+	writer := protocol.NewRESPWriter(conn)
+
+	if *password != "" {
+		if err := sendCommand(writer, []string{"AUTH", *password}); err != nil {
+			_ = conn.Close()
+			return nil, nil, nil, err
+		}
+		if _, err := reader.ReadCommand(); err != nil {
+			_ = conn.Close()
+			return nil, nil, nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	return conn, reader, writer, nil
+}
+
 func sendCommand(writer *protocol.RESPWriter, cmd []string) error {
 	if err := writer.WriteArray(cmd); err != nil {
 		return err
@@ -0,0 +1,117 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// errNotConnected is returned when the server can't be reached
+var errNotConnected = errors.New("not connected")
+
+// conn is a connection to the server, dialed on first use and again after
+// it fails
+type conn struct {
+	addr string
+	nc   net.Conn
+	r    *protocol.RESPReader
+	w    *protocol.RESPWriter
+}
+
+// connect dials the server unless already connected, authenticating if a
+// password is set
+func (c *conn) connect() error {
+	if c.nc != nil {
+		return nil
+	}
+
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	var nc net.Conn
+	var err error
+	if tlsConfig == nil {
+		nc, err = d.Dial("tcp", c.addr)
+	} else {
+		nc, err = tls.DialWithDialer(d, "tcp", c.addr, tlsConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("%w to %s: %v", errNotConnected, c.addr, err)
+	}
+	c.nc = nc
+	c.r = protocol.NewRESPReader(nc)
+	c.w = protocol.NewRESPWriter(nc)
+
+	if *password != "" {
+		args := []string{"AUTH", *password}
+		if *user != "" {
+			args = []string{"AUTH", *user, *password}
+		}
+		reply, err := c.do(args)
+		if err != nil {
+			return err
+		}
+		if reply.Type == '-' {
+			c.close()
+			return fmt.Errorf("authentication failed: %s", reply.Str)
+		}
+	}
+	return nil
+}
+
+// do sends a command and reads its reply, connecting first if needed. An
+// error reply is returned as a reply; the error is only set if the
+// connection failed, in which case it is closed.
+func (c *conn) do(args []string) (protocol.Reply, error) {
+	if err := c.connect(); err != nil {
+		return protocol.Reply{}, err
+	}
+	if err := c.send(args); err != nil {
+		return protocol.Reply{}, err
+	}
+	return c.read()
+}
+
+// send writes a command without waiting for its reply
+func (c *conn) send(args []string) error {
+	_ = c.w.WriteArray(args)
+	if err := c.w.Flush(); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// read reads the next reply
+func (c *conn) read() (protocol.Reply, error) {
+	reply, err := c.r.ReadReply()
+	if err != nil {
+		c.close()
+		return protocol.Reply{}, err
+	}
+	return reply, nil
+}
+
+// close closes the connection; the next command reconnects
+func (c *conn) close() {
+	if c.nc != nil {
+		_ = c.nc.Close()
+		c.nc, c.r, c.w = nil, nil, nil
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// vectorEdge is how many components are shown at each end of a long vector
+const vectorEdge = 4
+
+// formatReply renders a reply to cmd for reading, in the style of
+// redis-cli: strings quoted, numbered array elements, and "(nil)",
+// "(integer)" and "(error)" markers. Vectors are shown compactly with their
+// dimension, VSEARCH WITHSCORES results as a key and score table, and JSON
+// such as STATS as aligned fields.
+func formatReply(cmd []string, reply protocol.Reply) string {
+	switch {
+	case isScoredSearch(cmd, reply):
+		return formatScores(reply.Array)
+	case strings.EqualFold(cmd[0], "VGET") && reply.Type == '$' && reply.Str == "":
+		// VGET replies to a missing key with an empty string
+		return "(nil)"
+	case strings.EqualFold(cmd[0], "VMGET") && reply.Type == '*':
+		return formatVectors(reply.Array)
+	}
+	return formatValue(reply, "")
+}
+
+func formatValue(r protocol.Reply, indent string) string {
+	switch {
+	case r.Type == '-':
+		return "(error) " + r.Str
+	case r.Null:
+		return "(nil)"
+	case r.Type == ':':
+		return "(integer) " + strconv.FormatInt(r.Int, 10)
+	case r.Type == '+':
+		return r.Str
+	case r.Type == '*':
+		return formatArray(r.Array, indent)
+	}
+
+	if v, ok := parseVector(r.Str); ok {
+		return formatVector(v)
+	}
+	if s, ok := formatJSON(r.Str); ok {
+		return s
+	}
+	if strings.Contains(r.Str, "\n") {
+		return strings.TrimRight(strings.ReplaceAll(r.Str, "\r\n", "\n"), "\n")
+	}
+	return strconv.Quote(r.Str)
+}
+
+// formatArray numbers the elements, indenting nested arrays under their
+// number
+func formatArray(elems []protocol.Reply, indent string) string {
+	if len(elems) == 0 {
+		return "(empty array)"
+	}
+	width := len(strconv.Itoa(len(elems)))
+	var b strings.Builder
+	for i, elem := range elems {
+		if i > 0 {
+			b.WriteString("\n" + indent)
+		}
+		label := fmt.Sprintf("%*d) ", width, i+1)
+		b.WriteString(label)
+		b.WriteString(formatValue(elem, indent+strings.Repeat(" ", len(label))))
+	}
+	return b.String()
+}
+
+// isScoredSearch reports whether reply is a VSEARCH WITHSCORES result
+func isScoredSearch(cmd []string, reply protocol.Reply) bool {
+	if len(cmd) < 4 || !strings.EqualFold(cmd[0], "VSEARCH") || reply.Type != '*' || len(reply.Array)%2 != 0 {
+		return false
+	}
+	for _, arg := range cmd[3:] {
+		if strings.EqualFold(arg, "WITHSCORES") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatScores renders key and score pairs as a table
+func formatScores(pairs []protocol.Reply) string {
+	if len(pairs) == 0 {
+		return "(empty array)"
+	}
+	n := len(pairs) / 2
+	width, keyWidth := len(strconv.Itoa(n)), 0
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Quote(pairs[2*i].Str)
+		keyWidth = max(keyWidth, len(keys[i]))
+	}
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%*d) %-*s  %s", width, i+1, keyWidth, key, pairs[2*i+1].Str)
+	}
+	return b.String()
+}
+
+// formatVectors renders a VMGET reply, in which each vector is an array of
+// its components
+func formatVectors(elems []protocol.Reply) string {
+	lines := make([]string, len(elems))
+	width := len(strconv.Itoa(len(elems)))
+	for i, elem := range elems {
+		text := formatValue(elem, "")
+		if elem.Type == '*' && !elem.Null {
+			v := make([]float32, 0, len(elem.Array))
+			for _, x := range elem.Array {
+				f, err := strconv.ParseFloat(x.Str, 32)
+				if err != nil {
+					break
+				}
+				v = append(v, float32(f))
+			}
+			if len(v) == len(elem.Array) {
+				text = formatVector(v)
+			}
+		}
+		lines[i] = fmt.Sprintf("%*d) %s", width, i+1, text)
+	}
+	if len(lines) == 0 {
+		return "(empty array)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseVector parses a bulk string holding a vector, as VGET replies
+func parseVector(s string) ([]float32, bool) {
+	if len(s) < 3 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, false
+	}
+	v, err := protocol.ParseVector([]byte(s))
+	return v, err == nil
+}
+
+// formatVector shows a vector's components, eliding the middle of long
+// ones, followed by its dimension
+func formatVector(v []float32) string {
+	parts := make([]string, 0, 2*vectorEdge+1)
+	for i, x := range v {
+		if len(v) > 2*vectorEdge+1 && i == vectorEdge {
+			parts = append(parts, "…")
+		}
+		if len(v) > 2*vectorEdge+1 && i >= vectorEdge && i < len(v)-vectorEdge {
+			continue
+		}
+		parts = append(parts, strconv.FormatFloat(float64(x), 'g', 6, 32))
+	}
+	return fmt.Sprintf("[%s]  (dim %d)", strings.Join(parts, ", "), len(v))
+}
+
+// formatJSON renders a JSON object as one aligned field per line, keeping
+// the server's field order
+func formatJSON(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '{' || !json.Valid([]byte(s)) {
+		return "", false
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return "", false
+	}
+
+	var names, values []string
+	width := 0
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		name, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return "", false
+		}
+		value, err := formatJSONValue(raw)
+		if err != nil {
+			return "", false
+		}
+		names = append(names, name)
+		values = append(values, value)
+		width = max(width, len(name))
+	}
+	if len(names) == 0 {
+		return "{}", true
+	}
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%-*s  %s", width+1, name+":", values[i])
+	}
+	return b.String(), true
+}
+
+// formatJSONValue shows a string unquoted and anything else as compact JSON
+func formatJSONValue(raw json.RawMessage) (string, error) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, nil
+	}
+	var value bytes.Buffer
+	if err := json.Compact(&value, raw); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+func bulk(s string) protocol.Reply {
+	return protocol.Reply{Type: '$', Str: s}
+}
+
+func array(elems ...protocol.Reply) protocol.Reply {
+	return protocol.Reply{Type: '*', Array: elems}
+}
+
+func TestFormatReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   []string
+		reply protocol.Reply
+		want  string
+	}{
+		{"simple string", []string{"PING"}, protocol.Reply{Type: '+', Str: "PONG"}, "PONG"},
+		{"error", []string{"VGET"}, protocol.Reply{Type: '-', Str: "ERR wrong number of arguments"}, "(error) ERR wrong number of arguments"},
+		{"integer", []string{"DBSIZE"}, protocol.Reply{Type: ':', Int: 42}, "(integer) 42"},
+		{"null", []string{"TTL", "k"}, protocol.Reply{Type: '$', Null: true}, "(nil)"},
+		{"bulk string", []string{"ECHO", "hi"}, bulk("hi"), `"hi"`},
+		{"multi-line string", []string{"INFO"}, bulk("# Server\r\nrole:leader\r\n"), "# Server\nrole:leader"},
+		{"missing vector", []string{"vget", "k"}, bulk(""), "(nil)"},
+		{"vector", []string{"VGET", "k"}, bulk("[0.6,0.8]"), "[0.6, 0.8]  (dim 2)"},
+		{"long vector", []string{"VGET", "k"}, bulk("[1,2,3,4,5,6,7,8,9,10]"), "[1, 2, 3, 4, …, 7, 8, 9, 10]  (dim 10)"},
+		{"vector of 9", []string{"VGET", "k"}, bulk("[1,2,3,4,5,6,7,8,9]"), "[1, 2, 3, 4, 5, 6, 7, 8, 9]  (dim 9)"},
+		{"not a vector", []string{"ECHO", "[x]"}, bulk("[x]"), `"[x]"`},
+		{"empty array", []string{"SCAN", "0"}, array(), "(empty array)"},
+		{
+			"nested array", []string{"SCAN", "0"},
+			array(bulk("0"), array(bulk("a"), bulk("b"))),
+			"1) \"0\"\n2) 1) \"a\"\n   2) \"b\"",
+		},
+		{
+			"scored search", []string{"VSEARCH", "[1,0]", "2", "withscores"},
+			array(bulk("a"), bulk("0.9"), bulk("longer"), bulk("0.5")),
+			"1) \"a\"       0.9\n2) \"longer\"  0.5",
+		},
+		{"scored search without results", []string{"VSEARCH", "[1,0]", "2", "WITHSCORES"}, array(), "(empty array)"},
+		{
+			"search without scores", []string{"VSEARCH", "[1,0]", "2"},
+			array(bulk("a"), bulk("b")),
+			"1) \"a\"\n2) \"b\"",
+		},
+		{
+			"vmget", []string{"VMGET", "a", "b", "c"},
+			array(array(bulk("1"), bulk("0")), protocol.Reply{Type: '*', Null: true}, array(bulk("x"))),
+			"1) [1, 0]  (dim 2)\n2) (nil)\n3) 1) \"x\"",
+		},
+		{
+			"json", []string{"STATS"},
+			bulk(`{"total_keys":3,"role":"leader","shards":[1,2]}`),
+			"total_keys:  3\nrole:        leader\nshards:      [1,2]",
+		},
+		{"empty json", []string{"STATS"}, bulk("{}"), "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatReply(tt.cmd, tt.reply); got != tt.want {
+				t.Errorf("formatReply() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
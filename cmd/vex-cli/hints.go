@@ -0,0 +1,142 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// commandHelp describes a command for hints and the help command
+type commandHelp struct {
+	name    string
+	args    string
+	summary string
+	group   string
+}
+
+// commands lists the server's commands, by group
+var commands = []commandHelp{
+	{"VSET", "key vector [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds] [NX|XX] [IFVERSION version] [WITHVERSION] [ASYNC]", "Store a vector", "vector"},
+	{"VGET", "key [WITHVERSION]", "Get a vector", "vector"},
+	{"VDEL", "key", "Delete a vector", "vector"},
+	{"VMSET", "key vector [key vector ...]", "Store several vectors at once", "vector"},
+	{"VMGET", "key [key ...]", "Get several vectors", "vector"},
+	{"VSEARCH", "vector k [WITHSCORES]", "Find the k most similar vectors", "vector"},
+	{"VASYNC", "ON|OFF", "Make this connection's writes asynchronous", "vector"},
+	{"VFLUSH", "", "Wait for queued asynchronous writes", "vector"},
+
+	{"EXPIRE", "key seconds", "Set a key's time to live", "keys"},
+	{"PEXPIRE", "key milliseconds", "Set a key's time to live in milliseconds", "keys"},
+	{"EXPIREAT", "key unix-time-seconds", "Set the time a key expires at", "keys"},
+	{"PEXPIREAT", "key unix-time-milliseconds", "Set the time a key expires at in milliseconds", "keys"},
+	{"TTL", "key", "Get a key's time to live", "keys"},
+	{"PTTL", "key", "Get a key's time to live in milliseconds", "keys"},
+	{"PERSIST", "key", "Remove a key's time to live", "keys"},
+	{"SCAN", "cursor [MATCH pattern] [COUNT count]", "Iterate over the keys", "keys"},
+	{"EXISTS", "key [key ...]", "Count the keys that exist", "keys"},
+	{"DBSIZE", "", "Count the keys", "keys"},
+	{"RENAME", "key newkey", "Rename a key", "keys"},
+	{"COPY", "source destination [REPLACE]", "Copy a key", "keys"},
+	{"TYPE", "key", "Get a key's type", "keys"},
+	{"RANDOMKEY", "", "Get a random key", "keys"},
+	{"CLEAR", "", "Delete every key", "keys"},
+
+	{"MULTI", "", "Start a transaction", "transactions"},
+	{"EXEC", "", "Run the queued commands", "transactions"},
+	{"DISCARD", "", "Abandon the transaction", "transactions"},
+	{"WATCH", "key [key ...]", "Abort EXEC if the keys change", "transactions"},
+	{"UNWATCH", "", "Forget the watched keys", "transactions"},
+
+	{"SUBSCRIBE", "channel [channel ...]", "Listen for messages", "pubsub"},
+	{"PSUBSCRIBE", "pattern [pattern ...]", "Listen for messages on matching channels", "pubsub"},
+	{"UNSUBSCRIBE", "[channel ...]", "Stop listening", "pubsub"},
+	{"PUNSUBSCRIBE", "[pattern ...]", "Stop listening to patterns", "pubsub"},
+	{"PUBLISH", "channel message", "Send a message", "pubsub"},
+
+	{"CLREAD", "[COUNT n] [BLOCK ms] seq|$", "Read the change log", "changelog"},
+	{"CLGROUP", "CREATE group [seq|$] | DESTROY group", "Manage consumer groups", "changelog"},
+	{"CLREADGROUP", "group [COUNT n] [BLOCK ms] [>|0]", "Read the change log as a group", "changelog"},
+	{"CLACK", "group seq", "Acknowledge changes", "changelog"},
+	{"CLINFO", "", "Describe the change log", "changelog"},
+
+	{"PING", "[message]", "Check the connection", "server"},
+	{"ECHO", "message", "Echo a message", "server"},
+	{"AUTH", "[username] password", "Authenticate", "server"},
+	{"HELLO", "[protover [AUTH username password]]", "Handshake", "server"},
+	{"ACL", "SETUSER name [rule ...] | DELUSER name [name ...] | LIST | WHOAMI | LOAD", "Manage users", "server"},
+	{"STATS", "", "Server statistics", "server"},
+	{"INFO", "[section]", "Server information", "server"},
+	{"ROLE", "", "Replication role", "server"},
+	{"REPLICAOF", "host port | NO ONE", "Follow a leader", "server"},
+	{"RAFT", "STATUS", "Raft group status", "server"},
+	{"CLUSTER", "INFO | MYID | SLOTS | NODES | KEYSLOT key | MEET host port | ADDSLOTS slot [slot ...] | SETSLOT slot ...", "Manage the cluster", "server"},
+	{"MIGRATE", "host port key|\"\" timeout-ms [COPY] [REPLACE] [KEYS key [key ...]]", "Move keys to another node", "server"},
+	{"QUIT", "", "Close the connection", "server"},
+}
+
+// lookupCommand returns the help for a command name, ignoring case
+func lookupCommand(name string) (commandHelp, bool) {
+	for _, c := range commands {
+		if strings.EqualFold(c.name, name) {
+			return c, true
+		}
+	}
+	return commandHelp{}, false
+}
+
+// hint returns the syntax still to type after line, shown after the cursor
+// while editing. Each argument typed covers one required parameter; the
+// optional ones stay in the hint. Nothing is shown while a word is being
+// typed, except for the command name itself.
+func hint(line string) string {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+	c, ok := lookupCommand(args[0])
+	if !ok || c.args == "" {
+		return ""
+	}
+	if !strings.HasSuffix(line, " ") {
+		if len(args) == 1 {
+			return " " + c.args
+		}
+		return ""
+	}
+
+	parts := strings.Fields(c.args)
+	for typed := len(args) - 1; typed > 0 && len(parts) > 0 && !strings.HasPrefix(parts[0], "["); typed-- {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, " ")
+}
+
+// complete returns the completions of line's command name, or nothing once
+// the name is complete
+func complete(line string) []string {
+	if strings.ContainsAny(line, " \t") {
+		return nil
+	}
+	upper := strings.ToUpper(line)
+	var matches []string
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, upper) {
+			matches = append(matches, c.name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupCommand(t *testing.T) {
+	if c, ok := lookupCommand("vSeT"); !ok || c.name != "VSET" {
+		t.Errorf("lookupCommand(vSeT) = %+v, %v, want VSET", c, ok)
+	}
+	if _, ok := lookupCommand("NOPE"); ok {
+		t.Error("lookupCommand(NOPE) found a command")
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range commands {
+		if c.name != strings.ToUpper(c.name) || seen[c.name] {
+			t.Errorf("command %q is not upper case or is listed twice", c.name)
+		}
+		seen[c.name] = true
+	}
+}
+
+func TestHint(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"", ""},
+		{"COPY", " source destination [REPLACE]"},
+		{"copy ", "source destination [REPLACE]"},
+		{"COPY a ", "destination [REPLACE]"},
+		{"COPY a b ", "[REPLACE]"},
+		{"COPY a b REPLACE ", "[REPLACE]"},
+		{"COPY a", ""},
+		{"VSET k [0.1, 0.2] ", "[EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds] [NX|XX] [IFVERSION version] [WITHVERSION] [ASYNC]"},
+		{"DBSIZE ", ""},
+		{"NOPE ", ""},
+		{`ECHO "unbalanced `, ""},
+	}
+	for _, tt := range tests {
+		if got := hint(tt.line); got != tt.want {
+			t.Errorf("hint(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"vs", []string{"VSEARCH", "VSET"}},
+		{"CL", []string{"CLACK", "CLEAR", "CLGROUP", "CLINFO", "CLREAD", "CLREADGROUP", "CLUSTER"}},
+		{"VSET", []string{"VSET"}},
+		{"VSET ", nil},
+		{"zz", nil},
+	}
+	for _, tt := range tests {
+		if got := complete(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history file
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when Ctrl-C abandons the line
+var errInterrupted = errors.New("interrupted")
+
+// editor reads lines from a terminal with Emacs-style editing keys,
+// history and hints
+type editor struct {
+	fd          int
+	in          *bufio.Reader
+	out         *bufio.Writer
+	history     []string
+	historyFile string
+
+	// State of the line being edited
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+func newEditor(fd int, historyFile string) *editor {
+	e := &editor{
+		fd:          fd,
+		in:          bufio.NewReader(os.Stdin),
+		out:         bufio.NewWriter(os.Stdout),
+		historyFile: historyFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+// readLine shows prompt and returns the line typed. It returns io.EOF for
+// Ctrl-D on an empty line and errInterrupted for Ctrl-C.
+func (e *editor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e.prompt, e.buf, e.pos = prompt, e.buf[:0], 0
+	histIdx, saved := len(e.history), ""
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			line := string(e.buf)
+			e.buf = e.buf[:0]
+			e.pos = 0
+			e.refreshWith(line, len([]rune(line)), "")
+			e.write("\r\n")
+			return line, nil
+		case 3: // Ctrl-C
+			e.write("^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case 127, 8: // Backspace, Ctrl-H
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case 1: // Ctrl-A
+			e.pos = 0
+		case 5: // Ctrl-E
+			e.pos = len(e.buf)
+		case 2: // Ctrl-B
+			e.pos = max(e.pos-1, 0)
+		case 6: // Ctrl-F
+			e.pos = min(e.pos+1, len(e.buf))
+		case 11: // Ctrl-K
+			e.buf = e.buf[:e.pos]
+		case 21: // Ctrl-U
+			e.buf = append(e.buf[:0], e.buf[e.pos:]...)
+			e.pos = 0
+		case 23: // Ctrl-W
+			start := e.pos
+			for start > 0 && e.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.buf[start-1] != ' ' {
+				start--
+			}
+			e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+			e.pos = start
+		case 12: // Ctrl-L
+			e.write("\x1b[H\x1b[2J")
+		case 9: // Tab
+			e.complete()
+		case 16, 14: // Ctrl-P, Ctrl-N
+			histIdx, saved = e.browse(r == 16, histIdx, saved)
+		case 27: // Escape sequence
+			switch e.readEscape() {
+			case 'A':
+				histIdx, saved = e.browse(true, histIdx, saved)
+			case 'B':
+				histIdx, saved = e.browse(false, histIdx, saved)
+			case 'C':
+				e.pos = min(e.pos+1, len(e.buf))
+			case 'D':
+				e.pos = max(e.pos-1, 0)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.buf)
+			case '~':
+				e.deleteAt(e.pos)
+			}
+		default:
+			if r >= 32 {
+				e.buf = append(e.buf, 0)
+				copy(e.buf[e.pos+1:], e.buf[e.pos:])
+				e.buf[e.pos] = r
+				e.pos++
+			}
+		}
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence, returning the arrow
+// letter for the cursor keys, H or F for Home and End, ~ for Delete, and 0
+// for anything else
+func (e *editor) readEscape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	var param []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c >= '0' && c <= '9' || c == ';' {
+			param = append(param, c)
+			continue
+		}
+		if c != '~' {
+			return c
+		}
+		switch string(param) {
+		case "1", "7":
+			return 'H'
+		case "4", "8":
+			return 'F'
+		case "3":
+			return '~'
+		}
+		return 0
+	}
+}
+
+// deleteAt removes the character at i, if any
+func (e *editor) deleteAt(i int) {
+	if i < len(e.buf) {
+		e.buf = append(e.buf[:i], e.buf[i+1:]...)
+	}
+}
+
+// browse moves through the history, keeping the line being typed to come
+// back to
+func (e *editor) browse(back bool, idx int, saved string) (int, string) {
+	switch {
+	case back && idx > 0:
+		if idx == len(e.history) {
+			saved = string(e.buf)
+		}
+		idx--
+		e.buf = []rune(e.history[idx])
+	case !back && idx < len(e.history):
+		idx++
+		if idx == len(e.history) {
+			e.buf = []rune(saved)
+		} else {
+			e.buf = []rune(e.history[idx])
+		}
+	}
+	e.pos = len(e.buf)
+	return idx, saved
+}
+
+// complete completes the command name at the start of the line, listing
+// the candidates when there is more than one
+func (e *editor) complete() {
+	if e.pos != len(e.buf) {
+		return
+	}
+	matches := complete(string(e.buf))
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		e.buf = []rune(matches[0] + " ")
+	default:
+		prefix := matches[0]
+		for _, m := range matches[1:] {
+			for !strings.HasPrefix(m, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		if len(prefix) > len(e.buf) {
+			e.buf = []rune(prefix)
+		} else {
+			e.write("\r\n" + strings.Join(matches, "  ") + "\r\n")
+		}
+	}
+	e.pos = len(e.buf)
+}
+
+// refresh redraws the line being edited with its hint
+func (e *editor) refresh() {
+	line := string(e.buf)
+	e.refreshWith(line, e.pos, hint(line))
+}
+
+// refreshWith redraws the prompt and line with the cursor at pos. A line
+// wider than the terminal scrolls horizontally to keep the cursor in view,
+// and the hint is shown dimmed in whatever room is left after the line.
+func (e *editor) refreshWith(line string, pos int, hintText string) {
+	runes := []rune(line)
+	room := termWidth(e.fd) - len(e.prompt) - 1
+	if room < 1 {
+		room = 1
+	}
+	start := 0
+	if pos >= room {
+		start = pos - room + 1
+	}
+	end := min(len(runes), start+room)
+
+	var b strings.Builder
+	b.WriteString("\r")
+	b.WriteString(e.prompt)
+	b.WriteString(string(runes[start:end]))
+	if left := room - (end - start); end == len(runes) && hintText != "" && left > 0 {
+		if h := []rune(hintText); len(h) > left {
+			hintText = string(h[:left])
+		}
+		b.WriteString("\x1b[90m" + hintText + "\x1b[0m")
+	}
+	b.WriteString("\x1b[0K\r")
+	if col := len(e.prompt) + pos - start; col > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", col)
+	}
+	e.write(b.String())
+}
+
+func (e *editor) write(s string) {
+	_, _ = e.out.WriteString(s)
+	_ = e.out.Flush()
+}
+
+// addHistory records a line, skipping repeats and lines with passwords
+func (e *editor) addHistory(line string) {
+	if line == "" || hasSecret(line) {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// hasSecret reports whether a line may hold a password, so it is kept out
+// of the history
+func hasSecret(line string) bool {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return true
+	}
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "HELLO":
+		return true
+	case "ACL":
+		return len(args) > 1 && strings.EqualFold(args[1], "SETUSER")
+	}
+	return false
+}
+
+// loadHistory reads the history file, if there is one
+func (e *editor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		e.addHistory(line)
+	}
+}
+
+// saveHistory writes the history file
+func (e *editor) saveHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data := strings.Join(e.history, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	if err := os.WriteFile(e.historyFile, []byte(data), 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save history: %v\n", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/tlsconfig"
+)
+
+var (
+	host        = flag.String("host", "localhost", "Server host")
+	port        = flag.String("port", "6379", "Server port")
+	user        = flag.String("user", "", "Username to AUTH with (requires -password)")
+	password    = flag.String("password", "", "Password to AUTH with after connecting")
+	pipe        = flag.Bool("pipe", false, "Send the commands read from stdin without waiting for replies, then report errors")
+	stat        = flag.Bool("stat", false, "Poll STATS and print a row per interval with the rolling QPS")
+	interval    = flag.Duration("interval", time.Second, "Polling interval for -stat")
+	historyFile = flag.String("history", defaultHistoryFile(), "History file for the REPL (empty to disable)")
+	showVer     = flag.Bool("version", false, "Show version and exit")
+
+	tlsFlags = tlsconfig.AddFlags(flag.CommandLine)
+
+	// tlsConfig is built once from the TLS flags
+	tlsConfig *tls.Config
+
+	// Version is set at build time via ldflags
+	Version = "dev"
+)
+
+func main() {
+	// Customize usage output
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: vex-cli [options] [command [arg ...]]\n\n")
+		fmt.Fprintf(os.Stderr, "Vex CLI is an interactive client for the Vex vector database. Without a\n")
+		fmt.Fprintf(os.Stderr, "command it starts a REPL; with one it prints the reply and exits.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	// Handle version detection for 'go install'
+	if Version == "dev" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			if info.Main.Version != "" && info.Main.Version != "(devel)" {
+				Version = info.Main.Version
+			}
+		}
+	}
+
+	if *showVer {
+		fmt.Printf("Vex CLI version %s\n", Version)
+		return
+	}
+
+	if *user != "" && *password == "" {
+		fmt.Fprintln(os.Stderr, "-user requires -password")
+		os.Exit(2)
+	}
+	cfg, err := tlsFlags.Config(*host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid TLS configuration: %s\n", err)
+		os.Exit(1)
+	}
+	tlsConfig = cfg
+
+	c := &conn{addr: net.JoinHostPort(*host, *port)}
+
+	switch {
+	case *pipe:
+		os.Exit(runPipe(c, os.Stdin))
+	case *stat:
+		os.Exit(runStat(c, *interval))
+	case flag.NArg() > 0:
+		os.Exit(runOnce(c, flag.Args()))
+	default:
+		os.Exit(runREPL(c))
+	}
+}
+
+// runOnce runs a single command given on the command line
+func runOnce(c *conn, args []string) int {
+	reply, err := c.do(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Println(formatReply(args, reply))
+	if reply.Type == '-' {
+		return 1
+	}
+	return 0
+}
+
+// defaultHistoryFile returns ~/.vexcli_history, or nothing without a home
+// directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ""
+	}
+	return filepath.Join(home, ".vexcli_history")
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// splitArgs splits a command line into arguments. Arguments are separated
+// by spaces; double quotes allow spaces and escapes such as \n, \" and \xff,
+// single quotes take everything literally, and a vector in square brackets
+// is one argument even with spaces inside, so
+//
+//	VSET doc:1 [0.1, 0.2, 0.3] EX 60
+//
+// has five arguments.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		for i < len(line) && !isSpace(line[i]) {
+			switch line[i] {
+			case '"':
+				end, err := readDoubleQuoted(line, i+1, &arg)
+				if err != nil {
+					return nil, err
+				}
+				i = end
+			case '\'':
+				end := strings.IndexByte(line[i+1:], '\'')
+				if end < 0 {
+					return nil, errors.New("unbalanced quotes")
+				}
+				arg.WriteString(line[i+1 : i+1+end])
+				i += end + 2
+			case '[':
+				end := strings.IndexByte(line[i:], ']')
+				if end < 0 {
+					return nil, errors.New("unbalanced brackets")
+				}
+				arg.WriteString(line[i : i+end+1])
+				i += end + 1
+			default:
+				arg.WriteByte(line[i])
+				i++
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+// readDoubleQuoted unescapes a double-quoted string starting at i, after
+// the opening quote, and returns the position after the closing one
+func readDoubleQuoted(line string, i int, arg *strings.Builder) (int, error) {
+	for i < len(line) {
+		ch := line[i]
+		switch {
+		case ch == '"':
+			return i + 1, nil
+		case ch == '\\' && i+1 < len(line):
+			i++
+			switch e := line[i]; e {
+			case 'n':
+				arg.WriteByte('\n')
+			case 'r':
+				arg.WriteByte('\r')
+			case 't':
+				arg.WriteByte('\t')
+			case 'x':
+				if i+2 < len(line) {
+					if b, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+						arg.WriteByte(byte(b))
+						i += 2
+						break
+					}
+				}
+				arg.WriteByte(e)
+			default:
+				arg.WriteByte(e)
+			}
+		default:
+			arg.WriteByte(ch)
+		}
+		i++
+	}
+	return 0, errors.New("unbalanced quotes")
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", line: "", want: nil},
+		{name: "only spaces", line: " \t ", want: nil},
+		{name: "plain", line: "VGET doc:1", want: []string{"VGET", "doc:1"}},
+		{name: "repeated spaces", line: "  VGET \t doc:1  ", want: []string{"VGET", "doc:1"}},
+		{name: "vector with spaces", line: "VSET doc:1 [0.1, 0.2, 0.3] EX 60", want: []string{"VSET", "doc:1", "[0.1, 0.2, 0.3]", "EX", "60"}},
+		{name: "double quoted", line: `SET "a b" c`, want: []string{"SET", "a b", "c"}},
+		{name: "empty quoted", line: `MIGRATE h 1 "" 10`, want: []string{"MIGRATE", "h", "1", "", "10"}},
+		{name: "escapes", line: `ECHO "a\nb\tc\r\"d\\"`, want: []string{"ECHO", "a\nb\tc\r\"d\\"}},
+		{name: "hex escape", line: `ECHO "\x41\xff"`, want: []string{"ECHO", "A\xff"}},
+		{name: "invalid hex escape", line: `ECHO "\xzz"`, want: []string{"ECHO", "xzz"}},
+		{name: "short hex escape", line: `ECHO "\x4"`, want: []string{"ECHO", "x4"}},
+		{name: "unknown escape", line: `ECHO "\q"`, want: []string{"ECHO", "q"}},
+		{name: "single quoted", line: `ECHO 'a "b" \n'`, want: []string{"ECHO", `a "b" \n`}},
+		{name: "quotes inside a word", line: `ECHO ab"c d"'e f'`, want: []string{"ECHO", "abc de f"}},
+		{name: "unbalanced double quote", line: `ECHO "abc`, wantErr: true},
+		{name: "escaped closing quote", line: `ECHO "abc\"`, wantErr: true},
+		{name: "unbalanced single quote", line: `ECHO 'abc`, wantErr: true},
+		{name: "unbalanced bracket", line: "VSEARCH [0.1, 0.2 3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitArgs(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitArgs(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDoubleQuoted(t *testing.T) {
+	var arg strings.Builder
+	line := `ECHO "a b"c`
+	end, err := readDoubleQuoted(line, len(`ECHO "`), &arg)
+	if err != nil || end != len(`ECHO "a b"`) || arg.String() != "a b" {
+		t.Errorf("readDoubleQuoted() = %d, %q, %v, want the position after the closing quote", end, arg.String(), err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// pipeWindow bounds the commands sent ahead of their replies
+const pipeWindow = 10000
+
+// pipeResult is what the reply reader of runPipe saw
+type pipeResult struct {
+	replies, errors int
+	err             error
+}
+
+// runPipe sends every command read from in without waiting for replies,
+// reading them concurrently, and reports the error replies with the line
+// they answer. Input starting with '*' is taken as RESP, as produced for
+// redis-cli --pipe; anything else as one command per line in the REPL's
+// syntax, skipping blank lines and lines starting with #.
+func runPipe(c *conn, in io.Reader) int {
+	if err := c.connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect: %v\n", err)
+		return 1
+	}
+	start := time.Now()
+
+	// The reader learns each command's line number in the order sent
+	lines := make(chan int, pipeWindow)
+	results := make(chan pipeResult, 1)
+	go func() {
+		var res pipeResult
+		for line := range lines {
+			if res.err != nil {
+				continue // Drain so the writer never blocks
+			}
+			reply, err := c.r.ReadReply()
+			if err != nil {
+				res.err = err
+				continue
+			}
+			res.replies++
+			if reply.Type == '-' {
+				res.errors++
+				fmt.Fprintf(os.Stderr, "line %d: %s\n", line, reply.Str)
+			}
+		}
+		results <- res
+	}()
+
+	br := bufio.NewReaderSize(in, 64*1024)
+	var next func() ([]string, int, error)
+	if b, err := br.Peek(1); err == nil && b[0] == '*' {
+		next = respCommands(br)
+	} else {
+		next = lineCommands(br)
+	}
+
+	sent, invalid := 0, 0
+	var sendErr error
+send:
+	for {
+		// Send what is buffered before waiting for more input
+		if br.Buffered() == 0 {
+			if sendErr = c.w.Flush(); sendErr != nil {
+				break
+			}
+		}
+		args, line, err := next()
+		switch {
+		case err == io.EOF:
+			break send
+		case err != nil && line == 0:
+			// Malformed RESP can't be resynchronized
+			invalid++
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			break send
+		case err != nil:
+			invalid++
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			continue
+		}
+
+		_ = c.w.WriteArray(args)
+		sent++
+		select {
+		case lines <- line:
+		default:
+			// The window is full: make sure the reader has replies coming
+			if sendErr = c.w.Flush(); sendErr != nil {
+				break send
+			}
+			lines <- line
+		}
+	}
+	if sendErr == nil {
+		sendErr = c.w.Flush()
+	}
+	close(lines)
+	res := <-results
+
+	elapsed := time.Since(start)
+	fmt.Printf("Sent %d commands in %v (%.0f/s); replies: %d, errors: %d\n",
+		sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds(), res.replies, res.errors+invalid)
+	for _, err := range []error{sendErr, res.err} {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Connection lost: %v\n", err)
+			return 1
+		}
+	}
+	if res.errors+invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lineCommands returns a function reading the next command line from r
+func lineCommands(r *bufio.Reader) func() ([]string, int, error) {
+	line := 0
+	return func() ([]string, int, error) {
+		for {
+			text, err := r.ReadString('\n')
+			if err == io.EOF && text == "" {
+				return nil, line, io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return nil, line, err
+			}
+			line++
+			text = strings.TrimSpace(text)
+			if text == "" || text[0] == '#' {
+				continue
+			}
+			args, err := splitArgs(text)
+			if err != nil {
+				return nil, line, fmt.Errorf("invalid argument(s): %w", err)
+			}
+			return args, line, nil
+		}
+	}
+}
+
+// respCommands returns a function reading the next RESP command from r,
+// numbering commands instead of lines. A malformed command is reported as
+// line 0.
+func respCommands(r *bufio.Reader) func() ([]string, int, error) {
+	reader := protocol.NewRESPReader(r)
+	n := 0
+	return func() ([]string, int, error) {
+		args, err := reader.ReadCommand()
+		if err == io.EOF {
+			return nil, n, io.EOF
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("command %d: %w", n+1, err)
+		}
+		n++
+		return args, n, nil
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// session is the REPL's state beyond the connection
+type session struct {
+	c    *conn
+	inTx bool // Inside MULTI, shown in the prompt
+}
+
+// runREPL reads commands from the terminal until EOF, QUIT or EXIT. Input
+// that isn't a terminal is run line by line without editing.
+func runREPL(c *conn) int {
+	s := &session{c: c}
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		return s.runLines(os.Stdin)
+	}
+
+	if err := c.connect(); err != nil {
+		fmt.Printf("Could not connect: %v\n", err)
+	}
+
+	ed := newEditor(fd, *historyFile)
+	defer ed.saveHistory()
+
+	// Ctrl-C while a command runs closes the connection to abandon it;
+	// while editing, the terminal is raw and the editor sees it as a key
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	for {
+		line, err := ed.readLine(s.prompt())
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
+			return 0
+		}
+		ed.addHistory(line)
+		if !s.runLine(line, sigs) {
+			return 0
+		}
+	}
+}
+
+// runLines runs each line of r, for input piped into the REPL
+func (s *session) runLines(r io.Reader) int {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if !s.runLine(scanner.Text(), nil) {
+			break
+		}
+	}
+	return 0
+}
+
+// prompt shows where commands go and whether a transaction is open
+func (s *session) prompt() string {
+	if s.c.nc == nil {
+		return "not connected> "
+	}
+	if s.inTx {
+		return s.c.addr + "(TX)> "
+	}
+	return s.c.addr + "> "
+}
+
+// runLine runs one line of input, returning false to leave the REPL
+func (s *session) runLine(line string, sigs chan os.Signal) bool {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Printf("Invalid argument(s): %v\n", err)
+		return true
+	}
+	if len(args) == 0 {
+		return true
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "QUIT", "EXIT":
+		return false
+	case "HELP":
+		printHelp(args[1:])
+		return true
+	}
+	s.run(args, sigs)
+	return true
+}
+
+// run sends a command and prints its reply. SUBSCRIBE and PSUBSCRIBE keep
+// printing messages until Ctrl-C.
+func (s *session) run(args []string, sigs chan os.Signal) {
+	if err := s.c.connect(); err != nil {
+		fmt.Printf("Could not connect: %v\n", err)
+		return
+	}
+
+	// Close the connection on Ctrl-C, which ends a blocked read
+	var interrupted atomic.Bool
+	if sigs != nil {
+		select {
+		case <-sigs:
+		default:
+		}
+		nc, done := s.c.nc, make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-sigs:
+				interrupted.Store(true)
+				_ = nc.Close()
+			case <-done:
+			}
+		}()
+	}
+	lost := func(err error) {
+		s.inTx = false
+		if interrupted.Load() {
+			fmt.Println("Interrupted; the next command reconnects")
+			return
+		}
+		fmt.Printf("Connection lost: %v\n", err)
+	}
+
+	name := strings.ToUpper(args[0])
+	subscribe := name == "SUBSCRIBE" || name == "PSUBSCRIBE"
+	if err := s.c.send(args); err != nil {
+		lost(err)
+		return
+	}
+	if subscribe {
+		fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	}
+	for {
+		reply, err := s.c.read()
+		if err != nil {
+			lost(err)
+			return
+		}
+		fmt.Println(formatReply(args, reply))
+		if !subscribe {
+			s.track(name, reply)
+			return
+		}
+	}
+}
+
+// track follows MULTI, EXEC and DISCARD for the prompt
+func (s *session) track(name string, reply protocol.Reply) {
+	switch {
+	case name == "MULTI" && reply.Type == '+':
+		s.inTx = true
+	case name == "EXEC" || name == "DISCARD":
+		s.inTx = false
+	}
+}
+
+// printHelp lists the commands, or describes those named
+func printHelp(names []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer func() { _ = w.Flush() }()
+
+	if len(names) > 0 {
+		for _, name := range names {
+			c, ok := lookupCommand(name)
+			if !ok {
+				fmt.Fprintf(w, "%s\tunknown command\n", strings.ToUpper(name))
+				continue
+			}
+			fmt.Fprintf(w, "%s %s\n  %s\t(%s)\n", c.name, c.args, c.summary, c.group)
+		}
+		return
+	}
+
+	group := ""
+	for _, c := range commands {
+		if c.group != group {
+			if group != "" {
+				fmt.Fprintln(w)
+			}
+			group = c.group
+			fmt.Fprintf(w, "%s:\n", strings.ToUpper(group[:1])+group[1:])
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "HELP command shows its syntax. Vectors can be typed as [0.1, 0.2, 0.3].")
+	fmt.Fprintln(w, "Tab completes command names, the arrow keys browse the history and Ctrl-C abandons a running command.")
+}
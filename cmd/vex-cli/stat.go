@@ -0,0 +1,95 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/uzqw/vex/internal/metrics"
+)
+
+const (
+	// statWindow is the number of samples the rolling QPS is averaged over
+	statWindow = 10
+
+	// statHeaderEvery is how many rows are printed between headers
+	statHeaderEvery = 20
+)
+
+// statSample is one poll of STATS
+type statSample struct {
+	at       time.Time
+	commands uint64
+}
+
+// runStat polls STATS every interval and prints a row per poll, with the
+// commands per second since the previous poll and averaged over the last
+// statWindow polls. The STATS commands sent by this loop are not counted.
+// It runs until interrupted, reconnecting if the connection fails.
+func runStat(c *conn, interval time.Duration) int {
+	var window []statSample
+	rows := 0
+	for ; ; time.Sleep(interval) {
+		reply, err := c.do([]string{"STATS"})
+		if err == nil && reply.Type == '-' {
+			fmt.Fprintf(os.Stderr, "STATS failed: %s\n", reply.Str)
+			return 1
+		}
+		var stats metrics.Snapshot
+		if err == nil {
+			err = json.Unmarshal([]byte(reply.Str), &stats)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", time.Now().Format(time.TimeOnly), err)
+			window = window[:0]
+			continue
+		}
+
+		now := statSample{at: time.Now(), commands: stats.TotalCommands}
+		qps, rolling := "-", "-"
+		if n := len(window); n > 0 {
+			qps = formatRate(window[n-1], now, 1)
+			rolling = formatRate(window[0], now, n)
+		}
+		window = append(window, now)
+		if len(window) > statWindow {
+			window = window[1:]
+		}
+
+		if rows%statHeaderEvery == 0 {
+			fmt.Printf("%-8s  %-10s  %-9s  %-7s  %-12s  %-10s  %s\n",
+				"time", "keys", "mem", "clients", "commands", "qps", fmt.Sprintf("qps(%d)", statWindow))
+		}
+		rows++
+		fmt.Printf("%-8s  %-10d  %-9s  %-7d  %-12d  %-10s  %s\n",
+			now.at.Format(time.TimeOnly), stats.TotalKeys, fmt.Sprintf("%.2fM", stats.DatasetMemoryMB),
+			stats.ActiveConnections, stats.TotalCommands, qps, rolling)
+	}
+}
+
+// formatRate returns the commands per second between two samples, not
+// counting the polls made in between
+func formatRate(from, to statSample, polls int) string {
+	elapsed := to.at.Sub(from.at).Seconds()
+	if elapsed <= 0 || to.commands < from.commands {
+		return "-"
+	}
+	commands := to.commands - from.commands
+	commands -= min(commands, uint64(polls))
+	return fmt.Sprintf("%.1f", float64(commands)/elapsed)
+}
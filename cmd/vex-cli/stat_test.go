@@ -0,0 +1,48 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatRate(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(seconds float64, commands uint64) statSample {
+		return statSample{at: start.Add(time.Duration(seconds * float64(time.Second))), commands: commands}
+	}
+
+	tests := []struct {
+		name     string
+		from, to statSample
+		polls    int
+		want     string
+	}{
+		{"one poll", sample(0, 100), sample(2, 301), 1, "100.0"},
+		{"rolling window", sample(0, 100), sample(10, 1110), 10, "100.0"},
+		{"only polls", sample(0, 100), sample(5, 105), 10, "0.0"},
+		{"fractional", sample(0, 0), sample(4, 11), 1, "2.5"},
+		{"no time elapsed", sample(1, 100), sample(1, 200), 1, "-"},
+		{"counter reset", sample(0, 100), sample(1, 10), 1, "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatRate(tt.from, tt.to, tt.polls); got != tt.want {
+				t.Errorf("formatRate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/metrics"
+)
+
+// authenticate validates a username/password pair for the connection,
+// updating the connection state and recording failures
+func authenticate(c *client, username, password string) bool {
+	if _, ok := users.Authenticate(username, password); ok {
+		c.username = username
+		return true
+	}
+
+	metrics.Global().IncrementAuthFailures()
+	c.log.Warn("authentication failed",
+		slog.String("remote", c.conn.RemoteAddr().String()),
+		slog.String("user", username),
+	)
+	return false
+}
+
+// handleAuth handles the AUTH command: AUTH [username] password
+func handleAuth(c *client, cmd [][]byte) {
+	if len(cmd) != 2 && len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'auth' command")
+		return
+	}
+
+	username, password := acl.DefaultUser, string(cmd[1])
+	if len(cmd) == 3 {
+		username, password = string(cmd[1]), string(cmd[2])
+	} else if u, ok := users.User(acl.DefaultUser); ok && u.NoPass() {
+		_ = c.writer.WriteError("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+
+	if !authenticate(c, username, password) {
+		_ = c.writer.WriteErrorCode("WRONGPASS", "invalid username-password pair or user is disabled.")
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleHello handles the HELLO command: HELLO [protover [AUTH username password]]
+// Only RESP2 is supported, so the reply is a flat array of field/value pairs.
+func handleHello(c *client, cmd [][]byte) {
+	args := cmd[1:]
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			_ = c.writer.WriteError("Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 {
+			_ = c.writer.WriteErrorCode("NOPROTO", "unsupported protocol version")
+			return
+		}
+		args = args[1:]
+	}
+
+	for len(args) > 0 {
+		switch strings.ToUpper(string(args[0])) {
+		case "AUTH":
+			if len(args) < 3 {
+				_ = c.writer.WriteError("syntax error in HELLO option 'AUTH'")
+				return
+			}
+			if !authenticate(c, string(args[1]), string(args[2])) {
+				_ = c.writer.WriteErrorCode("WRONGPASS", "invalid username-password pair or user is disabled.")
+				return
+			}
+			args = args[3:]
+		default:
+			_ = c.writer.WriteError("syntax error in HELLO option '" + string(args[0]) + "'")
+			return
+		}
+	}
+
+	if c.user() == nil {
+		_ = c.writer.WriteErrorCode("NOAUTH", "HELLO must be called with the client already authenticated, otherwise the HELLO AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+
+	mode, role := "standalone", "master"
+	if clusterState != nil {
+		mode = "cluster"
+	}
+	if replicating() {
+		role = "replica"
+	}
+	_ = c.writer.WriteArray([]string{
+		"server", "vex",
+		"version", Version,
+		"proto", "2",
+		"mode", mode,
+		"role", role,
+	})
+}
+
+// aclCategory returns the category needed for an ACL subcommand. Anyone may
+// ask who they are; everything else manages users and is admin only.
+func aclCategory(cmd [][]byte) acl.Category {
+	if len(cmd) > 1 && strings.EqualFold(string(cmd[1]), "WHOAMI") {
+		return acl.CategoryConnection
+	}
+	return acl.CategoryAdmin
+}
+
+// handleACL handles the ACL command:
+// ACL SETUSER name [rule ...] | DELUSER name [name ...] | LIST | WHOAMI | LOAD
+func handleACL(c *client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'acl' command")
+		return
+	}
+
+	sub := strings.ToUpper(string(cmd[1]))
+	args := make([]string, len(cmd)-2)
+	for i, arg := range cmd[2:] {
+		args[i] = string(arg)
+	}
+
+	switch sub {
+	case "SETUSER":
+		if len(args) < 1 {
+			_ = c.writer.WriteError("wrong number of arguments for 'acl|setuser' command")
+			return
+		}
+		if err := users.SetUser(args[0], args[1:]...); err != nil {
+			_ = c.writer.WriteError("Error in ACL SETUSER modifier: " + err.Error())
+			return
+		}
+		c.log.Info("acl user updated", slog.String("user", args[0]))
+		_ = c.writer.WriteSimpleString("OK")
+	case "DELUSER":
+		if len(args) < 1 {
+			_ = c.writer.WriteError("wrong number of arguments for 'acl|deluser' command")
+			return
+		}
+		n, err := users.DelUser(args...)
+		if err != nil {
+			_ = c.writer.WriteError(err.Error())
+			return
+		}
+		c.log.Info("acl users deleted", slog.Any("users", args), slog.Int("deleted", n))
+		_ = c.writer.WriteInteger(int64(n))
+	case "LIST":
+		_ = c.writer.WriteArray(users.List())
+	case "WHOAMI":
+		_ = c.writer.WriteBulkString(c.username)
+	case "LOAD":
+		if *aclFile == "" {
+			_ = c.writer.WriteError("This Vex server is not configured to use an ACL file. Start it with -aclfile to use ACL LOAD.")
+			return
+		}
+		if err := users.LoadFile(*aclFile); err != nil {
+			_ = c.writer.WriteError(err.Error())
+			return
+		}
+		c.log.Info("acl file reloaded", slog.String("file", *aclFile))
+		_ = c.writer.WriteSimpleString("OK")
+	default:
+		_ = c.writer.WriteError("unknown subcommand '" + strings.ToLower(sub) + "'. Try ACL SETUSER, DELUSER, LIST, WHOAMI or LOAD.")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/uzqw/vex/internal/cluster"
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/replication"
+)
+
+func TestHelloModeAndRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func()
+		mode, role string
+	}{
+		{"standalone", func() {}, "standalone", "master"},
+		{"replica", func() { replica.Store(&replication.Replica{}) }, "standalone", "replica"},
+		{"cluster", func() { clusterState = cluster.New("", "127.0.0.1:7001") }, "cluster", "master"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAPI(t)
+			t.Cleanup(func() {
+				replica.Store(nil)
+				clusterState = nil
+			})
+			tt.setup()
+
+			reply, err := protocol.NewRESPReader(strings.NewReader(newTestClient().do("HELLO", "2"))).ReadCommand()
+			if err != nil {
+				t.Fatalf("HELLO reply: %v", err)
+			}
+			fields := make(map[string]string)
+			for i := 0; i+1 < len(reply); i += 2 {
+				fields[reply[i]] = reply[i+1]
+			}
+			if fields["mode"] != tt.mode || fields["role"] != tt.role {
+				t.Errorf("HELLO mode, role = %q, %q, want %q, %q", fields["mode"], fields["role"], tt.mode, tt.role)
+			}
+		})
+	}
+}
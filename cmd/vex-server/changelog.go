@@ -0,0 +1,330 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/changelog"
+)
+
+// errChangelogDisabled is the reply to change log commands when no log is kept
+const errChangelogDisabled = "the change log is disabled, start the server with -changelog-maxlen or -changelog-maxbytes"
+
+// readOptions are the COUNT and BLOCK options of CLREAD and CLREADGROUP
+type readOptions struct {
+	count int           // 0 for every record available
+	block time.Duration // 0 to wait forever, if blocking is set
+	// blocking is set by BLOCK: with no records to return the command
+	// waits for one instead of replying with an empty array
+	blocking bool
+}
+
+// parseReadOptions parses the options at the start of args and returns the
+// arguments that follow them
+func parseReadOptions(args [][]byte) (readOptions, [][]byte, error) {
+	var opts readOptions
+	for len(args) >= 2 {
+		switch strings.ToUpper(string(args[0])) {
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < 1 {
+				return opts, nil, errors.New("value is out of range, must be positive")
+			}
+			opts.count = n
+		case "BLOCK":
+			ms, err := strconv.ParseInt(string(args[1]), 10, 64)
+			if err != nil || ms < 0 {
+				return opts, nil, errors.New("timeout is not an integer or out of range")
+			}
+			opts.block = time.Duration(ms) * time.Millisecond
+			opts.blocking = true
+		default:
+			return opts, args, nil
+		}
+		args = args[2:]
+	}
+	return opts, args, nil
+}
+
+// wait blocks as the options ask until wait returns, and reports whether
+// it returned because there is something new to read. A command inside
+// EXEC never blocks, since no other client can write while it runs.
+func (opts readOptions) wait(c *client, wait func(ctx context.Context) error) bool {
+	if !opts.blocking {
+		return false
+	}
+	if c.inExec() {
+		return false
+	}
+
+	ctx := context.Background()
+	if opts.block > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.block)
+		defer cancel()
+	}
+	return wait(ctx) == nil
+}
+
+// parseSeq parses a sequence number argument, where $ stands for the
+// newest record in the log
+func parseSeq(arg []byte) (uint64, error) {
+	if string(arg) == "$" {
+		return changes.LastSeq(), nil
+	}
+	seq, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid sequence number")
+	}
+	return seq, nil
+}
+
+// handleCLRead handles the CLREAD command: CLREAD [COUNT n] [BLOCK ms] seq|$
+// It replies with the records after seq, oldest first. With BLOCK it waits
+// up to ms milliseconds (0 for no limit) for a record if there are none,
+// and replies with a null array on timeout.
+func handleCLRead(c *client, cmd [][]byte) {
+	if changes == nil {
+		_ = c.writer.WriteError(errChangelogDisabled)
+		return
+	}
+	opts, args, err := parseReadOptions(cmd[1:])
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	if len(args) != 1 {
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+	after, err := parseSeq(args[0])
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+
+	records, err := changes.Read(after, opts.count)
+	if err == nil && len(records) == 0 && opts.blocking {
+		if !opts.wait(c, func(ctx context.Context) error { return changes.Wait(ctx, after) }) {
+			_ = c.writer.WriteNullArray()
+			return
+		}
+		records, err = changes.Read(after, opts.count)
+	}
+	if err != nil {
+		writeChangelogError(c, err, "")
+		return
+	}
+	writeRecords(c, records)
+}
+
+// handleCLGroup handles the CLGROUP command:
+//
+//	CLGROUP CREATE group [seq|$]
+//	CLGROUP DESTROY group
+//
+// A new group is delivered the records after seq, by default only the
+// records appended from now on.
+func handleCLGroup(c *client, cmd [][]byte) {
+	if changes == nil {
+		_ = c.writer.WriteError(errChangelogDisabled)
+		return
+	}
+	if len(cmd) < 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'clgroup' command")
+		return
+	}
+	name := string(cmd[2])
+
+	switch strings.ToUpper(string(cmd[1])) {
+	case "CREATE":
+		if len(cmd) > 4 {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		after := changes.LastSeq()
+		if len(cmd) == 4 {
+			var err error
+			if after, err = parseSeq(cmd[3]); err != nil {
+				_ = c.writer.WriteError(err.Error())
+				return
+			}
+		}
+		if err := changes.CreateGroup(name, after); err != nil {
+			writeChangelogError(c, err, name)
+			return
+		}
+		_ = c.writer.WriteSimpleString("OK")
+	case "DESTROY":
+		if len(cmd) != 3 {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		if changes.DestroyGroup(name) {
+			_ = c.writer.WriteInteger(1)
+		} else {
+			_ = c.writer.WriteInteger(0)
+		}
+	default:
+		_ = c.writer.WriteError(fmt.Sprintf("unknown subcommand '%s'", cmd[1]))
+	}
+}
+
+// handleCLReadGroup handles the CLREADGROUP command:
+// CLREADGROUP group [COUNT n] [BLOCK ms] [>|0]
+// With > (the default) it replies with records not yet delivered to the
+// group and marks them delivered, waiting for one with BLOCK as CLREAD
+// does. With 0 it replies again with the records delivered but not yet
+// acknowledged, and never blocks.
+func handleCLReadGroup(c *client, cmd [][]byte) {
+	if changes == nil {
+		_ = c.writer.WriteError(errChangelogDisabled)
+		return
+	}
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'clreadgroup' command")
+		return
+	}
+	name := string(cmd[1])
+	opts, args, err := parseReadOptions(cmd[2:])
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+
+	pending := false
+	switch {
+	case len(args) == 0 || (len(args) == 1 && string(args[0]) == ">"):
+	case len(args) == 1 && string(args[0]) == "0":
+		pending = true
+	default:
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+
+	records, err := changes.ReadGroup(name, opts.count, pending)
+	if err == nil && len(records) == 0 && opts.blocking && !pending {
+		if !opts.wait(c, func(ctx context.Context) error { return changes.WaitGroup(ctx, name) }) {
+			_ = c.writer.WriteNullArray()
+			return
+		}
+		records, err = changes.ReadGroup(name, opts.count, false)
+	}
+	if err != nil {
+		writeChangelogError(c, err, name)
+		return
+	}
+	writeRecords(c, records)
+}
+
+// handleCLAck handles the CLACK command: CLACK group seq
+// Every record delivered to the group up to seq is acknowledged. The reply
+// is the group's acknowledged sequence number afterwards.
+func handleCLAck(c *client, cmd [][]byte) {
+	if changes == nil {
+		_ = c.writer.WriteError(errChangelogDisabled)
+		return
+	}
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'clack' command")
+		return
+	}
+	seq, err := strconv.ParseUint(string(cmd[2]), 10, 64)
+	if err != nil {
+		_ = c.writer.WriteError("invalid sequence number")
+		return
+	}
+	acked, err := changes.Ack(string(cmd[1]), seq)
+	if err != nil {
+		writeChangelogError(c, err, string(cmd[1]))
+		return
+	}
+	_ = c.writer.WriteInteger(int64(acked))
+}
+
+// handleCLInfo handles the CLINFO command, replying with the state of the
+// change log and its consumer groups as field/value pairs
+func handleCLInfo(c *client, cmd [][]byte) {
+	if changes == nil {
+		_ = c.writer.WriteError(errChangelogDisabled)
+		return
+	}
+	if len(cmd) != 1 {
+		_ = c.writer.WriteError("wrong number of arguments for 'clinfo' command")
+		return
+	}
+
+	groups := changes.Groups()
+	_ = c.writer.WriteArrayHeader(10)
+	_ = c.writer.WriteBulkString("length")
+	_ = c.writer.WriteInteger(int64(changes.Len()))
+	_ = c.writer.WriteBulkString("bytes")
+	_ = c.writer.WriteInteger(changes.Bytes())
+	_ = c.writer.WriteBulkString("first-seq")
+	_ = c.writer.WriteInteger(int64(changes.FirstSeq()))
+	_ = c.writer.WriteBulkString("last-seq")
+	_ = c.writer.WriteInteger(int64(changes.LastSeq()))
+	_ = c.writer.WriteBulkString("groups")
+	_ = c.writer.WriteArrayHeader(len(groups))
+	for _, g := range groups {
+		_ = c.writer.WriteArrayHeader(8)
+		_ = c.writer.WriteBulkString("name")
+		_ = c.writer.WriteBulkString(g.Name)
+		_ = c.writer.WriteBulkString("delivered")
+		_ = c.writer.WriteInteger(int64(g.Delivered))
+		_ = c.writer.WriteBulkString("acked")
+		_ = c.writer.WriteInteger(int64(g.Acked))
+		_ = c.writer.WriteBulkString("lag")
+		_ = c.writer.WriteInteger(int64(g.Lag))
+	}
+}
+
+// writeRecords writes change log records, each as an array of its sequence
+// number, operation, key and vector, which is null unless the operation
+// stored one
+func writeRecords(c *client, records []changelog.Record) {
+	_ = c.writer.WriteArrayHeader(len(records))
+	for _, r := range records {
+		_ = c.writer.WriteArrayHeader(4)
+		_ = c.writer.WriteInteger(int64(r.Seq))
+		_ = c.writer.WriteBulkString(r.Op.String())
+		_ = c.writer.WriteBulkString(r.Key)
+		if r.Vector == nil {
+			_ = c.writer.WriteNull()
+		} else {
+			writeVector(c, r.Vector)
+		}
+	}
+}
+
+// writeChangelogError writes the reply for an error from the change log
+func writeChangelogError(c *client, err error, group string) {
+	switch {
+	case errors.Is(err, changelog.ErrTrimmed):
+		_ = c.writer.WriteError(fmt.Sprintf("records were trimmed from the change log, the oldest kept is %d", changes.FirstSeq()))
+	case errors.Is(err, changelog.ErrNoGroup):
+		_ = c.writer.WriteErrorCode("NOGROUP", fmt.Sprintf("No such consumer group '%s'", group))
+	case errors.Is(err, changelog.ErrGroupExists):
+		_ = c.writer.WriteErrorCode("BUSYGROUP", "Consumer group name already exists")
+	default:
+		_ = c.writer.WriteError(err.Error())
+	}
+}
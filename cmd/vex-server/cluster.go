@@ -0,0 +1,683 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/cluster"
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// clusterState is set in cluster mode, in which each node serves the keys
+// of the hash slots assigned to it and redirects clients for the others
+var (
+	clusterState    *cluster.State
+	clusterGossiper *cluster.Gossiper
+)
+
+// clusterGossipInterval is how often nodes exchange their views
+const clusterGossipInterval = time.Second
+
+// migrateMu keeps MIGRATE from moving keys between a command checking that
+// its keys are here and running. It is taken before the storage locks.
+var migrateMu sync.RWMutex
+
+// startCluster loads this node's view of the cluster and starts gossiping
+// with the other nodes until ctx is cancelled
+func startCluster(ctx context.Context) error {
+	if *replicaOf != "" || *raftID != "" {
+		return errors.New("cluster mode can't be used with replicaof or raft")
+	}
+
+	addr := *clusterAnnounceAddr
+	if addr == "" {
+		h := *host
+		if h == "" || h == "0.0.0.0" || h == "::" {
+			h = "127.0.0.1"
+		}
+		addr = net.JoinHostPort(h, *port)
+	}
+
+	state, err := cluster.Load(*clusterConfigFile, addr)
+	if err != nil {
+		return err
+	}
+	if err := state.Save(*clusterConfigFile); err != nil {
+		return err
+	}
+	state.OnChange(func() {
+		if err := state.Save(*clusterConfigFile); err != nil {
+			log.Error("failed to save cluster config", slog.String("error", err.Error()))
+		}
+	})
+
+	clusterState = state
+	clusterGossiper = &cluster.Gossiper{
+		State:    state,
+		User:     *leaderUser,
+		Password: *leaderAuth,
+		Interval: clusterGossipInterval,
+		Logger:   log,
+	}
+	go clusterGossiper.Run(ctx)
+	return nil
+}
+
+// routeCommand checks that this node serves the keys of cmd, replying with
+// a redirect or an error if not. When it returns true the caller runs the
+// command and then calls done.
+//
+// A slot being migrated is served here for the keys that haven't moved yet
+// and by the target for the others, which the client is sent to with ASK.
+// asking is set if the client sent ASKING just before, allowing it to use a
+// slot this node is importing.
+func routeCommand(c *client, spec *command, cmd [][]byte, asking bool) (done func(), ok bool) {
+	migrateMu.RLock()
+	if err := routeKeys(c.db, spec.keys(cmd), asking); err != nil {
+		migrateMu.RUnlock()
+		_ = c.writer.WriteErrorCode(err.code, err.msg)
+		return nil, false
+	}
+	return migrateMu.RUnlock, true
+}
+
+// routeError is the error reply to a command whose keys this node doesn't
+// serve
+type routeError struct {
+	code, msg string
+}
+
+// routeKeys checks that this node serves keys, as routeCommand does. Unless
+// the slot is stable, migrateMu must be held until the command has run.
+func routeKeys(db keyspace, keys [][]byte, asking bool) *routeError {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := cluster.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.KeySlot(key) != slot {
+			return &routeError{"CROSSSLOT", "Keys in request don't hash to the same slot"}
+		}
+	}
+
+	route := clusterState.Route(slot)
+	switch {
+	case route.Local && route.MigratingTo != "":
+		missing := 0
+		for _, key := range keys {
+			if !db.Exists(string(key)) {
+				missing++
+			}
+		}
+		switch missing {
+		case 0:
+			return nil
+		case len(keys):
+			return &routeError{"ASK", fmt.Sprintf("%d %s", slot, route.MigratingTo)}
+		default:
+			return &routeError{"TRYAGAIN", "Multiple keys request during rehashing of slot"}
+		}
+	case route.Local, route.Importing && asking:
+		return nil
+	case route.Owner == "":
+		return &routeError{"CLUSTERDOWN", "Hash slot not served"}
+	default:
+		return &routeError{"MOVED", fmt.Sprintf("%d %s", slot, route.Owner)}
+	}
+}
+
+// clusterCategory returns the ACL category of a CLUSTER subcommand. Clients
+// need the topology to route their commands, so reading it is allowed to
+// everyone.
+func clusterCategory(cmd [][]byte) acl.Category {
+	if len(cmd) > 1 {
+		switch strings.ToUpper(string(cmd[1])) {
+		case "INFO", "MYID", "SLOTS", "NODES", "KEYSLOT":
+			return acl.CategoryConnection
+		}
+	}
+	return acl.CategoryAdmin
+}
+
+// handleCluster handles the CLUSTER command:
+// CLUSTER INFO | MYID | SLOTS | NODES | KEYSLOT key | MEET host port |
+// ADDSLOTS slot [slot ...] | SETSLOT slot IMPORTING|MIGRATING|NODE id |
+// SETSLOT slot STABLE | GETKEYSINSLOT slot count | COUNTKEYSINSLOT slot
+//
+// CLUSTER GOSSIP carries views between nodes.
+func handleCluster(c *client, cmd [][]byte) {
+	if clusterState == nil {
+		_ = c.writer.WriteError("This instance has cluster support disabled")
+		return
+	}
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'cluster' command")
+		return
+	}
+
+	sub := strings.ToUpper(string(cmd[1]))
+	args := cmd[2:]
+	arity := map[string]int{
+		"INFO": 0, "MYID": 0, "SLOTS": 0, "NODES": 0, "KEYSLOT": 1, "MEET": 2,
+		"GETKEYSINSLOT": 2, "COUNTKEYSINSLOT": 1,
+	}
+	if n, ok := arity[sub]; ok && len(args) != n {
+		_ = c.writer.WriteError(fmt.Sprintf("wrong number of arguments for 'cluster|%s' command", strings.ToLower(sub)))
+		return
+	}
+
+	switch sub {
+	case "INFO":
+		_ = c.writer.WriteBulkString(clusterInfo())
+	case "MYID":
+		_ = c.writer.WriteBulkString(clusterState.ID())
+	case "SLOTS":
+		writeClusterSlots(c)
+	case "NODES":
+		_ = c.writer.WriteBulkString(clusterNodes())
+	case "KEYSLOT":
+		_ = c.writer.WriteInteger(int64(cluster.KeySlot(args[0])))
+	case "MEET":
+		if n, err := strconv.Atoi(string(args[1])); err != nil || n < 1 || n > 65535 {
+			_ = c.writer.WriteError(fmt.Sprintf("Invalid node address specified: %s:%s", args[0], args[1]))
+			return
+		}
+		addr := net.JoinHostPort(string(args[0]), string(args[1]))
+		if err := clusterGossiper.Meet(c.ctx, addr); err != nil {
+			_ = c.writer.WriteError(fmt.Sprintf("failed to meet %s: %s", addr, err))
+			return
+		}
+		_ = c.writer.WriteSimpleString("OK")
+	case "ADDSLOTS":
+		handleClusterAddSlots(c, args)
+	case "SETSLOT":
+		handleClusterSetSlot(c, args)
+	case "GETKEYSINSLOT", "COUNTKEYSINSLOT":
+		handleClusterKeysInSlot(c, sub, args)
+	case "GOSSIP":
+		nodes, err := cluster.DecodeGossip(args)
+		if err != nil {
+			_ = c.writer.WriteError(err.Error())
+			return
+		}
+		clusterState.Merge(nodes)
+		_ = c.writer.WriteArray(cluster.EncodeGossip(clusterState.Claims()))
+	default:
+		_ = c.writer.WriteError(fmt.Sprintf("unknown subcommand '%s'", cmd[1]))
+	}
+}
+
+// clusterInfo formats CLUSTER INFO. The cluster is ok once every slot is
+// served.
+func clusterInfo() string {
+	assigned := clusterState.Assigned()
+	state := "ok"
+	if assigned < cluster.SlotCount {
+		state = "fail"
+	}
+	myEpoch := uint64(0)
+	nodes := clusterState.Nodes()
+	for _, n := range nodes {
+		if n.Myself {
+			myEpoch = n.Epoch
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_enabled:1\r\n")
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(nodes))
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", clusterState.CurrentEpoch())
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", myEpoch)
+	return b.String()
+}
+
+// writeClusterSlots replies to CLUSTER SLOTS with each slot range and the
+// node serving it, as [start, end, [host, port, id]]
+func writeClusterSlots(c *client) {
+	slots := clusterState.Slots()
+	_ = c.writer.WriteArrayHeader(len(slots))
+	for _, s := range slots {
+		h, p, _ := net.SplitHostPort(s.Node.Addr)
+		nodePort, _ := strconv.Atoi(p)
+
+		_ = c.writer.WriteArrayHeader(3)
+		_ = c.writer.WriteInteger(int64(s.Start))
+		_ = c.writer.WriteInteger(int64(s.End))
+		_ = c.writer.WriteArrayHeader(3)
+		_ = c.writer.WriteBulkString(h)
+		_ = c.writer.WriteInteger(int64(nodePort))
+		_ = c.writer.WriteBulkString(s.Node.ID)
+	}
+}
+
+// clusterNodes formats CLUSTER NODES, one line per node in Redis' layout:
+// id addr@bus flags master ping-sent pong-recv epoch link-state slots...
+// Nodes talk over the client port, which is given as the bus port too.
+func clusterNodes() string {
+	migrating, importing := clusterState.Migrations()
+	var b strings.Builder
+	for _, n := range clusterState.Nodes() {
+		flags, link := "master", "connected"
+		pong := int64(0)
+		switch {
+		case n.Myself:
+			flags = "myself,master"
+		case n.LastSeen.IsZero() || time.Since(n.LastSeen) > 5*clusterGossipInterval:
+			link = "disconnected"
+		}
+		if !n.LastSeen.IsZero() {
+			pong = n.LastSeen.UnixMilli()
+		}
+		_, p, _ := net.SplitHostPort(n.Addr)
+		fmt.Fprintf(&b, "%s %s@%s %s - 0 %d %d %s", n.ID, n.Addr, p, flags, pong, n.Epoch, link)
+		for _, r := range n.Slots {
+			b.WriteString(" " + r.String())
+		}
+		if n.Myself {
+			for slot, id := range migrating {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, id)
+			}
+			for slot, id := range importing {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, id)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// parseSlots parses slot numbers, writing an error and returning false if
+// one is invalid
+func parseSlots(c *client, args [][]byte) ([]int, bool) {
+	slots := make([]int, len(args))
+	for i, arg := range args {
+		slot, err := cluster.ParseSlot(string(arg))
+		if err != nil {
+			_ = c.writer.WriteError("Invalid or out of range slot")
+			return nil, false
+		}
+		slots[i] = slot
+	}
+	return slots, true
+}
+
+// handleClusterAddSlots handles CLUSTER ADDSLOTS slot [slot ...]
+func handleClusterAddSlots(c *client, args [][]byte) {
+	if len(args) == 0 {
+		_ = c.writer.WriteError("wrong number of arguments for 'cluster|addslots' command")
+		return
+	}
+	slots, ok := parseSlots(c, args)
+	if !ok {
+		return
+	}
+	if err := clusterState.AddSlots(slots); err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleClusterSetSlot handles CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE
+// id and CLUSTER SETSLOT slot STABLE
+func handleClusterSetSlot(c *client, args [][]byte) {
+	if len(args) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'cluster|setslot' command")
+		return
+	}
+	slots, ok := parseSlots(c, args[:1])
+	if !ok {
+		return
+	}
+	slot := slots[0]
+
+	action := strings.ToUpper(string(args[1]))
+	if action == "STABLE" {
+		if len(args) != 2 {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		clusterState.SetStable(slot)
+		_ = c.writer.WriteSimpleString("OK")
+		return
+	}
+	if len(args) != 3 {
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+
+	id := string(args[2])
+	var err error
+	switch action {
+	case "MIGRATING":
+		err = clusterState.SetMigrating(slot, id)
+	case "IMPORTING":
+		err = clusterState.SetImporting(slot, id)
+	case "NODE":
+		err = clusterState.SetNode(slot, id)
+	default:
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleClusterKeysInSlot handles CLUSTER GETKEYSINSLOT slot count and
+// CLUSTER COUNTKEYSINSLOT slot. Keys are not indexed by slot, so both scan
+// the keyspace.
+func handleClusterKeysInSlot(c *client, sub string, args [][]byte) {
+	slots, ok := parseSlots(c, args[:1])
+	if !ok {
+		return
+	}
+	slot := slots[0]
+
+	limit := -1
+	if sub == "GETKEYSINSLOT" {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n < 0 {
+			_ = c.writer.WriteError("Invalid number of keys")
+			return
+		}
+		limit = n
+	}
+
+	u := c.user()
+	match := func(key string) bool {
+		return cluster.KeySlot([]byte(key)) == slot && u.CanAccessKey(key)
+	}
+	var keys []string
+	for cursor := uint64(0); limit < 0 || len(keys) < limit; {
+		batch, next := c.db.Scan(cursor, defaultScanCount*100, match)
+		keys = append(keys, batch...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	if limit < 0 {
+		_ = c.writer.WriteInteger(int64(len(keys)))
+		return
+	}
+	_ = c.writer.WriteArray(keys[:min(len(keys), limit)])
+}
+
+// handleAsking handles the ASKING command, which lets the next command use
+// a slot this node is importing
+func handleAsking(c *client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		_ = c.writer.WriteError("wrong number of arguments for 'asking' command")
+		return
+	}
+	if clusterState == nil {
+		_ = c.writer.WriteError("This instance has cluster support disabled")
+		return
+	}
+	c.asking = true
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleMigrate handles the MIGRATE command:
+// MIGRATE host port key|"" timeout-ms [COPY] [REPLACE] [KEYS key [key ...]]
+// It writes the keys to the node at host:port and deletes them here unless
+// COPY is given. The keys are read under a brief lock and sent with none
+// held, so while they move commands here keep using the copies here. Once
+// sent, the keys unchanged since they were read are deleted here, those
+// deleted here in the meantime are deleted on the target too, and those
+// written here in the meantime are kept on both nodes and reported with
+// TRYAGAIN.
+func handleMigrate(c *client, cmd [][]byte) {
+	if len(cmd) < 5 {
+		_ = c.writer.WriteError("wrong number of arguments for 'migrate' command")
+		return
+	}
+	if c.inExec() {
+		_ = c.writer.WriteError("MIGRATE can't be run inside a transaction")
+		return
+	}
+	timeout, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+	if err != nil || timeout < 0 {
+		_ = c.writer.WriteError("value is not an integer or out of range")
+		return
+	}
+	if timeout == 0 {
+		timeout = 1000
+	}
+
+	var copyKeys, replace bool
+	var keys []string
+	for i := 5; i < len(cmd); i++ {
+		switch strings.ToUpper(string(cmd[i])) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if len(cmd[3]) != 0 {
+				_ = c.writer.WriteError("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			for _, key := range cmd[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(cmd)
+		default:
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+	}
+	if len(keys) == 0 {
+		keys = []string{string(cmd[3])}
+	}
+
+	// MIGRATE locates its keys itself, so check them here
+	u := c.user()
+	for _, key := range keys {
+		if !u.CanAccessKey(key) {
+			_ = c.writer.WriteErrorCode("NOPERM", "No permissions to access a key")
+			return
+		}
+	}
+
+	addr := net.JoinHostPort(string(cmd[1]), string(cmd[2]))
+	conn, err := net.DialTimeout("tcp", addr, time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		_ = c.writer.WriteErrorCode("IOERR", fmt.Sprintf("error or timeout connecting to target instance: %s", err))
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	target := &migrateTarget{
+		conn:    conn,
+		reader:  protocol.NewRESPReader(conn),
+		writer:  protocol.NewRESPWriter(conn),
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	if *leaderAuth != "" {
+		args := []string{"AUTH", *leaderAuth}
+		if *leaderUser != "" {
+			args = []string{"AUTH", *leaderUser, *leaderAuth}
+		}
+		if _, err := target.call(args); err != nil {
+			_ = c.writer.WriteErrorCode("IOERR", fmt.Sprintf("error authenticating to the target: %s", err))
+			return
+		}
+	}
+
+	// The keys are read at once and sent with no lock held, so a slow
+	// target doesn't stall the server
+	var found []storage.KeyVersion
+	var writes [][]string
+	store.Atomically(func(tx *storage.Tx) {
+		for _, key := range keys {
+			values, version, ok := tx.GetWithVersion(key)
+			if !ok {
+				continue
+			}
+			args := []string{"VSET", key, formatVector(values)}
+			if ttl, _ := tx.TTL(key); ttl >= 0 {
+				args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+			}
+			if !replace {
+				args = append(args, "NX")
+			}
+			found = append(found, storage.KeyVersion{Key: key, Version: version})
+			writes = append(writes, args)
+		}
+	})
+
+	moved := 0
+	var failure error
+	for _, args := range writes {
+		if failure = target.set(args); failure != nil {
+			break
+		}
+		moved++
+	}
+
+	// A key deleted here while it was sent has version 0 and must not
+	// survive on the target, while one written here is newer than the copy
+	// sent and is kept
+	kept := 0
+	var gone []string
+	if !copyKeys && moved > 0 {
+		migrateMu.Lock()
+		store.Atomically(func(tx *storage.Tx) {
+			for _, kv := range found[:moved] {
+				switch tx.Version(kv.Key) {
+				case kv.Version:
+					tx.Delete(kv.Key)
+				case 0:
+					gone = append(gone, kv.Key)
+				default:
+					kept++
+				}
+			}
+		})
+		migrateMu.Unlock()
+	}
+	for _, key := range gone {
+		if _, err := target.asking([]string{"VDEL", key}); err != nil {
+			if failure == nil {
+				failure = err
+			}
+			break
+		}
+	}
+
+	var busy *migrateBusyError
+	var replied *migrateReplyError
+	switch {
+	case errors.As(failure, &busy):
+		_ = c.writer.WriteErrorCode("BUSYKEY", "Target key name already exists.")
+	case errors.As(failure, &replied):
+		_ = c.writer.WriteError(fmt.Sprintf("Target instance replied with error: %s", replied.msg))
+	case failure != nil:
+		_ = c.writer.WriteErrorCode("IOERR", fmt.Sprintf("error or timeout writing to target instance: %s", failure))
+	case kept > 0:
+		_ = c.writer.WriteErrorCode("TRYAGAIN", fmt.Sprintf("%d keys were written while being migrated and were kept, migrate them again with REPLACE", kept))
+	case moved == 0:
+		_ = c.writer.WriteSimpleString("NOKEY")
+	default:
+		_ = c.writer.WriteSimpleString("OK")
+	}
+}
+
+// migrateTarget is the connection MIGRATE writes keys over
+type migrateTarget struct {
+	conn    net.Conn
+	reader  *protocol.RESPReader
+	writer  *protocol.RESPWriter
+	timeout time.Duration
+}
+
+// migrateBusyError reports that a key already exists on the target
+type migrateBusyError struct{}
+
+func (*migrateBusyError) Error() string { return "target key name already exists" }
+
+// migrateReplyError is an error reply from the target
+type migrateReplyError struct {
+	msg string
+}
+
+func (e *migrateReplyError) Error() string { return e.msg }
+
+// read reads a reply from the target, telling error replies apart from
+// failures to read one
+func (t *migrateTarget) read() ([][]byte, error) {
+	reply, err := t.reader.ReadCommandBytes()
+	var netErr net.Error
+	switch {
+	case err == nil, errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, protocol.ErrInvalidProtocol), errors.Is(err, protocol.ErrInvalidLength):
+		return reply, err
+	}
+	return nil, &migrateReplyError{msg: err.Error()}
+}
+
+// call sends a command and returns the reply
+func (t *migrateTarget) call(args []string) ([][]byte, error) {
+	_ = t.conn.SetDeadline(time.Now().Add(t.timeout))
+	_ = t.writer.WriteArray(args)
+	if err := t.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return t.read()
+}
+
+// asking sends a command preceded by ASKING, since the target may not
+// serve the key's slot yet, and returns the reply
+func (t *migrateTarget) asking(args []string) ([][]byte, error) {
+	_ = t.conn.SetDeadline(time.Now().Add(t.timeout))
+	_ = t.writer.WriteArray([]string{"ASKING"})
+	_ = t.writer.WriteArray(args)
+	if err := t.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := t.read(); err != nil {
+		return nil, err
+	}
+	return t.read()
+}
+
+// set writes a key to the target
+func (t *migrateTarget) set(args []string) error {
+	reply, err := t.asking(args)
+	if err != nil {
+		return err
+	}
+	if len(reply) != 1 || string(reply[0]) != "OK" {
+		return &migrateBusyError{}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/uzqw/vex/internal/cluster"
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// newTestCluster makes this node "a" serve the slot of {here} and node "b"
+// at addrB serve the slot of {there}, and returns the two slots
+func newTestCluster(t *testing.T, addrB string) (here, there int) {
+	t.Helper()
+	newTestAPI(t)
+	here, there = cluster.KeySlot([]byte("here")), cluster.KeySlot([]byte("there"))
+
+	a, b := cluster.New("a", "127.0.0.1:7001"), cluster.New("b", addrB)
+	if err := a.AddSlots([]int{here}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddSlots([]int{there}); err != nil {
+		t.Fatal(err)
+	}
+	a.Merge(b.Claims())
+	clusterState = a
+	t.Cleanup(func() { clusterState = nil })
+	return here, there
+}
+
+func TestClusterRouting(t *testing.T) {
+	here, there := newTestCluster(t, "127.0.0.1:7002")
+	c := newTestClient()
+	if got := c.do("VSET", "{here}1", "[1,0]"); got != "+OK\r\n" {
+		t.Fatalf("VSET = %q", got)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"served here", []string{"VGET", "{here}1"}, "$20\r\n"},
+		{"moved", []string{"VGET", "{there}1"}, fmt.Sprintf("-MOVED %d 127.0.0.1:7002\r\n", there)},
+		{"unassigned", []string{"VGET", "{nowhere}1"}, "-CLUSTERDOWN"},
+		{"cross slot", []string{"VMGET", "{here}1", "{there}1"}, "-CROSSSLOT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.do(tt.args...); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+
+	// While the slot moves to b, the keys still here are served here and
+	// the others are asked for on b
+	if err := clusterState.SetMigrating(here, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.do("VGET", "{here}1"); !strings.HasPrefix(got, "$20\r\n") {
+		t.Errorf("VGET of a key not moved yet = %q", got)
+	}
+	if got, want := c.do("VGET", "{here}2"), fmt.Sprintf("-ASK %d 127.0.0.1:7002\r\n", here); got != want {
+		t.Errorf("VGET of a moved key = %q, want %q", got, want)
+	}
+	if got := c.do("VMGET", "{here}1", "{here}2"); !strings.HasPrefix(got, "-TRYAGAIN") {
+		t.Errorf("VMGET of a key moved and one not = %q, want TRYAGAIN", got)
+	}
+
+	// A slot being imported is only served after ASKING
+	if err := clusterState.SetImporting(there, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.do("VGET", "{there}1"); !strings.HasPrefix(got, "-MOVED") {
+		t.Errorf("VGET of an importing slot = %q, want MOVED", got)
+	}
+	c.do("ASKING")
+	if got := c.do("VGET", "{there}1"); strings.HasPrefix(got, "-") {
+		t.Errorf("VGET of an importing slot after ASKING = %q", got)
+	}
+	if got := c.do("VGET", "{there}1"); !strings.HasPrefix(got, "-MOVED") {
+		t.Errorf("ASKING applied to a second command: %q", got)
+	}
+}
+
+// fakeTarget accepts connections and records the commands it gets,
+// replying as a node would. onSet, if set, runs before VSET replies.
+type fakeTarget struct {
+	net.Listener
+	onSet func(key string)
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func newFakeTarget(t *testing.T) *fakeTarget {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	f := &fakeTarget{Listener: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeTarget) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r, w := protocol.NewRESPReader(conn), protocol.NewRESPWriter(conn)
+	for {
+		cmd, err := r.ReadCommandBytes()
+		if err != nil {
+			return
+		}
+		name := string(cmd[0])
+		if name != "ASKING" {
+			f.mu.Lock()
+			f.cmds = append(f.cmds, name+" "+string(cmd[1]))
+			f.mu.Unlock()
+		}
+		switch name {
+		case "VSET":
+			if f.onSet != nil {
+				f.onSet(string(cmd[1]))
+			}
+			_ = w.WriteSimpleString("OK")
+		case "VDEL":
+			_ = w.WriteInteger(1)
+		default:
+			_ = w.WriteSimpleString("OK")
+		}
+		_ = w.Flush()
+	}
+}
+
+func (f *fakeTarget) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.cmds...)
+}
+
+func TestMigrate(t *testing.T) {
+	target := newFakeTarget(t)
+	newTestCluster(t, target.Addr().String())
+	host, port, _ := net.SplitHostPort(target.Addr().String())
+
+	c := newTestClient()
+	for _, key := range []string{"{here}kept", "{here}deleted", "{here}moved", "{here}written"} {
+		c.do("VSET", key, "[1,0]")
+	}
+	// Change two keys here while they are on their way
+	target.onSet = func(key string) {
+		if key == "{here}written" {
+			_, _ = store.Set("{here}written", []float32{0, 1})
+			store.Delete("{here}deleted")
+		}
+	}
+
+	got := c.do("MIGRATE", host, port, "", "1000", "COPY", "KEYS", "{here}kept")
+	if got != "+OK\r\n" {
+		t.Fatalf("MIGRATE COPY = %q", got)
+	}
+	if !store.Exists("{here}kept") {
+		t.Error("MIGRATE COPY deleted the key here")
+	}
+
+	got = c.do("MIGRATE", host, port, "", "1000", "REPLACE", "KEYS", "{here}deleted", "{here}moved", "{here}written", "{here}missing")
+	if !strings.HasPrefix(got, "-TRYAGAIN 1 keys") {
+		t.Errorf("MIGRATE = %q, want TRYAGAIN for the key written meanwhile", got)
+	}
+	if store.Exists("{here}moved") {
+		t.Error("a migrated key is still here")
+	}
+	if _, ok := store.Get("{here}written"); !ok {
+		t.Error("a key written while migrated was deleted")
+	}
+
+	want := []string{"VSET {here}kept", "VSET {here}deleted", "VSET {here}moved", "VSET {here}written", "VDEL {here}deleted"}
+	if got := target.commands(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("target got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uzqw/vex/internal/acl"
+)
+
+// command describes a RESP command: its handler and the access it requires
+type command struct {
+	handler func(c *client, cmd [][]byte)
+
+	// category is the ACL category a user needs to run the command.
+	// categoryOf overrides it for commands whose subcommands differ.
+	category   acl.Category
+	categoryOf func(cmd [][]byte) acl.Category
+
+	// firstKey, lastKey and keyStep locate the key arguments, as in Redis'
+	// COMMAND INFO. firstKey 0 means no keys; lastKey -1 means the last argument.
+	firstKey, lastKey, keyStep int
+
+	// allKeys marks commands that act on the whole keyspace, such as CLEAR,
+	// and may only be run by users with access to every key
+	allKeys bool
+
+	// noAuth marks commands that may run before the connection authenticates
+	noAuth bool
+
+	// noQueue marks commands that run immediately inside MULTI rather than
+	// being queued, such as EXEC itself
+	noQueue bool
+
+	// subscribed marks the commands allowed while the connection is in
+	// pub/sub mode
+	subscribed bool
+
+	// write marks commands that change the stored vectors, which replicas
+	// refuse
+	write bool
+
+	// noRaft marks write commands refused in raft mode, such as MIGRATE,
+	// whose effects outside this server would be repeated by every node
+	// applying the log
+	noRaft bool
+}
+
+// commands is the command table, keyed by upper case command name. It is
+// filled in init so handlers can dispatch through processCommand without
+// creating an initialization cycle.
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"PING":      {handler: handlePing, category: acl.CategoryConnection, noAuth: true, subscribed: true},
+		"ECHO":      {handler: handleEcho, category: acl.CategoryConnection},
+		"AUTH":      {handler: handleAuth, category: acl.CategoryConnection, noAuth: true},
+		"HELLO":     {handler: handleHello, category: acl.CategoryConnection, noAuth: true},
+		"QUIT":      {handler: handleQuit, category: acl.CategoryConnection, noAuth: true, noQueue: true, subscribed: true},
+		"MULTI":     {handler: handleMulti, category: acl.CategoryConnection, noQueue: true},
+		"EXEC":      {handler: handleExec, category: acl.CategoryConnection, noQueue: true},
+		"DISCARD":   {handler: handleDiscard, category: acl.CategoryConnection, noQueue: true},
+		"WATCH":     {handler: handleWatch, category: acl.CategoryRead, firstKey: 1, lastKey: -1, keyStep: 1, noQueue: true},
+		"UNWATCH":   {handler: handleUnwatch, category: acl.CategoryConnection, noQueue: true},
+		"VSET":      {handler: handleVSet, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"VGET":      {handler: handleVGet, category: acl.CategoryRead, firstKey: 1, lastKey: 1, keyStep: 1},
+		"VDEL":      {handler: handleVDel, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"VFLUSH":    {handler: handleVFlush, category: acl.CategoryWrite},
+		"VASYNC":    {handler: handleVAsync, category: acl.CategoryConnection},
+		"VMSET":     {handler: handleVMSet, category: acl.CategoryWrite, firstKey: 1, lastKey: -1, keyStep: 2, write: true},
+		"VMGET":     {handler: handleVMGet, category: acl.CategoryRead, firstKey: 1, lastKey: -1, keyStep: 1},
+		"EXPIRE":    {handler: handleExpire, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"PEXPIRE":   {handler: handleExpire, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"EXPIREAT":  {handler: handleExpireAt, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"PEXPIREAT": {handler: handleExpireAt, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"PERSIST":   {handler: handlePersist, category: acl.CategoryWrite, firstKey: 1, lastKey: 1, keyStep: 1, write: true},
+		"TTL":       {handler: handleTTL, category: acl.CategoryRead, firstKey: 1, lastKey: 1, keyStep: 1},
+		"PTTL":      {handler: handleTTL, category: acl.CategoryRead, firstKey: 1, lastKey: 1, keyStep: 1},
+		"SCAN":      {handler: handleScan, category: acl.CategoryRead},
+		"EXISTS":    {handler: handleExists, category: acl.CategoryRead, firstKey: 1, lastKey: -1, keyStep: 1},
+		"DBSIZE":    {handler: handleDBSize, category: acl.CategoryRead},
+		"RENAME":    {handler: handleRename, category: acl.CategoryWrite, firstKey: 1, lastKey: 2, keyStep: 1, write: true},
+		"COPY":      {handler: handleCopy, category: acl.CategoryWrite, firstKey: 1, lastKey: 2, keyStep: 1, write: true},
+		"TYPE":      {handler: handleType, category: acl.CategoryRead, firstKey: 1, lastKey: 1, keyStep: 1},
+		"RANDOMKEY": {handler: handleRandomKey, category: acl.CategoryRead},
+		"VSEARCH":   {handler: handleVSearch, category: acl.CategorySearch},
+		"STATS":     {handler: handleStats, category: acl.CategoryAdmin},
+		"INFO":      {handler: handleInfo, category: acl.CategoryAdmin},
+		"CLEAR":     {handler: handleClear, category: acl.CategoryAdmin, allKeys: true, write: true},
+		"ACL":       {handler: handleACL, categoryOf: aclCategory},
+		"REPLICAOF": {handler: handleReplicaOf, category: acl.CategoryAdmin},
+		"ROLE":      {handler: handleRole, category: acl.CategoryAdmin},
+		"REPLCONF":  {handler: handleReplConf, category: acl.CategoryAdmin},
+		"PSYNC":     {handler: handlePSync, category: acl.CategoryAdmin, allKeys: true},
+		"RAFT":      {handler: handleRaft, category: acl.CategoryAdmin, allKeys: true},
+		"CLUSTER":   {handler: handleCluster, categoryOf: clusterCategory},
+		"ASKING":    {handler: handleAsking, category: acl.CategoryConnection},
+		"MIGRATE":   {handler: handleMigrate, category: acl.CategoryWrite, write: true, noRaft: true},
+
+		"SUBSCRIBE":    {handler: handleSubscribe, category: acl.CategoryPubSub, subscribed: true},
+		"PSUBSCRIBE":   {handler: handleSubscribe, category: acl.CategoryPubSub, subscribed: true},
+		"UNSUBSCRIBE":  {handler: handleUnsubscribe, category: acl.CategoryPubSub, subscribed: true},
+		"PUNSUBSCRIBE": {handler: handleUnsubscribe, category: acl.CategoryPubSub, subscribed: true},
+		"PUBLISH":      {handler: handlePublish, category: acl.CategoryPubSub},
+
+		// The change log names every key written, so reading it needs
+		// access to all of them
+		"CLREAD":      {handler: handleCLRead, category: acl.CategoryRead, allKeys: true},
+		"CLGROUP":     {handler: handleCLGroup, category: acl.CategoryRead, allKeys: true},
+		"CLREADGROUP": {handler: handleCLReadGroup, category: acl.CategoryRead, allKeys: true},
+		"CLACK":       {handler: handleCLAck, category: acl.CategoryRead, allKeys: true},
+		"CLINFO":      {handler: handleCLInfo, category: acl.CategoryRead, allKeys: true},
+	}
+}
+
+// keys returns the key arguments of cmd according to the spec
+func (spec *command) keys(cmd [][]byte) [][]byte {
+	if spec.firstKey == 0 || spec.firstKey >= len(cmd) {
+		return nil
+	}
+	last := spec.lastKey
+	if last < 0 || last >= len(cmd) {
+		last = len(cmd) - 1
+	}
+	step := max(spec.keyStep, 1)
+
+	keys := make([][]byte, 0, (last-spec.firstKey)/step+1)
+	for i := spec.firstKey; i <= last; i += step {
+		keys = append(keys, cmd[i])
+	}
+	return keys
+}
+
+// checkAccess verifies that the user may run cmd, returning the NOPERM
+// message if not
+func checkAccess(u *acl.User, spec *command, cmd [][]byte) (string, bool) {
+	category := spec.category
+	if spec.categoryOf != nil {
+		category = spec.categoryOf(cmd)
+	}
+
+	if !u.CanRun(category) {
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command", u.Name, strings.ToLower(string(cmd[0]))), false
+	}
+
+	if u.AllKeys() {
+		return "", true
+	}
+	if spec.allKeys {
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command on all keys", u.Name, strings.ToLower(string(cmd[0]))), false
+	}
+	for _, key := range spec.keys(cmd) {
+		if !u.CanAccessKey(string(key)) {
+			return "No permissions to access a key", false
+		}
+	}
+	return "", true
+}
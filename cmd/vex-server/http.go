@@ -0,0 +1,380 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/metrics"
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/internal/vector"
+)
+
+// maxHTTPBodyBytes bounds request bodies so a single upload can't exhaust memory
+const maxHTTPBodyBytes = 64 << 20
+
+// vectorRequest is the body of PUT /v1/vectors/{key}
+type vectorRequest struct {
+	Vector []float32 `json:"vector"`
+}
+
+// vectorResponse is the body returned by GET /v1/vectors/{key}
+type vectorResponse struct {
+	Key    string    `json:"key"`
+	Vector []float32 `json:"vector"`
+}
+
+// searchRequest is the body of POST /v1/search
+type searchRequest struct {
+	Vector []float32 `json:"vector"`
+	K      int       `json:"k"`
+}
+
+// searchResult is a single entry of a search response
+type searchResult struct {
+	Key        string  `json:"key"`
+	Similarity float32 `json:"similarity"`
+}
+
+// searchResponse is the body returned by POST /v1/search
+type searchResponse struct {
+	Results []searchResult `json:"results"`
+}
+
+// errorResponse is the body returned for any non-2xx status
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// userContextKey stores the authenticated *acl.User in the request context
+type userContextKey struct{}
+
+// newHTTPHandler builds the REST API routes. Handlers operate on the same
+// storage and metrics as the RESP commands.
+func newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/vectors/{key}", httpUpsert)
+	mux.HandleFunc("GET /v1/vectors/{key}", httpGet)
+	mux.HandleFunc("DELETE /v1/vectors/{key}", httpDelete)
+	mux.HandleFunc("POST /v1/search", httpSearch)
+	mux.HandleFunc("GET /v1/stats", httpStats)
+	return withRequestContext(mux)
+}
+
+// withRequestContext counts each request as a command, tags it with a request
+// ID and authenticates it against the ACL users, mirroring what
+// handleConnection and processCommand do for RESP clients
+func withRequestContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := uuid.New().String()
+		w.Header().Set("X-Request-ID", requestID)
+		reqLog := log.WithRequestID(r.Context(), requestID)
+
+		metrics.Global().IncrementCommands()
+
+		u, ok := httpAuthenticate(r)
+		if !ok {
+			metrics.Global().IncrementAuthFailures()
+			reqLog.Warn("authentication failed", slog.String("remote", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", `Basic realm="vex"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, u)))
+
+		reqLog.Debug("http request served",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote", r.RemoteAddr),
+			slog.Duration("latency", time.Since(start)),
+		)
+	})
+}
+
+// httpAuthenticate resolves the ACL user for a request. Credentials may be
+// given as HTTP basic auth, or as a bearer token holding the default user's
+// password. Requests without credentials run as the default user if it
+// needs no password.
+func httpAuthenticate(r *http.Request) (*acl.User, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		return users.Authenticate(username, password)
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return users.Authenticate(acl.DefaultUser, token)
+	}
+	if u, ok := users.User(acl.DefaultUser); ok && u.Enabled && u.NoPass() {
+		return u, true
+	}
+	return nil, false
+}
+
+// httpAllow checks that the request's user may use the given category and
+// key, writing a 403 if not. An empty key skips the key check.
+func httpAllow(w http.ResponseWriter, r *http.Request, category acl.Category, key string) (*acl.User, bool) {
+	u := r.Context().Value(userContextKey{}).(*acl.User)
+	if !u.CanRun(category) || (key != "" && !u.CanAccessKey(key)) {
+		writeJSONError(w, http.StatusForbidden, "no permissions to perform this request")
+		return nil, false
+	}
+	return u, true
+}
+
+// httpWritable checks that the server accepts writes, writing a 421 if it
+// is a read-only replica
+func httpWritable(w http.ResponseWriter) bool {
+	if replicating() {
+		writeJSONError(w, http.StatusMisdirectedRequest, "writes are not accepted by a read only replica")
+		return false
+	}
+	return true
+}
+
+// httpRoute checks that this node serves key in cluster mode, as
+// routeCommand does, writing a 421 naming the node that serves it, a 307
+// naming the node to ask while its slot moves, or a 503. An X-Asking header
+// stands in for ASKING. When it returns true the caller handles the request
+// and then calls done.
+func httpRoute(w http.ResponseWriter, r *http.Request, key string) (done func(), ok bool) {
+	if clusterState == nil {
+		return func() {}, true
+	}
+	migrateMu.RLock()
+	err := routeKeys(db, [][]byte{[]byte(key)}, r.Header.Get("X-Asking") != "")
+	if err == nil {
+		return migrateMu.RUnlock, true
+	}
+	migrateMu.RUnlock()
+
+	status := http.StatusServiceUnavailable
+	switch err.code {
+	case "MOVED":
+		status = http.StatusMisdirectedRequest
+	case "ASK":
+		status = http.StatusTemporaryRedirect
+	}
+	writeJSONError(w, status, err.code+" "+err.msg)
+	return nil, false
+}
+
+// serveHTTP runs the REST API on the given listener until ctx is cancelled,
+// then waits for in-flight requests to complete
+func serveHTTP(ctx context.Context, listener net.Listener) {
+	srv := &http.Server{
+		Handler:           newHTTPHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				metrics.Global().IncrementActiveConnections()
+			case http.StateClosed, http.StateHijacked:
+				metrics.Global().DecrementActiveConnections()
+			}
+		},
+	}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("http server stopped", slog.String("error", err.Error()))
+		}
+	}()
+
+	// Let in-flight requests finish before returning
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Warn("http shutdown incomplete", slog.String("error", err.Error()))
+	}
+}
+
+// httpUpsert handles PUT /v1/vectors/{key}
+func httpUpsert(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := httpAllow(w, r, acl.CategoryWrite, key); !ok || !httpWritable(w) {
+		return
+	}
+
+	var req vectorRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Vector) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "vector is required")
+		return
+	}
+	// Routed once the body is read, so a slow upload doesn't hold up MIGRATE
+	done, ok := httpRoute(w, r, key)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if raftNode != nil {
+		if _, ok := proposeHTTP(w, r, "VSET", key, formatVector(req.Vector)); ok {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	res, err := db.SetWithOptions(key, req.Vector, storage.SetOptions{})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	recordWrite(key, res.Inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpGet handles GET /v1/vectors/{key}
+func httpGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := httpAllow(w, r, acl.CategoryRead, key); !ok {
+		return
+	}
+	done, ok := httpRoute(w, r, key)
+	if !ok {
+		return
+	}
+	defer done()
+
+	values, ok := db.Get(key)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, vectorResponse{Key: key, Vector: values})
+}
+
+// httpDelete handles DELETE /v1/vectors/{key}
+func httpDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := httpAllow(w, r, acl.CategoryWrite, key); !ok || !httpWritable(w) {
+		return
+	}
+	done, ok := httpRoute(w, r, key)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if raftNode != nil {
+		reply, ok := proposeHTTP(w, r, "VDEL", key)
+		switch {
+		case ok && string(reply) == ":0\r\n":
+			writeJSONError(w, http.StatusNotFound, "key not found")
+		case ok:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if !db.Delete(key) {
+		writeJSONError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	recordDelete(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpSearch handles POST /v1/search
+func httpSearch(w http.ResponseWriter, r *http.Request) {
+	u, ok := httpAllow(w, r, acl.CategorySearch, "")
+	if !ok {
+		return
+	}
+
+	var req searchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.K <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "k must be positive")
+		return
+	}
+
+	results, err := search(req.Vector, req.K, u)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	resp := searchResponse{Results: make([]searchResult, len(results))}
+	for i, res := range results {
+		resp.Results[i] = searchResult{Key: res.Key, Similarity: res.Similarity}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// httpStats handles GET /v1/stats
+func httpStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := httpAllow(w, r, acl.CategoryAdmin, ""); !ok {
+		return
+	}
+	updateStorageMetrics()
+	writeJSON(w, http.StatusOK, metrics.Global().Snapshot())
+}
+
+// decodeJSON decodes the request body into v, writing a 400 on failure
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeStorageError maps storage errors onto HTTP status codes
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrDimensionMismatch), errors.Is(err, vector.ErrZeroVector):
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, storage.ErrOutOfMemory):
+		writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug("failed to write http response", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/ingest"
+	"github.com/uzqw/vex/internal/pubsub"
+	"github.com/uzqw/vex/internal/replication"
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/pkg/logger"
+)
+
+// newTestAPI initializes the server state that setup would, with an empty
+// store and the given rules for the default user, and returns the REST API
+func newTestAPI(t *testing.T, defaultRules ...string) http.Handler {
+	t.Helper()
+	log = logger.New(logger.Config{Level: slog.LevelError})
+
+	var err error
+	users, err = acl.NewRegistry(defaultRules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = storage.New()
+	db = store
+	broker = pubsub.NewBroker()
+	writeQueue = ingest.New(store, ingest.Config{})
+	t.Cleanup(writeQueue.Close)
+	return newHTTPHandler()
+}
+
+// serve sends a request to the API with an optional JSON body
+func serve(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// checkError checks that a response has the status and a JSON error body
+// containing want
+func checkError(t *testing.T, rec *httptest.ResponseRecorder, status int, want string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (body %q)", rec.Code, status, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var resp errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error body %q is not JSON: %v", rec.Body.String(), err)
+	}
+	if !strings.Contains(resp.Error, want) {
+		t.Errorf("error = %q, want it to contain %q", resp.Error, want)
+	}
+}
+
+func TestHTTPUpsert(t *testing.T) {
+	h := newTestAPI(t)
+
+	rec := serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [3, 4]}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 (body %q)", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Error("response has no X-Request-ID")
+	}
+	if _, ok := store.Get("doc:1"); !ok {
+		t.Fatal("doc:1 was not stored")
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"malformed JSON", `{"vector": [1, 2`, http.StatusBadRequest, "invalid JSON body"},
+		{"unknown field", `{"vector": [1, 2], "ttl": 5}`, http.StatusBadRequest, "unknown field"},
+		{"wrong type", `{"vector": "1, 2"}`, http.StatusBadRequest, "invalid JSON body"},
+		{"no vector", `{}`, http.StatusBadRequest, "vector is required"},
+		{"empty vector", `{"vector": []}`, http.StatusBadRequest, "vector is required"},
+		{"dimension mismatch", `{"vector": [1, 2, 3]}`, http.StatusUnprocessableEntity, "dimension mismatch"},
+		{"zero vector", `{"vector": [0, 0]}`, http.StatusUnprocessableEntity, "zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, serve(h, "PUT", "/v1/vectors/doc:2", tt.body), tt.status, tt.want)
+		})
+	}
+	if _, ok := store.Get("doc:2"); ok {
+		t.Error("a rejected upsert stored doc:2")
+	}
+}
+
+func TestHTTPUpsertReplica(t *testing.T) {
+	h := newTestAPI(t)
+	replica.Store(&replication.Replica{})
+	t.Cleanup(func() { replica.Store(nil) })
+
+	checkError(t, serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [1, 2]}`), http.StatusMisdirectedRequest, "read only replica")
+	checkError(t, serve(h, "DELETE", "/v1/vectors/doc:1", ""), http.StatusMisdirectedRequest, "read only replica")
+}
+
+func TestHTTPUpsertOutOfMemory(t *testing.T) {
+	h := newTestAPI(t)
+	store.SetMaxMemory(1, storage.NoEviction)
+
+	checkError(t, serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [1, 2]}`), http.StatusInsufficientStorage, "memory")
+}
+
+func TestHTTPGet(t *testing.T) {
+	h := newTestAPI(t)
+	serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [3, 4]}`)
+
+	rec := serve(h, "GET", "/v1/vectors/doc:1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var resp vectorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Key != "doc:1" || len(resp.Vector) != 2 || resp.Vector[0] != 0.6 || resp.Vector[1] != 0.8 {
+		t.Errorf("body = %+v, want doc:1 with the normalized vector [0.6 0.8]", resp)
+	}
+
+	checkError(t, serve(h, "GET", "/v1/vectors/missing", ""), http.StatusNotFound, "key not found")
+}
+
+func TestHTTPDelete(t *testing.T) {
+	h := newTestAPI(t)
+	serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [1, 2]}`)
+
+	if rec := serve(h, "DELETE", "/v1/vectors/doc:1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 (body %q)", rec.Code, rec.Body.String())
+	}
+	if _, ok := store.Get("doc:1"); ok {
+		t.Error("doc:1 still stored after DELETE")
+	}
+	checkError(t, serve(h, "DELETE", "/v1/vectors/doc:1", ""), http.StatusNotFound, "key not found")
+}
+
+func TestHTTPSearch(t *testing.T) {
+	h := newTestAPI(t)
+	serve(h, "PUT", "/v1/vectors/near", `{"vector": [1, 0.1]}`)
+	serve(h, "PUT", "/v1/vectors/far", `{"vector": [0, 1]}`)
+	serve(h, "PUT", "/v1/vectors/same", `{"vector": [1, 0]}`)
+
+	rec := serve(h, "POST", "/v1/search", `{"vector": [1, 0], "k": 2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var resp searchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Key != "same" || resp.Results[1].Key != "near" {
+		t.Fatalf("results = %+v, want same then near", resp.Results)
+	}
+	if s := resp.Results[0].Similarity; s < 0.999 || s > 1.001 {
+		t.Errorf("similarity of same = %v, want 1", s)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"malformed JSON", `{"vector": [1, 0], "k": }`, http.StatusBadRequest, "invalid JSON body"},
+		{"unknown field", `{"vector": [1, 0], "k": 2, "filter": "a*"}`, http.StatusBadRequest, "unknown field"},
+		{"no k", `{"vector": [1, 0]}`, http.StatusBadRequest, "k must be positive"},
+		{"negative k", `{"vector": [1, 0], "k": -1}`, http.StatusBadRequest, "k must be positive"},
+		{"dimension mismatch", `{"vector": [1, 0, 0], "k": 2}`, http.StatusUnprocessableEntity, "dimension mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, serve(h, "POST", "/v1/search", tt.body), tt.status, tt.want)
+		})
+	}
+}
+
+func TestHTTPStats(t *testing.T) {
+	h := newTestAPI(t)
+	serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [1, 2]}`)
+
+	rec := serve(h, "GET", "/v1/stats", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var stats map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["total_keys"] != float64(1) {
+		t.Errorf("total_keys = %v, want 1", stats["total_keys"])
+	}
+}
+
+func TestHTTPCluster(t *testing.T) {
+	here, there := newTestCluster(t, "127.0.0.1:7002")
+	h := newHTTPHandler()
+
+	if rec := serve(h, "PUT", "/v1/vectors/{here}1", `{"vector": [1, 2]}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT of a key served here: status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	moved := fmt.Sprintf("MOVED %d 127.0.0.1:7002", there)
+	checkError(t, serve(h, "GET", "/v1/vectors/{there}1", ""), http.StatusMisdirectedRequest, moved)
+	checkError(t, serve(h, "PUT", "/v1/vectors/{there}1", `{"vector": [1, 2]}`), http.StatusMisdirectedRequest, moved)
+	checkError(t, serve(h, "DELETE", "/v1/vectors/{there}1", ""), http.StatusMisdirectedRequest, moved)
+	checkError(t, serve(h, "GET", "/v1/vectors/{nowhere}1", ""), http.StatusServiceUnavailable, "CLUSTERDOWN")
+
+	if err := clusterState.SetMigrating(here, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(h, "GET", "/v1/vectors/{here}1", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET of a key not moved yet: status = %d, want 200", rec.Code)
+	}
+	checkError(t, serve(h, "PUT", "/v1/vectors/{here}2", `{"vector": [1, 2]}`), http.StatusTemporaryRedirect, fmt.Sprintf("ASK %d 127.0.0.1:7002", here))
+
+	if err := clusterState.SetImporting(there, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(h, "PUT", "/v1/vectors/{there}1", `{"vector": [1, 2]}`, "X-Asking", "1"); rec.Code != http.StatusNoContent {
+		t.Errorf("PUT with X-Asking to an importing slot: status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHTTPRoutes(t *testing.T) {
+	h := newTestAPI(t)
+
+	if rec := serve(h, "POST", "/v1/vectors/doc:1", `{"vector": [1, 2]}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /v1/vectors/doc:1 status = %d, want 405", rec.Code)
+	}
+	if rec := serve(h, "GET", "/v1/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /v1/unknown status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	h := newTestAPI(t, "on", ">secret", "allcommands", "allkeys")
+	if err := users.SetUser("reader", "on", ">pw", "+@read", "~doc:*"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(h, "GET", "/v1/vectors/doc:1", "")
+	checkError(t, rec, http.StatusUnauthorized, "authentication required")
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("401 response has no WWW-Authenticate header")
+	}
+	checkError(t, serve(h, "GET", "/v1/vectors/doc:1", "", "Authorization", "Bearer wrong"), http.StatusUnauthorized, "authentication required")
+
+	if rec := serve(h, "PUT", "/v1/vectors/doc:1", `{"vector": [1, 2]}`, "Authorization", "Bearer secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT with the bearer token: status = %d, want 204 (body %q)", rec.Code, rec.Body.String())
+	}
+
+	basic := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth("reader", "pw")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := basic("GET", "/v1/vectors/doc:1", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET as reader: status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	checkError(t, basic("GET", "/v1/vectors/other", ""), http.StatusForbidden, "no permissions")
+	checkError(t, basic("PUT", "/v1/vectors/doc:2", `{"vector": [1, 2]}`), http.StatusForbidden, "no permissions")
+	checkError(t, basic("DELETE", "/v1/vectors/doc:1", ""), http.StatusForbidden, "no permissions")
+	checkError(t, basic("POST", "/v1/search", `{"vector": [1, 2], "k": 1}`), http.StatusForbidden, "no permissions")
+	checkError(t, basic("GET", "/v1/stats", ""), http.StatusForbidden, "no permissions")
+}
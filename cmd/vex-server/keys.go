@@ -0,0 +1,179 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/uzqw/vex/internal/glob"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// defaultScanCount is the number of keys SCAN examines when COUNT is omitted
+const defaultScanCount = 10
+
+// handleScan handles the SCAN command: SCAN cursor [MATCH pattern] [COUNT count]
+// Keys the user may not access are left out, as VSEARCH does.
+func handleScan(c *client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'scan' command")
+		return
+	}
+
+	cursor, err := strconv.ParseUint(string(cmd[1]), 10, 64)
+	if err != nil {
+		_ = c.writer.WriteError("invalid cursor")
+		return
+	}
+
+	count := defaultScanCount
+	pattern := ""
+	for i := 2; i < len(cmd); i += 2 {
+		if i+1 == len(cmd) {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		switch strings.ToUpper(string(cmd[i])) {
+		case "MATCH":
+			pattern = string(cmd[i+1])
+		case "COUNT":
+			count, err = strconv.Atoi(string(cmd[i+1]))
+			if err != nil || count < 1 {
+				_ = c.writer.WriteError("value is out of range, must be positive")
+				return
+			}
+		default:
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+	}
+
+	u := c.user()
+	var match func(string) bool
+	switch {
+	case pattern != "" && pattern != "*" && !u.AllKeys():
+		match = func(key string) bool { return glob.Match(pattern, key) && u.CanAccessKey(key) }
+	case pattern != "" && pattern != "*":
+		match = func(key string) bool { return glob.Match(pattern, key) }
+	case !u.AllKeys():
+		match = u.CanAccessKey
+	}
+
+	keys, next := c.db.Scan(cursor, count, match)
+
+	_ = c.writer.WriteArrayHeader(2)
+	_ = c.writer.WriteBulkString(strconv.FormatUint(next, 10))
+	_ = c.writer.WriteArray(keys)
+}
+
+// handleExists handles the EXISTS command: EXISTS key [key ...]
+// A key given more than once is counted each time, as in Redis.
+func handleExists(c *client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'exists' command")
+		return
+	}
+
+	var n int64
+	for _, key := range cmd[1:] {
+		if c.db.Exists(string(key)) {
+			n++
+		}
+	}
+	_ = c.writer.WriteInteger(n)
+}
+
+// handleDBSize handles the DBSIZE command
+func handleDBSize(c *client, _ [][]byte) {
+	_ = c.writer.WriteInteger(int64(c.db.Count()))
+}
+
+// handleRename handles the RENAME command: RENAME key newkey
+func handleRename(c *client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'rename' command")
+		return
+	}
+
+	if err := c.db.Rename(string(cmd[1]), string(cmd[2])); err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	notifyKeyspaceEvent(notifyGeneric, "rename_from", string(cmd[1]))
+	notifyKeyspaceEvent(notifyGeneric, "rename_to", string(cmd[2]))
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleCopy handles the COPY command: COPY source destination [REPLACE]
+func handleCopy(c *client, cmd [][]byte) {
+	if len(cmd) != 3 && len(cmd) != 4 {
+		_ = c.writer.WriteError("wrong number of arguments for 'copy' command")
+		return
+	}
+	replace := false
+	if len(cmd) == 4 {
+		if !strings.EqualFold(string(cmd[3]), "REPLACE") {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		replace = true
+	}
+
+	copied, err := c.db.Copy(string(cmd[1]), string(cmd[2]), replace)
+	switch {
+	case errors.Is(err, storage.ErrNoSuchKey):
+		_ = c.writer.WriteInteger(0)
+	case err != nil:
+		writeStorageErrorRESP(c, err)
+	case copied:
+		notifyKeyspaceEvent(notifyGeneric, "copy_to", string(cmd[2]))
+		_ = c.writer.WriteInteger(1)
+	default:
+		_ = c.writer.WriteInteger(0)
+	}
+}
+
+// handleType handles the TYPE command: TYPE key
+// Every key holds a vector, so the reply is either "vector" or "none".
+func handleType(c *client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'type' command")
+		return
+	}
+
+	if c.db.Exists(string(cmd[1])) {
+		_ = c.writer.WriteSimpleString("vector")
+	} else {
+		_ = c.writer.WriteSimpleString("none")
+	}
+}
+
+// handleRandomKey handles the RANDOMKEY command, only choosing among keys the
+// user may access
+func handleRandomKey(c *client, _ [][]byte) {
+	var match func(string) bool
+	if u := c.user(); !u.AllKeys() {
+		match = u.CanAccessKey
+	}
+
+	key, ok := c.db.RandomKey(match)
+	if !ok {
+		_ = c.writer.WriteNull()
+		return
+	}
+	_ = c.writer.WriteBulkString(key)
+}
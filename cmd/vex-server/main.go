@@ -16,46 +16,117 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/changelog"
+	"github.com/uzqw/vex/internal/ingest"
 	"github.com/uzqw/vex/internal/metrics"
+	"github.com/uzqw/vex/internal/percore"
 	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/pubsub"
+	"github.com/uzqw/vex/internal/replication"
 	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/internal/vector"
 	"github.com/uzqw/vex/pkg/logger"
 )
 
 const (
 	defaultPort = "6379"
 	defaultHost = "0.0.0.0"
+
+	// expirySweepInterval is how often each shard looks for expired keys,
+	// matching Redis' default hz of 10
+	expirySweepInterval = 100 * time.Millisecond
 )
 
 var (
-	host      = flag.String("host", defaultHost, "Host to bind to")
-	port      = flag.String("port", defaultPort, "Port to listen on")
-	logFormat = flag.String("log-format", "text", "Log format: text or json")
-	logLevel  = flag.String("log-level", "info", "Log level: debug, info, warn, error")
-	showVer   = flag.Bool("version", false, "Show version and exit")
-	store     *storage.Storage
-	log       *logger.Logger
+	host        = flag.String("host", defaultHost, "Host to bind to")
+	port        = flag.String("port", defaultPort, "Port to listen on (0 picks a free port)")
+	tcp         = flag.Bool("tcp", true, "Accept TCP connections on -host and -port (false to serve only TLS or a Unix socket)")
+	logFormat   = flag.String("log-format", "text", "Log format: text or json")
+	logLevel    = flag.String("log-level", "info", "Log level: debug, info, warn, error")
+	requirePass = flag.String("requirepass", "", "Require clients to authenticate with this password (disabled if empty)")
+	httpAddr    = flag.String("http-addr", "", "Address for the HTTP/JSON API, e.g. :8080 (disabled if empty)")
+	showVer     = flag.Bool("version", false, "Show version and exit")
+	aclFile     = flag.String("aclfile", "", "Load ACL users from this file (see ACL LOAD)")
+	store       *storage.Storage
+	users       *acl.Registry
+	log         *logger.Logger
+
+	unixSocket     = flag.String("unixsocket", "", "Path of a Unix domain socket to listen on (disabled if empty)")
+	unixSocketPerm = flag.String("unixsocketperm", "700", "Permission bits for the Unix socket, in octal")
+
+	tlsPort        = flag.String("tls-port", "", "Port to accept TLS connections on (disabled if empty)")
+	tlsCert        = flag.String("tls-cert", "", "Server certificate file (PEM)")
+	tlsKey         = flag.String("tls-key", "", "Server private key file (PEM)")
+	tlsCACert      = flag.String("tls-ca-cert", "", "CA certificate file (PEM) used to verify client certificates")
+	tlsMinVersion  = flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
+	tlsAuthClients = flag.String("tls-auth-clients", "no", "Client certificate policy: no, optional or yes")
+
+	shards          = flag.Int("shards", storage.ShardCount, "Number of storage shards, from 1 to 1024 (in per-core mode the default is raised to the number of cores)")
+	maxMemory       = flag.String("maxmemory", "0", "Memory limit for stored vectors, e.g. 512mb or 2gb (0 for unlimited)")
+	maxMemoryPolicy = flag.String("maxmemory-policy", "noeviction", "Eviction policy: noeviction, allkeys-lru, allkeys-lfu, volatile-ttl or allkeys-random")
+
+	notifyEvents = flag.String("notify-keyspace-events", "", "Keyspace event classes to publish, e.g. KEA (disabled if empty)")
+	broker       *pubsub.Broker
+
+	replicaOf       = flag.String("replicaof", "", "Replicate the leader at this address, given as \"host port\" (disabled if empty)")
+	leaderUser      = flag.String("masteruser", "", "User to authenticate to the leader as (default user if empty)")
+	leaderAuth      = flag.String("masterauth", "", "Password to authenticate to the leader with (no authentication if empty)")
+	replBacklogSize = flag.String("repl-backlog-size", "16mb", "Memory kept of recent changes for replicas that reconnect")
+	leader          *replication.Leader
+
+	changelogMaxLen   = flag.Int("changelog-maxlen", 0, "Number of changes kept in the change log (0 for no limit)")
+	changelogMaxBytes = flag.String("changelog-maxbytes", "0", "Memory kept for the change log, e.g. 64mb (0 for no limit); the log is disabled unless a limit is set")
+	changes           *changelog.Log
+
+	raftID              = flag.String("raft-id", "", "Address of this node in its Raft group, as peers and clients reach it, e.g. 10.0.0.1:6379 (disabled if empty)")
+	raftPeers           = flag.String("raft-peers", "", "Comma separated addresses of the nodes in the Raft group, this one included or not")
+	raftDir             = flag.String("raft-dir", "", "Directory the Raft log and state are kept in")
+	raftElectionTimeout = flag.Duration("raft-election-timeout", time.Second, "Time without a leader before a Raft node starts an election")
+	raftSnapshotEvery   = flag.Uint64("raft-snapshot-threshold", 10000, "Entries applied between snapshots of the data, which replace the Raft log up to them")
+
+	clusterEnabled      = flag.Bool("cluster-enabled", false, "Serve a share of the hash slots as a node of a cluster")
+	clusterConfigFile   = flag.String("cluster-config-file", "nodes.conf", "File this node's view of the cluster is kept in")
+	clusterAnnounceAddr = flag.String("cluster-announce-addr", "", "Address other nodes and redirected clients reach this node at (default host:port, 127.0.0.1 for a wildcard host)")
+
+	executionMode = flag.String("execution-mode", "locking", "How searches reach the shards: locking (any goroutine, lock per shard) or per-core (fanned out to the cores owning the shards)")
+	cores         = flag.Int("cores", 0, "Number of cores in per-core execution mode (0 for GOMAXPROCS)")
+	db            keyspace
+
+	asyncBatchSize = flag.Int("async-batch-size", ingest.DefaultBatchSize, "Most asynchronous writes applied to a shard under one lock")
+	asyncQueueSize = flag.Int("async-queue-size", ingest.DefaultQueueSize, "Most asynchronous writes waiting per shard before VSET ASYNC blocks")
+	writeQueue     *ingest.Buffer
+
+	searchCoalesceWait = flag.Duration("search-coalesce-wait", 100*time.Millisecond, "Longest a search waits for an identical one in flight before running itself (0 disables coalescing)")
 
 	// Version is set at build time via ldflags
 	Version = "dev"
 )
 
-func init() {
+// setup parses the flags and initializes the logger, users, storage and the
+// other state shared by the connections. It runs from main rather than init so
+// that tests can build the package without the command line being parsed.
+func setup() {
 	// Customize usage output
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: vex-server [options]\n\n")
@@ -102,23 +173,211 @@ func init() {
 		Level:  level,
 	})
 
-	// Initialize storage
-	store = storage.New()
+	// Initialize ACL users; -requirepass sets the default user's password
+	var defaultRules []string
+	if *requirePass != "" {
+		defaultRules = []string{"on", ">" + *requirePass, "allcommands", "allkeys"}
+	}
+	var err error
+	users, err = acl.NewRegistry(defaultRules...)
+	if err != nil {
+		log.Error("invalid acl configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if *aclFile != "" {
+		if err := users.LoadFile(*aclFile); err != nil {
+			log.Error("failed to load acl file", slog.String("file", *aclFile), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	broker = pubsub.NewBroker()
+	notifyFlags, err = parseNotifyFlags(*notifyEvents)
+	if err != nil {
+		log.Error("invalid notify-keyspace-events", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Initialize storage. Each core owns at least one shard, so in per-core
+	// mode the default shard count grows with the number of cores.
+	shardCount := *shards
+	if *executionMode == "per-core" && !flagSet("shards") {
+		n := *cores
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		shardCount = min(max(shardCount, n), storage.MaxShards)
+	}
+	store, err = storage.NewWithConfig(storage.Config{Shards: shardCount})
+	if err != nil {
+		log.Error("invalid shards", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	store.OnExpire(func(key string) {
+		metrics.Global().IncrementExpiredKeys()
+		notifyKeyspaceEvent(notifyExpired, "expired", key)
+	})
+	store.OnEvict(func(key string) {
+		metrics.Global().IncrementEvictedKeys()
+		notifyKeyspaceEvent(notifyEvicted, "evicted", key)
+	})
+
+	limit, err := parseMemory(*maxMemory)
+	if err != nil {
+		log.Error("invalid maxmemory", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	policy, err := storage.ParseEvictionPolicy(strings.ToLower(*maxMemoryPolicy))
+	if err != nil {
+		log.Error("invalid maxmemory-policy", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	store.SetMaxMemory(limit, policy)
+
+	// The change log is bounded, so it is only kept if a limit is set
+	logBytes, err := parseMemory(*changelogMaxBytes)
+	if err != nil || *changelogMaxLen < 0 {
+		log.Error("invalid change log limit", slog.Int("maxlen", *changelogMaxLen), slog.String("maxbytes", *changelogMaxBytes))
+		os.Exit(1)
+	}
+	if *changelogMaxLen > 0 || logBytes > 0 {
+		changes = changelog.New(*changelogMaxLen, logBytes)
+	}
+
+	backlogSize, err := parseMemory(*replBacklogSize)
+	if err != nil || backlogSize == 0 {
+		log.Error("invalid repl-backlog-size", slog.String("size", *replBacklogSize))
+		os.Exit(1)
+	}
+	leader = replication.NewLeader(store, backlogSize)
+
+	store.OnChange(func(c storage.Change) {
+		writeGen.Add(1)
+		leader.Record(c)
+		if changes != nil {
+			changes.Append(c)
+		}
+	})
+
+	searches.MaxWait = *searchCoalesceWait
+	writeQueue = ingest.New(store, ingest.Config{
+		BatchSize: *asyncBatchSize,
+		QueueSize: *asyncQueueSize,
+		OnApply:   recordWrite,
+		OnError: func(key string, err error) {
+			log.Warn("async write failed", slog.String("key", key), slog.String("error", err.Error()))
+		},
+	})
+
+	switch *executionMode {
+	case "locking":
+		db = store
+	case "per-core":
+		engine := percore.New(store, *cores)
+		if *cores > engine.Cores() {
+			log.Warn("fewer cores than requested: each core needs a shard", slog.Int("cores", *cores), slog.Int("shards", store.Shards()))
+		}
+		log.Info("per-core execution enabled", slog.Int("cores", engine.Cores()), slog.Int("shards", store.Shards()))
+		db = engine
+	default:
+		log.Error("invalid execution-mode", slog.String("mode", *executionMode))
+		os.Exit(1)
+	}
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseMemory parses a byte count with an optional kb, mb or gb suffix
+func parseMemory(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		bytes  int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}} {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = rest, u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	return n * unit, nil
 }
 
 func main() {
-	addr := fmt.Sprintf("%s:%s", *host, *port)
-	log.Info("starting Vex server", slog.String("addr", addr))
+	setup()
 
-	// Start TCP listener
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Error("failed to start listener", slog.String("error", err.Error()))
+	var listeners []net.Listener
+
+	// Start TCP listener; -tcp=false disables it so the server can run on a
+	// Unix socket only
+	if *tcp {
+		addr := fmt.Sprintf("%s:%s", *host, *port)
+		log.Info("starting Vex server", slog.String("addr", addr))
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Error("failed to start listener", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		listeners = append(listeners, listener)
+
+		// With port 0 the system picks the port; record it for the cluster
+		// address and the port announced to a leader
+		*port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	// Start TLS listener
+	if *tlsPort != "" {
+		addr := fmt.Sprintf("%s:%s", *host, *tlsPort)
+		log.Info("starting Vex server", slog.String("tls_addr", addr))
+
+		tlsConfig, err := buildTLSConfig()
+		if err != nil {
+			log.Error("invalid tls configuration", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		listener, err := tls.Listen("tcp", addr, tlsConfig)
+		if err != nil {
+			log.Error("failed to start tls listener", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// Start Unix domain socket listener
+	if *unixSocket != "" {
+		log.Info("starting Vex server", slog.String("unixsocket", *unixSocket))
+
+		listener, err := listenUnix(*unixSocket, *unixSocketPerm)
+		if err != nil {
+			log.Error("failed to start unix socket listener", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		log.Error("no listeners configured: set -tcp, -tls-port or -unixsocket")
 		os.Exit(1)
 	}
-	defer func() { _ = listener.Close() }()
 
-	log.Info("server started successfully", slog.String("addr", addr))
+	for _, listener := range listeners {
+		log.Info("server started successfully", slog.String("addr", listener.Addr().String()))
+	}
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -132,24 +391,165 @@ func main() {
 		sig := <-sigChan
 		log.Info("received shutdown signal", slog.String("signal", sig.String()))
 		cancel()
-		_ = listener.Close()
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
 	}()
 
+	var wg sync.WaitGroup
+
+	// Start the optional HTTP/JSON API
+	if *httpAddr != "" {
+		httpListener, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			log.Error("failed to start http listener", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		log.Info("http api started", slog.String("addr", httpListener.Addr().String()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveHTTP(ctx, httpListener)
+		}()
+	}
+
+	// Follow the leader given on the command line
+	if *replicaOf != "" {
+		host, leaderPort, err := parseReplicaOf(strings.Fields(*replicaOf))
+		if err != nil {
+			log.Error("invalid replicaof", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		startReplica(host, leaderPort)
+	}
+
+	// Join the Raft group given on the command line
+	if *raftID != "" {
+		if err := startRaft(); err != nil {
+			log.Error("failed to start raft", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer raftNode.Stop()
+		log.Info("raft started", slog.String("id", *raftID), slog.String("peers", *raftPeers))
+	}
+
+	// Join the cluster as the node saved in the cluster config file
+	if *clusterEnabled {
+		if err := startCluster(ctx); err != nil {
+			log.Error("failed to start cluster mode", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		log.Info("cluster mode started", slog.String("id", clusterState.ID()), slog.String("addr", clusterState.Addr()))
+	}
+
 	// Start memory monitoring goroutine
 	go monitorMemory(ctx)
 
-	// Accept connections
+	// Reclaim expired keys that are never accessed again
+	if raftNode != nil {
+		go expireRaftKeys(ctx, expirySweepInterval)
+	} else {
+		store.RunExpirySweeper(ctx, expirySweepInterval)
+	}
+
+	// Accept connections on every listener
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			acceptLoop(ctx, listener)
+		}(listener)
+	}
+
+	wg.Wait()
+	writeQueue.Close()
+	log.Info("shutting down server")
+}
+
+// listenUnix listens on a Unix domain socket and applies the requested
+// permission bits. A stale socket file left behind by a previous run is
+// removed first, as long as it is actually a socket.
+//
+// The socket is created in a private temporary directory and only renamed
+// into place once its permissions are set, so it is never reachable with
+// the permissions the umask gives it.
+func listenUnix(path string, perm string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unix socket permissions %q: %w", perm, err)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// MkdirTemp creates the directory with mode 0700
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".vex-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := listener.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, os.FileMode(mode)); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ul, path: path}, nil
+}
+
+// unixListener removes the socket file from its final path when closed, as a
+// UnixListener would for the path it was created at
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Addr returns the final path of the socket
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket file
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if err == nil {
+		_ = os.Remove(l.path)
+	}
+	return err
+}
+
+// acceptLoop accepts connections on a listener until it is closed
+func acceptLoop(ctx context.Context, listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-ctx.Done():
-				log.Info("shutting down server")
 				return
 			default:
-				log.Error("failed to accept connection", slog.String("error", err.Error()))
-				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Error("failed to accept connection", slog.String("error", err.Error()))
+			continue
 		}
 
 		// Handle connection in a new goroutine
@@ -165,16 +565,60 @@ func handleConnection(ctx context.Context, conn net.Conn) {
 		metrics.Global().DecrementActiveConnections()
 	}()
 
+	// pushing is set once the connection has switched to pub/sub mode and
+	// a goroutine pushes published messages to it
+	pushing := false
+
 	// Generate request ID for tracing
 	requestID := uuid.New().String()
 	connLog := log.WithRequestID(ctx, requestID)
 
-	connLog.Info("new connection", slog.String("remote", conn.RemoteAddr().String()))
+	// For TLS connections, record the client certificate subject on every
+	// log line of the connection for auditing
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		subject, err := tlsHandshake(ctx, tlsConn)
+		if err != nil {
+			connLog.Warn("tls handshake failed",
+				slog.String("remote", conn.RemoteAddr().String()),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		if subject != "" {
+			connLog = connLog.WithFields(map[string]any{"client_cert": subject})
+		}
+	}
+
+	connLog.Info("new connection",
+		slog.String("network", conn.LocalAddr().Network()),
+		slog.String("remote", conn.RemoteAddr().String()),
+	)
 
 	// Create RESP reader and writer
 	reader := protocol.NewRESPReader(conn)
 	writer := protocol.NewRESPWriter(conn)
 
+	c := &client{
+		ctx:    ctx,
+		conn:   conn,
+		reader: reader,
+		writer: writer,
+		log:    connLog,
+		db:     db,
+	}
+
+	// Stop pushing messages once the connection ends
+	defer func() {
+		if c.sub != nil {
+			c.sub.Close()
+		}
+	}()
+
+	// Connections start out as the default user if it needs no password
+	if u, ok := users.User(acl.DefaultUser); ok && u.Enabled && u.NoPass() {
+		c.username = acl.DefaultUser
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -182,11 +626,17 @@ func handleConnection(ctx context.Context, conn net.Conn) {
 		default:
 		}
 
-		// Set read deadline to detect idle connections
-		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		// Set read deadline to detect idle connections. Subscribers are
+		// expected to sit idle, waiting for messages.
+		if c.subscribed() {
+			_ = conn.SetReadDeadline(time.Time{})
+		} else {
+			_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		}
 
-		// Read command
-		cmd, err := reader.ReadCommand()
+		// Read command. Arguments are views into the reader's buffer and are
+		// only valid until the next read, so handlers copy what they keep.
+		cmd, err := reader.ReadCommandBytes()
 		if err != nil {
 			// Check for normal connection closure (EOF means client disconnected)
 			if errors.Is(err, net.ErrClosed) || errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
@@ -200,6 +650,8 @@ func handleConnection(ctx context.Context, conn net.Conn) {
 			}
 			// Protocol errors - log but try to send error response
 			connLog.Warn("protocol error", slog.String("error", err.Error()))
+			c.mu.Lock()
+			defer c.mu.Unlock()
 			if writeErr := writer.WriteError(err.Error()); writeErr != nil {
 				connLog.Debug("failed to write error response", slog.String("error", writeErr.Error()))
 				return
@@ -220,109 +672,339 @@ func handleConnection(ctx context.Context, conn net.Conn) {
 		metrics.Global().IncrementCommands()
 
 		// Process command
+		c.mu.Lock()
 		start := time.Now()
-		processCommand(connLog, writer, cmd)
+		processCommand(c, cmd)
 		latency := time.Since(start)
 
 		// Log command execution
 		connLog.Debug("command executed",
-			slog.String("cmd", cmd[0]),
+			slog.String("cmd", string(cmd[0])),
 			slog.Int("args", len(cmd)-1),
 			slog.Duration("latency", latency),
 		)
 
 		// Flush response
-		if err := writer.Flush(); err != nil {
-			connLog.Error("failed to flush response", slog.String("error", err.Error()))
+		err = writer.Flush()
+		c.mu.Unlock()
+		if err != nil {
+			// A handler may have closed the connection, as PSYNC does once
+			// its replica is gone
+			if !errors.Is(err, net.ErrClosed) {
+				connLog.Error("failed to flush response", slog.String("error", err.Error()))
+			}
 			return
 		}
+
+		// The first subscription switches the connection to pub/sub mode:
+		// from then on published messages are pushed to it as they arrive
+		if c.sub != nil && !pushing {
+			pushing = true
+			go pushMessages(c, c.sub)
+		}
 	}
 }
 
+// client holds the per-connection state of a RESP client
+type client struct {
+	ctx    context.Context // Cancelled when the server shuts down
+	conn   net.Conn
+	reader *protocol.RESPReader
+	writer *protocol.RESPWriter
+	log    *logger.Logger
+
+	// mu serializes use of the writer between command replies and the
+	// messages pushed to the connection in pub/sub mode
+	mu sync.Mutex
+
+	// username is the ACL user the connection is authenticated as, or
+	// empty before a successful AUTH
+	username string
+
+	// db is what commands read and write: the storage, or a transaction
+	// view while EXEC runs the queued commands
+	db keyspace
+
+	// tx holds the MULTI and WATCH state of the connection
+	tx transaction
+
+	// sub receives the messages for the connection's subscriptions, and is
+	// nil until it first subscribes
+	sub *pubsub.Subscriber
+
+	// replPort is the port a replica connecting with this connection says
+	// it serves clients on
+	replPort int
+
+	// asking is set by ASKING for the next command only
+	asking bool
+
+	// asyncWrites is set by VASYNC ON to queue every VSET as if it had the
+	// ASYNC option
+	asyncWrites bool
+}
+
+// user returns the ACL user the connection is authenticated as. It returns nil
+// if the connection hasn't authenticated, or if its user has since been
+// deleted or disabled; permission changes apply to existing connections.
+func (c *client) user() *acl.User {
+	if c.username == "" {
+		return nil
+	}
+	u, ok := users.User(c.username)
+	if !ok || !u.Enabled {
+		return nil
+	}
+	return u
+}
+
 // processCommand handles individual commands
-func processCommand(log *logger.Logger, writer *protocol.RESPWriter, cmd []string) {
-	command := strings.ToUpper(cmd[0])
-
-	switch command {
-	case "PING":
-		handlePing(writer, cmd)
-	case "ECHO":
-		handleEcho(writer, cmd)
-	case "VSET":
-		handleVSet(log, writer, cmd)
-	case "VGET":
-		handleVGet(writer, cmd)
-	case "VDEL":
-		handleVDel(writer, cmd)
-	case "VSEARCH":
-		handleVSearch(log, writer, cmd)
-	case "STATS", "INFO":
-		handleStats(writer)
-	case "CLEAR":
-		handleClear(writer)
-	case "QUIT":
-		_ = writer.WriteSimpleString("OK")
-	default:
-		_ = writer.WriteError(fmt.Sprintf("unknown command '%s'", command))
+func processCommand(c *client, cmd [][]byte) {
+	// Upper-case the command name in place; the buffer belongs to this
+	// connection's reader so this avoids allocating a new string per command
+	upperASCII(cmd[0])
+
+	spec, ok := commands[string(cmd[0])]
+	if !ok {
+		c.tx.fail()
+		_ = c.writer.WriteError(fmt.Sprintf("unknown command '%s'", cmd[0]))
+		return
+	}
+
+	asking := c.asking
+	c.asking = false
+
+	if c.subscribed() && !spec.subscribed {
+		_ = c.writer.WriteError(fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(string(cmd[0]))))
+		return
+	}
+
+	if !spec.noAuth {
+		u := c.user()
+		if u == nil {
+			c.tx.fail()
+			_ = c.writer.WriteErrorCode("NOAUTH", "Authentication required.")
+			return
+		}
+		if msg, ok := checkAccess(u, spec, cmd); !ok {
+			c.log.Warn("permission denied",
+				slog.String("user", u.Name),
+				slog.String("cmd", string(cmd[0])),
+			)
+			c.tx.fail()
+			_ = c.writer.WriteErrorCode("NOPERM", msg)
+			return
+		}
+	}
+
+	// In a cluster, keys are only used on the node serving their slot. EXEC
+	// routes the commands it runs before locking the shards.
+	if clusterState != nil && spec.firstKey > 0 && !c.inExec() {
+		done, ok := routeCommand(c, spec, cmd, asking)
+		if !ok {
+			c.tx.fail()
+			return
+		}
+		defer done()
+	}
+
+	// Replicas only change their data as their leader tells them to
+	if spec.write && replicating() {
+		c.tx.fail()
+		_ = c.writer.WriteErrorCode("READONLY", "You can't write against a read only replica.")
+		return
+	}
+
+	// In a Raft group writes run once committed to the group's log, on
+	// every node, so they can't be part of a local transaction
+	if spec.write && raftNode != nil {
+		if spec.noRaft {
+			c.tx.fail()
+			_ = c.writer.WriteError(fmt.Sprintf("'%s' is not available in raft mode", strings.ToLower(string(cmd[0]))))
+			return
+		}
+		if c.tx.multi {
+			c.tx.fail()
+			_ = c.writer.WriteError(fmt.Sprintf("'%s' can't be queued in a transaction in raft mode", strings.ToLower(string(cmd[0]))))
+			return
+		}
+		proposeCommand(c, cmd)
+		return
+	}
+
+	// Inside MULTI, commands are queued to run on EXEC
+	if c.tx.multi && !spec.noQueue {
+		c.tx.queue(cmd)
+		_ = c.writer.WriteSimpleString("QUEUED")
+		return
+	}
+
+	spec.handler(c, cmd)
+}
+
+// upperASCII converts ASCII letters in b to upper case in place
+func upperASCII(b []byte) {
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
 	}
 }
 
 // handlePing handles the PING command
-func handlePing(writer *protocol.RESPWriter, cmd []string) {
+func handlePing(c *client, cmd [][]byte) {
+	// In pub/sub mode the reply is shaped like a message, as in Redis
+	if c.subscribed() {
+		_ = c.writer.WriteArrayHeader(2)
+		_ = c.writer.WriteBulkString("pong")
+		if len(cmd) > 1 {
+			_ = c.writer.WriteBulkBytes(cmd[1])
+		} else {
+			_ = c.writer.WriteBulkString("")
+		}
+		return
+	}
 	if len(cmd) == 1 {
-		_ = writer.WriteSimpleString("PONG")
+		_ = c.writer.WriteSimpleString("PONG")
 	} else {
-		_ = writer.WriteBulkString(cmd[1])
+		_ = c.writer.WriteBulkBytes(cmd[1])
 	}
 }
 
 // handleEcho handles the ECHO command
-func handleEcho(writer *protocol.RESPWriter, cmd []string) {
+func handleEcho(c *client, cmd [][]byte) {
 	if len(cmd) < 2 {
-		_ = writer.WriteError("wrong number of arguments for 'echo' command")
+		_ = c.writer.WriteError("wrong number of arguments for 'echo' command")
 		return
 	}
-	_ = writer.WriteBulkString(cmd[1])
+	_ = c.writer.WriteBulkBytes(cmd[1])
+}
+
+// handleQuit handles the QUIT command
+func handleQuit(c *client, _ [][]byte) {
+	_ = c.writer.WriteSimpleString("OK")
 }
 
-// handleVSet handles the VSET command: VSET key "[0.1, 0.2, 0.3]"
-func handleVSet(log *logger.Logger, writer *protocol.RESPWriter, cmd []string) {
+// handleVSet handles the VSET command:
+// VSET key "[0.1, 0.2, 0.3]" [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds]
+// [NX | XX] [IFVERSION version] [WITHVERSION] [ASYNC]
+func handleVSet(c *client, cmd [][]byte) {
 	if len(cmd) < 3 {
-		_ = writer.WriteError("wrong number of arguments for 'vset' command")
+		_ = c.writer.WriteError("wrong number of arguments for 'vset' command")
 		return
 	}
 
-	key := cmd[1]
-	vectorStr := cmd[2]
+	// The key is retained by storage, so it has to be copied out of the buffer
+	key := string(cmd[1])
 
 	// Parse vector
-	values, err := protocol.FastVectorParser(vectorStr)
+	values, err := protocol.ParseVector(cmd[2])
 	if err != nil {
-		_ = writer.WriteError(fmt.Sprintf("invalid vector format: %s", err.Error()))
+		_ = c.writer.WriteError(fmt.Sprintf("invalid vector format: %s", err.Error()))
+		return
+	}
+
+	// Parse options
+	var opts storage.SetOptions
+	withVersion, async := false, c.asyncWrites
+	for i := 3; i < len(cmd); i++ {
+		switch opt := strings.ToUpper(string(cmd[i])); {
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") && opts.TTL == 0 && opts.ExpireAt.IsZero() && i+1 < len(cmd):
+			i++
+			opts.TTL, err = parseTTL(cmd[i], opt == "EX" || opt == "EXAT")
+			if err != nil || opts.TTL <= 0 {
+				_ = c.writer.WriteError("invalid expire time in 'vset' command")
+				return
+			}
+			if strings.HasSuffix(opt, "AT") {
+				opts.ExpireAt, opts.TTL = time.Unix(0, int64(opts.TTL)), 0
+			}
+		case opt == "NX" && !opts.XX:
+			opts.NX = true
+		case opt == "XX" && !opts.NX:
+			opts.XX = true
+		case opt == "IFVERSION" && !opts.CheckVersion && i+1 < len(cmd):
+			i++
+			opts.Version, err = strconv.ParseUint(string(cmd[i]), 10, 64)
+			if err != nil {
+				_ = c.writer.WriteError("version is not an integer or out of range")
+				return
+			}
+			opts.CheckVersion = true
+		case opt == "WITHVERSION":
+			withVersion = true
+		case opt == "ASYNC":
+			async = true
+		default:
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+	}
+
+	// Queued writes are applied later, in order with each other but not
+	// with anything else. Writes in a transaction or from the Raft log run
+	// at once so that they keep their place.
+	if async && !c.inExec() && raftNode == nil {
+		if opts.NX || opts.XX || opts.CheckVersion || withVersion {
+			_ = c.writer.WriteError("NX, XX, IFVERSION and WITHVERSION can't be used with asynchronous writes")
+			return
+		}
+		ttl := opts.TTL
+		if !opts.ExpireAt.IsZero() {
+			// A deadline that has passed by the time the write is queued
+			// still leaves the key expired rather than without a TTL
+			ttl = max(time.Until(opts.ExpireAt), time.Nanosecond)
+		}
+		if err := writeQueue.Set(c.ctx, key, values, ttl); err != nil {
+			writeStorageErrorRESP(c, err)
+			return
+		}
+		_ = c.writer.WriteSimpleString("OK")
 		return
 	}
 
 	// Store vector
-	if err := store.Set(key, values); err != nil {
-		_ = writer.WriteError(err.Error())
+	res, err := c.db.SetWithOptions(key, values, opts)
+	if err != nil {
+		writeStorageErrorRESP(c, err)
 		return
 	}
 
-	metrics.Global().IncrementKeys()
-	_ = writer.WriteSimpleString("OK")
+	// Like SET NX, a write skipped because of a condition replies with null
+	if !res.Written {
+		_ = c.writer.WriteNull()
+		return
+	}
+	recordWrite(key, res.Inserted)
+	if withVersion {
+		_ = c.writer.WriteInteger(int64(res.Version))
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
 }
 
-// handleVGet handles the VGET command: VGET key
-func handleVGet(writer *protocol.RESPWriter, cmd []string) {
+// handleVGet handles the VGET command: VGET key [WITHVERSION]
+func handleVGet(c *client, cmd [][]byte) {
 	if len(cmd) < 2 {
-		_ = writer.WriteError("wrong number of arguments for 'vget' command")
+		_ = c.writer.WriteError("wrong number of arguments for 'vget' command")
 		return
 	}
 
-	key := cmd[1]
-	values, ok := store.Get(key)
+	withVersion := false
+	if len(cmd) == 3 && strings.EqualFold(string(cmd[2]), "WITHVERSION") {
+		withVersion = true
+	} else if len(cmd) > 2 {
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+
+	values, version, ok := c.db.GetWithVersion(string(cmd[1]))
+	if !ok && withVersion {
+		_ = c.writer.WriteNull()
+		return
+	}
 	if !ok {
-		_ = writer.WriteBulkString("") // Null bulk string
+		_ = c.writer.WriteBulkString("") // Null bulk string
 		return
 	}
 
@@ -337,82 +1019,313 @@ func handleVGet(writer *protocol.RESPWriter, cmd []string) {
 	}
 	sb.WriteString("]")
 
-	_ = writer.WriteBulkString(sb.String())
+	// WITHVERSION replies with the vector and its version
+	if withVersion {
+		_ = c.writer.WriteArrayHeader(2)
+		_ = c.writer.WriteBulkString(sb.String())
+		_ = c.writer.WriteInteger(int64(version))
+		return
+	}
+	_ = c.writer.WriteBulkString(sb.String())
 }
 
 // handleVDel handles the VDEL command: VDEL key
-func handleVDel(writer *protocol.RESPWriter, cmd []string) {
+func handleVDel(c *client, cmd [][]byte) {
 	if len(cmd) < 2 {
-		_ = writer.WriteError("wrong number of arguments for 'vdel' command")
+		_ = c.writer.WriteError("wrong number of arguments for 'vdel' command")
 		return
 	}
 
-	key := cmd[1]
-	deleted := store.Delete(key)
+	deleted := c.db.Delete(string(cmd[1]))
 	if deleted {
-		metrics.Global().DecrementKeys()
-		_ = writer.WriteInteger(1)
+		recordDelete(string(cmd[1]))
+		_ = c.writer.WriteInteger(1)
 	} else {
-		_ = writer.WriteInteger(0)
+		_ = c.writer.WriteInteger(0)
 	}
 }
 
-// handleVSearch handles the VSEARCH command: VSEARCH "[0.1, 0.2, 0.3]" k
-func handleVSearch(log *logger.Logger, writer *protocol.RESPWriter, cmd []string) {
-	if len(cmd) < 3 {
-		_ = writer.WriteError("wrong number of arguments for 'vsearch' command")
+// handleVMSet handles the VMSET command: VMSET key vector [key vector ...]
+// Either every vector is stored or, if any is invalid, none are.
+func handleVMSet(c *client, cmd [][]byte) {
+	if len(cmd) < 3 || len(cmd)%2 != 1 {
+		_ = c.writer.WriteError("wrong number of arguments for 'vmset' command")
+		return
+	}
+
+	n := (len(cmd) - 1) / 2
+	keys := make([]string, n)
+	values := make([][]float32, n)
+	for i := 0; i < n; i++ {
+		keys[i] = string(cmd[1+2*i])
+		vec, err := protocol.ParseVector(cmd[2+2*i])
+		if err != nil {
+			_ = c.writer.WriteError(fmt.Sprintf("invalid vector format for key %q: %s", keys[i], err.Error()))
+			return
+		}
+		values[i] = vec
+	}
+
+	inserted, err := c.db.SetMany(keys, values)
+	if err != nil {
+		writeStorageErrorRESP(c, err)
+		return
+	}
+
+	// Only the insert and update totals matter, not which keys were inserted
+	for i, key := range keys {
+		recordWrite(key, i < inserted)
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleVMGet handles the VMGET command: VMGET key [key ...]
+// Each vector is returned as an array of components, or null if missing.
+func handleVMGet(c *client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'vmget' command")
 		return
 	}
 
-	vectorStr := cmd[1]
-	var k int
-	_, _ = fmt.Sscanf(cmd[2], "%d", &k)
+	_ = c.writer.WriteArrayHeader(len(cmd) - 1)
+	for _, key := range cmd[1:] {
+		values, ok := c.db.Get(string(key))
+		if !ok {
+			_ = c.writer.WriteNull()
+			continue
+		}
+		writeVector(c, values)
+	}
+}
 
+// writeVector writes a vector as an array of its components
+func writeVector(c *client, values []float32) {
+	var buf [32]byte
+	_ = c.writer.WriteArrayHeader(len(values))
+	for _, v := range values {
+		_ = c.writer.WriteBulkBytes(strconv.AppendFloat(buf[:0], float64(v), 'f', 6, 32))
+	}
+}
+
+// handleExpire handles the EXPIRE and PEXPIRE commands: EXPIRE key seconds
+func handleExpire(c *client, cmd [][]byte) {
+	name := strings.ToLower(string(cmd[0]))
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError(fmt.Sprintf("wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	ttl, err := parseTTL(cmd[2], name == "expire")
+	if err != nil {
+		_ = c.writer.WriteError(fmt.Sprintf("invalid expire time in '%s' command", name))
+		return
+	}
+
+	if c.db.Expire(string(cmd[1]), ttl) {
+		if ttl <= 0 {
+			recordDelete(string(cmd[1]))
+		} else {
+			notifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
+		}
+		_ = c.writer.WriteInteger(1)
+	} else {
+		_ = c.writer.WriteInteger(0)
+	}
+}
+
+// handleExpireAt handles the EXPIREAT and PEXPIREAT commands:
+// EXPIREAT key unix-time-seconds
+func handleExpireAt(c *client, cmd [][]byte) {
+	name := strings.ToLower(string(cmd[0]))
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError(fmt.Sprintf("wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	// The time since the epoch parses like a TTL
+	sinceEpoch, err := parseTTL(cmd[2], name == "expireat")
+	if err != nil {
+		_ = c.writer.WriteError(fmt.Sprintf("invalid expire time in '%s' command", name))
+		return
+	}
+	at := time.Unix(0, int64(sinceEpoch))
+
+	if c.db.ExpireAt(string(cmd[1]), at) {
+		if !at.After(time.Now()) {
+			recordDelete(string(cmd[1]))
+		} else {
+			notifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
+		}
+		_ = c.writer.WriteInteger(1)
+	} else {
+		_ = c.writer.WriteInteger(0)
+	}
+}
+
+// handleTTL handles the TTL and PTTL commands: TTL key
+// Replies -2 if the key does not exist and -1 if it has no TTL.
+func handleTTL(c *client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		_ = c.writer.WriteError(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(string(cmd[0]))))
+		return
+	}
+
+	ttl, ok := c.db.TTL(string(cmd[1]))
+	switch {
+	case !ok:
+		_ = c.writer.WriteInteger(-2)
+	case ttl < 0:
+		_ = c.writer.WriteInteger(-1)
+	case string(cmd[0]) == "PTTL":
+		_ = c.writer.WriteInteger(ttl.Milliseconds())
+	default:
+		// Round to the nearest second, as Redis does
+		_ = c.writer.WriteInteger(int64((ttl + 500*time.Millisecond) / time.Second))
+	}
+}
+
+// handlePersist handles the PERSIST command: PERSIST key
+func handlePersist(c *client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'persist' command")
+		return
+	}
+
+	if c.db.Persist(string(cmd[1])) {
+		notifyKeyspaceEvent(notifyGeneric, "persist", string(cmd[1]))
+		_ = c.writer.WriteInteger(1)
+	} else {
+		_ = c.writer.WriteInteger(0)
+	}
+}
+
+// parseTTL parses a TTL argument in seconds or milliseconds
+func parseTTL(b []byte, seconds bool) (time.Duration, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := time.Millisecond
+	if seconds {
+		unit = time.Second
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, strconv.ErrRange
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// handleVSearch handles the VSEARCH command: VSEARCH "[0.1, 0.2, 0.3]" k [WITHSCORES]
+func handleVSearch(c *client, cmd [][]byte) {
+	if len(cmd) < 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'vsearch' command")
+		return
+	}
+
+	k, _ := strconv.Atoi(string(cmd[2]))
 	if k <= 0 {
-		_ = writer.WriteError("k must be positive")
+		_ = c.writer.WriteError("k must be positive")
 		return
 	}
 
 	// Parse query vector
-	query, err := protocol.FastVectorParser(vectorStr)
+	query, err := protocol.ParseVector(cmd[1])
 	if err != nil {
-		_ = writer.WriteError(fmt.Sprintf("invalid vector format: %s", err.Error()))
+		_ = c.writer.WriteError(fmt.Sprintf("invalid vector format: %s", err.Error()))
 		return
 	}
 
-	// Search
-	results, err := store.Search(query, k)
+	withScores := false
+	for _, opt := range cmd[3:] {
+		if !strings.EqualFold(string(opt), "WITHSCORES") {
+			_ = c.writer.WriteError("syntax error")
+			return
+		}
+		withScores = true
+	}
+
+	// Search, restricted to the keys the user may access. A search in a
+	// transaction sees its writes, so it runs on its own.
+	var results []vector.SearchResult
+	if u := c.user(); c.inExec() {
+		var filter func(string) bool
+		if u != nil && !u.AllKeys() {
+			filter = u.CanAccessKey
+		}
+		results, err = c.db.SearchFiltered(query, k, filter)
+	} else {
+		results, err = search(query, k, u)
+	}
 	if err != nil {
-		_ = writer.WriteError(err.Error())
+		_ = c.writer.WriteError(err.Error())
 		return
 	}
 
-	// Format results as array of keys
+	// Format results as array of keys, each followed by its similarity
+	// with WITHSCORES
+	if withScores {
+		_ = c.writer.WriteArrayHeader(2 * len(results))
+		for _, res := range results {
+			_ = c.writer.WriteBulkString(res.Key)
+			_ = c.writer.WriteBulkString(strconv.FormatFloat(float64(res.Similarity), 'g', -1, 32))
+		}
+		return
+	}
 	keys := make([]string, len(results))
 	for i, res := range results {
 		keys[i] = res.Key
 	}
 
-	_ = writer.WriteArray(keys)
+	_ = c.writer.WriteArray(keys)
+}
+
+// recordWrite counts a successful write to key as an insert or an update
+// and notifies subscribers
+func recordWrite(key string, inserted bool) {
+	if inserted {
+		metrics.Global().IncrementInserts()
+	} else {
+		metrics.Global().IncrementUpdates()
+	}
+	notifyKeyspaceEvent(notifyVector, "vset", key)
+}
+
+// recordDelete counts a key deleted by a client and notifies subscribers
+func recordDelete(key string) {
+	metrics.Global().IncrementDeletes()
+	notifyKeyspaceEvent(notifyGeneric, "del", key)
+}
+
+// writeStorageErrorRESP writes a storage error, using the OOM error code when
+// the memory limit was reached
+func writeStorageErrorRESP(c *client, err error) {
+	if errors.Is(err, storage.ErrOutOfMemory) {
+		_ = c.writer.WriteErrorCode("OOM", err.Error()+".")
+		return
+	}
+	_ = c.writer.WriteError(err.Error())
 }
 
 // handleStats handles the STATS/INFO command
-func handleStats(writer *protocol.RESPWriter) {
+func handleStats(c *client, _ [][]byte) {
+	updateStorageMetrics()
 	jsonStr, err := metrics.Global().JSON()
 	if err != nil {
-		_ = writer.WriteError(err.Error())
+		_ = c.writer.WriteError(err.Error())
 		return
 	}
-	_ = writer.WriteBulkString(jsonStr)
+	_ = c.writer.WriteBulkString(jsonStr)
 }
 
 // handleClear handles the CLEAR command
-func handleClear(writer *protocol.RESPWriter) {
-	store.Clear()
-	_ = writer.WriteSimpleString("OK")
+func handleClear(c *client, _ [][]byte) {
+	c.db.Clear()
+	metrics.Global().IncrementClears()
+	notifyKeyspaceEvent(notifyGeneric, "clear", "")
+	_ = c.writer.WriteSimpleString("OK")
 }
 
-// monitorMemory periodically updates memory usage metrics
+// monitorMemory periodically updates memory usage and key count metrics
 func monitorMemory(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -422,9 +1335,57 @@ func monitorMemory(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			var m runtime.MemStats
-			runtime.ReadMemStats(&m)
-			metrics.Global().SetMemoryUsage(m.Alloc)
+			updateStorageMetrics()
 		}
 	}
 }
+
+// updateStorageMetrics records the process heap size, along with the key
+// count and dataset size tracked by storage
+func updateStorageMetrics() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	metrics.Global().SetMemoryUsage(m.Alloc)
+	metrics.Global().SetDatasetMemoryUsage(uint64(store.MemoryUsage()))
+	metrics.Global().SetTotalKeys(uint64(store.Count()))
+
+	st := writeQueue.Stats()
+	metrics.Global().SetAsyncWrites(st.Queued, st.Applied, st.Failed, st.Batches, st.Wait, st.Apply)
+
+	sst := searches.Stats()
+	metrics.Global().SetSearchCoalescing(sst.Shared, sst.Timeouts)
+}
+
+// handleVAsync handles the VASYNC command: VASYNC ON | OFF
+// With VASYNC ON, every VSET on the connection is queued as with ASYNC.
+func handleVAsync(c *client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'vasync' command")
+		return
+	}
+	switch strings.ToUpper(string(cmd[1])) {
+	case "ON":
+		c.asyncWrites = true
+	case "OFF":
+		c.asyncWrites = false
+	default:
+		_ = c.writer.WriteError("syntax error")
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleVFlush handles the VFLUSH command, which waits until every write
+// queued with VSET ASYNC before it, by any connection, has been applied
+func handleVFlush(c *client, _ [][]byte) {
+	// The queued writes need the shard locks that EXEC holds
+	if c.inExec() {
+		_ = c.writer.WriteError("VFLUSH can't be run inside a transaction")
+		return
+	}
+	if err := writeQueue.Flush(c.ctx); err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
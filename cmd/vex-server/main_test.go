@@ -0,0 +1,125 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// testClient is a connection of the default user that collects its replies
+type testClient struct {
+	*client
+	out bytes.Buffer
+}
+
+func newTestClient() *testClient {
+	c := &testClient{}
+	c.client = &client{
+		ctx:      context.Background(),
+		writer:   protocol.NewRESPWriter(&c.out),
+		log:      log,
+		db:       db,
+		username: acl.DefaultUser,
+	}
+	return c
+}
+
+// do runs a command and returns its raw reply
+func (c *testClient) do(args ...string) string {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	processCommand(c.client, cmd)
+	_ = c.writer.Flush()
+	reply := c.out.String()
+	c.out.Reset()
+	return reply
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vex.sock")
+
+	listener, err := listenUnix(path, "660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o660 {
+		t.Errorf("mode = %v, want a socket with 0660", fi.Mode())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the socket", len(entries))
+	}
+
+	if got := listener.Addr().String(); got != path {
+		t.Errorf("Addr() = %q, want %q", got, path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left after Close: %v", err)
+	}
+}
+
+func TestListenUnixStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vex.sock")
+
+	// A socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := listenUnix(path, "700")
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	_ = listener.Close()
+
+	// Any other file is kept
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, "700"); err == nil {
+		t.Error("listenUnix replaced a regular file")
+	}
+	if _, err := listenUnix(filepath.Join(t.TempDir(), "s"), "9"); err == nil {
+		t.Error("listenUnix accepted invalid permissions")
+	}
+}
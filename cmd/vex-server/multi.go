@@ -0,0 +1,246 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/uzqw/vex/internal/percore"
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/internal/vector"
+)
+
+// keyspace is the set of storage operations commands use. *storage.Storage,
+// *percore.Engine and *storage.Tx implement it, so handlers run the same
+// way in either execution mode and inside EXEC.
+type keyspace interface {
+	Get(key string) ([]float32, bool)
+	GetWithVersion(key string) ([]float32, uint64, bool)
+	SetWithOptions(key string, values []float32, opts storage.SetOptions) (storage.SetResult, error)
+	SetMany(keys []string, values [][]float32) (int, error)
+	Delete(key string) bool
+	Exists(key string) bool
+	Expire(key string, ttl time.Duration) bool
+	ExpireAt(key string, at time.Time) bool
+	TTL(key string) (time.Duration, bool)
+	Persist(key string) bool
+	Rename(src, dst string) error
+	Copy(src, dst string, replace bool) (bool, error)
+	Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64)
+	RandomKey(match func(key string) bool) (string, bool)
+	SearchFiltered(query []float32, k int, filter func(key string) bool) ([]vector.SearchResult, error)
+	Count() int
+	Clear()
+	Version(key string) uint64
+}
+
+var (
+	_ keyspace = (*storage.Storage)(nil)
+	_ keyspace = (*storage.Tx)(nil)
+	_ keyspace = (*percore.Engine)(nil)
+)
+
+// transaction is a connection's MULTI and WATCH state
+type transaction struct {
+	// multi is set between MULTI and EXEC or DISCARD, while commands are
+	// queued instead of run
+	multi  bool
+	queued [][][]byte
+
+	// failed is set when a command could not be queued, so that EXEC
+	// discards the transaction, as in Redis
+	failed bool
+
+	// watched maps each watched key to its stamp when WATCH was called
+	watched map[string]watch
+}
+
+// watch is a watched key's version and shard clock, from storage.Stamp. A
+// key is unchanged if its version is the same and, while it is absent, its
+// shard's clock too, since creating and deleting it again leaves version 0.
+type watch struct {
+	version, clock uint64
+}
+
+// changed reports whether a key with the given stamp changed since w
+func (w watch) changed(version, clock uint64) bool {
+	return version != w.version || version == 0 && clock != w.clock
+}
+
+// queue copies cmd, whose arguments point into the reader's buffer, onto the
+// transaction's queue
+func (t *transaction) queue(cmd [][]byte) {
+	queued := make([][]byte, len(cmd))
+	for i, arg := range cmd {
+		queued[i] = slices.Clone(arg)
+	}
+	t.queued = append(t.queued, queued)
+}
+
+// fail marks an open transaction as failed; it does nothing outside MULTI
+func (t *transaction) fail() {
+	if t.multi {
+		t.failed = true
+	}
+}
+
+// reset ends the transaction and forgets the watched keys
+func (t *transaction) reset() {
+	*t = transaction{}
+}
+
+// inExec reports whether the connection is running the commands queued in
+// a transaction, with every shard locked
+func (c *client) inExec() bool {
+	_, ok := c.db.(*storage.Tx)
+	return ok
+}
+
+// handleMulti handles the MULTI command
+func handleMulti(c *client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		_ = c.writer.WriteError("wrong number of arguments for 'multi' command")
+		return
+	}
+	if c.tx.multi {
+		_ = c.writer.WriteError("MULTI calls can not be nested")
+		return
+	}
+	c.tx.multi = true
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleExec handles the EXEC command. The queued commands run with every
+// shard locked, so no other client observes the transaction half-applied.
+// If a watched key changed since WATCH, nothing runs and the reply is a
+// null array.
+func handleExec(c *client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.tx.fail()
+		_ = c.writer.WriteError("wrong number of arguments for 'exec' command")
+		return
+	}
+	if !c.tx.multi {
+		_ = c.writer.WriteError("EXEC without MULTI")
+		return
+	}
+
+	tx := c.tx
+	c.tx.reset()
+	if tx.failed {
+		_ = c.writer.WriteErrorCode("EXECABORT", "Transaction discarded because of previous errors.")
+		return
+	}
+
+	// Replies are buffered so a slow client can't stall the storage while
+	// every shard is locked
+	var buf bytes.Buffer
+	out, replies := c.writer, protocol.NewRESPWriter(&buf)
+	c.writer = replies
+
+	// In a cluster the commands are routed before the shards are locked,
+	// since MIGRATE takes migrateMu first too, and it is held until they
+	// have run. A command whose keys aren't served here any more gets the
+	// redirect as its reply.
+	var misrouted []*routeError
+	if clusterState != nil {
+		migrateMu.RLock()
+		misrouted = make([]*routeError, len(tx.queued))
+		for i, queued := range tx.queued {
+			if spec, ok := commands[string(queued[0])]; ok && spec.firstKey > 0 {
+				misrouted[i] = routeKeys(c.db, spec.keys(queued), false)
+			}
+		}
+	}
+
+	store.Atomically(func(view *storage.Tx) {
+		for key, w := range tx.watched {
+			if w.changed(view.Stamp(key)) {
+				_ = c.writer.WriteNullArray()
+				return
+			}
+		}
+
+		c.db = view
+		defer func() { c.db = db }()
+
+		_ = c.writer.WriteArrayHeader(len(tx.queued))
+		for i, queued := range tx.queued {
+			if misrouted != nil && misrouted[i] != nil {
+				_ = c.writer.WriteErrorCode(misrouted[i].code, misrouted[i].msg)
+				continue
+			}
+			// Commands are dispatched again so ACL changes since they were
+			// queued take effect
+			processCommand(c, queued)
+		}
+	})
+	if clusterState != nil {
+		migrateMu.RUnlock()
+	}
+
+	c.writer = out
+	_ = replies.Flush()
+	_ = c.writer.WriteRaw(buf.Bytes())
+}
+
+// handleDiscard handles the DISCARD command
+func handleDiscard(c *client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		_ = c.writer.WriteError("wrong number of arguments for 'discard' command")
+		return
+	}
+	if !c.tx.multi {
+		_ = c.writer.WriteError("DISCARD without MULTI")
+		return
+	}
+	c.tx.reset()
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleWatch handles the WATCH command: WATCH key [key ...]
+// EXEC aborts if any of the keys is written, deleted or expires before it runs.
+func handleWatch(c *client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'watch' command")
+		return
+	}
+	if c.tx.multi {
+		_ = c.writer.WriteError("WATCH inside MULTI is not allowed")
+		return
+	}
+
+	if c.tx.watched == nil {
+		c.tx.watched = make(map[string]watch, len(cmd)-1)
+	}
+	for _, key := range cmd[1:] {
+		// Watching a key again keeps the stamp it was first watched at
+		if _, ok := c.tx.watched[string(key)]; !ok {
+			var w watch
+			w.version, w.clock = store.Stamp(string(key))
+			c.tx.watched[string(key)] = w
+		}
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleUnwatch handles the UNWATCH command
+func handleUnwatch(c *client, _ [][]byte) {
+	c.tx.watched = nil
+	_ = c.writer.WriteSimpleString("OK")
+}
@@ -0,0 +1,50 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		other [][]string // commands another client runs after WATCH
+		want  string
+	}{
+		{"unchanged", nil, "*1\r\n+OK\r\n"},
+		{"written", [][]string{{"VSET", "present", "[0,1]"}}, "*-1\r\n"},
+		{"deleted", [][]string{{"VDEL", "present"}}, "*-1\r\n"},
+		{"absent key created", [][]string{{"VSET", "absent", "[1,0]"}}, "*-1\r\n"},
+		{"absent key created and deleted", [][]string{{"VSET", "absent", "[1,0]"}, {"VDEL", "absent"}}, "*-1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestAPI(t)
+			c, other := newTestClient(), newTestClient()
+			c.do("VSET", "present", "[1,0]")
+
+			if got := c.do("WATCH", "present", "absent"); got != "+OK\r\n" {
+				t.Fatalf("WATCH = %q", got)
+			}
+			for _, cmd := range tt.other {
+				other.do(cmd...)
+			}
+			c.do("MULTI")
+			c.do("VSET", "result", "[1,0]")
+			if got := c.do("EXEC"); got != tt.want {
+				t.Errorf("EXEC = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
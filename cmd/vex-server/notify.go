@@ -0,0 +1,98 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uzqw/vex/internal/pubsub"
+)
+
+// Keyspace notification classes, selected with -notify-keyspace-events
+// using the same letters as Redis
+const (
+	notifyKeyspace uint32 = 1 << iota // K: publish on __keyspace@0__:<key>
+	notifyKeyevent                    // E: publish on __keyevent@0__:<event>
+	notifyGeneric                     // g: del, expire, persist, rename, copy and clear
+	notifyVector                      // v: vset
+	notifyExpired                     // x: keys removed because their TTL passed
+	notifyEvicted                     // e: keys evicted to stay under maxmemory
+
+	// notifyAll is the A alias for every event class
+	notifyAll = notifyGeneric | notifyVector | notifyExpired | notifyEvicted
+)
+
+const (
+	keyspaceChannelPrefix = "__keyspace@0__:"
+	keyeventChannelPrefix = "__keyevent@0__:"
+)
+
+// notifyFlags holds the enabled notification classes. It is set once at
+// startup; with neither K nor E set nothing is published.
+var notifyFlags uint32
+
+// parseNotifyFlags parses a -notify-keyspace-events value such as "KEA"
+func parseNotifyFlags(s string) (uint32, error) {
+	var flags uint32
+	for _, c := range s {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case 'v':
+			flags |= notifyVector
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'A':
+			flags |= notifyAll
+		default:
+			return 0, fmt.Errorf("unknown keyspace event class %q", c)
+		}
+	}
+	return flags, nil
+}
+
+// notifyKeyspaceEvent publishes event for key if its class is enabled. It
+// never blocks, so it is safe to call with storage locks held. key is empty
+// for events that affect the whole keyspace, which have no keyspace channel.
+func notifyKeyspaceEvent(class uint32, event, key string) {
+	if notifyFlags&class == 0 {
+		return
+	}
+	if notifyFlags&notifyKeyspace != 0 && key != "" {
+		broker.Publish(keyspaceChannelPrefix+key, event)
+	}
+	if notifyFlags&notifyKeyevent != 0 {
+		broker.Publish(keyeventChannelPrefix+event, key)
+	}
+}
+
+// notificationKey returns the key a keyspace notification is about, and
+// false if m is not a keyspace notification
+func notificationKey(m pubsub.Message) (string, bool) {
+	if key, ok := strings.CutPrefix(m.Channel, keyspaceChannelPrefix); ok {
+		return key, true
+	}
+	if strings.HasPrefix(m.Channel, keyeventChannelPrefix) {
+		return m.Payload, true
+	}
+	return "", false
+}
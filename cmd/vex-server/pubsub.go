@@ -0,0 +1,204 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/pubsub"
+)
+
+const (
+	// subscriberBuffer is the number of messages a subscriber may fall
+	// behind by before it is disconnected, so that a slow subscriber never
+	// blocks the writers publishing to it
+	subscriberBuffer = 4096
+
+	// pubsubWriteTimeout bounds how long pushing messages to a subscriber
+	// may block before the subscriber is considered too slow
+	pubsubWriteTimeout = 10 * time.Second
+)
+
+// subscribed reports whether the connection is in pub/sub mode, which it is
+// while it has at least one channel or pattern subscription
+func (c *client) subscribed() bool {
+	return c.sub != nil && c.sub.Count() > 0
+}
+
+// subscriber returns the connection's subscriber, creating it on first use
+func (c *client) subscriber() *pubsub.Subscriber {
+	if c.sub == nil {
+		c.sub = broker.NewSubscriber(subscriberBuffer)
+	}
+	return c.sub
+}
+
+// handleSubscribe handles SUBSCRIBE channel [channel ...] and
+// PSUBSCRIBE pattern [pattern ...]
+func handleSubscribe(c *client, cmd [][]byte) {
+	name := strings.ToLower(string(cmd[0]))
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	names := make([]string, len(cmd)-1)
+	for i, arg := range cmd[1:] {
+		names[i] = string(arg)
+	}
+
+	var counts []int
+	if name == "psubscribe" {
+		counts = c.subscriber().PSubscribe(names...)
+	} else {
+		counts = c.subscriber().Subscribe(names...)
+	}
+	for i := range names {
+		writeSubscription(c, name, names[i], counts[i])
+	}
+}
+
+// handleUnsubscribe handles UNSUBSCRIBE [channel ...] and
+// PUNSUBSCRIBE [pattern ...]. Without arguments it unsubscribes from all.
+func handleUnsubscribe(c *client, cmd [][]byte) {
+	name := strings.ToLower(string(cmd[0]))
+	names := make([]string, len(cmd)-1)
+	for i, arg := range cmd[1:] {
+		names[i] = string(arg)
+	}
+
+	var counts []int
+	if name == "punsubscribe" {
+		names, counts = c.subscriber().PUnsubscribe(names...)
+	} else {
+		names, counts = c.subscriber().Unsubscribe(names...)
+	}
+
+	// Like Redis, unsubscribing with no subscriptions still gets a reply
+	if len(names) == 0 {
+		_ = c.writer.WriteArrayHeader(3)
+		_ = c.writer.WriteBulkString(name)
+		_ = c.writer.WriteNull()
+		_ = c.writer.WriteInteger(int64(c.sub.Count()))
+		return
+	}
+	for i := range names {
+		writeSubscription(c, name, names[i], counts[i])
+	}
+}
+
+// writeSubscription writes the confirmation for one (un)subscription
+func writeSubscription(c *client, kind, name string, count int) {
+	_ = c.writer.WriteArrayHeader(3)
+	_ = c.writer.WriteBulkString(kind)
+	_ = c.writer.WriteBulkString(name)
+	_ = c.writer.WriteInteger(int64(count))
+}
+
+// handlePublish handles the PUBLISH command: PUBLISH channel message
+// The reply is the number of subscribers the message was delivered to.
+func handlePublish(c *client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'publish' command")
+		return
+	}
+	n := broker.Publish(string(cmd[1]), string(cmd[2]))
+	_ = c.writer.WriteInteger(int64(n))
+}
+
+// pushMessages writes published messages to a connection in pub/sub mode
+// until the subscriber is closed. A subscriber that falls too far behind,
+// or whose socket stays blocked, is disconnected.
+func pushMessages(c *client, sub *pubsub.Subscriber) {
+	// A subscriber that fell behind may be stuck writing to its socket, so
+	// it is disconnected from a separate goroutine rather than the loop
+	go func() {
+		<-sub.Done()
+		if sub.Dropped() {
+			c.log.Warn("disconnecting slow subscriber", slog.Int("buffered", subscriberBuffer))
+			_ = c.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-sub.Done():
+			return
+		case m := <-sub.Messages():
+			c.mu.Lock()
+			u := c.user()
+			writeMessage(c, u, m)
+			// Batch whatever else is already waiting into the same flush
+			for more := true; more; {
+				select {
+				case m := <-sub.Messages():
+					writeMessage(c, u, m)
+				default:
+					more = false
+				}
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(pubsubWriteTimeout))
+			err := c.writer.Flush()
+			_ = c.conn.SetWriteDeadline(time.Time{})
+			c.mu.Unlock()
+
+			if err != nil {
+				if !sub.Dropped() {
+					c.log.Warn("disconnecting subscriber", slog.String("error", err.Error()))
+				}
+				_ = c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// writeMessage writes a message or pmessage push, unless u may not see it
+func writeMessage(c *client, u *acl.User, m pubsub.Message) {
+	if !canReceive(u, m) {
+		return
+	}
+	if m.Pattern != "" {
+		_ = c.writer.WriteArrayHeader(4)
+		_ = c.writer.WriteBulkString("pmessage")
+		_ = c.writer.WriteBulkString(m.Pattern)
+	} else {
+		_ = c.writer.WriteArrayHeader(3)
+		_ = c.writer.WriteBulkString("message")
+	}
+	_ = c.writer.WriteBulkString(m.Channel)
+	_ = c.writer.WriteBulkString(m.Payload)
+}
+
+// canReceive reports whether u may see m. The user is looked up again for
+// every batch, so ACL changes apply to existing subscriptions. Keyspace
+// notifications name a key, so they only reach users with access to it;
+// CLEAR's, which names no key, only reach users with access to every key.
+func canReceive(u *acl.User, m pubsub.Message) bool {
+	if u == nil || !u.CanRun(acl.CategoryPubSub) {
+		return false
+	}
+	if u.AllKeys() {
+		return true
+	}
+	key, ok := notificationKey(m)
+	if !ok {
+		return true
+	}
+	return key != "" && u.CanAccessKey(key)
+}
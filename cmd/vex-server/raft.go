@@ -0,0 +1,439 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/raft"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// raftNode is set when the server is a member of a Raft group, in which
+// case every write command is committed to the group's log before it runs
+var raftNode *raft.Node
+
+// startRaft joins the Raft group given by the -raft flags
+func startRaft() error {
+	if *replicaOf != "" {
+		return errors.New("raft and replicaof can't be used together")
+	}
+	if *raftDir == "" {
+		return errors.New("raft-dir is required to keep the raft log")
+	}
+	if *raftElectionTimeout < 10*time.Millisecond {
+		return fmt.Errorf("raft-election-timeout %s is too short", *raftElectionTimeout)
+	}
+
+	var peers []string
+	for _, peer := range strings.Split(*raftPeers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" && peer != *raftID {
+			peers = append(peers, peer)
+		}
+	}
+
+	// Keys only expire and get evicted through the log, so that every node
+	// and every replay of the log holds the same keys
+	store.SetPassive(true)
+
+	logStore, err := raft.OpenFileLogStore(*raftDir)
+	if err != nil {
+		return err
+	}
+	node, err := raft.Start(raft.Config{
+		ID:                *raftID,
+		Peers:             peers,
+		Transport:         raft.NewTCPTransport(*leaderUser, *leaderAuth),
+		Store:             logStore,
+		Apply:             applyRaftEntry,
+		Snapshot:          snapshotRaft,
+		Restore:           restoreRaft,
+		SnapshotThreshold: *raftSnapshotEvery,
+		ElectionTimeout:   *raftElectionTimeout,
+		HeartbeatInterval: *raftElectionTimeout / 10,
+		Logger:            log,
+	})
+	if err != nil {
+		_ = logStore.Close()
+		return err
+	}
+	raftNode = node
+	return nil
+}
+
+// propose commits a write command to the Raft log and returns its reply
+// once it has run on this node. Relative expiry times are made absolute
+// first, and the keys the command would find expired or need evicted to
+// make room are removed through the log ahead of it.
+func propose(ctx context.Context, cmd [][]byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, raftProposeTimeout)
+	defer cancel()
+
+	cmd = pinExpiry(cmd, time.Now())
+	if err := proposeRemovals(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return proposeEntry(ctx, cmd)
+}
+
+// proposeEntry commits cmd to the Raft log as it is and returns its reply
+func proposeEntry(ctx context.Context, cmd [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := protocol.NewRESPWriter(&buf)
+	_ = w.WriteArrayHeader(len(cmd))
+	for _, arg := range cmd {
+		_ = w.WriteBulkBytes(arg)
+	}
+	_ = w.Flush()
+
+	reply, err := raftNode.Propose(ctx, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return reply.([]byte), nil
+}
+
+// pinExpiry rewrites the relative expiry times in cmd as absolute ones, so
+// that a key expires at the same moment on every node however late they
+// apply the entry, including when the log is replayed after a restart.
+// Times that have already passed turn into a VDEL, as EXPIRE with a
+// non-positive TTL deletes the key. Invalid times are left for the handler
+// to reject.
+func pinExpiry(cmd [][]byte, now time.Time) [][]byte {
+	name := string(cmd[0])
+	switch name {
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		if len(cmd) != 3 {
+			return cmd
+		}
+		n, err := parseTTL(cmd[2], name == "EXPIRE" || name == "EXPIREAT")
+		if err != nil {
+			return cmd
+		}
+		at := now.Add(n)
+		if strings.HasSuffix(name, "AT") {
+			at = time.Unix(0, int64(n))
+		}
+		if !at.After(now) {
+			return [][]byte{[]byte("VDEL"), cmd[1]}
+		}
+		return [][]byte{[]byte("PEXPIREAT"), cmd[1], unixMilli(at)}
+	case "VSET":
+		pinned := cmd
+		for i := 3; i+1 < len(cmd); i++ {
+			opt := strings.ToUpper(string(cmd[i]))
+			if opt != "EX" && opt != "PX" {
+				continue
+			}
+			ttl, err := parseTTL(cmd[i+1], opt == "EX")
+			if err != nil || ttl <= 0 {
+				return cmd
+			}
+			pinned = slices.Clone(cmd)
+			pinned[i], pinned[i+1] = []byte("PXAT"), unixMilli(now.Add(ttl))
+			break
+		}
+		return pinned
+	}
+	return cmd
+}
+
+// unixMilli formats t as milliseconds since the epoch, rounded up so that a
+// key never expires early
+func unixMilli(t time.Time) []byte {
+	return strconv.AppendInt(nil, (t.UnixNano()+int64(time.Millisecond)-1)/int64(time.Millisecond), 10)
+}
+
+// proposeRemovals has the leader remove through the log, before cmd is
+// proposed, the keys of cmd that have expired and the keys to evict to
+// make room for the vectors it stores. Entries apply in log order, so
+// every node sees the keys go before cmd runs.
+func proposeRemovals(ctx context.Context, cmd [][]byte) error {
+	if raftNode.Leader() != *raftID {
+		// Only the leader can propose; the command itself gets redirected
+		return nil
+	}
+
+	spec := commands[string(cmd[0])]
+	var expired []storage.KeyVersion
+	for _, key := range spec.keys(cmd) {
+		if version, ok := store.ExpiredVersion(string(key)); ok {
+			expired = append(expired, storage.KeyVersion{Key: string(key), Version: version})
+		}
+	}
+	if len(expired) > 0 {
+		if _, err := proposeEntry(ctx, removalCommand("VEXPIRED", expired)); err != nil {
+			return err
+		}
+	}
+
+	victims, err := store.Victims(store.Growth(storedKeys(spec, cmd)...))
+	if err != nil || len(victims) == 0 {
+		return err
+	}
+	_, err = proposeEntry(ctx, removalCommand("VEVICTED", victims))
+	return err
+}
+
+// storedKeys returns the keys cmd stores vectors under, which may need
+// room made for them
+func storedKeys(spec *command, cmd [][]byte) []string {
+	keys := spec.keys(cmd)
+	switch string(cmd[0]) {
+	case "VSET", "VMSET":
+	case "COPY":
+		keys = keys[min(1, len(keys)):]
+	default:
+		return nil
+	}
+
+	stored := make([]string, len(keys))
+	for i, key := range keys {
+		stored[i] = string(key)
+	}
+	return stored
+}
+
+// removalCommand builds a log entry that removes each of keys if it still
+// has the version it had when the leader chose it
+func removalCommand(name string, keys []storage.KeyVersion) [][]byte {
+	cmd := make([][]byte, 0, 1+2*len(keys))
+	cmd = append(cmd, []byte(name))
+	for _, k := range keys {
+		cmd = append(cmd, []byte(k.Key), strconv.AppendUint(nil, k.Version, 10))
+	}
+	return cmd
+}
+
+// raftRemovals are the entries the leader writes to the log on its own to
+// expire and evict keys: VEXPIRED key version [key version ...], and the
+// same for VEVICTED. They can't be sent by clients.
+var raftRemovals = map[string]func(key string, version uint64) bool{
+	"VEXPIRED": func(key string, version uint64) bool { return store.RemoveExpired(key, version) },
+	"VEVICTED": func(key string, version uint64) bool { return store.Evict(key, version) },
+}
+
+// raftExpireBatch bounds the keys removed by each VEXPIRED entry
+const raftExpireBatch = 1000
+
+// expireRaftKeys has the leader remove the keys whose TTL passed through
+// the log every interval, in place of the storage's own expiry sweeper,
+// until ctx is cancelled
+func expireRaftKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if raftNode.Leader() != *raftID {
+			continue
+		}
+		expired := store.Expired(raftExpireBatch)
+		if len(expired) == 0 {
+			continue
+		}
+		proposeCtx, cancel := context.WithTimeout(ctx, raftProposeTimeout)
+		_, err := proposeEntry(proposeCtx, removalCommand("VEXPIRED", expired))
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Warn("failed to expire keys through raft", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// raftProposeTimeout bounds how long a write waits to be committed
+const raftProposeTimeout = 10 * time.Second
+
+// proposeCommand runs a write command through the Raft log and relays its
+// reply. Followers redirect the client to the leader.
+func proposeCommand(c *client, cmd [][]byte) {
+	reply, err := propose(c.ctx, cmd)
+
+	var notLeader *raft.NotLeaderError
+	switch {
+	case err == nil:
+		_ = c.writer.WriteRaw(reply)
+	case errors.As(err, &notLeader) && notLeader.Leader != "":
+		_ = c.writer.WriteErrorCode("REDIRECT", notLeader.Leader)
+	case errors.As(err, &notLeader), errors.Is(err, raft.ErrDropped):
+		_ = c.writer.WriteErrorCode("TRYAGAIN", err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		_ = c.writer.WriteErrorCode("TIMEOUT", "the write was not committed in time and may still be applied")
+	case errors.Is(err, storage.ErrOutOfMemory):
+		writeStorageErrorRESP(c, err)
+	default:
+		_ = c.writer.WriteError(err.Error())
+	}
+}
+
+// proposeHTTP runs a write command from the HTTP API through the Raft log
+// and returns its reply, or writes an error response and returns false
+func proposeHTTP(w http.ResponseWriter, r *http.Request, cmd ...string) ([]byte, bool) {
+	args := make([][]byte, len(cmd))
+	for i, arg := range cmd {
+		args[i] = []byte(arg)
+	}
+	reply, err := propose(r.Context(), args)
+
+	var notLeader *raft.NotLeaderError
+	switch {
+	case err == nil && bytes.HasPrefix(reply, []byte("-")):
+		msg := strings.TrimSpace(string(reply[1:]))
+		writeJSONError(w, http.StatusBadRequest, strings.TrimPrefix(msg, "ERR "))
+		return nil, false
+	case err == nil:
+		return reply, true
+	case errors.As(err, &notLeader) && notLeader.Leader != "":
+		writeJSONError(w, http.StatusMisdirectedRequest, err.Error())
+	case errors.As(err, &notLeader), errors.Is(err, raft.ErrDropped):
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeJSONError(w, http.StatusGatewayTimeout, "the write was not committed in time and may still be applied")
+	case errors.Is(err, storage.ErrOutOfMemory):
+		writeStorageError(w, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+	return nil, false
+}
+
+// applyRaftEntry runs a committed write command and returns its reply. The
+// command was checked against the proposing client's ACLs before it was
+// proposed, so it runs here without a user.
+func applyRaftEntry(e raft.Entry) any {
+	cmd, err := protocol.NewRESPReader(bytes.NewReader(e.Command)).ReadCommandBytes()
+	if err != nil || len(cmd) == 0 {
+		log.Error("invalid raft entry", slog.Uint64("index", e.Index))
+		return []byte("-ERR invalid raft entry\r\n")
+	}
+
+	var buf bytes.Buffer
+	c := &client{
+		ctx:    context.Background(),
+		writer: protocol.NewRESPWriter(&buf),
+		log:    log,
+		db:     db,
+	}
+	if remove, ok := raftRemovals[string(cmd[0])]; ok {
+		applyRemovals(c, remove, cmd[1:])
+	} else if spec, ok := commands[string(cmd[0])]; ok && spec.write && !spec.noRaft {
+		spec.handler(c, cmd)
+	} else {
+		_ = c.writer.WriteError(fmt.Sprintf("'%s' can't be applied from the raft log", cmd[0]))
+	}
+	_ = c.writer.Flush()
+	return buf.Bytes()
+}
+
+// snapshotRaft captures the data for a Raft snapshot. Versions are kept, as
+// VEXPIRED, VEVICTED and IFVERSION entries depend on them, so every node of
+// a group must run with the same number of shards.
+func snapshotRaft() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := store.WriteSnapshot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restoreRaft replaces the data with a Raft snapshot's
+func restoreRaft(data []byte) error {
+	return store.LoadSnapshot(bytes.NewReader(data))
+}
+
+// applyRemovals runs a VEXPIRED or VEVICTED entry, replying with the number
+// of keys removed. Keys written since the leader chose them are kept.
+func applyRemovals(c *client, remove func(key string, version uint64) bool, args [][]byte) {
+	if len(args)%2 != 0 {
+		_ = c.writer.WriteError("wrong number of arguments for key removal")
+		return
+	}
+
+	removed := 0
+	for i := 0; i < len(args); i += 2 {
+		version, err := strconv.ParseUint(string(args[i+1]), 10, 64)
+		if err != nil {
+			_ = c.writer.WriteError("version is not an integer or out of range")
+			return
+		}
+		if remove(string(args[i]), version) {
+			removed++
+		}
+	}
+	_ = c.writer.WriteInteger(int64(removed))
+}
+
+// handleRaft handles the RAFT command: RAFT STATUS reports this node's
+// view of the group, while RAFT VOTE, RAFT APPEND and RAFT SNAPSHOT carry
+// requests between nodes
+func handleRaft(c *client, cmd [][]byte) {
+	if raftNode == nil {
+		_ = c.writer.WriteError("raft is not enabled")
+		return
+	}
+	if len(cmd) < 2 {
+		_ = c.writer.WriteError("wrong number of arguments for 'raft' command")
+		return
+	}
+
+	if strings.EqualFold(string(cmd[1]), "STATUS") {
+		s := raftNode.Status()
+		_ = c.writer.WriteArray([]string{
+			"id", s.ID,
+			"state", s.State.String(),
+			"term", strconv.FormatUint(s.Term, 10),
+			"leader", s.Leader,
+			"last_index", strconv.FormatUint(s.LastIndex, 10),
+			"commit_index", strconv.FormatUint(s.CommitIndex, 10),
+			"applied_index", strconv.FormatUint(s.AppliedIndex, 10),
+		})
+		return
+	}
+
+	reply, err := raft.HandleCommand(raftNode, cmd[1:])
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	_ = c.writer.WriteArray(reply)
+}
+
+// formatVector formats values the way VSET parses them, without losing
+// precision
+func formatVector(values []float32) string {
+	b := []byte{'['}
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, float64(v), 'g', -1, 32)
+	}
+	return string(append(b, ']'))
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/raft"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+func TestPinExpiry(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"EXPIRE k 10", "PEXPIREAT k 1700000010000"},
+		{"PEXPIRE k 1500", "PEXPIREAT k 1700000001500"},
+		{"EXPIREAT k 1700000020", "PEXPIREAT k 1700000020000"},
+		{"PEXPIREAT k 1700000000001", "PEXPIREAT k 1700000000001"},
+		{"EXPIRE k 0", "VDEL k"},
+		{"PEXPIRE k -5", "VDEL k"},
+		{"EXPIREAT k 1600000000", "VDEL k"},
+		{"EXPIRE k soon", "EXPIRE k soon"},
+		{"EXPIRE k", "EXPIRE k"},
+		{"VSET k [1,2] EX 10 NX", "VSET k [1,2] PXAT 1700000010000 NX"},
+		{"VSET k [1,2] WITHVERSION px 5", "VSET k [1,2] WITHVERSION PXAT 1700000000005"},
+		{"VSET k [1,2] PXAT 1600000000000", "VSET k [1,2] PXAT 1600000000000"},
+		{"VSET k [1,2] EX 0", "VSET k [1,2] EX 0"},
+		{"VSET k [1,2]", "VSET k [1,2]"},
+		{"VGET k", "VGET k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			cmd := bytes.Fields([]byte(tt.cmd))
+			got := pinExpiry(cmd, now)
+			if s := string(bytes.Join(got, []byte(" "))); s != tt.want {
+				t.Errorf("pinExpiry() = %q, want %q", s, tt.want)
+			}
+			if s := string(bytes.Join(cmd, []byte(" "))); s != tt.cmd {
+				t.Errorf("pinExpiry() changed its argument to %q", s)
+			}
+		})
+	}
+}
+
+// raftEntry encodes a command as it is written to the Raft log
+func raftEntry(args ...string) raft.Entry {
+	var buf bytes.Buffer
+	w := protocol.NewRESPWriter(&buf)
+	_ = w.WriteArray(args)
+	_ = w.Flush()
+	return raft.Entry{Index: 1, Command: buf.Bytes()}
+}
+
+func TestApplyRaftRemovals(t *testing.T) {
+	newTestAPI(t)
+	store.SetPassive(true)
+
+	vec := []float32{1, 2}
+	past := time.Now().Add(-time.Second)
+	res, err := store.SetWithOptions("expired", vec, storage.SetOptions{ExpireAt: past})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := strconv.FormatUint(res.Version, 10)
+	res, _ = store.SetWithOptions("victim", vec, storage.SetOptions{})
+	victimVersion := strconv.FormatUint(res.Version, 10)
+
+	tests := []struct {
+		name  string
+		entry raft.Entry
+		want  string
+	}{
+		{"stale version", raftEntry("VEXPIRED", "expired", version+"0"), ":0\r\n"},
+		{"expired", raftEntry("VEXPIRED", "expired", version, "missing", "1"), ":1\r\n"},
+		{"evicted", raftEntry("VEVICTED", "victim", victimVersion), ":1\r\n"},
+		{"odd arguments", raftEntry("VEVICTED", "victim"), "-ERR"},
+		{"bad version", raftEntry("VEXPIRED", "k", "v"), "-ERR"},
+		{"read command", raftEntry("VGET", "k"), "-ERR"},
+		{"migrate", raftEntry("MIGRATE", "127.0.0.1", "1", "k", "0", "1000"), "-ERR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(applyRaftEntry(tt.entry).([]byte)); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("applyRaftEntry() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if n := store.Count(); n != 0 {
+		t.Errorf("Count() = %d after the removals, want 0", n)
+	}
+
+	if _, ok := commands["VEXPIRED"]; ok {
+		t.Error("VEXPIRED can be sent by clients")
+	}
+}
+
+func TestRaftSnapshot(t *testing.T) {
+	newTestAPI(t)
+	store.SetPassive(true)
+	if _, err := store.SetWithOptions("k", []float32{1, 0}, storage.SetOptions{TTL: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	version := store.Version("k")
+
+	data, err := snapshotRaft()
+	if err != nil {
+		t.Fatalf("snapshotRaft() error = %v", err)
+	}
+	store.Clear()
+	_, _ = store.Set("other", []float32{0, 1})
+	if err := restoreRaft(data); err != nil {
+		t.Fatalf("restoreRaft() error = %v", err)
+	}
+
+	if got := store.Version("k"); got != version {
+		t.Errorf("Version(k) = %d after restoring, want %d", got, version)
+	}
+	if ttl, ok := store.TTL("k"); !ok || ttl <= 0 {
+		t.Errorf("TTL(k) = %v, %v after restoring, want the TTL kept", ttl, ok)
+	}
+	if store.Exists("other") {
+		t.Error("restoreRaft() kept a key the snapshot doesn't have")
+	}
+}
+
+func TestApplyRaftPinnedExpiry(t *testing.T) {
+	newTestAPI(t)
+	store.SetPassive(true)
+
+	// Replaying a pinned entry later gives the key the same deadline
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	entry := raftEntry(pinStrings(pinExpiry(bytes.Fields([]byte("VSET k [1,2] EX 3600")), at.Add(-time.Hour)))...)
+	for i := 0; i < 2; i++ {
+		if got := string(applyRaftEntry(entry).([]byte)); got != "+OK\r\n" {
+			t.Fatalf("applyRaftEntry() = %q", got)
+		}
+		want := time.Until(at)
+		ttl, ok := store.TTL("k")
+		if !ok || ttl > want || ttl < want-time.Second {
+			t.Errorf("TTL() = %v, %v, want about %v", ttl, ok, want)
+		}
+	}
+}
+
+// pinStrings converts command arguments to strings
+func pinStrings(cmd [][]byte) []string {
+	args := make([]string, len(cmd))
+	for i, arg := range cmd {
+		args[i] = string(arg)
+	}
+	return args
+}
@@ -0,0 +1,275 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uzqw/vex/internal/replication"
+)
+
+var (
+	// replica is set while the server replicates a leader
+	replica atomic.Pointer[replication.Replica]
+
+	// replicaMu serializes switching leaders
+	replicaMu sync.Mutex
+)
+
+// replicating reports whether the server is a replica, and so read only
+func replicating() bool {
+	return replica.Load() != nil
+}
+
+// parseReplicaOf parses the host and port arguments of REPLICAOF
+func parseReplicaOf(args []string) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", errors.New(`expected "host port"`)
+	}
+	if n, err := strconv.Atoi(args[1]); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q", args[1])
+	}
+	return args[0], args[1], nil
+}
+
+// startReplica makes the server a replica of host:leaderPort, replacing
+// any leader it was following. Keys are only expired or evicted when the
+// leader says so, as the replica would otherwise drift from it.
+func startReplica(host, leaderPort string) {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+
+	if old := replica.Load(); old != nil {
+		old.Stop()
+	}
+	store.SetPassive(true)
+	listening, _ := strconv.Atoi(*port)
+	replica.Store(replication.Start(store, replication.Config{
+		Host:          host,
+		Port:          leaderPort,
+		User:          *leaderUser,
+		Password:      *leaderAuth,
+		ListeningPort: listening,
+		Logger:        log,
+	}))
+	log.Info("replicating leader", slog.String("leader", host+":"+leaderPort))
+}
+
+// stopReplica makes the server a leader again, keeping its data
+func stopReplica() {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+
+	if old := replica.Swap(nil); old != nil {
+		old.Stop()
+		store.SetPassive(false)
+		log.Info("stopped replicating, now a leader")
+	}
+}
+
+// handleReplicaOf handles the REPLICAOF command: REPLICAOF host port
+// makes the server a read-only replica of another; REPLICAOF NO ONE turns
+// it back into a leader, keeping the data it has.
+func handleReplicaOf(c *client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'replicaof' command")
+		return
+	}
+	// Stopping a replica waits for it to finish applying changes, which it
+	// can't do while EXEC holds every shard
+	if c.inExec() {
+		_ = c.writer.WriteError("REPLICAOF can't be run inside a transaction")
+		return
+	}
+	if raftNode != nil {
+		_ = c.writer.WriteError("REPLICAOF is not supported in raft mode")
+		return
+	}
+	if clusterState != nil {
+		_ = c.writer.WriteError("REPLICAOF is not supported in cluster mode")
+		return
+	}
+
+	if strings.EqualFold(string(cmd[1]), "NO") && strings.EqualFold(string(cmd[2]), "ONE") {
+		stopReplica()
+		_ = c.writer.WriteSimpleString("OK")
+		return
+	}
+
+	host, leaderPort, err := parseReplicaOf([]string{string(cmd[1]), string(cmd[2])})
+	if err != nil {
+		_ = c.writer.WriteError(err.Error())
+		return
+	}
+	startReplica(host, leaderPort)
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handleReplConf handles the REPLCONF command a replica sends before
+// PSYNC: REPLCONF listening-port port. Other options are accepted and
+// ignored, as Redis replicas send some this server has no use for.
+func handleReplConf(c *client, cmd [][]byte) {
+	if len(cmd) < 3 || len(cmd)%2 == 0 {
+		_ = c.writer.WriteError("wrong number of arguments for 'replconf' command")
+		return
+	}
+	for i := 1; i < len(cmd); i += 2 {
+		if strings.EqualFold(string(cmd[i]), "listening-port") {
+			port, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil || port < 0 || port > 65535 {
+				_ = c.writer.WriteError("invalid listening port")
+				return
+			}
+			c.replPort = port
+		}
+	}
+	_ = c.writer.WriteSimpleString("OK")
+}
+
+// handlePSync handles the PSYNC command: PSYNC leader-id offset
+// The connection becomes a replication stream until the replica goes away,
+// and is then closed.
+func handlePSync(c *client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		_ = c.writer.WriteError("wrong number of arguments for 'psync' command")
+		return
+	}
+	if c.inExec() {
+		_ = c.writer.WriteError("PSYNC can't be run inside a transaction")
+		return
+	}
+	offset, err := strconv.ParseUint(string(cmd[2]), 10, 64)
+	if err != nil {
+		_ = c.writer.WriteError("invalid replication offset")
+		return
+	}
+
+	c.log.Info("replica connected", slog.Int("listening_port", c.replPort))
+	err = leader.Serve(c.ctx, c.conn, c.reader, c.writer, replication.SyncRequest{
+		ID:            string(cmd[1]),
+		Offset:        offset,
+		ListeningPort: c.replPort,
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.log.Info("replica disconnected", slog.String("reason", err.Error()))
+	}
+	_ = c.conn.Close()
+}
+
+// handleRole handles the ROLE command, replying as Redis does: a leader
+// with ["master", offset, [[ip, port, offset], ...]], a replica with
+// ["slave", host, port, state, offset]
+func handleRole(c *client, _ [][]byte) {
+	if r := replica.Load(); r != nil {
+		s := r.Status()
+		port, _ := strconv.Atoi(s.Port)
+		_ = c.writer.WriteArrayHeader(5)
+		_ = c.writer.WriteBulkString("slave")
+		_ = c.writer.WriteBulkString(s.Host)
+		_ = c.writer.WriteInteger(int64(port))
+		_ = c.writer.WriteBulkString(s.State)
+		_ = c.writer.WriteInteger(int64(s.Offset))
+		return
+	}
+
+	replicas := leader.Replicas()
+	_ = c.writer.WriteArrayHeader(3)
+	_ = c.writer.WriteBulkString("master")
+	_ = c.writer.WriteInteger(int64(leader.Offset()))
+	_ = c.writer.WriteArrayHeader(len(replicas))
+	for _, info := range replicas {
+		_ = c.writer.WriteArray([]string{info.Addr, strconv.Itoa(info.Port), strconv.FormatUint(info.Offset, 10)})
+	}
+}
+
+// handleInfo handles the INFO command. INFO replication reports the
+// replication state in Redis' format; otherwise it replies like STATS.
+func handleInfo(c *client, cmd [][]byte) {
+	if len(cmd) == 2 && strings.EqualFold(string(cmd[1]), "replication") {
+		_ = c.writer.WriteBulkString(replicationInfo())
+		return
+	}
+	handleStats(c, cmd)
+}
+
+// replicationInfo returns the replication section of INFO. A replica
+// reports its link to the leader, and any server reports the replicas
+// following it and its own offset.
+func replicationInfo() string {
+	var b strings.Builder
+	field := func(name string, value any) {
+		fmt.Fprintf(&b, "%s:%v\r\n", name, value)
+	}
+
+	b.WriteString("# Replication\r\n")
+	if r := replica.Load(); r != nil {
+		s := r.Status()
+		link, lastIO := "down", int64(-1)
+		if s.State == replication.StateConnected {
+			link = "up"
+		}
+		if !s.LastIO.IsZero() {
+			lastIO = int64(time.Since(s.LastIO) / time.Second)
+		}
+		syncing := 0
+		if s.State == replication.StateSync {
+			syncing = 1
+		}
+		field("role", "slave")
+		field("master_host", s.Host)
+		field("master_port", s.Port)
+		field("master_link_status", link)
+		field("master_last_io_seconds_ago", lastIO)
+		field("master_sync_in_progress", syncing)
+		field("slave_repl_offset", s.Offset)
+		field("slave_repl_lag", s.LeaderOffset-s.Offset)
+	} else {
+		field("role", "master")
+	}
+
+	replicas := leader.Replicas()
+	field("connected_slaves", len(replicas))
+	for i, info := range replicas {
+		lag := int64(-1)
+		if !info.LastAck.IsZero() {
+			lag = int64(time.Since(info.LastAck) / time.Second)
+		}
+		field(fmt.Sprintf("slave%d", i), fmt.Sprintf("ip=%s,port=%d,state=%s,offset=%d,lag=%d,offset_lag=%d",
+			info.Addr, info.Port, info.State, info.Offset, lag, leader.Offset()-min(info.Offset, leader.Offset())))
+	}
+
+	full, partial := leader.SyncCounts()
+	field("master_replid", leader.ID())
+	field("master_repl_offset", leader.Offset())
+	field("sync_full", full)
+	field("sync_partial_ok", partial)
+	if backlog := leader.Backlog(); backlog != nil {
+		field("repl_backlog_active", 1)
+		field("repl_backlog_bytes", backlog.Bytes())
+		field("repl_backlog_first_offset", backlog.FirstSeq())
+		field("repl_backlog_histlen", backlog.Len())
+	} else {
+		field("repl_backlog_active", 0)
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplicaLeavesExpiryToLeader(t *testing.T) {
+	newTestAPI(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, leaderPort, _ := net.SplitHostPort(ln.Addr().String())
+	_ = ln.Close()
+
+	startReplica(host, leaderPort)
+	t.Cleanup(stopReplica)
+	if _, err := store.SetWithTTL("k", []float32{1, 0}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// A replica hides the expired key but keeps it until the leader
+	// removes it
+	if _, ok := store.Get("k"); ok {
+		t.Error("Get() found an expired key on a replica")
+	}
+	if _, ok := store.ExpiredVersion("k"); !ok {
+		t.Error("a replica removed an expired key itself")
+	}
+
+	// Once it leads, it expires keys itself again
+	stopReplica()
+	store.Get("k")
+	if _, ok := store.ExpiredVersion("k"); ok {
+		t.Error("an expired key was kept after REPLICAOF NO ONE")
+	}
+}
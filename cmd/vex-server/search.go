@@ -0,0 +1,68 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sync/atomic"
+
+	"github.com/uzqw/vex/internal/acl"
+	"github.com/uzqw/vex/internal/coalesce"
+	"github.com/uzqw/vex/internal/vector"
+)
+
+var (
+	// searches lets identical searches in flight share one scan of the
+	// shards
+	searches coalesce.Group[searchKey, []vector.SearchResult]
+
+	// writeGen counts the changes to the stored vectors. A search only
+	// shares the result of one that started after the latest change, so
+	// it always sees the writes made before it.
+	writeGen atomic.Uint64
+)
+
+// searchKey identifies identical searches
+type searchKey struct {
+	query string // The query's components as raw bits
+	k     int
+	user  string // The user whose key patterns filter the results, empty for none
+}
+
+// search returns the k vectors most similar to query among the keys u may
+// access, sharing the result of an identical search in flight if there is
+// one. The results must not be modified.
+func search(query []float32, k int, u *acl.User) ([]vector.SearchResult, error) {
+	var filter func(string) bool
+	user := ""
+	if u != nil && !u.AllKeys() {
+		filter, user = u.CanAccessKey, u.Name
+	}
+	run := func() ([]vector.SearchResult, error) {
+		return db.SearchFiltered(query, k, filter)
+	}
+	if *searchCoalesceWait <= 0 {
+		return run()
+	}
+
+	b := make([]byte, 0, 4*len(query))
+	for _, v := range query {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+	key := searchKey{query: string(b), k: k, user: user}
+	results, err, _ := searches.Do(key, writeGen.Load(), run)
+	return results, err
+}
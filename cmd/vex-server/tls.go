@@ -0,0 +1,100 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// buildTLSConfig creates the server TLS configuration from the command line flags
+func buildTLSConfig() (*tls.Config, error) {
+	if *tlsCert == "" || *tlsKey == "" {
+		return nil, errors.New("-tls-cert and -tls-key are required when -tls-port is set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	switch *tlsMinVersion {
+	case "1.2":
+		cfg.MinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported -tls-min-version %q: use 1.2 or 1.3", *tlsMinVersion)
+	}
+
+	switch strings.ToLower(*tlsAuthClients) {
+	case "no":
+		cfg.ClientAuth = tls.NoClientCert
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "yes":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported -tls-auth-clients %q: use no, optional or yes", *tlsAuthClients)
+	}
+
+	if cfg.ClientAuth != tls.NoClientCert {
+		if *tlsCACert == "" {
+			return nil, errors.New("-tls-ca-cert is required to verify client certificates")
+		}
+		pem, err := os.ReadFile(*tlsCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *tlsCACert)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// tlsHandshake completes the handshake up front so that handshake failures are
+// reported as such and the client certificate is known before the first command.
+// It returns the verified client certificate subject, or "" if none was sent.
+func tlsHandshake(ctx context.Context, conn *tls.Conn) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	return state.PeerCertificates[0].Subject.String(), nil
+}
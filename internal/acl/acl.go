@@ -0,0 +1,493 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/uzqw/vex/internal/glob"
+)
+
+// DefaultUser is the user new connections are authenticated as when it
+// requires no password, and the user AUTH <password> logs in as
+const DefaultUser = "default"
+
+// ErrDeleteDefaultUser is returned when trying to delete the default user
+var ErrDeleteDefaultUser = errors.New("the 'default' user cannot be removed")
+
+// Category is a set of command categories, used as a bit mask
+type Category uint32
+
+const (
+	// CategoryConnection covers commands every user may run (PING, AUTH, ...)
+	CategoryConnection Category = 1 << iota
+	// CategoryRead covers commands that read individual keys
+	CategoryRead
+	// CategoryWrite covers commands that modify individual keys
+	CategoryWrite
+	// CategorySearch covers similarity search
+	CategorySearch
+	// CategoryAdmin covers server management, including CLEAR and ACL changes
+	CategoryAdmin
+	// CategoryPubSub covers publishing and subscribing to channels
+	CategoryPubSub
+
+	// CategoryAll is every grantable category
+	CategoryAll = CategoryRead | CategoryWrite | CategorySearch | CategoryAdmin | CategoryPubSub
+)
+
+// categoryNames maps the names used in rules (+@read) to categories
+var categoryNames = map[string]Category{
+	"read":   CategoryRead,
+	"write":  CategoryWrite,
+	"search": CategorySearch,
+	"admin":  CategoryAdmin,
+	"pubsub": CategoryPubSub,
+	"all":    CategoryAll,
+}
+
+// User is an immutable snapshot of an ACL user. Registry methods that change
+// a user publish a new snapshot, so a *User can be used without locking.
+type User struct {
+	Name    string
+	Enabled bool
+
+	noPass     bool
+	passwords  []string // hex-encoded SHA-256 of each accepted password
+	categories Category
+	allKeys    bool
+	patterns   []string
+}
+
+// newUser returns a user in the reset state: disabled, with no passwords,
+// no commands and no keys
+func newUser(name string) *User {
+	return &User{Name: name}
+}
+
+// clone returns a deep copy that rules can be applied to
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.patterns = append([]string(nil), u.patterns...)
+	return &c
+}
+
+// CheckPassword reports whether password is accepted for the user.
+// Hashes are compared in constant time.
+func (u *User) CheckPassword(password string) bool {
+	if u.noPass {
+		return true
+	}
+	sum := sha256.Sum256([]byte(password))
+	hash := hex.EncodeToString(sum[:])
+
+	ok := false
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// NoPass reports whether the user accepts any password
+func (u *User) NoPass() bool {
+	return u.noPass
+}
+
+// CanRun reports whether the user may run commands of the given category
+func (u *User) CanRun(c Category) bool {
+	if c == CategoryConnection {
+		return true
+	}
+	return u.categories&c == c
+}
+
+// AllKeys reports whether the user may access every key
+func (u *User) AllKeys() bool {
+	return u.allKeys
+}
+
+// CanAccessKey reports whether the key matches one of the user's key patterns
+func (u *User) CanAccessKey(key string) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, p := range u.patterns {
+		if glob.Match(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the user as a rule list that recreates it, in the same
+// format used by ACL LIST and ACL files
+func (u *User) String() string {
+	var sb strings.Builder
+	sb.WriteString("user ")
+	sb.WriteString(u.Name)
+
+	if u.Enabled {
+		sb.WriteString(" on")
+	} else {
+		sb.WriteString(" off")
+	}
+
+	if u.noPass {
+		sb.WriteString(" nopass")
+	}
+	for _, p := range u.passwords {
+		sb.WriteString(" #")
+		sb.WriteString(p)
+	}
+
+	if u.allKeys {
+		sb.WriteString(" ~*")
+	} else {
+		for _, p := range u.patterns {
+			sb.WriteString(" ~")
+			sb.WriteString(p)
+		}
+	}
+
+	if u.categories == CategoryAll {
+		sb.WriteString(" +@all")
+	} else if u.categories == 0 {
+		sb.WriteString(" -@all")
+	} else {
+		for _, name := range []string{"read", "write", "search", "admin", "pubsub"} {
+			if u.categories&categoryNames[name] != 0 {
+				sb.WriteString(" +@")
+				sb.WriteString(name)
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// apply applies a single rule to the user. Supported rules:
+//
+//	on, off              enable or disable the user
+//	>password, <password add or remove a password
+//	#hash, !hash         add or remove a password by its hex SHA-256
+//	nopass, resetpass    accept any password, or forget all passwords
+//	+@category, -@category, allcommands, nocommands
+//	~pattern, allkeys, resetkeys
+//	reset                return to the state of a new user
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+		return nil
+	case "off":
+		u.Enabled = false
+		return nil
+	case "nopass":
+		u.noPass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.noPass = false
+		u.passwords = nil
+		return nil
+	case "allcommands":
+		u.categories = CategoryAll
+		return nil
+	case "nocommands":
+		u.categories = 0
+		return nil
+	case "allkeys":
+		u.allKeys = true
+		u.patterns = nil
+		return nil
+	case "resetkeys":
+		u.allKeys = false
+		u.patterns = nil
+		return nil
+	case "reset":
+		*u = *newUser(u.Name)
+		return nil
+	}
+
+	if rule == "" {
+		return errors.New("empty rule")
+	}
+
+	switch rule[0] {
+	case '>':
+		sum := sha256.Sum256([]byte(rule[1:]))
+		u.addPassword(hex.EncodeToString(sum[:]))
+	case '<':
+		sum := sha256.Sum256([]byte(rule[1:]))
+		u.removePassword(hex.EncodeToString(sum[:]))
+	case '#':
+		hash, err := parseHash(rule[1:])
+		if err != nil {
+			return err
+		}
+		u.addPassword(hash)
+	case '!':
+		hash, err := parseHash(rule[1:])
+		if err != nil {
+			return err
+		}
+		u.removePassword(hash)
+	case '~':
+		pattern := rule[1:]
+		if pattern == "*" {
+			u.allKeys = true
+			u.patterns = nil
+		} else if !u.allKeys {
+			u.patterns = append(u.patterns, pattern)
+		}
+	case '+', '-':
+		if !strings.HasPrefix(rule[1:], "@") {
+			return fmt.Errorf("unsupported rule '%s': commands are granted by category, e.g. +@read", rule)
+		}
+		c, ok := categoryNames[strings.ToLower(rule[2:])]
+		if !ok {
+			return fmt.Errorf("unknown command category '%s'", rule[2:])
+		}
+		if rule[0] == '+' {
+			u.categories |= c
+		} else {
+			u.categories &^= c
+		}
+	default:
+		return fmt.Errorf("syntax error in rule '%s'", rule)
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.noPass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *User) removePassword(hash string) {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+func parseHash(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		return "", errors.New("password hash must be 64 hex characters (SHA-256)")
+	}
+	return strings.ToLower(s), nil
+}
+
+// Registry holds the ACL users of a server
+type Registry struct {
+	mu    sync.RWMutex
+	users map[string]*User
+
+	// defaultRules are applied to a fresh default user whenever the
+	// registry is (re)loaded, before any rules from a file
+	defaultRules []string
+}
+
+// NewRegistry creates a registry containing only the default user, built by
+// applying defaultRules to a reset user. With no rules the default user is
+// "on nopass allcommands allkeys", i.e. no authentication at all.
+func NewRegistry(defaultRules ...string) (*Registry, error) {
+	if len(defaultRules) == 0 {
+		defaultRules = []string{"on", "nopass", "allcommands", "allkeys"}
+	}
+	r := &Registry{defaultRules: defaultRules}
+
+	users, err := r.baseUsers()
+	if err != nil {
+		return nil, err
+	}
+	r.users = users
+	return r, nil
+}
+
+// baseUsers returns a user map holding only the configured default user
+func (r *Registry) baseUsers() (map[string]*User, error) {
+	def := newUser(DefaultUser)
+	for _, rule := range r.defaultRules {
+		if err := def.apply(rule); err != nil {
+			return nil, fmt.Errorf("default user: %w", err)
+		}
+	}
+	return map[string]*User{DefaultUser: def}, nil
+}
+
+// User returns the current snapshot of a user
+func (r *Registry) User(name string) (*User, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	u, ok := r.users[name]
+	return u, ok
+}
+
+// Authenticate returns the user if it exists, is enabled and accepts the password
+func (r *Registry) Authenticate(name, password string) (*User, bool) {
+	u, ok := r.User(name)
+	if !ok || !u.Enabled || !u.CheckPassword(password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// SetUser creates or modifies a user by applying rules in order. Rules are
+// applied to a copy, so an invalid rule leaves the user unchanged.
+func (r *Registry) SetUser(name string, rules ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return err
+		}
+	}
+
+	r.users[name] = u
+	return nil
+}
+
+// DelUser removes users and returns how many existed. The default user
+// cannot be removed.
+func (r *Registry) DelUser(names ...string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, ErrDeleteDefaultUser
+		}
+	}
+
+	deleted := 0
+	for _, name := range names {
+		if _, ok := r.users[name]; ok {
+			delete(r.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// List describes every user, sorted by name
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.users))
+	for name := range r.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = r.users[name].String()
+	}
+	return out
+}
+
+// Load replaces all users with those described by an ACL file. Each
+// non-empty line that isn't a # comment has the form
+//
+//	user <name> [rules...]
+//
+// The file is fully parsed before anything is replaced, so an error leaves
+// the registry untouched. A default user not mentioned in the file keeps the
+// registry's default rules.
+func (r *Registry) Load(rd io.Reader) error {
+	users, err := r.baseUsers()
+	if err != nil {
+		return err
+	}
+
+	// The first line naming a user starts from the reset state, even for
+	// the default user; later lines for the same user add to it
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(rd)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("line %d: expected 'user <name> [rules...]'", lineNo)
+		}
+
+		name := fields[1]
+		u := users[name]
+		if !seen[name] {
+			u = newUser(name)
+			seen[name] = true
+		}
+		for _, rule := range fields[2:] {
+			if err := u.apply(rule); err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.users = users
+	r.mu.Unlock()
+	return nil
+}
+
+// LoadFile replaces all users with those described by the file at path
+func (r *Registry) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return r.Load(f)
+}
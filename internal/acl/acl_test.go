@@ -0,0 +1,280 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	r, err := NewRegistry()
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	u, ok := r.Authenticate(DefaultUser, "anything")
+	if !ok {
+		t.Fatal("default user should accept any password")
+	}
+	if !u.NoPass() || !u.AllKeys() || !u.CanRun(CategoryAdmin) {
+		t.Errorf("default user = %s, want nopass with all commands and keys", u)
+	}
+}
+
+func TestRequirePassDefault(t *testing.T) {
+	r, err := NewRegistry("on", ">secret", "allcommands", "allkeys")
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	if _, ok := r.Authenticate(DefaultUser, "wrong"); ok {
+		t.Error("Authenticate() accepted a wrong password")
+	}
+	if _, ok := r.Authenticate(DefaultUser, "secret"); !ok {
+		t.Error("Authenticate() rejected the right password")
+	}
+	if u, _ := r.User(DefaultUser); u.NoPass() {
+		t.Error("default user should require a password")
+	}
+}
+
+func TestSetUser(t *testing.T) {
+	r, _ := NewRegistry()
+
+	if err := r.SetUser("alice", "on", ">pw1", ">pw2", "+@read", "+@search", "~doc:*"); err != nil {
+		t.Fatalf("SetUser() error = %v", err)
+	}
+
+	u, ok := r.Authenticate("alice", "pw2")
+	if !ok {
+		t.Fatal("Authenticate(alice) failed")
+	}
+
+	t.Run("categories", func(t *testing.T) {
+		if !u.CanRun(CategoryRead) || !u.CanRun(CategorySearch) {
+			t.Error("alice should be able to read and search")
+		}
+		if u.CanRun(CategoryWrite) || u.CanRun(CategoryAdmin) {
+			t.Error("alice should not be able to write or administer")
+		}
+		if !u.CanRun(CategoryConnection) {
+			t.Error("connection commands are always allowed")
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		if !u.CanAccessKey("doc:1") {
+			t.Error("alice should access doc:1")
+		}
+		if u.CanAccessKey("user:1") {
+			t.Error("alice should not access user:1")
+		}
+	})
+
+	t.Run("incremental changes", func(t *testing.T) {
+		if err := r.SetUser("alice", "<pw1", "-@read", "+@write"); err != nil {
+			t.Fatalf("SetUser() error = %v", err)
+		}
+		if _, ok := r.Authenticate("alice", "pw1"); ok {
+			t.Error("removed password still accepted")
+		}
+		u, ok := r.Authenticate("alice", "pw2")
+		if !ok {
+			t.Fatal("remaining password rejected")
+		}
+		if u.CanRun(CategoryRead) || !u.CanRun(CategoryWrite) {
+			t.Errorf("alice = %s, want write but not read", u)
+		}
+	})
+
+	t.Run("snapshots are immutable", func(t *testing.T) {
+		before, _ := r.User("alice")
+		_ = r.SetUser("alice", "off")
+		if !before.Enabled {
+			t.Error("SetUser() modified a published snapshot")
+		}
+		if _, ok := r.Authenticate("alice", "pw2"); ok {
+			t.Error("disabled user authenticated")
+		}
+	})
+
+	t.Run("invalid rule leaves user unchanged", func(t *testing.T) {
+		before, _ := r.User("alice")
+		if err := r.SetUser("alice", "on", "+@bogus"); err == nil {
+			t.Error("SetUser() accepted an unknown category")
+		}
+		after, _ := r.User("alice")
+		if before != after {
+			t.Error("failed SetUser() replaced the user")
+		}
+	})
+
+	t.Run("new users start reset", func(t *testing.T) {
+		_ = r.SetUser("bob")
+		u, _ := r.User("bob")
+		if u.Enabled || u.CanRun(CategoryRead) || u.CanAccessKey("x") {
+			t.Errorf("bob = %s, want everything off", u)
+		}
+	})
+}
+
+func TestRules(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		rules   []string
+		want    string
+		wantErr bool
+	}{
+		{"reset", []string{"on", "allkeys", "allcommands", "reset"}, "user u off -@all", false},
+		{"hashed password", []string{"#" + strings.ToUpper(hash)}, "user u off #" + hash + " -@all", false},
+		{"remove hash", []string{"#" + hash, "!" + hash}, "user u off -@all", false},
+		{"nopass clears passwords", []string{">x", "nopass"}, "user u off nopass -@all", false},
+		{"password clears nopass", []string{"nopass", "#" + hash}, "user u off #" + hash + " -@all", false},
+		{"patterns", []string{"~a:*", "~b:*"}, "user u off ~a:* ~b:* -@all", false},
+		{"star pattern", []string{"~a:*", "~*"}, "user u off ~* -@all", false},
+		{"resetkeys", []string{"allkeys", "resetkeys", "~c"}, "user u off ~c -@all", false},
+		{"categories", []string{"+@all", "-@admin"}, "user u off +@read +@write +@search +@pubsub", false},
+		{"nocommands", []string{"allcommands", "nocommands"}, "user u off -@all", false},
+		{"case insensitive", []string{"ON", "+@READ"}, "user u on +@read", false},
+		{"bad hash", []string{"#abc"}, "", true},
+		{"single command", []string{"+vset"}, "", true},
+		{"unknown category", []string{"+@nope"}, "", true},
+		{"unknown rule", []string{"whatever"}, "", true},
+		{"empty rule", []string{""}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := NewRegistry()
+			err := r.SetUser("u", tt.rules...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			u, _ := r.User("u")
+			if got := u.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelUser(t *testing.T) {
+	r, _ := NewRegistry()
+	_ = r.SetUser("a", "on")
+	_ = r.SetUser("b", "on")
+
+	n, err := r.DelUser("a", "b", "c")
+	if err != nil || n != 2 {
+		t.Errorf("DelUser() = %d, %v, want 2, nil", n, err)
+	}
+	if _, ok := r.User("a"); ok {
+		t.Error("user a still exists")
+	}
+
+	if _, err := r.DelUser(DefaultUser); !errors.Is(err, ErrDeleteDefaultUser) {
+		t.Errorf("DelUser(default) error = %v, want ErrDeleteDefaultUser", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	r, _ := NewRegistry()
+	_ = r.SetUser("zed", "on", "+@read", "~z:*")
+
+	got := r.List()
+	want := []string{
+		"user default on nopass ~* +@all",
+		"user zed on ~z:* +@read",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("List() = %q, want %q", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("replaces users", func(t *testing.T) {
+		r, _ := NewRegistry("on", ">secret", "allcommands", "allkeys")
+		_ = r.SetUser("stale", "on")
+
+		file := `
+# indexer may only write vectors under doc:
+user indexer on >idx +@write ~doc:*
+user reader on >rd +@read
+user reader +@search ~*
+`
+		if err := r.Load(strings.NewReader(file)); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+
+		if _, ok := r.User("stale"); ok {
+			t.Error("Load() kept a user that isn't in the file")
+		}
+		if _, ok := r.Authenticate(DefaultUser, "secret"); !ok {
+			t.Error("default user lost its configured password")
+		}
+		u, ok := r.Authenticate("reader", "rd")
+		if !ok || !u.CanRun(CategorySearch) || !u.AllKeys() {
+			t.Errorf("reader = %v, want rules from both lines", u)
+		}
+	})
+
+	t.Run("default user in file", func(t *testing.T) {
+		r, _ := NewRegistry()
+		if err := r.Load(strings.NewReader("user default on >pw +@read ~*\n")); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		u, ok := r.Authenticate(DefaultUser, "pw")
+		if !ok || u.NoPass() || u.CanRun(CategoryAdmin) {
+			t.Errorf("default = %v, want the file's definition only", u)
+		}
+	})
+
+	t.Run("errors leave registry untouched", func(t *testing.T) {
+		r, _ := NewRegistry()
+		_ = r.SetUser("keep", "on")
+
+		for _, file := range []string{"user ok on\nuser bad +@nope\n", "nobody here\n", "user\n"} {
+			if err := r.Load(strings.NewReader(file)); err == nil {
+				t.Errorf("Load(%q) should fail", file)
+			}
+		}
+		if _, ok := r.User("keep"); !ok {
+			t.Error("failed Load() replaced the users")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.acl")
+		if err := os.WriteFile(path, []byte("user svc on nopass +@all ~svc:*\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := NewRegistry()
+		if err := r.LoadFile(path); err != nil {
+			t.Fatalf("LoadFile() error = %v", err)
+		}
+		if _, ok := r.Authenticate("svc", ""); !ok {
+			t.Error("svc user not loaded")
+		}
+		if err := r.LoadFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+			t.Error("LoadFile() of a missing file should fail")
+		}
+	})
+}
@@ -0,0 +1,303 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package changelog keeps a bounded, ordered log of the changes made to the
+// storage, which clients read from an offset like a Redis stream. Consumer
+// groups remember how far each reader has been delivered and acknowledged.
+package changelog
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// recordOverhead estimates the bytes a record takes besides its key and
+// vector, for the byte retention limit
+const recordOverhead = 64
+
+var (
+	// ErrTrimmed is returned when records after the requested offset have
+	// already been dropped by retention
+	ErrTrimmed = errors.New("requested records were trimmed from the change log")
+	// ErrNoGroup is returned for a consumer group that does not exist
+	ErrNoGroup = errors.New("no such consumer group")
+	// ErrGroupExists is returned when creating a group that already exists
+	ErrGroupExists = errors.New("consumer group already exists")
+)
+
+// Record is a change with its position in the log. Sequence numbers start
+// at 1 and have no gaps.
+type Record struct {
+	Seq uint64
+	storage.Change
+}
+
+// size estimates the memory held by r
+func (r Record) size() int64 {
+	return int64(len(r.Key) + 4*len(r.Vector) + recordOverhead)
+}
+
+// GroupInfo describes a consumer group
+type GroupInfo struct {
+	Name      string
+	Delivered uint64 // Last sequence number handed out by ReadGroup
+	Acked     uint64 // Every record up to this one has been acknowledged
+	Lag       uint64 // Records in the log not yet delivered to the group
+}
+
+// group is the state of a consumer group
+type group struct {
+	delivered uint64
+	acked     uint64
+}
+
+// Log is a bounded change log, safe for concurrent use. When either limit
+// is exceeded the oldest records are dropped.
+type Log struct {
+	mu      sync.Mutex
+	records []Record // Oldest first; records[0].Seq == first
+	first   uint64   // Sequence number of records[0], or next if empty
+	next    uint64   // Sequence number the next record gets
+	bytes   int64
+
+	maxLen   int   // 0 for no limit on the number of records
+	maxBytes int64 // 0 for no limit on their size
+
+	groups map[string]*group
+
+	// wake is closed and replaced whenever a record is appended, waking
+	// the readers blocked in Wait
+	wake chan struct{}
+}
+
+// New creates an empty log that keeps at most maxLen records and at most
+// maxBytes bytes of them; a limit of 0 disables it
+func New(maxLen int, maxBytes int64) *Log {
+	return &Log{
+		first:    1,
+		next:     1,
+		maxLen:   maxLen,
+		maxBytes: maxBytes,
+		groups:   make(map[string]*group),
+		wake:     make(chan struct{}),
+	}
+}
+
+// Append adds c to the log, trimming the oldest records if needed, and
+// returns its sequence number
+func (l *Log) Append(c storage.Change) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r := Record{Seq: l.next, Change: c}
+	l.next++
+	l.records = append(l.records, r)
+	l.bytes += r.size()
+	l.trim()
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+	return r.Seq
+}
+
+// trim drops the oldest records until the log is within its limits. The
+// newest record is always kept. The caller must hold l.mu.
+func (l *Log) trim() {
+	n := 0
+	for n < len(l.records)-1 &&
+		((l.maxLen > 0 && len(l.records)-n > l.maxLen) || (l.maxBytes > 0 && l.bytes > l.maxBytes)) {
+		l.bytes -= l.records[n].size()
+		l.records[n] = Record{} // Let the vector be collected
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	l.records = l.records[n:]
+	l.first += uint64(n)
+}
+
+// Read returns up to count records with sequence numbers greater than
+// after, or every one of them if count is not positive. It returns
+// ErrTrimmed if some of those records are no longer in the log.
+func (l *Log) Read(after uint64, count int) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.read(after, l.next-1, count)
+}
+
+// read returns up to count records in (after, until]. The caller must hold
+// l.mu.
+func (l *Log) read(after, until uint64, count int) ([]Record, error) {
+	if after+1 < l.first {
+		return nil, ErrTrimmed
+	}
+	if after >= until {
+		return nil, nil
+	}
+	n := int(until - after)
+	if count > 0 && count < n {
+		n = count
+	}
+	start := int(after + 1 - l.first)
+	return append([]Record(nil), l.records[start:start+n]...), nil
+}
+
+// Wait blocks until the log holds a record with a sequence number greater
+// than after, or ctx is done
+func (l *Log) Wait(ctx context.Context, after uint64) error {
+	for {
+		l.mu.Lock()
+		if l.next-1 > after {
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FirstSeq returns the sequence number of the oldest record kept, or the
+// next one to be appended if the log is empty
+func (l *Log) FirstSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.first
+}
+
+// LastSeq returns the sequence number of the newest record, or 0 if none
+// was ever appended
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// Len returns the number of records kept
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.records)
+}
+
+// Bytes returns the estimated memory held by the records kept
+func (l *Log) Bytes() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes
+}
+
+// CreateGroup creates a consumer group that is delivered the records after
+// the sequence number after, which it also counts as acknowledged
+func (l *Log) CreateGroup(name string, after uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.groups[name]; ok {
+		return ErrGroupExists
+	}
+	after = min(after, l.next-1)
+	l.groups[name] = &group{delivered: after, acked: after}
+	return nil
+}
+
+// DestroyGroup removes a consumer group and reports whether it existed
+func (l *Log) DestroyGroup(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.groups[name]
+	delete(l.groups, name)
+	return ok
+}
+
+// ReadGroup returns up to count records for a consumer group. Normally it
+// returns records not yet delivered to the group and marks them delivered;
+// with pending set it instead returns again the records delivered but not
+// yet acknowledged.
+func (l *Log) ReadGroup(name string, count int, pending bool) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	if pending {
+		return l.read(g.acked, g.delivered, count)
+	}
+
+	records, err := l.read(g.delivered, l.next-1, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		g.delivered = records[len(records)-1].Seq
+	}
+	return records, nil
+}
+
+// WaitGroup blocks until a consumer group has records left to be
+// delivered, or ctx is done
+func (l *Log) WaitGroup(ctx context.Context, name string) error {
+	l.mu.Lock()
+	g, ok := l.groups[name]
+	var after uint64
+	if ok {
+		after = g.delivered
+	}
+	l.mu.Unlock()
+	if !ok {
+		return ErrNoGroup
+	}
+	return l.Wait(ctx, after)
+}
+
+// Ack acknowledges every record delivered to a consumer group up to seq
+// and returns the group's acknowledged sequence number afterwards.
+// Records not yet delivered can't be acknowledged.
+func (l *Log) Ack(name string, seq uint64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.groups[name]
+	if !ok {
+		return 0, ErrNoGroup
+	}
+	g.acked = max(g.acked, min(seq, g.delivered))
+	return g.acked, nil
+}
+
+// Groups returns the consumer groups sorted by name
+func (l *Log) Groups() []GroupInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	infos := make([]GroupInfo, 0, len(l.groups))
+	for name, g := range l.groups {
+		infos = append(infos, GroupInfo{
+			Name:      name,
+			Delivered: g.delivered,
+			Acked:     g.acked,
+			Lag:       l.next - 1 - g.delivered,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
@@ -0,0 +1,193 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// set returns an OpSet change for key
+func set(key string) storage.Change {
+	return storage.Change{Op: storage.OpSet, Key: key, Vector: []float32{1, 0}}
+}
+
+// seqs returns the sequence numbers of records
+func seqs(records []Record) []uint64 {
+	out := make([]uint64, len(records))
+	for i, r := range records {
+		out[i] = r.Seq
+	}
+	return out
+}
+
+func TestRead(t *testing.T) {
+	l := New(0, 0)
+	for i := 0; i < 5; i++ {
+		if seq := l.Append(set(fmt.Sprint(i))); seq != uint64(i+1) {
+			t.Fatalf("Append() = %d, want %d", seq, i+1)
+		}
+	}
+
+	records, err := l.Read(0, 0)
+	if err != nil || fmt.Sprint(seqs(records)) != "[1 2 3 4 5]" {
+		t.Errorf("Read(0, 0) = %v, %v", seqs(records), err)
+	}
+	if records[2].Key != "2" || records[2].Op != storage.OpSet {
+		t.Errorf("record 3 = %+v", records[2])
+	}
+
+	records, _ = l.Read(2, 2)
+	if fmt.Sprint(seqs(records)) != "[3 4]" {
+		t.Errorf("Read(2, 2) = %v, want [3 4]", seqs(records))
+	}
+	if records, err := l.Read(5, 0); err != nil || len(records) != 0 {
+		t.Errorf("Read(5, 0) = %v, %v, want nothing", records, err)
+	}
+	if records, err := l.Read(100, 0); err != nil || len(records) != 0 {
+		t.Errorf("Read(100, 0) = %v, %v, want nothing", records, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	t.Run("by count", func(t *testing.T) {
+		l := New(3, 0)
+		for i := 0; i < 10; i++ {
+			l.Append(set("k"))
+		}
+		if l.Len() != 3 || l.FirstSeq() != 8 || l.LastSeq() != 10 {
+			t.Errorf("Len, FirstSeq, LastSeq = %d, %d, %d, want 3, 8, 10", l.Len(), l.FirstSeq(), l.LastSeq())
+		}
+		if _, err := l.Read(6, 0); !errors.Is(err, ErrTrimmed) {
+			t.Errorf("Read(6) error = %v, want ErrTrimmed", err)
+		}
+		if records, err := l.Read(7, 0); err != nil || len(records) != 3 {
+			t.Errorf("Read(7) = %v, %v, want 3 records", seqs(records), err)
+		}
+	})
+
+	t.Run("by bytes", func(t *testing.T) {
+		vec := set("k").Vector
+		limit := 4 * (int64(len("k")+4*len(vec)) + recordOverhead)
+		l := New(0, limit)
+		for i := 0; i < 10; i++ {
+			l.Append(set("k"))
+		}
+		if l.Len() != 4 || l.Bytes() > limit {
+			t.Errorf("Len, Bytes = %d, %d, want 4 records within %d", l.Len(), l.Bytes(), limit)
+		}
+	})
+
+	t.Run("newest record is always kept", func(t *testing.T) {
+		l := New(0, 1)
+		l.Append(set("k"))
+		l.Append(set("k"))
+		if l.Len() != 1 || l.FirstSeq() != 2 {
+			t.Errorf("Len, FirstSeq = %d, %d, want 1, 2", l.Len(), l.FirstSeq())
+		}
+	})
+}
+
+func TestWait(t *testing.T) {
+	l := New(0, 0)
+	l.Append(set("a"))
+
+	if err := l.Wait(context.Background(), 0); err != nil {
+		t.Errorf("Wait() with a record available = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want DeadlineExceeded", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	l.Append(set("b"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() not woken by Append()")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	l := New(0, 0)
+	for i := 0; i < 5; i++ {
+		l.Append(set(fmt.Sprint(i)))
+	}
+
+	if err := l.CreateGroup("g", 1); err != nil {
+		t.Fatalf("CreateGroup() = %v", err)
+	}
+	if err := l.CreateGroup("g", 0); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("CreateGroup() again = %v, want ErrGroupExists", err)
+	}
+	if _, err := l.ReadGroup("nope", 0, false); !errors.Is(err, ErrNoGroup) {
+		t.Errorf("ReadGroup(nope) = %v, want ErrNoGroup", err)
+	}
+
+	records, _ := l.ReadGroup("g", 2, false)
+	if fmt.Sprint(seqs(records)) != "[2 3]" {
+		t.Errorf("ReadGroup() = %v, want [2 3]", seqs(records))
+	}
+	records, _ = l.ReadGroup("g", 0, false)
+	if fmt.Sprint(seqs(records)) != "[4 5]" {
+		t.Errorf("ReadGroup() = %v, want [4 5]", seqs(records))
+	}
+
+	if acked, _ := l.Ack("g", 3); acked != 3 {
+		t.Errorf("Ack(3) = %d, want 3", acked)
+	}
+	records, _ = l.ReadGroup("g", 0, true)
+	if fmt.Sprint(seqs(records)) != "[4 5]" {
+		t.Errorf("pending = %v, want [4 5]", seqs(records))
+	}
+
+	// Acks are cumulative and can't pass what was delivered
+	if acked, _ := l.Ack("g", 2); acked != 3 {
+		t.Errorf("Ack(2) = %d, want 3", acked)
+	}
+	l.Append(set("5"))
+	if acked, _ := l.Ack("g", 100); acked != 5 {
+		t.Errorf("Ack(100) = %d, want 5", acked)
+	}
+
+	infos := l.Groups()
+	want := GroupInfo{Name: "g", Delivered: 5, Acked: 5, Lag: 1}
+	if len(infos) != 1 || infos[0] != want {
+		t.Errorf("Groups() = %+v, want [%+v]", infos, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.WaitGroup(ctx, "g"); err != nil {
+		t.Errorf("WaitGroup() with a record pending = %v", err)
+	}
+
+	if !l.DestroyGroup("g") || l.DestroyGroup("g") {
+		t.Error("DestroyGroup() did not report the group's existence")
+	}
+}
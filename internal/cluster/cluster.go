@@ -0,0 +1,490 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cluster spreads keys over several servers by hash slot, as Redis
+// Cluster does. Every key hashes to one of SlotCount slots, and each slot is
+// served by one node. Nodes learn about each other and about who serves
+// which slot by gossip, and a slot can be moved from one node to another
+// while both keep serving it.
+//
+// Each node is the authority on the slots it claims and versions its claim
+// with a config epoch, raised whenever the claim changes. When two nodes
+// claim a slot, the claim with the higher epoch wins, and the node with the
+// older claim gives the slot up.
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownNode is returned for a node ID the cluster has not met
+	ErrUnknownNode = errors.New("unknown node")
+
+	// ErrSlotBusy is returned when adding a slot that is already served
+	ErrSlotBusy = errors.New("slot is already busy")
+
+	// ErrNotOwner is returned when migrating a slot this node does not
+	// serve, or importing one it does
+	ErrNotOwner = errors.New("slot is not served by this node")
+)
+
+// Node describes a member of the cluster
+type Node struct {
+	ID   string
+	Addr string
+
+	// Epoch is the config epoch of the node's slot claim
+	Epoch uint64
+
+	// Slots are the ranges the node serves, in order
+	Slots []SlotRange
+
+	// Myself marks this node
+	Myself bool
+
+	// LastSeen is when the node last answered gossip, zero if never or if
+	// it is this node
+	LastSeen time.Time
+}
+
+// Route says where a slot is served from this node's point of view
+type Route struct {
+	// Owner is the address of the node serving the slot, empty if none
+	Owner string
+
+	// Local is set if this node serves the slot
+	Local bool
+
+	// MigratingTo is the address of the node the slot is being moved to,
+	// if this node serves it and is moving it
+	MigratingTo string
+
+	// Importing is set if the slot is being moved to this node
+	Importing bool
+}
+
+// member is what this node knows of a node: its latest claim
+type member struct {
+	id       string
+	addr     string
+	epoch    uint64
+	slots    slotSet
+	lastSeen time.Time
+}
+
+// State is one node's view of the cluster
+type State struct {
+	mu      sync.RWMutex
+	myself  *member
+	members map[string]*member
+
+	// owner is derived from the claims: the member serving each slot
+	owner [SlotCount]*member
+
+	// migrating and importing map slots being moved to or from this node
+	// to the other node involved
+	migrating map[int]*member
+	importing map[int]*member
+
+	// onChange is called, without the lock, when this node's slots or the
+	// set of members change, so that the configuration can be saved
+	onChange func()
+}
+
+// New returns the state of a node that knows only itself. An empty id is
+// replaced by a random one.
+func New(id, addr string) *State {
+	if id == "" {
+		id = newID()
+	}
+	me := &member{id: id, addr: addr}
+	return &State{
+		myself:    me,
+		members:   map[string]*member{id: me},
+		migrating: make(map[int]*member),
+		importing: make(map[int]*member),
+	}
+}
+
+// newID returns a random 40 character node ID
+func newID() string {
+	var b [20]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// OnChange registers fn to be called when the configuration changes
+func (s *State) OnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// changed runs the change hook. It must be called without the lock.
+func (s *State) changed() {
+	s.mu.RLock()
+	fn := s.onChange
+	s.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// ID returns this node's ID
+func (s *State) ID() string {
+	return s.myself.id
+}
+
+// Addr returns this node's address
+func (s *State) Addr() string {
+	return s.myself.addr
+}
+
+// CurrentEpoch returns the highest config epoch known
+func (s *State) CurrentEpoch() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentEpoch()
+}
+
+func (s *State) currentEpoch() uint64 {
+	var epoch uint64
+	for _, m := range s.members {
+		epoch = max(epoch, m.epoch)
+	}
+	return epoch
+}
+
+// Route returns how slot is served
+func (s *State) Route(slot int) Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var r Route
+	if owner := s.owner[slot]; owner != nil {
+		r.Owner = owner.addr
+		r.Local = owner == s.myself
+	}
+	if target := s.migrating[slot]; target != nil && r.Local {
+		r.MigratingTo = target.addr
+	}
+	r.Importing = s.importing[slot] != nil
+	return r
+}
+
+// Nodes returns every known node, this one first and the others by ID
+func (s *State) Nodes() []Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make([]Node, 0, len(s.members))
+	for _, m := range s.members {
+		nodes = append(nodes, s.describe(m))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Myself != nodes[j].Myself {
+			return nodes[i].Myself
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// describe returns what is known of m. Its slots are those it serves in
+// this node's view, which may lag behind its own claim. The lock must be
+// held.
+func (s *State) describe(m *member) Node {
+	var served slotSet
+	for slot, owner := range s.owner {
+		if owner == m {
+			served.add(slot)
+		}
+	}
+	return Node{
+		ID:       m.id,
+		Addr:     m.addr,
+		Epoch:    m.epoch,
+		Slots:    served.ranges(),
+		Myself:   m == s.myself,
+		LastSeen: m.lastSeen,
+	}
+}
+
+// SlotOwner is a range of slots and the node serving them
+type SlotOwner struct {
+	SlotRange
+	Node Node
+}
+
+// Slots returns the assigned slot ranges in order, each with its node
+func (s *State) Slots() []SlotOwner {
+	nodes := s.Nodes()
+	var out []SlotOwner
+	for _, n := range nodes {
+		for _, r := range n.Slots {
+			out = append(out, SlotOwner{SlotRange: r, Node: n})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+// Assigned returns the number of slots served by some node
+func (s *State) Assigned() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, owner := range s.owner {
+		if owner != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Migrations returns the slots being moved to and from this node, each
+// with the ID of the other node
+func (s *State) Migrations() (migrating, importing map[int]string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	migrating = make(map[int]string, len(s.migrating))
+	for slot, m := range s.migrating {
+		migrating[slot] = m.id
+	}
+	importing = make(map[int]string, len(s.importing))
+	for slot, m := range s.importing {
+		importing[slot] = m.id
+	}
+	return migrating, importing
+}
+
+// AddSlots makes this node serve slots, which no node may serve yet
+func (s *State) AddSlots(slots []int) error {
+	s.mu.Lock()
+	for _, slot := range slots {
+		if s.owner[slot] != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %d", ErrSlotBusy, slot)
+		}
+	}
+	for _, slot := range slots {
+		s.myself.slots.add(slot)
+	}
+	s.bumpEpoch()
+	s.mu.Unlock()
+	s.changed()
+	return nil
+}
+
+// SetMigrating marks a slot this node serves as being moved to node id
+func (s *State) SetMigrating(slot int, id string) error {
+	s.mu.Lock()
+	target, ok := s.members[id]
+	switch {
+	case !ok || target == s.myself:
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	case s.owner[slot] != s.myself:
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %d", ErrNotOwner, slot)
+	}
+	s.migrating[slot] = target
+	s.mu.Unlock()
+	s.changed()
+	return nil
+}
+
+// SetImporting marks a slot served by another node as being moved to this
+// one from node id
+func (s *State) SetImporting(slot int, id string) error {
+	s.mu.Lock()
+	source, ok := s.members[id]
+	switch {
+	case !ok || source == s.myself:
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	case s.owner[slot] == s.myself:
+		s.mu.Unlock()
+		return fmt.Errorf("slot %d is already served by this node", slot)
+	}
+	s.importing[slot] = source
+	s.mu.Unlock()
+	s.changed()
+	return nil
+}
+
+// SetStable cancels a slot's migration or import
+func (s *State) SetStable(slot int) {
+	s.mu.Lock()
+	delete(s.migrating, slot)
+	delete(s.importing, slot)
+	s.mu.Unlock()
+	s.changed()
+}
+
+// SetNode assigns a slot to node id, ending its migration. Assigning it to
+// this node claims it with a new epoch, which ends an import; assigning a
+// slot this node serves to another gives it up.
+func (s *State) SetNode(slot int, id string) error {
+	s.mu.Lock()
+	m, ok := s.members[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+
+	delete(s.migrating, slot)
+	delete(s.importing, slot)
+	if m == s.myself {
+		s.myself.slots.add(slot)
+		s.bumpEpoch()
+	} else {
+		s.myself.slots.remove(slot)
+		m.slots.add(slot)
+		s.assign()
+	}
+	s.mu.Unlock()
+	s.changed()
+	return nil
+}
+
+// bumpEpoch gives this node's claim an epoch above every other, so that
+// it wins over older claims. The lock must be held.
+func (s *State) bumpEpoch() {
+	s.myself.epoch = s.currentEpoch() + 1
+	s.assign()
+}
+
+// assign derives the slot owners from the claims: of the members claiming
+// a slot, the one with the highest epoch, or the lowest ID if tied. This
+// node gives up the slots it lost. The lock must be held.
+func (s *State) assign() {
+	members := make([]*member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].epoch != members[j].epoch {
+			return members[i].epoch > members[j].epoch
+		}
+		return members[i].id < members[j].id
+	})
+
+	s.owner = [SlotCount]*member{}
+	for _, m := range members {
+		for word, set := range m.slots {
+			for set != 0 {
+				slot := word*64 + bits.TrailingZeros64(set)
+				set &= set - 1
+				if s.owner[slot] == nil {
+					s.owner[slot] = m
+				}
+			}
+		}
+	}
+
+	for slot := 0; slot < SlotCount; slot++ {
+		if s.myself.slots.has(slot) && s.owner[slot] != s.myself {
+			s.myself.slots.remove(slot)
+			delete(s.migrating, slot)
+		}
+		if s.owner[slot] == s.myself {
+			delete(s.importing, slot)
+		}
+	}
+}
+
+// Merge updates the view with the nodes another node gossiped, sender first.
+// A claim replaces the one known for its node if its epoch is higher, or
+// equal and sent by the node itself. It reports whether anything changed.
+func (s *State) Merge(nodes []Node) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	s.mu.Lock()
+	changed := false
+	for i, n := range nodes {
+		if n.ID == s.myself.id {
+			continue
+		}
+		m, ok := s.members[n.ID]
+		if !ok {
+			m = &member{id: n.ID}
+			s.members[n.ID] = m
+			changed = true
+		}
+		if i == 0 {
+			m.lastSeen = time.Now()
+		}
+		if ok && n.Epoch < m.epoch || ok && n.Epoch == m.epoch && i != 0 {
+			continue
+		}
+		if m.addr != n.Addr && n.Addr != "" {
+			m.addr = n.Addr
+			changed = true
+		}
+		var slots slotSet
+		for _, r := range n.Slots {
+			for slot := r.Start; slot <= r.End; slot++ {
+				slots.add(slot)
+			}
+		}
+		if m.epoch != n.Epoch || m.slots != slots {
+			m.epoch, m.slots = n.Epoch, slots
+			changed = true
+		}
+	}
+	if changed {
+		s.assign()
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.changed()
+	}
+	return changed
+}
+
+// Claims returns every known node with the slots it claims, this node
+// first, as they are gossiped. Unlike Nodes, each node's slots are its own
+// claim rather than what it serves in this node's view.
+func (s *State) Claims() []Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := []Node{s.claim(s.myself)}
+	ids := make([]string, 0, len(s.members))
+	for id := range s.members {
+		if id != s.myself.id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		nodes = append(nodes, s.claim(s.members[id]))
+	}
+	return nodes
+}
+
+func (s *State) claim(m *member) Node {
+	return Node{ID: m.id, Addr: m.addr, Epoch: m.epoch, Slots: m.slots.ranges(), Myself: m == s.myself}
+}
@@ -0,0 +1,195 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// exchange gossips between two states in both directions
+func exchange(a, b *State) {
+	b.Merge(a.Claims())
+	a.Merge(b.Claims())
+}
+
+func slots(start, end int) []int {
+	var out []int
+	for slot := start; slot <= end; slot++ {
+		out = append(out, slot)
+	}
+	return out
+}
+
+func TestAddSlots(t *testing.T) {
+	s := New("", "127.0.0.1:7000")
+	if len(s.ID()) != 40 {
+		t.Errorf("ID() = %q, want 40 characters", s.ID())
+	}
+	if r := s.Route(5); r.Owner != "" || r.Local {
+		t.Errorf("Route() of an unassigned slot = %+v", r)
+	}
+
+	if err := s.AddSlots(slots(0, 9)); err != nil {
+		t.Fatalf("AddSlots() error = %v", err)
+	}
+	if r := s.Route(5); r.Owner != "127.0.0.1:7000" || !r.Local {
+		t.Errorf("Route() = %+v, want served here", r)
+	}
+	if err := s.AddSlots([]int{9, 10}); !errors.Is(err, ErrSlotBusy) {
+		t.Errorf("AddSlots() of a busy slot error = %v, want ErrSlotBusy", err)
+	}
+	if s.Assigned() != 10 || s.CurrentEpoch() != 1 {
+		t.Errorf("Assigned() = %d, CurrentEpoch() = %d, want 10 and 1", s.Assigned(), s.CurrentEpoch())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, c := New("a", "127.0.0.1:7000"), New("b", "127.0.0.1:7001"), New("c", "127.0.0.1:7002")
+	_ = a.AddSlots(slots(0, 99))
+	_ = b.AddSlots(slots(100, 199))
+
+	// c learns about a through b, and a about c
+	exchange(a, b)
+	exchange(b, c)
+	exchange(a, b)
+
+	for _, s := range []*State{a, b, c} {
+		if got := fmt.Sprint(s.Slots()); got != fmt.Sprint([]SlotOwner{
+			{SlotRange{0, 99}, s.describeID("a")},
+			{SlotRange{100, 199}, s.describeID("b")},
+		}) {
+			t.Errorf("%s: Slots() = %v", s.ID(), got)
+		}
+		if len(s.Nodes()) != 3 || !s.Nodes()[0].Myself || s.Nodes()[0].ID != s.ID() {
+			t.Errorf("%s: Nodes() = %+v, want 3 nodes, this one first", s.ID(), s.Nodes())
+		}
+	}
+	if r := c.Route(150); r.Owner != "127.0.0.1:7001" || r.Local {
+		t.Errorf("Route() on c = %+v, want served by b", r)
+	}
+	if a.Merge(b.Claims()) {
+		t.Error("Merge() of an unchanged view reported a change")
+	}
+}
+
+// describeID returns the node with id as s describes it
+func (s *State) describeID(id string) Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.describe(s.members[id])
+}
+
+func TestConflictingClaims(t *testing.T) {
+	a, b := New("a", "127.0.0.1:7000"), New("b", "127.0.0.1:7001")
+	_ = a.AddSlots([]int{1, 2})
+	_ = b.AddSlots([]int{2, 3})
+
+	// Equal epochs: the lower ID wins, and b gives the slot up
+	exchange(a, b)
+	for _, s := range []*State{a, b} {
+		if r := s.Route(2); r.Owner != "127.0.0.1:7000" {
+			t.Errorf("%s: Route(2) = %+v, want a", s.ID(), r)
+		}
+	}
+	if got := b.Claims()[0].Slots; fmt.Sprint(got) != "[3]" {
+		t.Errorf("b claims %v after losing slot 2, want [3]", got)
+	}
+
+	// A claim with a newer epoch wins
+	if err := b.SetNode(1, "b"); err != nil {
+		t.Fatalf("SetNode() error = %v", err)
+	}
+	exchange(b, a)
+	for _, s := range []*State{a, b} {
+		if r := s.Route(1); r.Owner != "127.0.0.1:7001" {
+			t.Errorf("%s: Route(1) = %+v, want b", s.ID(), r)
+		}
+	}
+}
+
+func TestMigration(t *testing.T) {
+	a, b := New("a", "127.0.0.1:7000"), New("b", "127.0.0.1:7001")
+	_ = a.AddSlots([]int{5})
+	exchange(a, b)
+
+	if err := b.SetImporting(5, "a"); err != nil {
+		t.Fatalf("SetImporting() error = %v", err)
+	}
+	if err := a.SetMigrating(5, "b"); err != nil {
+		t.Fatalf("SetMigrating() error = %v", err)
+	}
+	if r := a.Route(5); !r.Local || r.MigratingTo != "127.0.0.1:7001" {
+		t.Errorf("Route() on the source = %+v", r)
+	}
+	if r := b.Route(5); r.Local || !r.Importing || r.Owner != "127.0.0.1:7000" {
+		t.Errorf("Route() on the target = %+v", r)
+	}
+	migrating, importing := a.Migrations()
+	if migrating[5] != "b" || len(importing) != 0 {
+		t.Errorf("Migrations() = %v, %v", migrating, importing)
+	}
+
+	// The target takes the slot over and the source learns it by gossip
+	if err := b.SetNode(5, "b"); err != nil {
+		t.Fatalf("SetNode() error = %v", err)
+	}
+	if r := b.Route(5); !r.Local || r.Importing {
+		t.Errorf("Route() on the target after SetNode() = %+v", r)
+	}
+	exchange(b, a)
+	if r := a.Route(5); r.Local || r.MigratingTo != "" || r.Owner != "127.0.0.1:7001" {
+		t.Errorf("Route() on the source after gossip = %+v", r)
+	}
+
+	// Giving a slot away directly works too
+	_ = a.AddSlots([]int{6})
+	_ = a.SetMigrating(6, "b")
+	if err := a.SetNode(6, "b"); err != nil {
+		t.Fatalf("SetNode() error = %v", err)
+	}
+	if r := a.Route(6); r.Local || r.MigratingTo != "" || r.Owner != "127.0.0.1:7001" {
+		t.Errorf("Route() after giving the slot away = %+v", r)
+	}
+
+	a.SetStable(6)
+	if err := a.SetMigrating(6, "b"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("SetMigrating() of a slot served elsewhere error = %v", err)
+	}
+	if err := b.SetImporting(5, "a"); err == nil {
+		t.Error("SetImporting() of a slot served here should fail")
+	}
+	for _, err := range []error{a.SetMigrating(7, "x"), a.SetImporting(7, "a"), a.SetNode(7, "x")} {
+		if !errors.Is(err, ErrUnknownNode) {
+			t.Errorf("error = %v, want ErrUnknownNode", err)
+		}
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	a, b := New("a", "127.0.0.1:7000"), New("b", "127.0.0.1:7001")
+	changes := 0
+	a.OnChange(func() { changes++ })
+
+	_ = a.AddSlots([]int{1})
+	a.Merge(b.Claims())
+	a.Merge(b.Claims())
+	_ = a.SetMigrating(1, "b")
+	a.SetStable(1)
+	if changes != 4 {
+		t.Errorf("OnChange() hook ran %d times, want 4", changes)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// config is the saved form of a node's view, so that it keeps its ID,
+// slots and peers across restarts
+type config struct {
+	ID        string            `json:"id"`
+	Nodes     []configNode      `json:"nodes"`
+	Migrating map[string]string `json:"migrating,omitempty"`
+	Importing map[string]string `json:"importing,omitempty"`
+}
+
+type configNode struct {
+	ID    string `json:"id"`
+	Addr  string `json:"addr"`
+	Epoch uint64 `json:"epoch"`
+	Slots string `json:"slots"`
+}
+
+// Load reads the view saved at path, giving this node the address addr. A
+// missing file yields a new node with a random ID.
+func Load(path, addr string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New("", addr), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil || cfg.ID == "" {
+		return nil, fmt.Errorf("invalid cluster config %s", path)
+	}
+	s := New(cfg.ID, addr)
+	for _, n := range cfg.Nodes {
+		slots, err := parseRanges(n.Slots)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster config %s: %w", path, err)
+		}
+		m := s.myself
+		if n.ID != cfg.ID {
+			m = &member{id: n.ID, addr: n.Addr}
+			s.members[n.ID] = m
+		}
+		m.epoch, m.slots = n.Epoch, *slots
+	}
+	s.assign()
+
+	if err := loadMoves(s, cfg.Migrating, s.migrating); err != nil {
+		return nil, fmt.Errorf("invalid cluster config %s: %w", path, err)
+	}
+	if err := loadMoves(s, cfg.Importing, s.importing); err != nil {
+		return nil, fmt.Errorf("invalid cluster config %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// loadMoves fills moves from the saved slot to node ID map
+func loadMoves(s *State, saved map[string]string, moves map[int]*member) error {
+	for slotStr, id := range saved {
+		slot, err := ParseSlot(slotStr)
+		if err != nil {
+			return err
+		}
+		m, ok := s.members[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+		}
+		moves[slot] = m
+	}
+	return nil
+}
+
+// Save writes the view to path, replacing the file atomically
+func (s *State) Save(path string) error {
+	migrating, importing := s.Migrations()
+	cfg := config{ID: s.ID()}
+	for _, n := range s.Claims() {
+		cfg.Nodes = append(cfg.Nodes, configNode{ID: n.ID, Addr: n.Addr, Epoch: n.Epoch, Slots: formatRanges(n.Slots)})
+	}
+	if len(migrating) > 0 {
+		cfg.Migrating = make(map[string]string, len(migrating))
+		for slot, id := range migrating {
+			cfg.Migrating[strconv.Itoa(slot)] = id
+		}
+	}
+	if len(importing) > 0 {
+		cfg.Importing = make(map[string]string, len(importing))
+		for slot, id := range importing {
+			cfg.Importing[strconv.Itoa(slot)] = id
+		}
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,66 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+
+	s, err := Load(path, "127.0.0.1:7000")
+	if err != nil || len(s.ID()) != 40 {
+		t.Fatalf("Load() of a missing file = %v, %v, want a new node", s, err)
+	}
+
+	a, b := New("a", "127.0.0.1:7000"), New("b", "127.0.0.1:7001")
+	_ = a.AddSlots(slots(0, 9))
+	_ = b.AddSlots(slots(10, 19))
+	exchange(a, b)
+	_ = a.SetMigrating(3, "b")
+	_ = a.SetImporting(12, "b")
+	if err := a.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := Load(path, "10.0.0.1:7000")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.ID() != "a" || loaded.Addr() != "10.0.0.1:7000" {
+		t.Errorf("Load() = %s at %s, want a at the new address", loaded.ID(), loaded.Addr())
+	}
+	if got, want := fmt.Sprint(loaded.Claims()[1:]), fmt.Sprint(a.Claims()[1:]); got != want {
+		t.Errorf("Load() claims = %s, want %s", got, want)
+	}
+	if loaded.Assigned() != 20 || loaded.CurrentEpoch() != a.CurrentEpoch() {
+		t.Errorf("Load() assigned %d slots at epoch %d", loaded.Assigned(), loaded.CurrentEpoch())
+	}
+	migrating, importing := loaded.Migrations()
+	if migrating[3] != "b" || importing[12] != "b" {
+		t.Errorf("Load() migrations = %v, %v", migrating, importing)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, ""); err == nil {
+		t.Error("Load() of an invalid file should fail")
+	}
+}
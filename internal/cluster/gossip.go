@@ -0,0 +1,156 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/pkg/logger"
+)
+
+// Nodes gossip over RESP on their client port. A node sends
+//
+//	CLUSTER GOSSIP [<id> <addr> <epoch> <slots>]...
+//
+// listing itself first and then every node it knows with its claim, slots
+// formatted as "0-99,120" or "-" for none, and the receiver merges the list
+// and replies with its own, as an array of the same fields. A single
+// exchange thus updates both sides.
+
+// gossipFields is the number of fields describing one node
+const gossipFields = 4
+
+// EncodeGossip returns the fields describing nodes
+func EncodeGossip(nodes []Node) []string {
+	fields := make([]string, 0, gossipFields*len(nodes))
+	for _, n := range nodes {
+		fields = append(fields, n.ID, n.Addr, strconv.FormatUint(n.Epoch, 10), formatRanges(n.Slots))
+	}
+	return fields
+}
+
+// DecodeGossip parses fields written by EncodeGossip
+func DecodeGossip(fields [][]byte) ([]Node, error) {
+	if len(fields)%gossipFields != 0 {
+		return nil, errors.New("invalid gossip: wrong number of fields")
+	}
+	nodes := make([]Node, 0, len(fields)/gossipFields)
+	for i := 0; i < len(fields); i += gossipFields {
+		epoch, err := strconv.ParseUint(string(fields[i+2]), 10, 64)
+		if err != nil || len(fields[i]) == 0 {
+			return nil, fmt.Errorf("invalid gossip for node %q", fields[i])
+		}
+		slots, err := parseRanges(string(fields[i+3]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gossip for node %q: %w", fields[i], err)
+		}
+		nodes = append(nodes, Node{ID: string(fields[i]), Addr: string(fields[i+1]), Epoch: epoch, Slots: slots.ranges()})
+	}
+	return nodes, nil
+}
+
+// Gossiper exchanges views with the other nodes
+type Gossiper struct {
+	State *State
+
+	// User and Password authenticate to other nodes if Password is set
+	User     string
+	Password string
+
+	// Interval is how often every other node is contacted
+	Interval time.Duration
+
+	Logger *logger.Logger
+}
+
+// Meet exchanges views with the node at addr, adding it to the cluster
+func (g *Gossiper) Meet(ctx context.Context, addr string) error {
+	return g.exchange(ctx, addr)
+}
+
+// Run gossips with every known node each interval until ctx is cancelled
+func (g *Gossiper) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, n := range g.State.Nodes() {
+			if n.Myself || n.Addr == "" {
+				continue
+			}
+			if err := g.exchange(ctx, n.Addr); err != nil && g.Logger != nil {
+				g.Logger.Debug("cluster gossip failed", slog.String("node", n.ID), slog.String("addr", n.Addr), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// exchange sends this node's view to addr and merges the reply
+func (g *Gossiper) exchange(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, max(g.Interval, time.Second))
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	reader := protocol.NewRESPReader(conn)
+	writer := protocol.NewRESPWriter(conn)
+	call := func(args ...string) ([][]byte, error) {
+		_ = writer.WriteArray(args)
+		if err := writer.Flush(); err != nil {
+			return nil, err
+		}
+		return reader.ReadCommandBytes()
+	}
+
+	if g.Password != "" {
+		args := []string{"AUTH", g.Password}
+		if g.User != "" {
+			args = []string{"AUTH", g.User, g.Password}
+		}
+		if _, err := call(args...); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	reply, err := call(append([]string{"CLUSTER", "GOSSIP"}, EncodeGossip(g.State.Claims())...)...)
+	if err != nil {
+		return err
+	}
+	nodes, err := DecodeGossip(reply)
+	if err != nil {
+		return err
+	}
+	g.State.Merge(nodes)
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+func TestGossipEncoding(t *testing.T) {
+	nodes := []Node{
+		{ID: "a", Addr: "127.0.0.1:7000", Epoch: 3, Slots: []SlotRange{{0, 99}, {120, 120}}},
+		{ID: "b", Addr: "", Epoch: 0},
+	}
+	fields := EncodeGossip(nodes)
+	if got := strings.Join(fields, " "); got != "a 127.0.0.1:7000 3 0-99,120 b  0 -" {
+		t.Errorf("EncodeGossip() = %q", got)
+	}
+
+	args := make([][]byte, len(fields))
+	for i, f := range fields {
+		args[i] = []byte(f)
+	}
+	decoded, err := DecodeGossip(args)
+	if err != nil || fmt.Sprint(decoded) != fmt.Sprint(nodes) {
+		t.Errorf("DecodeGossip() = %v, %v, want %v", decoded, err, nodes)
+	}
+
+	for _, bad := range [][]string{
+		{"a", "addr", "1"},
+		{"a", "addr", "x", "-"},
+		{"", "addr", "1", "-"},
+		{"a", "addr", "1", "9-3"},
+	} {
+		args := make([][]byte, len(bad))
+		for i, f := range bad {
+			args[i] = []byte(f)
+		}
+		if _, err := DecodeGossip(args); err == nil {
+			t.Errorf("DecodeGossip(%q) should fail", bad)
+		}
+	}
+}
+
+// serveGossip answers AUTH and CLUSTER GOSSIP for s on a new listener and
+// returns its address
+func serveGossip(t *testing.T, password string, s func() *State) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = l.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { _ = conn.Close() }()
+				reader := protocol.NewRESPReader(conn)
+				writer := protocol.NewRESPWriter(conn)
+				authed := false
+				for {
+					cmd, err := reader.ReadCommandBytes()
+					if err != nil {
+						return
+					}
+					switch {
+					case string(cmd[0]) == "AUTH":
+						authed = string(cmd[len(cmd)-1]) == password
+						_ = writer.WriteSimpleString("OK")
+					case !authed:
+						_ = writer.WriteErrorCode("NOAUTH", "Authentication required.")
+					default:
+						nodes, err := DecodeGossip(cmd[2:])
+						if err != nil {
+							_ = writer.WriteError(err.Error())
+							break
+						}
+						s().Merge(nodes)
+						_ = writer.WriteArray(EncodeGossip(s().Claims()))
+					}
+					if writer.Flush() != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestGossiper(t *testing.T) {
+	var mu sync.Mutex
+	states := make([]*State, 3)
+	get := func(i int) func() *State {
+		return func() *State {
+			mu.Lock()
+			defer mu.Unlock()
+			return states[i]
+		}
+	}
+	for i := range states {
+		addr := serveGossip(t, "secret", get(i))
+		mu.Lock()
+		states[i] = New(fmt.Sprintf("n%d", i), addr)
+		mu.Unlock()
+		_ = states[i].AddSlots([]int{i})
+	}
+
+	gossipers := make([]*Gossiper, 3)
+	for i, s := range states {
+		gossipers[i] = &Gossiper{State: s, Password: "secret", Interval: 20 * time.Millisecond}
+	}
+
+	// n0 meets n1 and n1 meets n2; gossip spreads the rest
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := gossipers[0].Meet(ctx, states[1].Addr()); err != nil {
+		t.Fatalf("Meet() error = %v", err)
+	}
+	if err := gossipers[1].Meet(ctx, states[2].Addr()); err != nil {
+		t.Fatalf("Meet() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, g := range gossipers {
+		wg.Add(1)
+		go func(g *Gossiper) {
+			defer wg.Done()
+			g.Run(ctx)
+		}(g)
+	}
+	defer wg.Wait()
+	defer cancel()
+
+	// Every node ends up knowing every slot, and hearing from every other
+	// node directly
+	deadline := time.Now().Add(5 * time.Second)
+	for _, s := range states {
+		for !converged(s) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not converge: %+v", s.ID(), s.Nodes())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	wrong := &Gossiper{State: New("x", ""), Password: "wrong", Interval: time.Second}
+	if err := wrong.Meet(ctx, states[0].Addr()); err == nil {
+		t.Error("Meet() with a wrong password should fail")
+	}
+}
+
+// converged reports whether s knows 3 nodes serving a slot each, and has
+// heard from the other two
+func converged(s *State) bool {
+	nodes := s.Nodes()
+	if s.Assigned() != 3 || len(nodes) != 3 {
+		return false
+	}
+	for _, n := range nodes[1:] {
+		if n.LastSeen.IsZero() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of hash slots keys are spread over, as in Redis
+const SlotCount = 16384
+
+// KeySlot returns the hash slot of key: the CRC16 of the key modulo
+// SlotCount. If the key contains a non-empty hash tag, the part between the
+// first { and the next }, only the tag is hashed, so that related keys can
+// be kept in one slot.
+func KeySlot(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+// crc16 is CRC-16/XMODEM, the variant Redis uses for hash slots
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// SlotRange is a range of slots, both ends included
+type SlotRange struct {
+	Start, End int
+}
+
+func (r SlotRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ParseSlot parses a slot number
+func ParseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("invalid slot %q", s)
+	}
+	return slot, nil
+}
+
+// slotSet is a set of slots
+type slotSet [SlotCount / 64]uint64
+
+func (s *slotSet) has(slot int) bool {
+	return s[slot/64]&(1<<(slot%64)) != 0
+}
+
+func (s *slotSet) add(slot int) {
+	s[slot/64] |= 1 << (slot % 64)
+}
+
+func (s *slotSet) remove(slot int) {
+	s[slot/64] &^= 1 << (slot % 64)
+}
+
+// ranges returns the set as sorted, maximal ranges
+func (s *slotSet) ranges() []SlotRange {
+	var out []SlotRange
+	for slot := 0; slot < SlotCount; slot++ {
+		if !s.has(slot) {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].End == slot-1 {
+			out[n-1].End = slot
+		} else {
+			out = append(out, SlotRange{slot, slot})
+		}
+	}
+	return out
+}
+
+// formatRanges formats ranges as "0-99,120", or "-" for none
+func formatRanges(ranges []SlotRange) string {
+	if len(ranges) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseRanges parses the output of formatRanges into a set
+func parseRanges(s string) (*slotSet, error) {
+	set := new(slotSet)
+	if s == "-" {
+		return set, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, found := strings.Cut(part, "-")
+		start, err := ParseSlot(lo)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if found {
+			if end, err = ParseSlot(hi); err != nil || end < start {
+				return nil, fmt.Errorf("invalid slot range %q", part)
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			set.add(slot)
+		}
+	}
+	return set, nil
+}
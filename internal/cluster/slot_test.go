@@ -0,0 +1,64 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	// Values from Redis' CLUSTER KEYSLOT
+	for key, want := range map[string]int{
+		"":                     0,
+		"foo":                  12182,
+		"bar":                  5061,
+		"123456789":            12739,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"foo{}{bar}":           8363,
+		"foo{{bar}}zap":        4015,
+		"foo{bar}{zap}":        5061,
+	} {
+		if got := KeySlot([]byte(key)); got != want {
+			t.Errorf("KeySlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	set, err := parseRanges("0-2,5,7-8")
+	if err != nil {
+		t.Fatalf("parseRanges() error = %v", err)
+	}
+	if got := formatRanges(set.ranges()); got != "0-2,5,7-8" {
+		t.Errorf("ranges round trip = %q", got)
+	}
+
+	set.remove(1)
+	set.add(6)
+	if got := fmt.Sprint(set.ranges()); got != "[0 2 5-8]" {
+		t.Errorf("ranges() = %s, want [0 2 5-8]", got)
+	}
+
+	if empty, err := parseRanges("-"); err != nil || len(empty.ranges()) != 0 || formatRanges(nil) != "-" {
+		t.Errorf("empty set does not round trip: %v, %v", empty.ranges(), err)
+	}
+	for _, bad := range []string{"", "x", "5-2", "16384", "0-16384", "1,,2"} {
+		if _, err := parseRanges(bad); err == nil {
+			t.Errorf("parseRanges(%q) should fail", bad)
+		}
+	}
+}
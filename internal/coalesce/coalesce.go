@@ -0,0 +1,121 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coalesce lets concurrent identical requests share one result.
+// The first caller for a key runs the request, and callers that arrive
+// while it is in flight wait for its result instead of running it again.
+//
+// A caller only waits for a request that is fresh enough: each request is
+// tagged with a generation, such as a count of writes, taken when it
+// starts, and a caller only joins a request whose generation is at least
+// its own. A search that starts after a write thus never gets the result
+// of one that started before it.
+package coalesce
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Group coalesces calls with the same key. The zero value is ready to use
+// and waits without limit.
+type Group[K comparable, V any] struct {
+	// MaxWait bounds how long a caller waits for another's result before
+	// running the request itself; zero means no limit
+	MaxWait time.Duration
+
+	mu    sync.Mutex
+	calls map[K]*call[V]
+
+	runs, shared, timeouts atomic.Uint64
+}
+
+// call is a request in flight
+type call[V any] struct {
+	gen  uint64
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// Stats counts what happened to the calls of a Group
+type Stats struct {
+	// Runs counts the requests run, Shared the calls that got the result
+	// of another's request, and Timeouts the calls that stopped waiting
+	// for one and ran their own
+	Runs, Shared, Timeouts uint64
+}
+
+// Do returns the result of fn for key. If a call for key started at
+// generation gen or later is in flight, Do waits for its result instead of
+// running fn, and shared is set. Callers waiting on the same call get the
+// same value, which they must not modify.
+func (g *Group[K, V]) Do(key K, gen uint64, fn func() (V, error)) (v V, err error, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok && c.gen >= gen {
+		g.mu.Unlock()
+		if v, err, ok := g.wait(c); ok {
+			g.shared.Add(1)
+			return v, err, true
+		}
+		g.timeouts.Add(1)
+		g.runs.Add(1)
+		v, err = fn()
+		return v, err, false
+	}
+
+	// Later callers join this call, even if an older one is still running
+	c := &call[V]{gen: gen, done: make(chan struct{})}
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.runs.Add(1)
+	defer func() {
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
+
+// wait waits for c to finish, for at most MaxWait
+func (g *Group[K, V]) wait(c *call[V]) (V, error, bool) {
+	if g.MaxWait <= 0 {
+		<-c.done
+		return c.val, c.err, true
+	}
+
+	timer := time.NewTimer(g.MaxWait)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return c.val, c.err, true
+	case <-timer.C:
+		var zero V
+		return zero, nil, false
+	}
+}
+
+// Stats returns the counts of runs, shared results and timeouts so far
+func (g *Group[K, V]) Stats() Stats {
+	return Stats{Runs: g.runs.Load(), Shared: g.shared.Load(), Timeouts: g.timeouts.Load()}
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coalesce
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowCall starts a call for key that runs until release is closed, and
+// waits until it is in flight
+func slowCall(t *testing.T, g *Group[string, int], key string, gen uint64, val int, release chan struct{}) chan int {
+	t.Helper()
+	started := make(chan struct{})
+	result := make(chan int, 1)
+	go func() {
+		v, _, _ := g.Do(key, gen, func() (int, error) {
+			close(started)
+			<-release
+			return val, nil
+		})
+		result <- v
+	}()
+	<-started
+	return result
+}
+
+func TestDoShares(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	leader := slowCall(t, &g, "q", 1, 42, release)
+
+	var runs atomic.Int32
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	shared := make([]bool, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, shared[i] = g.Do("q", 1, func() (int, error) {
+				runs.Add(1)
+				return 0, nil
+			})
+		}(i)
+	}
+
+	// Let the followers reach the wait before the leader finishes
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if v := <-leader; v != 42 {
+		t.Errorf("leader got %d, want 42", v)
+	}
+	if runs.Load() != 0 {
+		t.Errorf("followers ran the request %d times, want 0", runs.Load())
+	}
+	for i := range results {
+		if results[i] != 42 || !shared[i] {
+			t.Errorf("follower %d got %d, shared = %v, want 42 shared", i, results[i], shared[i])
+		}
+	}
+	if st := g.Stats(); st.Runs != 1 || st.Shared != 10 || st.Timeouts != 0 {
+		t.Errorf("Stats() = %+v, want 1 run and 10 shared", st)
+	}
+
+	// Once the call is over, the next one runs again
+	v, _, wasShared := g.Do("q", 1, func() (int, error) { return 7, nil })
+	if v != 7 || wasShared {
+		t.Errorf("Do() after the call = %d, shared = %v, want 7 not shared", v, wasShared)
+	}
+}
+
+func TestDoKeys(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	defer close(release)
+	slowCall(t, &g, "a", 1, 1, release)
+
+	v, _, shared := g.Do("b", 1, func() (int, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Errorf("Do(b) = %d, shared = %v, want its own result", v, shared)
+	}
+}
+
+func TestDoGeneration(t *testing.T) {
+	var g Group[string, int]
+	releaseOld := make(chan struct{})
+	old := slowCall(t, &g, "q", 1, 1, releaseOld)
+
+	// A write happened since the old call started, so it is not reused
+	releaseNew := make(chan struct{})
+	newer := slowCall(t, &g, "q", 2, 2, releaseNew)
+
+	// Callers at either generation join the newer call
+	results := make(chan int, 2)
+	for _, gen := range []uint64{1, 2} {
+		go func(gen uint64) {
+			v, _, _ := g.Do("q", gen, func() (int, error) { return -1, nil })
+			results <- v
+		}(gen)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(releaseNew)
+	for i := 0; i < 2; i++ {
+		if v := <-results; v != 2 {
+			t.Errorf("caller got %d, want the newer call's 2", v)
+		}
+	}
+	if v := <-newer; v != 2 {
+		t.Errorf("newer call got %d, want 2", v)
+	}
+
+	// The old call finishing doesn't disturb later calls
+	close(releaseOld)
+	if v := <-old; v != 1 {
+		t.Errorf("old call got %d, want 1", v)
+	}
+	if st := g.Stats(); st.Runs != 2 || st.Shared != 2 {
+		t.Errorf("Stats() = %+v, want 2 runs and 2 shared", st)
+	}
+}
+
+func TestDoMaxWait(t *testing.T) {
+	g := Group[string, int]{MaxWait: 10 * time.Millisecond}
+	release := make(chan struct{})
+	defer close(release)
+	slowCall(t, &g, "q", 1, 1, release)
+
+	v, _, shared := g.Do("q", 1, func() (int, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Errorf("Do() = %d, shared = %v, want its own result after the wait", v, shared)
+	}
+	if st := g.Stats(); st.Timeouts != 1 || st.Runs != 2 {
+		t.Errorf("Stats() = %+v, want 1 timeout and 2 runs", st)
+	}
+}
+
+func TestDoSharesErrors(t *testing.T) {
+	var g Group[string, int]
+	errSearch := errors.New("search failed")
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _, _ = g.Do("q", 1, func() (int, error) {
+			close(started)
+			<-release
+			return 0, errSearch
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err, _ := g.Do("q", 1, func() (int, error) { return 0, nil })
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-done; !errors.Is(err, errSearch) {
+		t.Errorf("follower error = %v, want the leader's", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ingest queues vector writes and applies them in batches. Each
+// storage shard has its own queue and worker, which takes every write
+// waiting for the shard, up to a batch, and applies them under one
+// acquisition of the shard's lock. Under a heavy write load this takes the
+// lock far less often than writing each vector as it arrives, so searches
+// are held up less.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// ErrClosed is returned for writes and flushes after Close
+var ErrClosed = errors.New("ingest buffer is closed")
+
+const (
+	// DefaultBatchSize is the batch size used if Config.BatchSize is zero
+	DefaultBatchSize = 512
+
+	// DefaultQueueSize is the queue size used if Config.QueueSize is zero
+	DefaultQueueSize = 4096
+)
+
+// Config holds the settings of a Buffer
+type Config struct {
+	// BatchSize is the most writes applied to a shard under one lock
+	BatchSize int
+
+	// QueueSize is the most writes waiting for each shard. Set blocks
+	// while the queue of its key's shard is full.
+	QueueSize int
+
+	// OnApply, if set, is called for each write once it is stored
+	OnApply func(key string, inserted bool)
+
+	// OnError, if set, is called for each write that fails to be stored
+	OnError func(key string, err error)
+}
+
+// Stats describes the activity of a Buffer
+type Stats struct {
+	// Queued is the number of writes waiting to be applied
+	Queued int64
+
+	// Applied counts the writes stored, Failed those that failed, and
+	// Batches the batches they were applied in
+	Applied, Failed, Batches uint64
+
+	// Wait is a moving average of the time from a write being queued to
+	// it being applied, and Apply one of the time taken to apply a batch
+	Wait, Apply time.Duration
+}
+
+// item is a queued write, or a barrier if done is set
+type item struct {
+	write  storage.Write
+	queued time.Time
+	done   chan struct{}
+}
+
+// Buffer queues writes per shard and applies them in batches
+type Buffer struct {
+	store *storage.Storage
+	cfg   Config
+
+	// mu is held shared while queueing and exclusively to close the queues
+	mu     sync.RWMutex
+	closed bool
+	queues []chan item
+	wg     sync.WaitGroup
+
+	queued                   atomic.Int64
+	applied, failed, batches atomic.Uint64
+	wait, apply              atomic.Int64 // Moving averages in nanoseconds
+}
+
+// New starts a worker per shard applying the writes queued for store
+func New(store *storage.Storage, cfg Config) *Buffer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+
+	b := &Buffer{store: store, cfg: cfg, queues: make([]chan item, store.Shards())}
+	for i := range b.queues {
+		b.queues[i] = make(chan item, cfg.QueueSize)
+		b.wg.Add(1)
+		go b.run(b.queues[i])
+	}
+	return b
+}
+
+// Set queues a write of values to key, with a TTL unless ttl is zero. A
+// vector whose dimension differs from the stored ones is rejected at once;
+// other errors are only found when the write is applied, and reported to
+// Config.OnError. It waits for room in the queue until ctx is done.
+func (b *Buffer) Set(ctx context.Context, key string, values []float32, ttl time.Duration) error {
+	if dim := b.store.Dimension(); dim != 0 && len(values) != dim {
+		return fmt.Errorf("%w: expected %d, got %d", storage.ErrDimensionMismatch, dim, len(values))
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	it := item{write: storage.Write{Key: key, Values: values, TTL: ttl}, queued: time.Now()}
+	b.queued.Add(1)
+	select {
+	case b.queues[b.store.ShardOf(key)] <- it:
+		return nil
+	case <-ctx.Done():
+		b.queued.Add(-1)
+		return ctx.Err()
+	}
+}
+
+// Flush waits until every write queued before it was called has been
+// applied, or until ctx is done
+func (b *Buffer) Flush(ctx context.Context) error {
+	var barriers []chan struct{}
+	err := func() error {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		if b.closed {
+			return ErrClosed
+		}
+		for _, q := range b.queues {
+			done := make(chan struct{})
+			select {
+			case q <- item{done: done}:
+				barriers = append(barriers, done)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, done := range barriers {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Stats returns the current statistics
+func (b *Buffer) Stats() Stats {
+	return Stats{
+		Queued:  b.queued.Load(),
+		Applied: b.applied.Load(),
+		Failed:  b.failed.Load(),
+		Batches: b.batches.Load(),
+		Wait:    time.Duration(b.wait.Load()),
+		Apply:   time.Duration(b.apply.Load()),
+	}
+}
+
+// Close applies the writes still queued and stops the workers. Writes
+// queued afterwards fail with ErrClosed.
+func (b *Buffer) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, q := range b.queues {
+			close(q)
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// run applies the writes queued for one shard until the queue is closed
+func (b *Buffer) run(queue chan item) {
+	defer b.wg.Done()
+
+	batch := make([]item, 0, b.cfg.BatchSize)
+	for it := range queue {
+		batch = append(batch[:0], it)
+
+		// Take whatever else is already waiting, up to a batch
+	drain:
+		for len(batch) < b.cfg.BatchSize {
+			select {
+			case it, ok := <-queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, it)
+			default:
+				break drain
+			}
+		}
+		b.applyBatch(batch)
+	}
+}
+
+// applyBatch stores the writes in batch and then releases its barriers,
+// which every write queued before them is part of or precedes
+func (b *Buffer) applyBatch(batch []item) {
+	writes := make([]storage.Write, 0, len(batch))
+	var waited time.Duration
+	start := time.Now()
+	for _, it := range batch {
+		if it.done == nil {
+			writes = append(writes, it.write)
+			waited += start.Sub(it.queued)
+		}
+	}
+
+	if len(writes) > 0 {
+		results := b.store.SetBatch(writes)
+		b.batches.Add(1)
+		b.queued.Add(-int64(len(writes)))
+		updateAverage(&b.apply, time.Since(start))
+		updateAverage(&b.wait, waited/time.Duration(len(writes)))
+
+		for i, res := range results {
+			key := writes[i].Key
+			if res.Err != nil {
+				b.failed.Add(1)
+				if b.cfg.OnError != nil {
+					b.cfg.OnError(key, res.Err)
+				}
+				continue
+			}
+			b.applied.Add(1)
+			if b.cfg.OnApply != nil {
+				b.cfg.OnApply(key, res.Inserted)
+			}
+		}
+	}
+
+	for _, it := range batch {
+		if it.done != nil {
+			close(it.done)
+		}
+	}
+}
+
+// updateAverage folds d into the moving average avg, giving it a weight of
+// 1/8. Concurrent updates may occasionally lose a sample, which is
+// acceptable for a statistic.
+func updateAverage(avg *atomic.Int64, d time.Duration) {
+	old := avg.Load()
+	if old == 0 {
+		avg.Store(int64(d))
+		return
+	}
+	avg.Store(old + (int64(d)-old)/8)
+}
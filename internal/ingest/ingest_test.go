@@ -0,0 +1,246 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+func TestSetAndFlush(t *testing.T) {
+	store := storage.New()
+	b := New(store, Config{})
+	defer b.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if err := b.Set(ctx, fmt.Sprintf("key%d", i), []float32{1, float32(i)}, 0); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := b.Set(ctx, "ttl", []float32{1, 1}, time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if store.Count() != 101 {
+		t.Errorf("Count() = %d after Flush, want 101", store.Count())
+	}
+	if ttl, _ := store.TTL("ttl"); ttl <= 0 {
+		t.Errorf("TTL(ttl) = %v, want the TTL given", ttl)
+	}
+	st := b.Stats()
+	if st.Queued != 0 || st.Applied != 101 || st.Batches == 0 || st.Batches > 101 {
+		t.Errorf("Stats() = %+v, want 101 writes applied and none queued", st)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	store := storage.New()
+	_, _ = store.Set("old", []float32{1, 0})
+
+	var mu sync.Mutex
+	applied := make(map[string]bool)
+	var failed []string
+	b := New(store, Config{
+		OnApply: func(key string, inserted bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			applied[key] = inserted
+		},
+		OnError: func(key string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, key)
+		},
+	})
+	defer b.Close()
+
+	ctx := context.Background()
+	_ = b.Set(ctx, "old", []float32{0, 1}, 0)
+	_ = b.Set(ctx, "new", []float32{0, 1}, 0)
+	_ = b.Set(ctx, "zero", []float32{0, 0}, 0)
+	if err := b.Set(ctx, "wrong", []float32{1, 0, 0}, 0); !errors.Is(err, storage.ErrDimensionMismatch) {
+		t.Errorf("Set() of a wrong dimension: error = %v, want ErrDimensionMismatch", err)
+	}
+	_ = b.Flush(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if inserted, ok := applied["old"]; !ok || inserted {
+		t.Errorf("OnApply(old) inserted = %v, called = %v, want an update", inserted, ok)
+	}
+	if inserted := applied["new"]; !inserted {
+		t.Error("OnApply(new) inserted = false, want true")
+	}
+	if len(failed) != 1 || failed[0] != "zero" {
+		t.Errorf("OnError called for %v, want [zero]", failed)
+	}
+	if st := b.Stats(); st.Failed != 1 || st.Applied != 2 {
+		t.Errorf("Stats() = %+v, want 2 applied and 1 failed", st)
+	}
+}
+
+// blockFirst returns an OnApply callback that blocks the first write until
+// release is closed, so that the following writes pile up in the queue
+func blockFirst(release chan struct{}) func(string, bool) {
+	var once sync.Once
+	return func(string, bool) {
+		once.Do(func() { <-release })
+	}
+}
+
+// sameShardKeys returns n keys that all belong to one shard of store
+func sameShardKeys(store *storage.Storage, n int) []string {
+	var keys []string
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if store.ShardOf(key) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestBatching(t *testing.T) {
+	store := storage.New()
+	release := make(chan struct{})
+	b := New(store, Config{BatchSize: 10, OnApply: blockFirst(release)})
+	defer b.Close()
+
+	ctx := context.Background()
+	keys := sameShardKeys(store, 21)
+	_ = b.Set(ctx, keys[0], []float32{1, 0}, 0)
+	waitFor(t, func() bool { return b.Stats().Queued == 0 })
+
+	// The worker is stuck on the first write while the rest queue up
+	for _, key := range keys[1:] {
+		_ = b.Set(ctx, key, []float32{1, 0}, 0)
+	}
+	if st := b.Stats(); st.Queued != 20 {
+		t.Errorf("Stats().Queued = %d, want 20", st.Queued)
+	}
+	close(release)
+	_ = b.Flush(ctx)
+
+	if st := b.Stats(); st.Batches != 3 || st.Applied != 21 {
+		t.Errorf("Stats() = %+v, want 21 writes in 3 batches", st)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	store := storage.New()
+	release := make(chan struct{})
+	b := New(store, Config{QueueSize: 1, OnApply: blockFirst(release)})
+	defer b.Close()
+
+	keys := sameShardKeys(store, 3)
+	ctx := context.Background()
+	_ = b.Set(ctx, keys[0], []float32{1, 0}, 0)
+	waitFor(t, func() bool { return b.Stats().Queued == 0 })
+	_ = b.Set(ctx, keys[1], []float32{1, 0}, 0)
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Set(ctx, keys[2], []float32{1, 0}, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set() on a full queue: error = %v, want DeadlineExceeded", err)
+	}
+	if err := b.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush() on a full queue: error = %v, want DeadlineExceeded", err)
+	}
+	close(release)
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if store.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", store.Count())
+	}
+}
+
+func TestClose(t *testing.T) {
+	store := storage.New()
+	b := New(store, Config{})
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		_ = b.Set(ctx, fmt.Sprintf("key%d", i), []float32{1, 0}, 0)
+	}
+	b.Close()
+	b.Close()
+
+	if store.Count() != 50 {
+		t.Errorf("Count() = %d after Close, want 50", store.Count())
+	}
+	if err := b.Set(ctx, "late", []float32{1, 0}, 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() after Close: error = %v, want ErrClosed", err)
+	}
+	if err := b.Flush(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Flush() after Close: error = %v, want ErrClosed", err)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	store := storage.New()
+	b := New(store, Config{BatchSize: 16, QueueSize: 64})
+	defer b.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if err := b.Set(ctx, fmt.Sprintf("w%d:%d", w, i), []float32{1, float32(i)}, 0); err != nil {
+					t.Errorf("Set() error = %v", err)
+					return
+				}
+			}
+			if err := b.Flush(ctx); err != nil {
+				t.Errorf("Flush() error = %v", err)
+			}
+		}(w)
+	}
+	go func() {
+		for i := 0; i < 50; i++ {
+			_, _ = store.Search([]float32{1, 0}, 5)
+		}
+	}()
+	wg.Wait()
+
+	if store.Count() != 1600 {
+		t.Errorf("Count() = %d, want 1600", store.Count())
+	}
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
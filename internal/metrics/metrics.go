@@ -28,7 +28,27 @@ type Stats struct {
 	totalCommands     atomic.Uint64 // Total number of commands processed
 	activeConnections atomic.Int64  // Current number of active connections
 	totalKeys         atomic.Uint64 // Total number of keys stored
+	inserts           atomic.Uint64 // Total number of writes that created a key
+	updates           atomic.Uint64 // Total number of writes that replaced an existing key
+	deletes           atomic.Uint64 // Total number of keys deleted by clients
+	clears            atomic.Uint64 // Total number of CLEAR commands
 	memoryUsage       atomic.Uint64 // Approximate memory usage in bytes
+	datasetMemory     atomic.Uint64 // Memory held by stored vectors, as accounted by storage
+	authFailures      atomic.Uint64 // Total number of rejected authentication attempts
+	expiredKeys       atomic.Uint64 // Total number of keys removed because their TTL passed
+	evictedKeys       atomic.Uint64 // Total number of keys removed to stay under maxmemory
+
+	// Asynchronous writes
+	asyncQueued  atomic.Int64  // Writes waiting to be applied
+	asyncWrites  atomic.Uint64 // Total number of queued writes applied
+	asyncErrors  atomic.Uint64 // Total number of queued writes that failed
+	asyncBatches atomic.Uint64 // Total number of batches the writes were applied in
+	asyncWait    atomic.Int64  // Recent time from queueing to applying a write, in nanoseconds
+	asyncApply   atomic.Int64  // Recent time taken to apply a batch, in nanoseconds
+
+	// Search coalescing
+	searchesCoalesced atomic.Uint64 // Total number of searches given the result of an identical one in flight
+	coalesceTimeouts  atomic.Uint64 // Total number of searches that stopped waiting for one and ran
 
 	// Timing
 	startTime time.Time // Server start time for uptime calculation
@@ -69,11 +89,57 @@ func (s *Stats) DecrementKeys() {
 	s.totalKeys.Add(^uint64(0)) // Atomic decrement by 1
 }
 
+// SetTotalKeys sets the total keys counter, for callers that track the count
+// authoritatively elsewhere
+func (s *Stats) SetTotalKeys(n uint64) {
+	s.totalKeys.Store(n)
+}
+
+// IncrementInserts increments the counter of writes that created a key
+func (s *Stats) IncrementInserts() {
+	s.inserts.Add(1)
+}
+
+// IncrementUpdates increments the counter of writes that replaced a key
+func (s *Stats) IncrementUpdates() {
+	s.updates.Add(1)
+}
+
+// IncrementDeletes increments the deleted keys counter
+func (s *Stats) IncrementDeletes() {
+	s.deletes.Add(1)
+}
+
+// IncrementClears increments the CLEAR counter
+func (s *Stats) IncrementClears() {
+	s.clears.Add(1)
+}
+
 // SetMemoryUsage sets the approximate memory usage
 func (s *Stats) SetMemoryUsage(bytes uint64) {
 	s.memoryUsage.Store(bytes)
 }
 
+// SetDatasetMemoryUsage sets the memory held by stored vectors
+func (s *Stats) SetDatasetMemoryUsage(bytes uint64) {
+	s.datasetMemory.Store(bytes)
+}
+
+// IncrementAuthFailures increments the rejected authentication counter
+func (s *Stats) IncrementAuthFailures() {
+	s.authFailures.Add(1)
+}
+
+// IncrementExpiredKeys increments the expired keys counter
+func (s *Stats) IncrementExpiredKeys() {
+	s.expiredKeys.Add(1)
+}
+
+// IncrementEvictedKeys increments the evicted keys counter
+func (s *Stats) IncrementEvictedKeys() {
+	s.evictedKeys.Add(1)
+}
+
 // GetTotalCommands returns the total number of commands processed
 func (s *Stats) GetTotalCommands() uint64 {
 	return s.totalCommands.Load()
@@ -89,11 +155,71 @@ func (s *Stats) GetTotalKeys() uint64 {
 	return s.totalKeys.Load()
 }
 
+// GetInserts returns the number of writes that created a key
+func (s *Stats) GetInserts() uint64 {
+	return s.inserts.Load()
+}
+
+// GetUpdates returns the number of writes that replaced an existing key
+func (s *Stats) GetUpdates() uint64 {
+	return s.updates.Load()
+}
+
+// GetDeletes returns the number of keys deleted by clients
+func (s *Stats) GetDeletes() uint64 {
+	return s.deletes.Load()
+}
+
+// GetClears returns the number of CLEAR commands
+func (s *Stats) GetClears() uint64 {
+	return s.clears.Load()
+}
+
 // GetMemoryUsage returns the approximate memory usage in bytes
 func (s *Stats) GetMemoryUsage() uint64 {
 	return s.memoryUsage.Load()
 }
 
+// GetDatasetMemoryUsage returns the memory held by stored vectors in bytes
+func (s *Stats) GetDatasetMemoryUsage() uint64 {
+	return s.datasetMemory.Load()
+}
+
+// GetAuthFailures returns the number of rejected authentication attempts
+func (s *Stats) GetAuthFailures() uint64 {
+	return s.authFailures.Load()
+}
+
+// SetAsyncWrites records the state of the asynchronous write queues: the
+// writes waiting, the totals applied, failed and batches, and the recent
+// time writes wait to be applied and batches take to apply
+func (s *Stats) SetAsyncWrites(queued int64, applied, failed, batches uint64, wait, apply time.Duration) {
+	s.asyncQueued.Store(queued)
+	s.asyncWrites.Store(applied)
+	s.asyncErrors.Store(failed)
+	s.asyncBatches.Store(batches)
+	s.asyncWait.Store(int64(wait))
+	s.asyncApply.Store(int64(apply))
+}
+
+// SetSearchCoalescing records the number of searches that shared the
+// result of an identical one in flight, and of those that stopped waiting
+// for one and ran themselves
+func (s *Stats) SetSearchCoalescing(coalesced, timeouts uint64) {
+	s.searchesCoalesced.Store(coalesced)
+	s.coalesceTimeouts.Store(timeouts)
+}
+
+// GetExpiredKeys returns the number of keys removed because their TTL passed
+func (s *Stats) GetExpiredKeys() uint64 {
+	return s.expiredKeys.Load()
+}
+
+// GetEvictedKeys returns the number of keys removed to stay under maxmemory
+func (s *Stats) GetEvictedKeys() uint64 {
+	return s.evictedKeys.Load()
+}
+
 // GetUptime returns the server uptime duration
 func (s *Stats) GetUptime() time.Duration {
 	return time.Since(s.startTime)
@@ -105,7 +231,23 @@ type Snapshot struct {
 	TotalCommands     uint64  `json:"total_commands"`
 	ActiveConnections int64   `json:"active_connections"`
 	TotalKeys         uint64  `json:"total_keys"`
+	Inserts           uint64  `json:"inserts"`
+	Updates           uint64  `json:"updates"`
+	Deletes           uint64  `json:"deletes"`
+	Clears            uint64  `json:"clears"`
 	MemoryUsageMB     float64 `json:"memory_usage_mb"`
+	DatasetMemoryMB   float64 `json:"dataset_memory_mb"`
+	AuthFailures      uint64  `json:"auth_failures"`
+	ExpiredKeys       uint64  `json:"expired_keys"`
+	EvictedKeys       uint64  `json:"evicted_keys"`
+	AsyncQueueDepth   int64   `json:"async_queue_depth"`
+	AsyncWrites       uint64  `json:"async_writes"`
+	AsyncErrors       uint64  `json:"async_errors"`
+	AsyncBatches      uint64  `json:"async_batches"`
+	AsyncWaitMs       float64 `json:"async_wait_ms"`
+	AsyncApplyMs      float64 `json:"async_apply_ms"`
+	SearchesCoalesced uint64  `json:"searches_coalesced"`
+	CoalesceTimeouts  uint64  `json:"search_coalesce_timeouts"`
 	Uptime            string  `json:"uptime"`
 	QPS               float64 `json:"qps"` // Queries per second
 }
@@ -126,7 +268,23 @@ func (s *Stats) Snapshot() *Snapshot {
 		TotalCommands:     totalCommands,
 		ActiveConnections: s.GetActiveConnections(),
 		TotalKeys:         s.GetTotalKeys(),
+		Inserts:           s.GetInserts(),
+		Updates:           s.GetUpdates(),
+		Deletes:           s.GetDeletes(),
+		Clears:            s.GetClears(),
 		MemoryUsageMB:     float64(s.GetMemoryUsage()) / 1024 / 1024,
+		DatasetMemoryMB:   float64(s.GetDatasetMemoryUsage()) / 1024 / 1024,
+		AuthFailures:      s.GetAuthFailures(),
+		ExpiredKeys:       s.GetExpiredKeys(),
+		EvictedKeys:       s.GetEvictedKeys(),
+		AsyncQueueDepth:   s.asyncQueued.Load(),
+		AsyncWrites:       s.asyncWrites.Load(),
+		AsyncErrors:       s.asyncErrors.Load(),
+		AsyncBatches:      s.asyncBatches.Load(),
+		AsyncWaitMs:       float64(s.asyncWait.Load()) / float64(time.Millisecond),
+		AsyncApplyMs:      float64(s.asyncApply.Load()) / float64(time.Millisecond),
+		SearchesCoalesced: s.searchesCoalesced.Load(),
+		CoalesceTimeouts:  s.coalesceTimeouts.Load(),
 		Uptime:            uptime.String(),
 		QPS:               qps,
 	}
@@ -90,6 +90,93 @@ func TestStatsMemoryUsage(t *testing.T) {
 	}
 }
 
+func TestStatsAuthFailures(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.IncrementAuthFailures()
+	s.IncrementAuthFailures()
+
+	if s.GetAuthFailures() != 2 {
+		t.Errorf("GetAuthFailures() = %d, want 2", s.GetAuthFailures())
+	}
+	if s.Snapshot().AuthFailures != 2 {
+		t.Errorf("Snapshot.AuthFailures = %d, want 2", s.Snapshot().AuthFailures)
+	}
+}
+
+func TestStatsWrites(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.IncrementInserts()
+	s.IncrementInserts()
+	s.IncrementUpdates()
+	s.IncrementDeletes()
+	s.IncrementClears()
+	s.SetTotalKeys(7)
+
+	snap := s.Snapshot()
+	if snap.Inserts != 2 || snap.Updates != 1 || snap.Deletes != 1 || snap.Clears != 1 {
+		t.Errorf("Snapshot = %+v, want 2 inserts, 1 update, 1 delete and 1 clear", snap)
+	}
+	if snap.TotalKeys != 7 {
+		t.Errorf("Snapshot.TotalKeys = %d, want 7", snap.TotalKeys)
+	}
+}
+
+func TestStatsExpiredKeys(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.IncrementExpiredKeys()
+
+	if s.GetExpiredKeys() != 1 {
+		t.Errorf("GetExpiredKeys() = %d, want 1", s.GetExpiredKeys())
+	}
+	if s.Snapshot().ExpiredKeys != 1 {
+		t.Errorf("Snapshot.ExpiredKeys = %d, want 1", s.Snapshot().ExpiredKeys)
+	}
+}
+
+func TestStatsEviction(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.IncrementEvictedKeys()
+	s.SetDatasetMemoryUsage(2 * 1024 * 1024)
+
+	snap := s.Snapshot()
+	if snap.EvictedKeys != 1 {
+		t.Errorf("Snapshot.EvictedKeys = %d, want 1", snap.EvictedKeys)
+	}
+	if snap.DatasetMemoryMB != 2 {
+		t.Errorf("Snapshot.DatasetMemoryMB = %v, want 2", snap.DatasetMemoryMB)
+	}
+}
+
+func TestStatsAsyncWrites(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.SetAsyncWrites(5, 100, 2, 10, 3*time.Millisecond, 500*time.Microsecond)
+
+	snap := s.Snapshot()
+	if snap.AsyncQueueDepth != 5 || snap.AsyncWrites != 100 || snap.AsyncErrors != 2 || snap.AsyncBatches != 10 {
+		t.Errorf("Snapshot async counters = %d, %d, %d, %d, want 5, 100, 2, 10",
+			snap.AsyncQueueDepth, snap.AsyncWrites, snap.AsyncErrors, snap.AsyncBatches)
+	}
+	if snap.AsyncWaitMs != 3 || snap.AsyncApplyMs != 0.5 {
+		t.Errorf("Snapshot async times = %vms, %vms, want 3ms, 0.5ms", snap.AsyncWaitMs, snap.AsyncApplyMs)
+	}
+}
+
+func TestStatsSearchCoalescing(t *testing.T) {
+	s := &Stats{startTime: time.Now()}
+
+	s.SetSearchCoalescing(30, 2)
+
+	snap := s.Snapshot()
+	if snap.SearchesCoalesced != 30 || snap.CoalesceTimeouts != 2 {
+		t.Errorf("Snapshot coalescing = %d, %d, want 30, 2", snap.SearchesCoalesced, snap.CoalesceTimeouts)
+	}
+}
+
 func TestStatsUptime(t *testing.T) {
 	s := &Stats{startTime: time.Now().Add(-time.Second * 5)}
 
@@ -153,7 +240,7 @@ func TestJSON(t *testing.T) {
 	}
 
 	// Check required fields exist
-	requiredFields := []string{"goroutines", "total_commands", "active_connections", "total_keys", "memory_usage_mb", "uptime", "qps"}
+	requiredFields := []string{"goroutines", "total_commands", "active_connections", "total_keys", "inserts", "updates", "deletes", "clears", "memory_usage_mb", "dataset_memory_mb", "auth_failures", "expired_keys", "evicted_keys", "uptime", "qps"}
 	for _, field := range requiredFields {
 		if _, ok := result[field]; !ok {
 			t.Errorf("JSON() missing field: %s", field)
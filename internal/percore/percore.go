@@ -0,0 +1,256 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package percore partitions a storage's shards between cores for searches.
+// Each core is a goroutine locked to its own OS thread, pinned to a CPU
+// where the platform allows it, that owns a disjoint set of the shards.
+// Searches are fanned out as one message per core, each scanning only the
+// shards it owns, and the partial top-K lists merged by the caller, so a
+// search's scan stays on the CPUs whose caches hold those shards.
+//
+// Cores do not own their shards exclusively: every other operation runs on
+// the caller's goroutine against the storage directly, under the shard
+// locks, as do expiry, eviction and queued asynchronous writes. Handing a
+// single-key operation to the owning core costs a thread switch several
+// times longer than the uncontended shard lock it would save.
+package percore
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/internal/vector"
+)
+
+// queueSize is the number of operations a core's run queue buffers before
+// senders block
+const queueSize = 1024
+
+// Engine runs operations on a storage, fanning searches out to the cores
+// owning its shards
+type Engine struct {
+	store *storage.Storage
+	cores []*core
+
+	// owner maps each shard to the core that owns it
+	owner []*core
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// core is one worker thread and the shards it owns
+type core struct {
+	id     int
+	queue  chan func()
+	shards []int
+}
+
+// New starts n cores over store, sharing its shards round-robin. n <= 0
+// uses GOMAXPROCS, and n is capped at the number of shards so that every core
+// owns at least one shard. Close stops the cores.
+func New(store *storage.Storage, n int) *Engine {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	n = min(n, store.Shards())
+
+	e := &Engine{store: store, cores: make([]*core, n), owner: make([]*core, store.Shards())}
+	for i := range e.cores {
+		e.cores[i] = &core{id: i, queue: make(chan func(), queueSize)}
+	}
+	for shard := range e.owner {
+		c := e.cores[shard%n]
+		c.shards = append(c.shards, shard)
+		e.owner[shard] = c
+	}
+
+	e.wg.Add(n)
+	for _, c := range e.cores {
+		go func(c *core) {
+			defer e.wg.Done()
+			c.run()
+		}(c)
+	}
+	return e
+}
+
+// run pins the core to an OS thread, and that thread to a CPU where the
+// platform allows it, then runs queued operations until the queue closes
+func (c *core) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	pin(c.id)
+
+	for fn := range c.queue {
+		fn()
+	}
+}
+
+// Close stops the cores once their queues are drained. The engine must not
+// be used afterwards; the storage stays usable.
+func (e *Engine) Close() {
+	e.closeOnce.Do(func() {
+		for _, c := range e.cores {
+			close(c.queue)
+		}
+		e.wg.Wait()
+	})
+}
+
+// Cores returns the number of cores
+func (e *Engine) Cores() int {
+	return len(e.cores)
+}
+
+// Storage returns the storage the engine runs on
+func (e *Engine) Storage() *storage.Storage {
+	return e.store
+}
+
+// Get returns the vector stored at key
+func (e *Engine) Get(key string) ([]float32, bool) {
+	return e.store.Get(key)
+}
+
+// GetWithVersion returns the vector stored at key and its version
+func (e *Engine) GetWithVersion(key string) ([]float32, uint64, bool) {
+	return e.store.GetWithVersion(key)
+}
+
+// Set stores values at key, reporting whether the key was new
+func (e *Engine) Set(key string, values []float32) (bool, error) {
+	return e.store.Set(key, values)
+}
+
+// SetWithOptions stores values at key under the given conditions
+func (e *Engine) SetWithOptions(key string, values []float32, opts storage.SetOptions) (storage.SetResult, error) {
+	return e.store.SetWithOptions(key, values, opts)
+}
+
+// SetMany stores several vectors at once
+func (e *Engine) SetMany(keys []string, values [][]float32) (int, error) {
+	return e.store.SetMany(keys, values)
+}
+
+// Delete removes key, reporting whether it existed
+func (e *Engine) Delete(key string) bool {
+	return e.store.Delete(key)
+}
+
+// Exists reports whether key is stored
+func (e *Engine) Exists(key string) bool {
+	return e.store.Exists(key)
+}
+
+// Expire sets a TTL on key
+func (e *Engine) Expire(key string, ttl time.Duration) bool {
+	return e.store.Expire(key, ttl)
+}
+
+// ExpireAt makes key expire at the given time
+func (e *Engine) ExpireAt(key string, at time.Time) bool {
+	return e.store.ExpireAt(key, at)
+}
+
+// TTL returns the time left before key expires
+func (e *Engine) TTL(key string) (time.Duration, bool) {
+	return e.store.TTL(key)
+}
+
+// Persist removes the TTL of key
+func (e *Engine) Persist(key string) bool {
+	return e.store.Persist(key)
+}
+
+// Version returns the version of key
+func (e *Engine) Version(key string) uint64 {
+	return e.store.Version(key)
+}
+
+// Rename moves src to dst
+func (e *Engine) Rename(src, dst string) error {
+	return e.store.Rename(src, dst)
+}
+
+// Copy copies src to dst
+func (e *Engine) Copy(src, dst string, replace bool) (bool, error) {
+	return e.store.Copy(src, dst, replace)
+}
+
+// Scan iterates over the keys of every shard
+func (e *Engine) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	return e.store.Scan(cursor, count, match)
+}
+
+// RandomKey returns a random key accepted by match
+func (e *Engine) RandomKey(match func(key string) bool) (string, bool) {
+	return e.store.RandomKey(match)
+}
+
+// Count returns the number of keys stored
+func (e *Engine) Count() int {
+	return e.store.Count()
+}
+
+// Clear removes every key
+func (e *Engine) Clear() {
+	e.store.Clear()
+}
+
+// SearchFiltered sends the search to every core, each scanning only the
+// shards it owns, and merges their top-K lists. The cores search within one
+// storage.Consistent call, so together they see a multi-shard write either
+// entirely or not at all, as a search in locking mode does.
+func (e *Engine) SearchFiltered(query []float32, k int, filter func(key string) bool) ([]vector.SearchResult, error) {
+	type partial struct {
+		results []vector.SearchResult
+		err     error
+	}
+	replies := make(chan partial, len(e.cores))
+
+	var merged []vector.SearchResult
+	var err error
+	e.store.Consistent(func() {
+		for _, c := range e.cores {
+			c.queue <- func() {
+				results, err := e.store.SearchShardsLocked(query, k, filter, c.shards)
+				replies <- partial{results, err}
+			}
+		}
+
+		// Wait for every core, so none scans after the batch lock is released
+		for range e.cores {
+			p := <-replies
+			if p.err != nil {
+				err = p.err
+			}
+			merged = append(merged, p.results...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Similarity > merged[j].Similarity
+	})
+	if len(merged) > k {
+		merged = merged[:k]
+	}
+	return merged, nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package percore
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		want int
+	}{
+		{n: 1, want: 1},
+		{n: 4, want: 4},
+		{n: 100, want: storage.ShardCount},
+	} {
+		e := New(storage.New(), tt.n)
+		if e.Cores() != tt.want {
+			t.Errorf("New(%d).Cores() = %d, want %d", tt.n, e.Cores(), tt.want)
+		}
+
+		// Every shard is owned by exactly one core
+		owned := make(map[int]int)
+		for _, c := range e.cores {
+			for _, shard := range c.shards {
+				owned[shard]++
+				if e.owner[shard] != c {
+					t.Errorf("New(%d): shard %d listed by core %d but owned by core %d", tt.n, shard, c.id, e.owner[shard].id)
+				}
+			}
+		}
+		for shard := 0; shard < storage.ShardCount; shard++ {
+			if owned[shard] != 1 {
+				t.Errorf("New(%d): shard %d owned by %d cores", tt.n, shard, owned[shard])
+			}
+		}
+		e.Close()
+	}
+
+	e := New(storage.New(), 0)
+	defer e.Close()
+	if e.Cores() < 1 {
+		t.Errorf("New(0).Cores() = %d, want at least 1", e.Cores())
+	}
+}
+
+func TestOperations(t *testing.T) {
+	store := storage.New()
+	e := New(store, 4)
+	defer e.Close()
+
+	if inserted, err := e.Set("a", []float32{1, 0}); err != nil || !inserted {
+		t.Fatalf("Set() = %v, %v, want true, nil", inserted, err)
+	}
+	if _, err := e.Set("b", []float32{1, 0, 0}); err == nil {
+		t.Error("Set() with wrong dimension should fail")
+	}
+	if values, ok := store.Get("a"); !ok || values[0] != 1 {
+		t.Errorf("storage Get() = %v, %v, want the vector set through the engine", values, ok)
+	}
+
+	values, version, ok := e.GetWithVersion("a")
+	if !ok || len(values) != 2 || version == 0 || e.Version("a") != version {
+		t.Errorf("GetWithVersion() = %v, %d, %v", values, version, ok)
+	}
+	if _, err := e.SetWithOptions("a", []float32{0, 1}, storage.SetOptions{CheckVersion: true, Version: version}); err != nil {
+		t.Errorf("SetWithOptions() error = %v", err)
+	}
+	if e.Version("a") == version {
+		t.Error("Version() did not change after SetWithOptions()")
+	}
+
+	if !e.Expire("a", time.Hour) {
+		t.Error("Expire() = false, want true")
+	}
+	if ttl, ok := e.TTL("a"); !ok || ttl <= 0 {
+		t.Errorf("TTL() = %v, %v, want a positive TTL", ttl, ok)
+	}
+	if !e.Persist("a") {
+		t.Error("Persist() = false, want true")
+	}
+
+	if n, err := e.SetMany([]string{"x", "y"}, [][]float32{{1, 1}, {0, 1}}); err != nil || n != 2 {
+		t.Errorf("SetMany() = %d, %v, want 2, nil", n, err)
+	}
+	if err := e.Rename("x", "z"); err != nil || e.Exists("x") || !e.Exists("z") {
+		t.Errorf("Rename() error = %v, or keys not moved", err)
+	}
+	if e.Count() != 3 {
+		t.Errorf("Count() = %d, want 3", e.Count())
+	}
+
+	if !e.Delete("a") || e.Delete("a") {
+		t.Error("Delete() should remove a key exactly once")
+	}
+	if _, ok := e.Get("a"); ok {
+		t.Error("Get() found a deleted key")
+	}
+
+	e.Clear()
+	if e.Count() != 0 {
+		t.Errorf("Count() after Clear() = %d, want 0", e.Count())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := storage.New()
+	e := New(store, 3)
+	defer e.Close()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 500; i++ {
+		_, _ = store.Set(fmt.Sprintf("key:%d", i), []float32{rng.Float32(), rng.Float32(), rng.Float32()})
+	}
+
+	query := []float32{0.3, 0.5, 0.2}
+	filter := func(key string) bool { return key != "key:7" }
+	want, err := store.SearchFiltered(query, 10, filter)
+	if err != nil {
+		t.Fatalf("storage SearchFiltered() error = %v", err)
+	}
+	got, err := e.SearchFiltered(query, 10, filter)
+	if err != nil {
+		t.Fatalf("SearchFiltered() error = %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("SearchFiltered() returned %d results, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Similarity != want[i].Similarity {
+			t.Errorf("result %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := e.SearchFiltered([]float32{1, 0}, 10, nil); err == nil {
+		t.Error("SearchFiltered() with wrong dimension should fail")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	e := New(storage.New(), 4)
+	defer e.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key:%d:%d", g, i)
+				if _, err := e.Set(key, []float32{float32(g), float32(i) + 1}); err != nil {
+					t.Errorf("Set() error = %v", err)
+					return
+				}
+				if _, ok := e.Get(key); !ok {
+					t.Errorf("Get(%q) found nothing after Set()", key)
+				}
+				if i%50 == 0 {
+					if _, err := e.SearchFiltered([]float32{1, 1}, 5, nil); err != nil {
+						t.Errorf("SearchFiltered() error = %v", err)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if e.Count() != 8*200 {
+		t.Errorf("Count() = %d, want %d", e.Count(), 8*200)
+	}
+}
+
+func TestSearchAtomicBatches(t *testing.T) {
+	store := storage.New()
+	e := New(store, 4)
+	defer e.Close()
+
+	// Every batch moves all keys, spread over every core, to one of two
+	// vectors, so a search for one of them scores every key the same
+	const n = 64
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	batch := func(v []float32) [][]float32 {
+		values := make([][]float32, n)
+		for i := range values {
+			values[i] = v
+		}
+		return values
+	}
+	if _, err := e.SetMany(keys, batch([]float32{1, 0})); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			v := []float32{1, 0}
+			if i%2 == 0 {
+				v = []float32{0, 1}
+			}
+			if _, err := e.SetMany(keys, batch(v)); err != nil {
+				t.Errorf("SetMany() error = %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		results, err := e.SearchFiltered([]float32{1, 0}, n, nil)
+		if err != nil {
+			t.Fatalf("SearchFiltered() error = %v", err)
+		}
+		if len(results) != n || results[0].Similarity != results[n-1].Similarity {
+			t.Fatalf("search %d saw part of a batch: scores from %v to %v", i, results[0].Similarity, results[len(results)-1].Similarity)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// The benchmarks below compare the lock-per-shard design, where every
+// goroutine reaches into the shards directly, with routing through the cores.
+// They are most telling with -cpu set to several values on a many-core
+// machine.
+
+const benchDim = 128
+
+func benchVector(rng *rand.Rand) []float32 {
+	v := make([]float32, benchDim)
+	for i := range v {
+		v[i] = rng.Float32()
+	}
+	return v
+}
+
+// benchStores returns the two ways of running operations on a fresh storage
+// holding n vectors
+func benchStores(b *testing.B, n int) (*storage.Storage, *Engine) {
+	store := storage.New()
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < n; i++ {
+		_, _ = store.Set(fmt.Sprintf("key:%d", i), benchVector(rng))
+	}
+	e := New(store, 0)
+	b.Cleanup(e.Close)
+	return store, e
+}
+
+func BenchmarkGet(b *testing.B) {
+	store, e := benchStores(b, 10000)
+	for _, mode := range []struct {
+		name string
+		get  func(key string) ([]float32, bool)
+	}{
+		{"locking", store.Get},
+		{"per-core", e.Get},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					mode.get(fmt.Sprintf("key:%d", i%10000))
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	store, e := benchStores(b, 0)
+	for _, mode := range []struct {
+		name string
+		set  func(key string, values []float32) (bool, error)
+	}{
+		{"locking", store.Set},
+		{"per-core", e.Set},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				v := benchVector(rand.New(rand.NewPCG(3, 4)))
+				i := 0
+				for pb.Next() {
+					_, _ = mode.set(fmt.Sprintf("key:%d", i%10000), v)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	store, e := benchStores(b, 10000)
+	query := benchVector(rand.New(rand.NewPCG(5, 6)))
+	for _, mode := range []struct {
+		name   string
+		search func() error
+	}{
+		{"locking", func() error { _, err := store.SearchFiltered(query, 10, nil); return err }},
+		{"per-core", func() error { _, err := e.SearchFiltered(query, 10, nil); return err }},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := mode.search(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package percore
+
+import (
+	"math/bits"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSet is a Linux cpu_set_t, large enough for 1024 CPUs
+type cpuSet [16]uint64
+
+// pin restricts the calling thread to a single CPU, the n-th (modulo their
+// number) of those it is allowed to run on. It is best effort: on failure
+// the thread keeps its affinity.
+func pin(n int) {
+	var allowed cpuSet
+	if !schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &allowed) {
+		return
+	}
+	count := 0
+	for _, word := range allowed {
+		count += bits.OnesCount64(word)
+	}
+	if count == 0 {
+		return
+	}
+
+	n %= count
+	for i, word := range allowed {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			if n == 0 {
+				var one cpuSet
+				one[i] = 1 << bit
+				schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &one)
+				return
+			}
+			n--
+			word &^= 1 << bit
+		}
+	}
+}
+
+// schedAffinity gets or sets the affinity of the calling thread
+func schedAffinity(trap uintptr, set *cpuSet) bool {
+	_, _, errno := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*set), uintptr(unsafe.Pointer(set)))
+	return errno == 0
+}
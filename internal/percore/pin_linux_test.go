@@ -0,0 +1,46 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package percore
+
+import (
+	"math/bits"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	done := make(chan int)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		pin(3)
+		var set cpuSet
+		if !schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &set) {
+			done <- -1
+			return
+		}
+		count := 0
+		for _, word := range set {
+			count += bits.OnesCount64(word)
+		}
+		done <- count
+	}()
+
+	if count := <-done; count != 1 {
+		t.Errorf("pinned thread may run on %d CPUs, want 1", count)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Reply is a RESP value of any type, as read by ReadReply
+type Reply struct {
+	// Type is the RESP type byte: '+' for a simple string, '-' for an
+	// error, ':' for an integer, '$' for a bulk string or '*' for an array
+	Type byte
+
+	// Str holds simple strings, errors and bulk strings, and Int integers
+	Str string
+	Int int64
+
+	// Array holds the elements of an array
+	Array []Reply
+
+	// Null marks a null bulk string or array
+	Null bool
+}
+
+// maxReplyDepth bounds the nesting of arrays read by ReadReply
+const maxReplyDepth = 32
+
+// ReadReply reads a RESP value of any type, such as a server's reply.
+// Unlike ReadCommand, error replies and nested arrays are returned as
+// values; the error is only set if the value can't be read.
+func (r *RESPReader) ReadReply() (Reply, error) {
+	return r.readReply(0)
+}
+
+func (r *RESPReader) readReply(depth int) (Reply, error) {
+	typ, err := r.reader.ReadByte()
+	if err != nil {
+		return Reply{}, err
+	}
+	reply := Reply{Type: typ}
+
+	switch typ {
+	case '+', '-':
+		reply.Str, err = r.readLine()
+	case ':':
+		var line string
+		if line, err = r.readLine(); err == nil {
+			reply.Int, err = strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				err = fmt.Errorf("%w: invalid integer '%s'", ErrInvalidProtocol, line)
+			}
+		}
+	case '$':
+		var line string
+		if line, err = r.readLine(); err != nil {
+			break
+		}
+		length, convErr := strconv.Atoi(line)
+		switch {
+		case convErr != nil || length < -1:
+			err = fmt.Errorf("%w: invalid bulk string length '%s'", ErrInvalidLength, line)
+		case length == -1:
+			reply.Null = true
+		default:
+			buf := make([]byte, length+2)
+			if _, err = io.ReadFull(r.reader, buf); err == nil && (buf[length] != '\r' || buf[length+1] != '\n') {
+				err = fmt.Errorf("%w: missing CRLF after bulk string", ErrInvalidProtocol)
+			}
+			reply.Str = string(buf[:length])
+		}
+	case '*':
+		if depth >= maxReplyDepth {
+			return Reply{}, fmt.Errorf("%w: arrays nested too deeply", ErrInvalidProtocol)
+		}
+		var line string
+		if line, err = r.readLine(); err != nil {
+			break
+		}
+		count, convErr := strconv.Atoi(line)
+		switch {
+		case convErr != nil || count < -1:
+			err = fmt.Errorf("%w: invalid array length '%s'", ErrInvalidLength, line)
+		case count == -1:
+			reply.Null = true
+		default:
+			reply.Array = make([]Reply, count)
+			for i := range reply.Array {
+				if reply.Array[i], err = r.readReply(depth + 1); err != nil {
+					break
+				}
+			}
+		}
+	default:
+		err = fmt.Errorf("%w: unexpected type byte '%c'", ErrInvalidProtocol, typ)
+	}
+
+	if err != nil {
+		return Reply{}, err
+	}
+	return reply, nil
+}
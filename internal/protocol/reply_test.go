@@ -0,0 +1,107 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Reply
+	}{
+		{"simple string", "+OK\r\n", Reply{Type: '+', Str: "OK"}},
+		{"error", "-ERR bad thing\r\n", Reply{Type: '-', Str: "ERR bad thing"}},
+		{"integer", ":-42\r\n", Reply{Type: ':', Int: -42}},
+		{"bulk string", "$5\r\nhe\r\no\r\n", Reply{Type: '$', Str: "he\r\no"}},
+		{"empty bulk string", "$0\r\n\r\n", Reply{Type: '$'}},
+		{"null bulk string", "$-1\r\n", Reply{Type: '$', Null: true}},
+		{"null array", "*-1\r\n", Reply{Type: '*', Null: true}},
+		{"empty array", "*0\r\n", Reply{Type: '*', Array: []Reply{}}},
+		{
+			"nested array",
+			"*3\r\n:1\r\n*2\r\n$1\r\na\r\n$-1\r\n-ERR inner\r\n",
+			Reply{Type: '*', Array: []Reply{
+				{Type: ':', Int: 1},
+				{Type: '*', Array: []Reply{{Type: '$', Str: "a"}, {Type: '$', Null: true}}},
+				{Type: '-', Str: "ERR inner"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRESPReader(strings.NewReader(tt.input)).ReadReply()
+			if err != nil {
+				t.Fatalf("ReadReply() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadReply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadReplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"unknown type", "?x\r\n", ErrInvalidProtocol},
+		{"bad integer", ":abc\r\n", ErrInvalidProtocol},
+		{"bad bulk length", "$-2\r\n", ErrInvalidLength},
+		{"bad array length", "*x\r\n", ErrInvalidLength},
+		{"missing CRLF", "$2\r\nabcd", ErrInvalidProtocol},
+		{"truncated", "*2\r\n:1\r\n", io.EOF},
+		{"too deep", strings.Repeat("*1\r\n", maxReplyDepth+1) + ":1\r\n", ErrInvalidProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRESPReader(strings.NewReader(tt.input)).ReadReply()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ReadReply() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadReplyRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRESPWriter(&buf)
+	_ = w.WriteArrayHeader(2)
+	_ = w.WriteBulkString("key")
+	_ = w.WriteInteger(7)
+	_ = w.WriteErrorCode("MOVED", "12 127.0.0.1:7002")
+	_ = w.Flush()
+
+	r := NewRESPReader(&buf)
+	first, err := r.ReadReply()
+	if err != nil || len(first.Array) != 2 || first.Array[0].Str != "key" || first.Array[1].Int != 7 {
+		t.Errorf("ReadReply() = %+v, %v, want [key 7]", first, err)
+	}
+	second, err := r.ReadReply()
+	if err != nil || second.Type != '-' || second.Str != "MOVED 12 127.0.0.1:7002" {
+		t.Errorf("ReadReply() = %+v, %v, want the MOVED error", second, err)
+	}
+}
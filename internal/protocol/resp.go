@@ -16,11 +16,12 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
-	"strings"
 )
 
 var (
@@ -33,6 +34,12 @@ var (
 // Uses buffered I/O to reduce syscalls and improve performance
 type RESPReader struct {
 	reader *bufio.Reader
+
+	// Reusable state for ReadCommandBytes. All arguments of a command are
+	// stored back to back in buf; ends records where each one stops.
+	buf  []byte
+	ends []int
+	args [][]byte
 }
 
 // NewRESPReader creates a new RESP reader
@@ -181,6 +188,171 @@ func (r *RESPReader) readLine() (string, error) {
 	return line[:len(line)-2], nil
 }
 
+// ReadCommandBytes reads and parses a RESP command like ReadCommand, but
+// returns the arguments as views into a buffer owned by the reader instead of
+// allocating a string per argument.
+//
+// The returned slices are only valid until the next call to ReadCommandBytes.
+// Callers that need to keep an argument (e.g. as a map key) must copy it.
+func (r *RESPReader) ReadCommandBytes() ([][]byte, error) {
+	r.buf = r.buf[:0]
+	r.ends = r.ends[:0]
+
+	typ, err := r.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case '*':
+		count, err := r.readLengthBytes()
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("%w: invalid array length '%d'", ErrInvalidLength, count)
+		}
+		for i := 0; i < count; i++ {
+			if err := r.appendValue(); err != nil {
+				return nil, err
+			}
+		}
+	case '+', '-', ':', '$':
+		if err := r.reader.UnreadByte(); err != nil {
+			return nil, err
+		}
+		if err := r.appendValue(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unexpected type byte '%c'", ErrInvalidProtocol, typ)
+	}
+
+	// Slice the arguments out only once the buffer has stopped growing
+	r.args = r.args[:0]
+	start := 0
+	for _, end := range r.ends {
+		r.args = append(r.args, r.buf[start:end:end])
+		start = end
+	}
+	return r.args, nil
+}
+
+// appendValue reads a single RESP value and appends its payload to r.buf
+func (r *RESPReader) appendValue() error {
+	typ, err := r.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case '$':
+		length, err := r.readLengthBytes()
+		if err != nil {
+			return err
+		}
+		if length == -1 {
+			// Null bulk string
+			r.ends = append(r.ends, len(r.buf))
+			return nil
+		}
+		if length < 0 {
+			return fmt.Errorf("%w: negative bulk string length %d", ErrInvalidLength, length)
+		}
+
+		start := len(r.buf)
+		r.buf = slices.Grow(r.buf, length+2)[:start+length+2] // +2 for \r\n
+		if _, err := io.ReadFull(r.reader, r.buf[start:]); err != nil {
+			return err
+		}
+		if r.buf[start+length] != '\r' || r.buf[start+length+1] != '\n' {
+			return fmt.Errorf("%w: missing CRLF after bulk string", ErrInvalidProtocol)
+		}
+		r.buf = r.buf[:start+length]
+	case '+', ':':
+		if err := r.appendLine(); err != nil {
+			return err
+		}
+	case '-':
+		start := len(r.buf)
+		if err := r.appendLine(); err != nil {
+			return err
+		}
+		return errors.New(string(r.buf[start:]))
+	default:
+		return fmt.Errorf("%w: unexpected type byte '%c'", ErrInvalidProtocol, typ)
+	}
+
+	r.ends = append(r.ends, len(r.buf))
+	return nil
+}
+
+// appendLine appends a CRLF terminated line to r.buf, without the terminator
+func (r *RESPReader) appendLine() error {
+	start := len(r.buf)
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		r.buf = append(r.buf, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+	}
+
+	end := len(r.buf)
+	if end-start < 2 || r.buf[end-2] != '\r' {
+		return fmt.Errorf("%w: line not terminated with CRLF", ErrInvalidProtocol)
+	}
+	r.buf = r.buf[:end-2]
+	return nil
+}
+
+// readLengthBytes reads an array or bulk string length line without allocating
+func (r *RESPReader) readLengthBytes() (int, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return 0, fmt.Errorf("%w: length line too long", ErrInvalidLength)
+		}
+		return 0, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return 0, fmt.Errorf("%w: line not terminated with CRLF", ErrInvalidProtocol)
+	}
+
+	n, ok := parseInt(line[:len(line)-2])
+	if !ok {
+		return 0, fmt.Errorf("%w: invalid length '%s'", ErrInvalidLength, line[:len(line)-2])
+	}
+	return n, nil
+}
+
+// parseInt parses a base-10 integer with an optional leading minus sign
+func parseInt(b []byte) (int, bool) {
+	neg := false
+	if len(b) > 0 && b[0] == '-' {
+		neg = true
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
 // RESPWriter handles writing RESP protocol messages
 // Buffers output to reduce syscalls
 type RESPWriter struct {
@@ -222,6 +394,27 @@ func (w *RESPWriter) WriteError(msg string) error {
 	return nil
 }
 
+// WriteErrorCode writes a RESP error with a custom error code instead of ERR
+// (-NOAUTH Authentication required.\r\n), so clients can tell error kinds apart
+func (w *RESPWriter) WriteErrorCode(code, msg string) error {
+	if _, err := w.writer.WriteString("-"); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString(code); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString(" "); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString(msg); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return nil
+}
+
 // WriteBulkString writes a RESP bulk string ($6\r\nfoobar\r\n)
 func (w *RESPWriter) WriteBulkString(s string) error {
 	length := len(s)
@@ -243,17 +436,51 @@ func (w *RESPWriter) WriteBulkString(s string) error {
 	return nil
 }
 
-// WriteArray writes a RESP array
-func (w *RESPWriter) WriteArray(elements []string) error {
-	if _, err := w.writer.WriteString("*"); err != nil {
+// WriteNull writes a RESP null bulk string ($-1\r\n)
+func (w *RESPWriter) WriteNull() error {
+	_, err := w.writer.WriteString("$-1\r\n")
+	return err
+}
+
+// WriteNullArray writes a RESP null array (*-1\r\n), as returned by an
+// aborted transaction
+func (w *RESPWriter) WriteNullArray() error {
+	_, err := w.writer.WriteString("*-1\r\n")
+	return err
+}
+
+// WriteRaw writes data that is already RESP encoded, such as replies
+// buffered by another RESPWriter
+func (w *RESPWriter) WriteRaw(data []byte) error {
+	_, err := w.writer.Write(data)
+	return err
+}
+
+// WriteBulkBytes writes a RESP bulk string from a byte slice
+func (w *RESPWriter) WriteBulkBytes(b []byte) error {
+	if _, err := w.writer.WriteString("$"); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString(strconv.Itoa(len(b))); err != nil {
 		return err
 	}
-	if _, err := w.writer.WriteString(strconv.Itoa(len(elements))); err != nil {
+	if _, err := w.writer.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(b); err != nil {
 		return err
 	}
 	if _, err := w.writer.WriteString("\r\n"); err != nil {
 		return err
 	}
+	return nil
+}
+
+// WriteArray writes a RESP array of bulk strings
+func (w *RESPWriter) WriteArray(elements []string) error {
+	if err := w.WriteArrayHeader(len(elements)); err != nil {
+		return err
+	}
 
 	for _, elem := range elements {
 		if err := w.WriteBulkString(elem); err != nil {
@@ -264,6 +491,21 @@ func (w *RESPWriter) WriteArray(elements []string) error {
 	return nil
 }
 
+// WriteArrayHeader writes the header of a RESP array of n elements (*2\r\n).
+// The caller writes the elements next, which lets arrays be nested.
+func (w *RESPWriter) WriteArrayHeader(n int) error {
+	if _, err := w.writer.WriteString("*"); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString(strconv.Itoa(n)); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return nil
+}
+
 // WriteInteger writes a RESP integer (:1000\r\n)
 func (w *RESPWriter) WriteInteger(n int64) error {
 	if _, err := w.writer.WriteString(":"); err != nil {
@@ -287,33 +529,43 @@ func (w *RESPWriter) Flush() error {
 // Expects format: "[0.1, 0.2, 0.3]" or "[0.1,0.2,0.3]"
 // This is a performance optimization mentioned in the design doc
 func FastVectorParser(s string) ([]float32, error) {
-	s = strings.TrimSpace(s)
+	return ParseVector([]byte(s))
+}
+
+// ParseVector is the byte-slice form of FastVectorParser. It parses the
+// vector in place, so the only allocation on success is the result slice.
+func ParseVector(b []byte) ([]float32, error) {
+	b = bytes.TrimSpace(b)
 
 	// Check for brackets
-	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+	if len(b) < 2 || b[0] != '[' || b[len(b)-1] != ']' {
 		return nil, errors.New("vector must be enclosed in brackets")
 	}
 
 	// Remove brackets
-	s = s[1 : len(s)-1]
+	b = bytes.TrimSpace(b[1 : len(b)-1])
 
 	// Handle empty vector
-	s = strings.TrimSpace(s)
-	if s == "" {
+	if len(b) == 0 {
 		return []float32{}, nil
 	}
 
-	// Split by comma
-	parts := strings.Split(s, ",")
-	result := make([]float32, 0, len(parts))
+	result := make([]float32, 0, bytes.Count(b, []byte{','})+1)
+
+	for len(b) > 0 {
+		var part []byte
+		if i := bytes.IndexByte(b, ','); i >= 0 {
+			part, b = b[:i], b[i+1:]
+		} else {
+			part, b = b, nil
+		}
 
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
+		part = bytes.TrimSpace(part)
+		if len(part) == 0 {
 			continue
 		}
 
-		val, err := strconv.ParseFloat(part, 32)
+		val, err := strconv.ParseFloat(string(part), 32)
 		if err != nil {
 			return nil, fmt.Errorf("invalid vector element '%s': %w", part, err)
 		}
@@ -17,6 +17,7 @@ package protocol
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"math"
 	"strings"
 	"testing"
@@ -62,6 +63,27 @@ func TestFastVectorParser(t *testing.T) {
 	}
 }
 
+func TestParseVector(t *testing.T) {
+	got, err := ParseVector([]byte(" [0.5, -1,,2e-1] "))
+	if err != nil {
+		t.Fatalf("ParseVector() error = %v", err)
+	}
+	want := []float32{0.5, -1, 0.2}
+	if len(got) != len(want) {
+		t.Fatalf("got length %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.Abs(float64(got[i]-want[i])) > 1e-6 {
+			t.Errorf("got[%d] = %f, want %f", i, got[i], want[i])
+		}
+	}
+
+	_, err = ParseVector([]byte("[0.1, x]"))
+	if err == nil || !strings.Contains(err.Error(), "'x'") {
+		t.Errorf("expected error naming the bad element, got %v", err)
+	}
+}
+
 func TestRESPReaderComprehensive(t *testing.T) {
 	t.Run("valid commands", func(t *testing.T) {
 		inputs := []struct {
@@ -135,6 +157,100 @@ func TestRESPReaderComprehensive(t *testing.T) {
 	})
 }
 
+func TestReadCommandBytes(t *testing.T) {
+	t.Run("matches ReadCommand", func(t *testing.T) {
+		inputs := []string{
+			"*1\r\n$4\r\nPING\r\n",
+			"*3\r\n$4\r\nVSET\r\n$3\r\nk:1\r\n$10\r\n[0.1, 0.2]\r\n",
+			"*2\r\n$4\r\nVGET\r\n$-1\r\n",
+			"*2\r\n+OK\r\n:12\r\n",
+			"*0\r\n",
+			"+OK\r\n",
+			":100\r\n",
+			"$5\r\nhello\r\n",
+			"$-1\r\n",
+		}
+		for _, input := range inputs {
+			want, wantErr := NewRESPReader(strings.NewReader(input)).ReadCommand()
+			got, err := NewRESPReader(strings.NewReader(input)).ReadCommandBytes()
+			if (err != nil) != (wantErr != nil) {
+				t.Errorf("%q: error = %v, ReadCommand error = %v", input, err, wantErr)
+				continue
+			}
+			if len(got) != len(want) {
+				t.Errorf("%q: got %d args, want %d", input, len(got), len(want))
+				continue
+			}
+			for i := range want {
+				if string(got[i]) != want[i] {
+					t.Errorf("%q: arg %d = %q, want %q", input, i, got[i], want[i])
+				}
+			}
+		}
+	})
+
+	t.Run("error paths", func(t *testing.T) {
+		inputs := []string{
+			"",
+			"X",
+			"*\r\n",
+			"*abc\r\n",
+			"*-5\r\n",
+			"$abc\r\n",
+			"$-5\r\n",
+			"$5\r\nshrt\r\n",
+			"$5\r\nhelloXX",
+			"+OK",
+			"*1\r\n+OK",
+			"*1\r\n$3\r\nab",
+			"*1\r\n$3\r\nabcXX",
+			"*1\r\nX",
+			"*1\r\n-ERR boom\r\n",
+			"-ERR incomplete",
+			"+\r",
+			"+\n",
+			"*1\n",
+		}
+		for _, input := range inputs {
+			r := NewRESPReader(strings.NewReader(input))
+			if _, err := r.ReadCommandBytes(); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		}
+	})
+
+	t.Run("buffer reuse", func(t *testing.T) {
+		input := "*2\r\n$4\r\nECHO\r\n$5\r\nfirst\r\n*2\r\n$4\r\nECHO\r\n$6\r\nsecond\r\n"
+		r := NewRESPReader(strings.NewReader(input))
+
+		first, err := r.ReadCommandBytes()
+		if err != nil {
+			t.Fatalf("first command: %v", err)
+		}
+		kept := string(first[1])
+
+		second, err := r.ReadCommandBytes()
+		if err != nil {
+			t.Fatalf("second command: %v", err)
+		}
+		if kept != "first" || string(second[1]) != "second" {
+			t.Errorf("got %q then %q", kept, second[1])
+		}
+	})
+
+	t.Run("long simple string", func(t *testing.T) {
+		long := strings.Repeat("a", 10000)
+		r := NewRESPReader(strings.NewReader("+" + long + "\r\n"))
+		cmd, err := r.ReadCommandBytes()
+		if err != nil {
+			t.Fatalf("ReadCommandBytes() error = %v", err)
+		}
+		if string(cmd[0]) != long {
+			t.Errorf("got %d bytes, want %d", len(cmd[0]), len(long))
+		}
+	})
+}
+
 type sequencedWriter struct {
 	failAt int
 	count  int
@@ -154,11 +270,17 @@ func TestRESPWriterComprehensive(t *testing.T) {
 		w := NewRESPWriter(&buf)
 		_ = w.WriteSimpleString("OK")
 		_ = w.WriteError("fail")
+		_ = w.WriteErrorCode("NOAUTH", "nope")
 		_ = w.WriteInteger(42)
 		_ = w.WriteBulkString("hi")
+		_ = w.WriteBulkBytes([]byte("yo"))
 		_ = w.WriteArray([]string{"a"})
+		_ = w.WriteArrayHeader(2)
+		_ = w.WriteNull()
+		_ = w.WriteNullArray()
+		_ = w.WriteRaw([]byte(":1\r\n"))
 		_ = w.Flush()
-		expected := "+OK\r\n-ERR fail\r\n:42\r\n$2\r\nhi\r\n*1\r\n$1\r\na\r\n"
+		expected := "+OK\r\n-ERR fail\r\n-NOAUTH nope\r\n:42\r\n$2\r\nhi\r\n$2\r\nyo\r\n*1\r\n$1\r\na\r\n*2\r\n$-1\r\n*-1\r\n:1\r\n"
 		if buf.String() != expected {
 			t.Errorf("got %q, want %q", buf.String(), expected)
 		}
@@ -180,12 +302,24 @@ func TestRESPWriterComprehensive(t *testing.T) {
 			{"Error_Call2", 4096 - 5, func(w *RESPWriter) error { return w.WriteError("fail") }},
 			{"Error_Call3", 4096 - 5 - 4, func(w *RESPWriter) error { return w.WriteError("fail") }},
 
+			{"ErrorCode_Call1", 4096, func(w *RESPWriter) error { return w.WriteErrorCode("NOAUTH", "x") }},
+			{"ErrorCode_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteErrorCode("NOAUTH", "x") }},
+			{"ErrorCode_Call3", 4096 - 1 - 6, func(w *RESPWriter) error { return w.WriteErrorCode("NOAUTH", "x") }},
+			{"ErrorCode_Call4", 4096 - 1 - 6 - 1, func(w *RESPWriter) error { return w.WriteErrorCode("NOAUTH", "x") }},
+			{"ErrorCode_Call5", 4096 - 1 - 6 - 1 - 1, func(w *RESPWriter) error { return w.WriteErrorCode("NOAUTH", "x") }},
+
 			{"Bulk_Call1", 4096, func(w *RESPWriter) error { return w.WriteBulkString("hi") }},
 			{"Bulk_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteBulkString("hi") }},
 			{"Bulk_Call3", 4096 - 1 - 1, func(w *RESPWriter) error { return w.WriteBulkString("hi") }},
 			{"Bulk_Call4", 4096 - 1 - 1 - 2, func(w *RESPWriter) error { return w.WriteBulkString("hi") }},
 			{"Bulk_Call5", 4096 - 1 - 1 - 2 - 2, func(w *RESPWriter) error { return w.WriteBulkString("hi") }},
 
+			{"BulkBytes_Call1", 4096, func(w *RESPWriter) error { return w.WriteBulkBytes([]byte("hi")) }},
+			{"BulkBytes_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteBulkBytes([]byte("hi")) }},
+			{"BulkBytes_Call3", 4096 - 1 - 1, func(w *RESPWriter) error { return w.WriteBulkBytes([]byte("hi")) }},
+			{"BulkBytes_Call4", 4096 - 1 - 1 - 2, func(w *RESPWriter) error { return w.WriteBulkBytes([]byte("hi")) }},
+			{"BulkBytes_Call5", 4096 - 1 - 1 - 2 - 2, func(w *RESPWriter) error { return w.WriteBulkBytes([]byte("hi")) }},
+
 			{"Integer_Call1", 4096, func(w *RESPWriter) error { return w.WriteInteger(42) }},
 			{"Integer_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteInteger(42) }},
 			{"Integer_Call3", 4096 - 1 - 2, func(w *RESPWriter) error { return w.WriteInteger(42) }},
@@ -193,6 +327,15 @@ func TestRESPWriterComprehensive(t *testing.T) {
 			{"Array_Call1", 4096, func(w *RESPWriter) error { return w.WriteArray([]string{"a"}) }},
 			{"Array_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteArray([]string{"a"}) }},
 			{"Array_Call3", 4096 - 1 - 1, func(w *RESPWriter) error { return w.WriteArray([]string{"a"}) }},
+			{"Array_Call4", 4096 - 1 - 1 - 2, func(w *RESPWriter) error { return w.WriteArray([]string{"a"}) }},
+
+			{"Null", 4096, func(w *RESPWriter) error { return w.WriteNull() }},
+			{"NullArray", 4096, func(w *RESPWriter) error { return w.WriteNullArray() }},
+			{"Raw", 4096, func(w *RESPWriter) error { return w.WriteRaw([]byte(":1\r\n")) }},
+
+			{"ArrayHeader_Call1", 4096, func(w *RESPWriter) error { return w.WriteArrayHeader(2) }},
+			{"ArrayHeader_Call2", 4096 - 1, func(w *RESPWriter) error { return w.WriteArrayHeader(2) }},
+			{"ArrayHeader_Call3", 4096 - 1 - 1, func(w *RESPWriter) error { return w.WriteArrayHeader(2) }},
 		}
 
 		for _, tc := range cases {
@@ -251,3 +394,84 @@ func BenchmarkRESPReader(b *testing.B) {
 		_, _ = r.ReadCommand()
 	}
 }
+
+// loopReader replays the same payload forever, so a single RESPReader can be
+// benchmarked across many commands and its buffers actually get reused.
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], l.data[l.pos:])
+		n += c
+		l.pos = (l.pos + c) % len(l.data)
+	}
+	return n, nil
+}
+
+func vsetPayload(dim int) string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i := 0; i < dim; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%.6f", float32(i)/float32(dim))
+	}
+	sb.WriteString("]")
+	vec := sb.String()
+	return fmt.Sprintf("*3\r\n$4\r\nVSET\r\n$9\r\nvec:12345\r\n$%d\r\n%s\r\n", len(vec), vec)
+}
+
+// BenchmarkVSETStrings measures the string-based path: ReadCommand allocates
+// every argument and FastVectorParser splits the vector into substrings.
+func BenchmarkVSETStrings(b *testing.B) {
+	r := NewRESPReader(&loopReader{data: []byte(vsetPayload(128))})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmd, err := r.ReadCommand()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := FastVectorParser(cmd[2]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkVSETBytes measures the zero-copy path: ReadCommandBytes reuses the
+// reader's buffer and ParseVector only allocates the resulting []float32.
+func BenchmarkVSETBytes(b *testing.B) {
+	r := NewRESPReader(&loopReader{data: []byte(vsetPayload(128))})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmd, err := r.ReadCommandBytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ParseVector(cmd[2]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	r := NewRESPReader(&loopReader{data: []byte("*2\r\n$4\r\nVGET\r\n$9\r\nvec:12345\r\n")})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.ReadCommand()
+	}
+}
+
+func BenchmarkReadCommandBytes(b *testing.B) {
+	r := NewRESPReader(&loopReader{data: []byte("*2\r\n$4\r\nVGET\r\n$9\r\nvec:12345\r\n")})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.ReadCommandBytes()
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pubsub routes published messages to channel and pattern
+// subscribers, Redis style. Publishing never blocks: a subscriber whose
+// buffer is full is dropped rather than slowing down the publisher.
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/uzqw/vex/internal/glob"
+)
+
+// Message is a published message as delivered to a subscriber
+type Message struct {
+	// Pattern is the pattern the subscriber matched the channel with, or
+	// empty for a channel subscription
+	Pattern string
+	Channel string
+	Payload string
+}
+
+// Broker keeps track of subscriptions and delivers published messages
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+}
+
+// NewBroker creates a broker with no subscriptions
+func NewBroker() *Broker {
+	return &Broker{
+		channels: make(map[string]map[*Subscriber]struct{}),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+	}
+}
+
+// Subscriber receives the messages published to its channels and patterns.
+// Its subscription methods are meant to be called from one goroutine, the
+// one serving the connection.
+type Subscriber struct {
+	broker *Broker
+	msgs   chan Message
+
+	done     chan struct{} // Closed once the subscriber is dropped or closed
+	doneOnce sync.Once
+	dropped  bool // Set before done is closed if the buffer overflowed
+
+	// Owned by the subscribing goroutine, and also guarded by broker.mu
+	// where the broker's maps are changed alongside
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// NewSubscriber creates a subscriber that buffers up to buffer messages.
+// A publish that finds the buffer full drops the subscriber.
+func (b *Broker) NewSubscriber(buffer int) *Subscriber {
+	return &Subscriber{
+		broker:   b,
+		msgs:     make(chan Message, buffer),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// Publish delivers payload to the subscribers of channel and of every
+// pattern matching it, and returns the number of deliveries
+func (b *Broker) Publish(channel, payload string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := 0
+	for sub := range b.channels[channel] {
+		if sub.deliver(Message{Channel: channel, Payload: payload}) {
+			n++
+		}
+	}
+	for pattern, subs := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			if sub.deliver(Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// deliver queues m without blocking, dropping the subscriber if it is too
+// far behind. It reports whether m was queued.
+func (s *Subscriber) deliver(m Message) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.msgs <- m:
+		return true
+	default:
+		s.doneOnce.Do(func() {
+			s.dropped = true
+			close(s.done)
+		})
+		return false
+	}
+}
+
+// Subscribe subscribes to channels and returns the total number of
+// channels and patterns subscribed to after each one
+func (s *Subscriber) Subscribe(channels ...string) []int {
+	return s.update(channels, s.channels, s.broker.channels, true)
+}
+
+// Unsubscribe unsubscribes from channels, or from every channel if none are
+// given, returning the channels and the subscription count after each one
+func (s *Subscriber) Unsubscribe(channels ...string) ([]string, []int) {
+	if len(channels) == 0 {
+		channels = s.Channels()
+	}
+	return channels, s.update(channels, s.channels, s.broker.channels, false)
+}
+
+// PSubscribe subscribes to glob patterns, like Subscribe
+func (s *Subscriber) PSubscribe(patterns ...string) []int {
+	return s.update(patterns, s.patterns, s.broker.patterns, true)
+}
+
+// PUnsubscribe unsubscribes from patterns, or from every pattern if none
+// are given, like Unsubscribe
+func (s *Subscriber) PUnsubscribe(patterns ...string) ([]string, []int) {
+	if len(patterns) == 0 {
+		patterns = s.Patterns()
+	}
+	return patterns, s.update(patterns, s.patterns, s.broker.patterns, false)
+}
+
+// update adds or removes names from the subscriber's own set and the
+// broker's index, returning the subscription count after each name
+func (s *Subscriber) update(names []string, own map[string]struct{}, index map[string]map[*Subscriber]struct{}, add bool) []int {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	counts := make([]int, len(names))
+	for i, name := range names {
+		if add {
+			own[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = make(map[*Subscriber]struct{})
+			}
+			index[name][s] = struct{}{}
+		} else if _, ok := own[name]; ok {
+			delete(own, name)
+			delete(index[name], s)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+		counts[i] = len(s.channels) + len(s.patterns)
+	}
+	return counts
+}
+
+// Count returns the number of channels and patterns subscribed to
+func (s *Subscriber) Count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// Channels returns the subscribed channels in sorted order
+func (s *Subscriber) Channels() []string {
+	return sortedKeys(s.channels)
+}
+
+// Patterns returns the subscribed patterns in sorted order
+func (s *Subscriber) Patterns() []string {
+	return sortedKeys(s.patterns)
+}
+
+// Messages returns the channel messages are delivered on
+func (s *Subscriber) Messages() <-chan Message {
+	return s.msgs
+}
+
+// Done returns a channel that is closed when the subscriber is closed or
+// dropped for falling behind
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped reports whether the subscriber was dropped because its buffer
+// filled up. It is only meaningful once Done is closed.
+func (s *Subscriber) Dropped() bool {
+	select {
+	case <-s.done:
+		return s.dropped
+	default:
+		return false
+	}
+}
+
+// Close unsubscribes from everything and closes Done. Messages already
+// buffered are discarded.
+func (s *Subscriber) Close() {
+	s.Unsubscribe()
+	s.PUnsubscribe()
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// receive returns the next buffered message, failing if there is none
+func receive(t *testing.T, s *Subscriber) Message {
+	t.Helper()
+	select {
+	case m := <-s.Messages():
+		return m
+	default:
+		t.Fatal("no message buffered")
+		return Message{}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := NewBroker()
+	news := b.NewSubscriber(10)
+	all := b.NewSubscriber(10)
+
+	if counts := news.Subscribe("news", "sport"); !slices.Equal(counts, []int{1, 2}) {
+		t.Errorf("Subscribe() counts = %v, want [1 2]", counts)
+	}
+	all.PSubscribe("*")
+
+	if n := b.Publish("news", "hello"); n != 2 {
+		t.Errorf("Publish() = %d, want 2 deliveries", n)
+	}
+	if m := receive(t, news); m != (Message{Channel: "news", Payload: "hello"}) {
+		t.Errorf("channel subscriber got %+v", m)
+	}
+	if m := receive(t, all); m != (Message{Pattern: "*", Channel: "news", Payload: "hello"}) {
+		t.Errorf("pattern subscriber got %+v", m)
+	}
+
+	if n := b.Publish("weather", "rain"); n != 1 {
+		t.Errorf("Publish(weather) = %d, want 1 delivery", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := NewBroker()
+	s := b.NewSubscriber(10)
+	s.Subscribe("a", "b")
+	s.PSubscribe("c*")
+
+	channels, counts := s.Unsubscribe("a")
+	if !slices.Equal(channels, []string{"a"}) || !slices.Equal(counts, []int{2}) {
+		t.Errorf("Unsubscribe(a) = %v, %v, want [a] [2]", channels, counts)
+	}
+	if b.Publish("a", "x") != 0 {
+		t.Error("message delivered after unsubscribing")
+	}
+
+	// Unsubscribing from a channel never subscribed to changes nothing
+	if _, counts := s.Unsubscribe("zzz"); counts[0] != 2 {
+		t.Errorf("Unsubscribe(zzz) count = %d, want 2", counts[0])
+	}
+
+	if patterns, counts := s.PUnsubscribe(); !slices.Equal(patterns, []string{"c*"}) || counts[0] != 1 {
+		t.Errorf("PUnsubscribe() = %v, %v, want [c*] [1]", patterns, counts)
+	}
+
+	s.Close()
+	if s.Count() != 0 || len(b.channels) != 0 || len(b.patterns) != 0 {
+		t.Error("Close() left subscriptions behind")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done() not closed after Close()")
+	}
+	if s.Dropped() {
+		t.Error("Dropped() = true for a closed subscriber")
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	b := NewBroker()
+	slow := b.NewSubscriber(2)
+	fast := b.NewSubscriber(10)
+	slow.Subscribe("ch")
+	fast.Subscribe("ch")
+
+	for i := 0; i < 5; i++ {
+		b.Publish("ch", fmt.Sprint(i))
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow subscriber not dropped")
+	}
+	if !slow.Dropped() {
+		t.Error("Dropped() = false")
+	}
+	if len(fast.Messages()) != 5 {
+		t.Errorf("fast subscriber got %d messages, want 5", len(fast.Messages()))
+	}
+	if n := b.Publish("ch", "more"); n != 1 {
+		t.Errorf("Publish() after drop = %d, want 1", n)
+	}
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	b := NewBroker()
+	s := b.NewSubscriber(1000)
+	s.PSubscribe("ch:*")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				b.Publish(fmt.Sprintf("ch:%d", i), "x")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(s.Messages()) != 500 {
+		t.Errorf("got %d messages, want 500", len(s.Messages()))
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HardState is the part of a node's state that must survive restarts
+// besides its log: the latest term it has seen and who it voted for in it
+type HardState struct {
+	Term uint64
+	Vote string
+}
+
+// LogStore keeps a node's hard state, log and snapshot. Calls are
+// serialized by the node, except that LoadSnapshot and SaveSnapshot may run
+// alongside the others; a method must not return before its changes are
+// durable.
+type LogStore interface {
+	// Load returns what was saved, with the entries in index order. Entries
+	// the snapshot covers may be included, and are ignored.
+	Load() (HardState, []Entry, error)
+
+	// LoadSnapshot returns the saved snapshot, with a zero Index if there
+	// is none
+	LoadSnapshot() (Snapshot, error)
+
+	// SaveSnapshot replaces the snapshot, leaving the log as it is
+	SaveSnapshot(snap Snapshot) error
+
+	// Compact replaces the log with entries, which follow the saved
+	// snapshot
+	Compact(entries []Entry) error
+
+	// SaveState replaces the hard state
+	SaveState(st HardState) error
+
+	// Append adds entries after the last one stored
+	Append(entries []Entry) error
+
+	// Truncate removes the entries from index on
+	Truncate(index uint64) error
+}
+
+// MemoryLogStore is a LogStore that keeps nothing across restarts, for
+// tests and for nodes whose data is rebuilt from the group anyway
+type MemoryLogStore struct {
+	mu       sync.Mutex
+	state    HardState
+	entries  []Entry
+	snapshot Snapshot
+}
+
+// NewMemoryLogStore returns an empty in-memory store
+func NewMemoryLogStore() *MemoryLogStore {
+	return &MemoryLogStore{}
+}
+
+func (m *MemoryLogStore) Load() (HardState, []Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, append([]Entry(nil), m.entries...), nil
+}
+
+func (m *MemoryLogStore) SaveState(st HardState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = st
+	return nil
+}
+
+func (m *MemoryLogStore) Append(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+func (m *MemoryLogStore) Truncate(index uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = truncateEntries(m.entries, index)
+	return nil
+}
+
+func (m *MemoryLogStore) LoadSnapshot() (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot, nil
+}
+
+func (m *MemoryLogStore) SaveSnapshot(snap Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = snap
+	return nil
+}
+
+func (m *MemoryLogStore) Compact(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append([]Entry(nil), entries...)
+	return nil
+}
+
+// FileLogStore is a LogStore kept in a directory: the hard state and the
+// snapshot in files replaced atomically, and the log in an append-only file
+// of records, each an entry or a truncation, checksummed so that a record
+// torn by a crash is ignored when loading. Compacting rewrites the log
+// with only the entries that follow the snapshot.
+type FileLogStore struct {
+	dir string
+	log *os.File
+	buf *bufio.Writer
+}
+
+const (
+	stateFile    = "raft-state"
+	logFile      = "raft-log"
+	snapshotFile = "raft-snapshot"
+
+	recordEntry    = 1
+	recordTruncate = 2
+
+	// recordHeader is the kind, index, term and command length
+	recordHeader = 1 + 8 + 8 + 4
+)
+
+var errCorrupt = errors.New("corrupt raft log record")
+
+// OpenFileLogStore opens or creates the store in dir
+func OpenFileLogStore(dir string) (*FileLogStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLogStore{dir: dir, log: f, buf: bufio.NewWriter(f)}, nil
+}
+
+// Close closes the log file
+func (s *FileLogStore) Close() error {
+	return s.log.Close()
+}
+
+// Load reads the saved state and replays the log. A torn record at the end
+// of the log is cut off so that later appends follow the last good one.
+func (s *FileLogStore) Load() (HardState, []Entry, error) {
+	st, err := s.loadState()
+	if err != nil {
+		return HardState{}, nil, err
+	}
+
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return HardState{}, nil, err
+	}
+	r := bufio.NewReader(s.log)
+	var entries []Entry
+	var good int64
+	for {
+		kind, e, n, err := readRecord(r)
+		if err == io.EOF || errors.Is(err, errCorrupt) || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return HardState{}, nil, err
+		}
+		good += n
+
+		switch kind {
+		case recordEntry:
+			entries = append(entries, e)
+		case recordTruncate:
+			entries = truncateEntries(entries, e.Index)
+		}
+	}
+
+	if err := s.log.Truncate(good); err != nil {
+		return HardState{}, nil, err
+	}
+	if _, err := s.log.Seek(good, io.SeekStart); err != nil {
+		return HardState{}, nil, err
+	}
+	s.buf.Reset(s.log)
+	return st, entries, nil
+}
+
+func (s *FileLogStore) loadState() (HardState, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return HardState{}, nil
+	}
+	if err != nil {
+		return HardState{}, err
+	}
+	if len(data) < 12 || crc32.ChecksumIEEE(data[4:]) != binary.LittleEndian.Uint32(data) {
+		return HardState{}, fmt.Errorf("corrupt raft state file in %s", s.dir)
+	}
+	return HardState{Term: binary.LittleEndian.Uint64(data[4:]), Vote: string(data[12:])}, nil
+}
+
+// SaveState writes the state to a temporary file and renames it over the
+// old one, so that a crash leaves either state whole
+func (s *FileLogStore) SaveState(st HardState) error {
+	data := make([]byte, 12+len(st.Vote))
+	binary.LittleEndian.PutUint64(data[4:], st.Term)
+	copy(data[12:], st.Vote)
+	binary.LittleEndian.PutUint32(data, crc32.ChecksumIEEE(data[4:]))
+	return s.replace(stateFile, data)
+}
+
+// LoadSnapshot reads the snapshot file: a checksum of the rest, then the
+// index, the term and the data
+func (s *FileLogStore) LoadSnapshot() (Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, nil
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(data) < 20 || crc32.ChecksumIEEE(data[4:]) != binary.LittleEndian.Uint32(data) {
+		return Snapshot{}, fmt.Errorf("corrupt raft snapshot file in %s", s.dir)
+	}
+	return Snapshot{
+		Index: binary.LittleEndian.Uint64(data[4:]),
+		Term:  binary.LittleEndian.Uint64(data[12:]),
+		Data:  data[20:],
+	}, nil
+}
+
+// SaveSnapshot replaces the snapshot file. It only touches that file, so it
+// may run alongside the writes to the log.
+func (s *FileLogStore) SaveSnapshot(snap Snapshot) error {
+	data := make([]byte, 20+len(snap.Data))
+	binary.LittleEndian.PutUint64(data[4:], snap.Index)
+	binary.LittleEndian.PutUint64(data[12:], snap.Term)
+	copy(data[20:], snap.Data)
+	binary.LittleEndian.PutUint32(data, crc32.ChecksumIEEE(data[4:]))
+	return s.replace(snapshotFile, data)
+}
+
+// Compact replaces the log file with one that holds only entries. Until it
+// runs, the entries of the old log that the snapshot covers are ignored on
+// loading, so a crash before it loses nothing.
+func (s *FileLogStore) Compact(entries []Entry) error {
+	tmp := filepath.Join(s.dir, logFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	for _, e := range entries {
+		if err = writeRecord(buf, recordEntry, e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(s.dir, logFile))
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// Appends continue at the end of the new file
+	_ = s.log.Close()
+	s.log = f
+	s.buf.Reset(f)
+	return nil
+}
+
+// replace writes data to a temporary file and renames it over name, so that
+// a crash leaves either version whole
+func (s *FileLogStore) replace(name string, data []byte) error {
+	tmp := filepath.Join(s.dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+func (s *FileLogStore) Append(entries []Entry) error {
+	for _, e := range entries {
+		if err := writeRecord(s.buf, recordEntry, e); err != nil {
+			return err
+		}
+	}
+	return s.sync()
+}
+
+func (s *FileLogStore) Truncate(index uint64) error {
+	if err := writeRecord(s.buf, recordTruncate, Entry{Index: index}); err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+// writeRecord buffers a record: a checksum of the rest, then the header and
+// the command
+func writeRecord(w *bufio.Writer, kind byte, e Entry) error {
+	var hdr [4 + recordHeader]byte
+	hdr[4] = kind
+	binary.LittleEndian.PutUint64(hdr[5:], e.Index)
+	binary.LittleEndian.PutUint64(hdr[13:], e.Term)
+	binary.LittleEndian.PutUint32(hdr[21:], uint32(len(e.Command)))
+	crc := crc32.Update(crc32.ChecksumIEEE(hdr[4:]), crc32.IEEETable, e.Command)
+	binary.LittleEndian.PutUint32(hdr[:4], crc)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(e.Command); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *FileLogStore) sync() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// readRecord reads one record and returns its kind, the entry it holds, and
+// its size in bytes
+func readRecord(r *bufio.Reader) (byte, Entry, int64, error) {
+	var hdr [4 + recordHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, Entry{}, 0, err
+	}
+	kind := hdr[4]
+	e := Entry{
+		Index: binary.LittleEndian.Uint64(hdr[5:]),
+		Term:  binary.LittleEndian.Uint64(hdr[13:]),
+	}
+	n := binary.LittleEndian.Uint32(hdr[21:])
+	if kind != recordEntry && kind != recordTruncate || n > 1<<30 {
+		return 0, Entry{}, 0, errCorrupt
+	}
+	if n > 0 {
+		e.Command = make([]byte, n)
+		if _, err := io.ReadFull(r, e.Command); err != nil {
+			return 0, Entry{}, 0, err
+		}
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(hdr[4:]), crc32.IEEETable, e.Command)
+	if crc != binary.LittleEndian.Uint32(hdr[:4]) {
+		return 0, Entry{}, 0, errCorrupt
+	}
+	return kind, e, int64(len(hdr)) + int64(n), nil
+}
+
+// truncateEntries removes the entries from index on
+func truncateEntries(entries []Entry, index uint64) []Entry {
+	for i, e := range entries {
+		if e.Index >= index {
+			return entries[:i]
+		}
+	}
+	return entries
+}
@@ -0,0 +1,166 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testLogStore checks the behaviour every LogStore shares; reopen returns
+// the store as it would be found after a restart
+func testLogStore(t *testing.T, s LogStore, reopen func() LogStore) {
+	t.Helper()
+	if st, entries, err := s.Load(); err != nil || st != (HardState{}) || len(entries) != 0 {
+		t.Fatalf("Load() on a new store = %+v, %v, %v", st, entries, err)
+	}
+
+	if err := s.SaveState(HardState{Term: 3, Vote: "n1"}); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	entries := []Entry{{1, 1, []byte("a")}, {2, 1, nil}, {3, 2, []byte("c")}}
+	if err := s.Append(entries); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := s.Truncate(3); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if err := s.Append([]Entry{{3, 3, []byte("d")}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	st, got, err := reopen().Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if st != (HardState{Term: 3, Vote: "n1"}) {
+		t.Errorf("Load() state = %+v", st)
+	}
+	if want := "[{1 1 [97]} {2 1 []} {3 3 [100]}]"; fmt.Sprint(got) != want {
+		t.Errorf("Load() entries = %v, want %s", got, want)
+	}
+}
+
+// testSnapshot checks that a snapshot is saved and compacting replaces the
+// log it covers
+func testSnapshot(t *testing.T, s LogStore, reopen func() LogStore) {
+	t.Helper()
+	if snap, err := s.LoadSnapshot(); err != nil || snap.Index != 0 {
+		t.Fatalf("LoadSnapshot() on a new store = %+v, %v", snap, err)
+	}
+
+	if err := s.Append([]Entry{{1, 1, []byte("a")}, {2, 1, []byte("b")}, {3, 2, []byte("c")}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := s.SaveSnapshot(Snapshot{Index: 2, Term: 1, Data: []byte("ab")}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	if err := s.Append([]Entry{{4, 2, []byte("d")}}); err != nil {
+		t.Fatalf("Append() after SaveSnapshot() error = %v", err)
+	}
+	if err := s.Compact([]Entry{{3, 2, []byte("c")}, {4, 2, []byte("d")}}); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if err := s.Append([]Entry{{5, 2, []byte("e")}}); err != nil {
+		t.Fatalf("Append() after Compact() error = %v", err)
+	}
+
+	s = reopen()
+	snap, err := s.LoadSnapshot()
+	if err != nil || snap.Index != 2 || snap.Term != 1 || string(snap.Data) != "ab" {
+		t.Errorf("LoadSnapshot() = %+v, %v", snap, err)
+	}
+	_, got, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := "[{3 2 [99]} {4 2 [100]} {5 2 [101]}]"; fmt.Sprint(got) != want {
+		t.Errorf("Load() entries = %v, want %s", got, want)
+	}
+}
+
+func TestMemoryLogStore(t *testing.T) {
+	s := NewMemoryLogStore()
+	testLogStore(t, s, func() LogStore { return s })
+
+	snapshotted := NewMemoryLogStore()
+	testSnapshot(t, snapshotted, func() LogStore { return snapshotted })
+}
+
+func TestFileLogStore(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *FileLogStore {
+		s, err := OpenFileLogStore(dir)
+		if err != nil {
+			t.Fatalf("OpenFileLogStore() error = %v", err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		return s
+	}
+	testLogStore(t, open(), func() LogStore { return open() })
+
+	t.Run("snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		open := func() LogStore {
+			s, err := OpenFileLogStore(dir)
+			if err != nil {
+				t.Fatalf("OpenFileLogStore() error = %v", err)
+			}
+			t.Cleanup(func() { _ = s.Close() })
+			return s
+		}
+		testSnapshot(t, open(), open)
+
+		if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte("garbage snapshot file"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := open().LoadSnapshot(); err == nil {
+			t.Error("LoadSnapshot() with a corrupt file should fail")
+		}
+	})
+
+	t.Run("torn record", func(t *testing.T) {
+		// Half a record at the end, as a crash mid-write leaves it, is
+		// dropped and later appends follow the last whole record
+		f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write([]byte{1, 2, 3, 4, recordEntry, 4})
+		_ = f.Close()
+
+		s := open()
+		if _, entries, err := s.Load(); err != nil || len(entries) != 3 {
+			t.Fatalf("Load() = %v, %v, want the 3 whole entries", entries, err)
+		}
+		if err := s.Append([]Entry{{4, 3, []byte("e")}}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		if _, entries, err := open().Load(); err != nil || len(entries) != 4 || string(entries[3].Command) != "e" {
+			t.Errorf("Load() = %v, %v, want the new entry after the others", entries, err)
+		}
+	})
+
+	t.Run("corrupt state", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(dir, stateFile), []byte("garbage file"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := open().Load(); err == nil {
+			t.Error("Load() with a corrupt state file should fail")
+		}
+	})
+}
@@ -0,0 +1,815 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/pkg/logger"
+)
+
+const (
+	// maxBatch is the most entries sent to a follower in one request
+	maxBatch = 256
+
+	// defaultSnapshotThreshold is the default for Config.SnapshotThreshold
+	defaultSnapshotThreshold = 10000
+
+	// snapshotTimeout bounds sending a snapshot to a follower, which takes
+	// longer than other requests
+	snapshotTimeout = time.Minute
+)
+
+// Config configures a node
+type Config struct {
+	// ID identifies the node to its peers and Transport
+	ID string
+
+	// Peers are the IDs of the other nodes in the group
+	Peers []string
+
+	Transport Transport
+	Store     LogStore
+
+	// Apply is called with each committed entry, in log order and from a
+	// single goroutine. On the leader, its result is returned by the
+	// Propose call that added the entry.
+	Apply func(e Entry) any
+
+	// Snapshot returns the state machine's data as of the last entry
+	// applied, and Restore replaces the state machine with such data. Both
+	// are called from the goroutine that calls Apply, and Restore also by
+	// Start. When they are set, the applied entries are compacted into a
+	// snapshot once there are SnapshotThreshold of them (default 10000).
+	Snapshot          func() ([]byte, error)
+	Restore           func(data []byte) error
+	SnapshotThreshold uint64
+
+	// ElectionTimeout is the least time a follower waits without hearing
+	// from a leader before starting an election; the actual wait is
+	// randomized between it and twice it
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is how often the leader contacts idle followers.
+	// It must be well below ElectionTimeout.
+	HeartbeatInterval time.Duration
+
+	Logger *logger.Logger
+}
+
+// Status describes a node
+type Status struct {
+	ID     string
+	State  State
+	Term   uint64
+	Leader string
+
+	LastIndex    uint64
+	CommitIndex  uint64
+	AppliedIndex uint64
+}
+
+// Node is one member of a Raft group. Its state is guarded by a single
+// mutex; network calls and Apply run without it.
+type Node struct {
+	cfg    Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// applyMu is held while entries are applied or a snapshot is taken or
+	// restored, so that the state machine changes one way at a time. It is
+	// taken before mu.
+	applyMu sync.Mutex
+
+	mu     sync.Mutex
+	state  State
+	term   uint64
+	vote   string
+	leader string
+
+	// entries is the log after the snapshot, preceded by a sentinel with
+	// the index and term of the snapshot's last entry, zero without one
+	entries []Entry
+	commit  uint64
+	applied uint64
+
+	// deadline is when a follower or candidate starts the next election
+	deadline time.Time
+
+	// next and match are the leader's view of each follower's log: the
+	// next index to send, and the highest index known to be replicated
+	next  map[string]uint64
+	match map[string]uint64
+
+	// kick wakes each peer's replication loop when entries are appended
+	kick map[string]chan struct{}
+
+	// contact is when each peer last answered the leader
+	contact map[string]time.Time
+
+	// waiters hold the Propose calls waiting for their entry to be applied
+	waiters map[uint64]chan applyResult
+
+	// applyc wakes the apply loop when the commit index moves
+	applyc chan struct{}
+}
+
+// applyResult is what a waiting Propose learns once its index is applied
+type applyResult struct {
+	term  uint64
+	value any
+}
+
+// Start loads the node's state from its store and starts it as a follower
+func Start(cfg Config) (*Node, error) {
+	if cfg.ElectionTimeout <= 0 || cfg.HeartbeatInterval <= 0 || cfg.HeartbeatInterval >= cfg.ElectionTimeout {
+		return nil, fmt.Errorf("invalid raft timing: election timeout %s, heartbeat interval %s", cfg.ElectionTimeout, cfg.HeartbeatInterval)
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = defaultSnapshotThreshold
+	}
+	st, entries, err := cfg.Store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load raft state: %w", err)
+	}
+	snap, err := cfg.Store.LoadSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load raft snapshot: %w", err)
+	}
+	if snap.Index > 0 {
+		if cfg.Restore == nil {
+			return nil, fmt.Errorf("raft snapshot at index %d found but nothing restores it", snap.Index)
+		}
+		if err := cfg.Restore(snap.Data); err != nil {
+			return nil, fmt.Errorf("failed to restore raft snapshot: %w", err)
+		}
+	}
+	for len(entries) > 0 && entries[0].Index <= snap.Index {
+		entries = entries[1:]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		term:    st.Term,
+		vote:    st.Vote,
+		entries: append([]Entry{{Index: snap.Index, Term: snap.Term}}, entries...),
+		commit:  snap.Index,
+		applied: snap.Index,
+		waiters: make(map[uint64]chan applyResult),
+		applyc:  make(chan struct{}, 1),
+	}
+	n.resetDeadline()
+
+	n.wg.Add(2)
+	go n.tickLoop()
+	go n.applyLoop()
+	return n, nil
+}
+
+// Stop stops the node and waits for its goroutines to exit. Pending
+// proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// Status returns a snapshot of the node's state
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:           n.cfg.ID,
+		State:        n.state,
+		Term:         n.term,
+		Leader:       n.leader,
+		LastIndex:    n.lastIndex(),
+		CommitIndex:  n.commit,
+		AppliedIndex: n.applied,
+	}
+}
+
+// Leader returns the ID of the leader the node last heard from, itself if
+// it leads, or empty if none is known
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Propose appends cmd to the log and waits until it is committed and
+// applied on this node, returning the result of Apply. It fails with a
+// *NotLeaderError unless the node is the leader.
+func (n *Node) Propose(ctx context.Context, cmd []byte) (any, error) {
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("empty raft command")
+	}
+
+	n.mu.Lock()
+	if n.ctx.Err() != nil {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return nil, &NotLeaderError{Leader: n.leader}
+	}
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Command: cmd}
+	if err := n.cfg.Store.Append([]Entry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("failed to append to raft log: %w", err)
+	}
+	n.entries = append(n.entries, e)
+	done := make(chan applyResult, 1)
+	n.waiters[e.Index] = done
+	n.advanceCommit()
+	n.replicate()
+	n.mu.Unlock()
+
+	select {
+	case res := <-done:
+		if res.term != e.Term {
+			return nil, ErrDropped
+		}
+		return res.value, nil
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	case <-n.ctx.Done():
+		return nil, ErrStopped
+	}
+}
+
+// HandleVote answers a peer's VoteRequest
+func (n *Node) HandleVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	}
+	if req.Term < n.term {
+		return VoteResponse{Term: n.term}
+	}
+
+	// Only vote for candidates whose log holds every committed entry,
+	// which a log at least as up to date as this one does
+	last := n.entry(n.lastIndex())
+	upToDate := req.LastLogTerm > last.Term || req.LastLogTerm == last.Term && req.LastLogIndex >= last.Index
+	if !upToDate || n.vote != "" && n.vote != req.Candidate {
+		return VoteResponse{Term: n.term}
+	}
+
+	if n.vote == "" {
+		if err := n.cfg.Store.SaveState(HardState{Term: n.term, Vote: req.Candidate}); err != nil {
+			n.logError("failed to save raft vote", err)
+			return VoteResponse{Term: n.term}
+		}
+		n.vote = req.Candidate
+	}
+	n.resetDeadline()
+	return VoteResponse{Term: n.term, Granted: true}
+}
+
+// HandleAppend answers a peer's AppendRequest
+func (n *Node) HandleAppend(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	} else if n.state != Follower {
+		n.becomeFollower(n.term, n.vote)
+	}
+	n.leader = req.Leader
+	n.resetDeadline()
+
+	// Entries the snapshot covers are committed, so they match the leader's
+	if snap := n.entries[0]; req.PrevLogIndex < snap.Index {
+		skip := min(snap.Index-req.PrevLogIndex, uint64(len(req.Entries)))
+		req.Entries = req.Entries[skip:]
+		req.PrevLogIndex, req.PrevLogTerm = snap.Index, snap.Term
+	}
+
+	// The entries must follow on from the log as it is here
+	last := n.lastIndex()
+	if req.PrevLogIndex > last {
+		return AppendResponse{Term: n.term, ConflictIndex: last + 1}
+	}
+	if term := n.entry(req.PrevLogIndex).Term; term != req.PrevLogTerm {
+		// Skip back over the whole conflicting term at once
+		i := req.PrevLogIndex
+		for i > n.commit+1 && n.entry(i-1).Term == term {
+			i--
+		}
+		return AppendResponse{Term: n.term, ConflictIndex: i}
+	}
+
+	// Entries already present are skipped; the first that conflicts
+	// removes it and everything after it
+	for i, e := range req.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			if err := n.cfg.Store.Truncate(e.Index); err != nil {
+				n.logError("failed to truncate raft log", err)
+				return AppendResponse{Term: n.term, ConflictIndex: e.Index}
+			}
+			n.entries = n.entries[:e.Index-n.entries[0].Index]
+		}
+		if err := n.cfg.Store.Append(req.Entries[i:]); err != nil {
+			n.logError("failed to append to raft log", err)
+			return AppendResponse{Term: n.term, ConflictIndex: e.Index}
+		}
+		n.entries = append(n.entries, req.Entries[i:]...)
+		break
+	}
+
+	// Only what is known to match the leader's log can be committed
+	if commit := min(req.LeaderCommit, req.PrevLogIndex+uint64(len(req.Entries))); commit > n.commit {
+		n.commit = commit
+		n.wakeApply()
+	}
+	return AppendResponse{Term: n.term, Success: true}
+}
+
+// HandleSnapshot answers a peer's SnapshotRequest, restoring the state
+// machine from the snapshot unless this node has already committed what
+// it holds. Entries that follow the snapshot are kept if the log agrees
+// with it, otherwise the whole log is discarded.
+func (n *Node) HandleSnapshot(req SnapshotRequest) SnapshotResponse {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return SnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	} else if n.state != Follower {
+		n.becomeFollower(n.term, n.vote)
+	}
+	n.leader = req.Leader
+	n.resetDeadline()
+
+	snap := req.Snapshot
+	if snap.Index <= n.commit {
+		return SnapshotResponse{Term: n.term}
+	}
+	if n.cfg.Restore == nil {
+		n.logError("failed to restore raft snapshot", fmt.Errorf("snapshot at index %d with no Restore configured", snap.Index))
+		return SnapshotResponse{Term: n.term}
+	}
+	var rest []Entry
+	if snap.Index <= n.lastIndex() && n.entry(snap.Index).Term == snap.Term {
+		rest = append(rest, n.entries[snap.Index-n.entries[0].Index+1:]...)
+	}
+
+	if err := n.cfg.Restore(snap.Data); err != nil {
+		n.logError("failed to restore raft snapshot", err)
+		return SnapshotResponse{Term: n.term}
+	}
+	// The state machine now holds the snapshot either way; if it isn't
+	// saved, a restart goes back to the old log and catches up again
+	if err := n.cfg.Store.SaveSnapshot(snap); err != nil {
+		n.logError("failed to save raft snapshot", err)
+	} else if err := n.cfg.Store.Compact(rest); err != nil {
+		n.logError("failed to compact raft log", err)
+	}
+	n.entries = append([]Entry{{Index: snap.Index, Term: snap.Term}}, rest...)
+	n.commit, n.applied = snap.Index, snap.Index
+	// The entries the snapshot covers are never applied here, so their
+	// proposals can't learn their results. Term 0 fails them with ErrDropped.
+	for index, done := range n.waiters {
+		if index <= snap.Index {
+			done <- applyResult{}
+			delete(n.waiters, index)
+		}
+	}
+	n.resetDeadline()
+	return SnapshotResponse{Term: n.term}
+}
+
+// tickLoop starts elections when a follower or candidate has not heard
+// from a leader in time, and makes a leader that has lost touch with a
+// majority step down, so that its clients look for the new one
+func (n *Node) tickLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.ElectionTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.state != Leader && now.After(n.deadline):
+				n.startElection()
+			case n.state == Leader && !n.inTouch(now):
+				n.becomeFollower(n.term, n.vote)
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// startElection becomes a candidate in the next term and asks the peers
+// for their votes. The lock must be held.
+func (n *Node) startElection() {
+	term, id := n.term+1, n.cfg.ID
+	if err := n.cfg.Store.SaveState(HardState{Term: term, Vote: id}); err != nil {
+		n.logError("failed to save raft term", err)
+		n.resetDeadline()
+		return
+	}
+	n.state, n.term, n.vote, n.leader = Candidate, term, id, ""
+	n.resetDeadline()
+
+	if len(n.cfg.Peers) == 0 {
+		n.becomeLeader()
+		return
+	}
+
+	last := n.entry(n.lastIndex())
+	req := VoteRequest{Term: term, Candidate: id, LastLogIndex: last.Index, LastLogTerm: last.Term}
+	votes := 1
+	for _, peer := range n.cfg.Peers {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(n.ctx, n.cfg.ElectionTimeout)
+			resp, err := n.cfg.Transport.RequestVote(ctx, peer, req)
+			cancel()
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term, "")
+				return
+			}
+			if n.state != Candidate || n.term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if n.quorum(votes) {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader takes over as leader of the current term. A no-op entry of
+// the new term is appended at once, since entries of earlier terms only
+// count as committed once one of the leader's own is. The lock must be
+// held.
+func (n *Node) becomeLeader() {
+	n.state, n.leader = Leader, n.cfg.ID
+	if n.cfg.Logger != nil {
+		n.cfg.Logger.Info("elected raft leader", slog.String("id", n.cfg.ID), slog.Uint64("term", n.term))
+	}
+
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term}
+	if err := n.cfg.Store.Append([]Entry{e}); err != nil {
+		n.logError("failed to append to raft log", err)
+		n.becomeFollower(n.term, n.vote)
+		return
+	}
+	n.entries = append(n.entries, e)
+
+	n.next = make(map[string]uint64, len(n.cfg.Peers))
+	n.match = make(map[string]uint64, len(n.cfg.Peers))
+	n.kick = make(map[string]chan struct{}, len(n.cfg.Peers))
+	n.contact = make(map[string]time.Time, len(n.cfg.Peers))
+	for _, peer := range n.cfg.Peers {
+		n.next[peer] = e.Index
+		n.contact[peer] = time.Now()
+		kick := make(chan struct{}, 1)
+		n.kick[peer] = kick
+		n.wg.Add(1)
+		go n.replicateLoop(peer, n.term, kick)
+	}
+	n.advanceCommit()
+}
+
+// becomeFollower steps down to follower in term, keeping vote if the term
+// is unchanged. The lock must be held.
+func (n *Node) becomeFollower(term uint64, vote string) {
+	if term != n.term || vote != n.vote {
+		if err := n.cfg.Store.SaveState(HardState{Term: term, Vote: vote}); err != nil {
+			n.logError("failed to save raft term", err)
+		}
+	}
+	if term != n.term || n.state == Leader {
+		n.leader = ""
+	}
+	n.state, n.term, n.vote = Follower, term, vote
+	n.kick = nil
+	n.resetDeadline()
+}
+
+// replicateLoop keeps peer's log in line with the leader's for as long as
+// the node leads in term, sending new entries when kicked and heartbeats
+// when idle
+func (n *Node) replicateLoop(peer string, term uint64, kick chan struct{}) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		n.mu.Lock()
+		if n.state != Leader || n.term != term {
+			n.mu.Unlock()
+			return
+		}
+		next, first := n.next[peer], n.entries[0].Index
+		if next <= first {
+			// The entries the peer needs were compacted away
+			n.mu.Unlock()
+			if n.sendSnapshot(peer, term) {
+				continue
+			}
+		} else {
+			end := min(n.lastIndex()+1, next+maxBatch)
+			req := AppendRequest{
+				Term:         term,
+				Leader:       n.cfg.ID,
+				PrevLogIndex: next - 1,
+				PrevLogTerm:  n.entry(next - 1).Term,
+				Entries:      append([]Entry(nil), n.entries[next-first:end-first]...),
+				LeaderCommit: n.commit,
+			}
+			n.mu.Unlock()
+
+			ctx, cancel := context.WithTimeout(n.ctx, n.cfg.ElectionTimeout)
+			resp, err := n.cfg.Transport.AppendEntries(ctx, peer, req)
+			cancel()
+
+			more := false
+			if err == nil {
+				n.mu.Lock()
+				more = n.handleAppendResponse(peer, req, resp)
+				n.mu.Unlock()
+			}
+			if more {
+				continue
+			}
+		}
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendSnapshot sends peer the saved snapshot, and reports whether it was
+// installed, in which case entries may remain to be sent after it
+func (n *Node) sendSnapshot(peer string, term uint64) bool {
+	snap, err := n.cfg.Store.LoadSnapshot()
+	if err != nil {
+		n.logError("failed to load raft snapshot", err)
+		return false
+	}
+	req := SnapshotRequest{Term: term, Leader: n.cfg.ID, Snapshot: snap}
+	ctx, cancel := context.WithTimeout(n.ctx, snapshotTimeout)
+	resp, err := n.cfg.Transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	n.contact[peer] = time.Now()
+	if snap.Index > n.match[peer] {
+		n.match[peer] = snap.Index
+		n.next[peer] = snap.Index + 1
+		n.advanceCommit()
+	}
+	return true
+}
+
+// handleAppendResponse updates the leader's view of peer after it answered
+// req, and reports whether entries remain to be sent to it. The lock must be
+// held.
+func (n *Node) handleAppendResponse(peer string, req AppendRequest, resp AppendResponse) bool {
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != req.Term {
+		return false
+	}
+	n.contact[peer] = time.Now()
+
+	if !resp.Success {
+		n.next[peer] = max(1, min(resp.ConflictIndex, req.PrevLogIndex))
+		return true
+	}
+	if match := req.PrevLogIndex + uint64(len(req.Entries)); match > n.match[peer] {
+		n.match[peer] = match
+		n.next[peer] = match + 1
+		n.advanceCommit()
+	}
+	return n.next[peer] <= n.lastIndex()
+}
+
+// advanceCommit commits the highest entry of the current term stored by a
+// majority. The lock must be held.
+func (n *Node) advanceCommit() {
+	for i := n.lastIndex(); i > n.commit && n.entry(i).Term == n.term; i-- {
+		count := 1
+		for _, peer := range n.cfg.Peers {
+			if n.match[peer] >= i {
+				count++
+			}
+		}
+		if n.quorum(count) {
+			n.commit = i
+			n.wakeApply()
+			return
+		}
+	}
+}
+
+// replicate kicks every peer's replication loop. The lock must be held.
+func (n *Node) replicate() {
+	for _, kick := range n.kick {
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// applyLoop passes committed entries to Apply in order and hands the
+// results to the proposals waiting for them, then compacts the log if it
+// has grown enough
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.applyc:
+		}
+
+		n.applyMu.Lock()
+		n.applyCommitted()
+		n.compact()
+		n.applyMu.Unlock()
+	}
+}
+
+// applyCommitted applies the entries committed since the last call. The
+// apply lock must be held.
+func (n *Node) applyCommitted() {
+	n.mu.Lock()
+	first := n.entries[0].Index
+	pending := append([]Entry(nil), n.entries[n.applied+1-first:n.commit+1-first]...)
+	n.mu.Unlock()
+
+	for _, e := range pending {
+		var value any
+		if len(e.Command) > 0 {
+			value = n.cfg.Apply(e)
+		}
+
+		n.mu.Lock()
+		n.applied = e.Index
+		if done, ok := n.waiters[e.Index]; ok {
+			done <- applyResult{term: e.Term, value: value}
+			delete(n.waiters, e.Index)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// compact replaces the applied entries with a snapshot once there are
+// SnapshotThreshold of them. The apply lock must be held, so that the
+// snapshot is taken as of the last applied entry.
+func (n *Node) compact() {
+	if n.cfg.Snapshot == nil {
+		return
+	}
+	n.mu.Lock()
+	applied, first := n.applied, n.entries[0].Index
+	due := applied-first >= n.cfg.SnapshotThreshold
+	term := n.entry(applied).Term
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	data, err := n.cfg.Snapshot()
+	if err != nil {
+		n.logError("failed to take raft snapshot", err)
+		return
+	}
+	// Saving the snapshot leaves the log alone, so it is done without the
+	// lock and appends carry on meanwhile
+	snap := Snapshot{Index: applied, Term: term, Data: data}
+	if err := n.cfg.Store.SaveSnapshot(snap); err != nil {
+		n.logError("failed to save raft snapshot", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.entries[0].Index != first {
+		return
+	}
+	rest := append([]Entry(nil), n.entries[applied+1-first:]...)
+	if err := n.cfg.Store.Compact(rest); err != nil {
+		n.logError("failed to compact raft log", err)
+		return
+	}
+	n.entries = append([]Entry{{Index: snap.Index, Term: snap.Term}}, rest...)
+}
+
+func (n *Node) wakeApply() {
+	select {
+	case n.applyc <- struct{}{}:
+	default:
+	}
+}
+
+// inTouch reports whether a majority, counting the leader, answered it
+// within the election timeout. The lock must be held.
+func (n *Node) inTouch(now time.Time) bool {
+	count := 1
+	for _, at := range n.contact {
+		if now.Sub(at) < n.cfg.ElectionTimeout {
+			count++
+		}
+	}
+	return n.quorum(count)
+}
+
+// quorum reports whether votes make a majority of the group
+func (n *Node) quorum(votes int) bool {
+	return votes > (len(n.cfg.Peers)+1)/2
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.entries[0].Index + uint64(len(n.entries)-1)
+}
+
+// entry returns the entry at index, or the sentinel for the snapshot's last
+// entry. The lock must be held.
+func (n *Node) entry(index uint64) Entry {
+	return n.entries[index-n.entries[0].Index]
+}
+
+// resetDeadline schedules the next election a random time from now
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout
+	n.deadline = time.Now().Add(timeout + rand.N(timeout))
+}
+
+func (n *Node) logError(msg string, err error) {
+	if n.cfg.Logger != nil {
+		n.cfg.Logger.Error(msg, slog.String("id", n.cfg.ID), slog.String("error", err.Error()))
+	}
+}
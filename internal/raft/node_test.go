@@ -0,0 +1,336 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStartValidatesTiming(t *testing.T) {
+	_, err := Start(Config{
+		ID:                "n0",
+		Store:             NewMemoryLogStore(),
+		ElectionTimeout:   time.Second,
+		HeartbeatInterval: time.Second,
+	})
+	if err == nil {
+		t.Error("Start() with a heartbeat as long as the election timeout should fail")
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	c := newCluster(t, 1)
+	if got := c.propose("a"); got != "a@2" {
+		t.Errorf("Propose() = %q, want a@2 after the leader's no-op", got)
+	}
+	c.waitApplied("n0", []string{"a"})
+}
+
+func TestElection(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	st := leader.Status()
+
+	for _, id := range c.ids {
+		waitFor(t, id+" to follow the leader", func() bool {
+			return c.node(id).Leader() == st.ID
+		})
+		if id != st.ID && c.node(id).Status().State != Follower {
+			t.Errorf("%s is %s, want follower", id, c.node(id).Status().State)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprintf("cmd%d", i)
+		value, err := leader.Propose(context.Background(), []byte(cmd))
+		if err != nil {
+			t.Fatalf("Propose() error = %v", err)
+		}
+		if value != fmt.Sprintf("%s@%d", cmd, i+2) {
+			t.Errorf("Propose() = %v, want the leader's Apply result", value)
+		}
+		want = append(want, cmd)
+	}
+	for _, id := range c.ids {
+		c.waitApplied(id, want)
+	}
+
+	st := leader.Status()
+	if st.CommitIndex != 21 || st.AppliedIndex != 21 || st.LastIndex != 21 {
+		t.Errorf("Status() = %+v, want everything committed and applied", st)
+	}
+}
+
+func TestProposeToFollower(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader().Status().ID
+
+	for _, id := range c.ids {
+		if id == leader {
+			continue
+		}
+		waitFor(t, id+" to learn the leader", func() bool { return c.node(id).Leader() == leader })
+
+		_, err := c.node(id).Propose(context.Background(), []byte("x"))
+		var notLeader *NotLeaderError
+		if !errors.As(err, &notLeader) || notLeader.Leader != leader {
+			t.Errorf("Propose() on %s error = %v, want redirect to %s", id, err, leader)
+		}
+	}
+}
+
+func TestLeaderFailure(t *testing.T) {
+	c := newCluster(t, 3)
+	c.propose("a")
+
+	// Cut the leader off; the others elect a new one and carry on
+	old := c.leader().Status().ID
+	c.nw.isolate(old, true)
+	c.propose("b", old)
+	if leader := c.leader(old).Status().ID; leader == old {
+		t.Fatalf("isolated node %s still leads", old)
+	}
+
+	// The old leader notices it lost its majority and steps down
+	waitFor(t, "the old leader to step down", func() bool {
+		return c.node(old).Status().State != Leader
+	})
+
+	// Back on the network, it catches up
+	c.nw.isolate(old, false)
+	c.propose("c")
+	for _, id := range c.ids {
+		c.waitApplied(id, []string{"a", "b", "c"})
+	}
+}
+
+func TestMinorityPartition(t *testing.T) {
+	c := newCluster(t, 5)
+	c.propose("a")
+
+	// A majority of three keeps committing
+	c.nw.isolate("n0", true)
+	c.nw.isolate("n1", true)
+	c.propose("b", "n0", "n1")
+
+	// Two of five cannot: the leader loses another follower
+	leader := c.leader("n0", "n1")
+	for _, id := range []string{"n2", "n3", "n4"} {
+		if id != leader.Status().ID {
+			c.nw.isolate(id, true)
+			break
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testElectionTimeout)
+	defer cancel()
+	if _, err := leader.Propose(ctx, []byte("lost")); err == nil {
+		t.Error("Propose() committed without a majority")
+	}
+
+	// The timed out proposal may still be committed once the partition
+	// heals, but every node applies the same entries
+	for _, id := range c.ids {
+		c.nw.isolate(id, false)
+	}
+	c.propose("c")
+	want := []string{"a", "b", "c"}
+	c.mu.Lock()
+	if applied := c.applied[c.leader().Status().ID]; len(applied) == 4 {
+		want = applied
+	}
+	c.mu.Unlock()
+	for _, id := range c.ids {
+		c.waitApplied(id, want)
+	}
+}
+
+func TestUncommittedEntriesReplaced(t *testing.T) {
+	c := newCluster(t, 3)
+	c.propose("a")
+
+	// A leader cut off takes a proposal it can never commit
+	old := c.leader()
+	oldID := old.Status().ID
+	c.nw.isolate(oldID, true)
+	dropped := make(chan error, 1)
+	go func() {
+		_, err := old.Propose(context.Background(), []byte("lost"))
+		dropped <- err
+	}()
+
+	c.propose("b", oldID)
+	c.nw.isolate(oldID, false)
+
+	// The new leader's log wins, and the proposal learns it was dropped
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, ErrDropped) {
+			t.Errorf("Propose() error = %v, want ErrDropped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Propose() on the old leader never returned")
+	}
+	c.propose("c")
+	for _, id := range c.ids {
+		c.waitApplied(id, []string{"a", "b", "c"})
+	}
+}
+
+func TestLossyNetwork(t *testing.T) {
+	c := newCluster(t, 3)
+	c.nw.setLoss(0.2)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprintf("cmd%d", i)
+		c.propose(cmd)
+		want = append(want, cmd)
+	}
+
+	c.nw.setLoss(0)
+	for _, id := range c.ids {
+		c.waitApplied(id, want)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	c := newCluster(t, 3)
+	c.propose("a")
+
+	// A stopped follower misses entries, then catches up from its own log
+	// and the leader once it is back
+	follower := c.ids[0]
+	if c.leader().Status().ID == follower {
+		follower = c.ids[1]
+	}
+	c.stop(follower)
+	c.propose("b", follower)
+	c.propose("c", follower)
+
+	c.start(follower)
+	c.waitApplied(follower, []string{"a", "b", "c"})
+
+	// Stopping everything and starting again replays the stored logs
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	c.propose("d")
+	for _, id := range c.ids {
+		c.waitApplied(id, []string{"a", "b", "c", "d"})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := newSnapshottingCluster(t, 3, 5)
+	c.propose("a")
+
+	// A follower that misses more than the leader keeps is sent the
+	// leader's snapshot and then the entries after it
+	follower := c.ids[0]
+	if c.leader().Status().ID == follower {
+		follower = c.ids[1]
+	}
+	c.stop(follower)
+	var want []string
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprintf("c%d", i)
+		c.propose(cmd, follower)
+		want = append(want, cmd)
+	}
+	want = append([]string{"a"}, want...)
+
+	leader := c.leader(follower).Status().ID
+	snap, _ := c.stores[leader].LoadSnapshot()
+	_, entries, _ := c.stores[leader].Load()
+	if snap.Index == 0 || len(entries) > 10 {
+		t.Errorf("leader kept %d entries after a snapshot at %d, want the log compacted", len(entries), snap.Index)
+	}
+
+	c.start(follower)
+	c.waitApplied(follower, want)
+	if snap, _ := c.stores[follower].LoadSnapshot(); snap.Index == 0 {
+		t.Error("the follower did not save the snapshot it was sent")
+	}
+
+	// Restarting everything restores the snapshots and replays the rest
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	c.propose("d")
+	want = append(want, "d")
+	for _, id := range c.ids {
+		c.waitApplied(id, want)
+	}
+}
+
+func TestSnapshotDropsProposals(t *testing.T) {
+	c := newSnapshottingCluster(t, 3, 5)
+	c.propose("a")
+
+	// A leader cut off takes a proposal it can never commit, and the
+	// others compact their logs past it
+	old := c.leader()
+	oldID := old.Status().ID
+	c.nw.isolate(oldID, true)
+	dropped := make(chan error, 1)
+	go func() {
+		_, err := old.Propose(context.Background(), []byte("lost"))
+		dropped <- err
+	}()
+	want := []string{"a"}
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprintf("b%d", i)
+		c.propose(cmd, oldID)
+		want = append(want, cmd)
+	}
+	c.nw.isolate(oldID, false)
+
+	// The old leader is sent a snapshot covering the proposal's index
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, ErrDropped) {
+			t.Errorf("Propose() error = %v, want ErrDropped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Propose() on the old leader never returned")
+	}
+	c.waitApplied(oldID, want)
+}
+
+func TestStop(t *testing.T) {
+	c := newCluster(t, 1)
+	node := c.leader()
+	c.stop("n0")
+
+	if _, err := node.Propose(context.Background(), []byte("x")); !errors.Is(err, ErrStopped) {
+		t.Errorf("Propose() after Stop() error = %v, want ErrStopped", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raft implements the Raft consensus algorithm: leader election and
+// log replication among a fixed group of nodes. Commands proposed to the
+// leader are appended to its log, replicated to the followers, and handed to
+// every node's Apply function, in log order, once a majority stores them.
+// The applied part of the log is compacted into snapshots of the state
+// machine, which are sent to followers too far behind for the entries they
+// need.
+//
+// Messages between nodes go through a Transport, so that the algorithm can
+// run over the network or entirely in-process in tests. Each node's term,
+// vote and log are kept by a LogStore, which must be durable for the
+// guarantees to hold across restarts.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// State is a node's role in its current term
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Entry is one command in the replicated log. Indexes start at 1. An entry
+// with an empty command is the no-op a new leader appends to commit the
+// entries of earlier terms; it is not passed to Apply.
+type Entry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte
+}
+
+// Snapshot is the state machine as of the entry at Index, of term Term,
+// which replaces the log up to and including that entry
+type Snapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// VoteRequest asks a node to vote for Candidate in Term
+type VoteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// VoteResponse answers a VoteRequest
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest carries log entries, or none as a heartbeat, from the leader
+// of Term. Entries follow the entry at PrevLogIndex, which must have
+// PrevLogTerm on the follower for them to be accepted.
+type AppendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendResponse answers an AppendRequest. When Success is false and Term is
+// not newer than the leader's, ConflictIndex is where the leader should
+// retry from.
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// SnapshotRequest carries the snapshot of the leader of Term to a follower
+// that needs entries the leader has compacted away
+type SnapshotRequest struct {
+	Term     uint64
+	Leader   string
+	Snapshot Snapshot
+}
+
+// SnapshotResponse answers a SnapshotRequest
+type SnapshotResponse struct {
+	Term uint64
+}
+
+// Transport delivers requests to other nodes, identified by their IDs, and
+// returns their responses. An error means the request may or may not have
+// been delivered; the node retries later.
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+var (
+	// ErrStopped is returned by Propose once the node is stopped
+	ErrStopped = errors.New("raft node stopped")
+
+	// ErrDropped is returned by Propose when the proposer lost its
+	// leadership and the entry was replaced by another leader's, or was
+	// covered by a snapshot from the new leader before it was applied
+	ErrDropped = errors.New("entry dropped by a new leader")
+)
+
+// NotLeaderError is returned by Propose on a node that is not the leader.
+// Leader is the ID of the leader it last heard from, empty if none.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "no leader elected"
+	}
+	return "not the leader, the leader is " + e.Leader
+}
@@ -0,0 +1,321 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testElectionTimeout   = 60 * time.Millisecond
+	testHeartbeatInterval = 10 * time.Millisecond
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// network connects in-process nodes and injects faults: nodes cut off from
+// the rest, and requests or responses lost at random
+type network struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	isolated map[string]bool
+	loss     float64
+}
+
+func newNetwork() *network {
+	return &network{nodes: make(map[string]*Node), isolated: make(map[string]bool)}
+}
+
+func (nw *network) isolate(id string, cut bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.isolated[id] = cut
+}
+
+func (nw *network) setLoss(p float64) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.loss = p
+}
+
+// route returns the node a message from one node to another reaches, or
+// nil if it is lost
+func (nw *network) route(from, to string) *Node {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.isolated[from] || nw.isolated[to] || rand.Float64() < nw.loss {
+		return nil
+	}
+	return nw.nodes[to]
+}
+
+// transport is a node's end of the network
+type transport struct {
+	nw   *network
+	from string
+}
+
+func (t transport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	node := t.nw.route(t.from, peer)
+	if node == nil {
+		return VoteResponse{}, errUnreachable
+	}
+	resp := node.HandleVote(req)
+	if t.nw.route(peer, t.from) == nil {
+		return VoteResponse{}, errUnreachable
+	}
+	return resp, ctx.Err()
+}
+
+func (t transport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	node := t.nw.route(t.from, peer)
+	if node == nil {
+		return AppendResponse{}, errUnreachable
+	}
+	resp := node.HandleAppend(req)
+	if t.nw.route(peer, t.from) == nil {
+		return AppendResponse{}, errUnreachable
+	}
+	return resp, ctx.Err()
+}
+
+func (t transport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	node := t.nw.route(t.from, peer)
+	if node == nil {
+		return SnapshotResponse{}, errUnreachable
+	}
+	resp := node.HandleSnapshot(req)
+	if t.nw.route(peer, t.from) == nil {
+		return SnapshotResponse{}, errUnreachable
+	}
+	return resp, ctx.Err()
+}
+
+// cluster is a group of nodes on one network, each recording the commands
+// it applied. With a snapshot threshold, those records are what snapshots
+// hold.
+type cluster struct {
+	t      *testing.T
+	nw     *network
+	ids    []string
+	stores map[string]*MemoryLogStore
+
+	snapshotThreshold uint64
+
+	mu      sync.Mutex
+	applied map[string][]string
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	return newSnapshottingCluster(t, size, 0)
+}
+
+// newSnapshottingCluster is like newCluster, but the nodes compact their
+// logs every snapshotThreshold entries unless it is 0
+func newSnapshottingCluster(t *testing.T, size int, snapshotThreshold uint64) *cluster {
+	c := &cluster{
+		t:                 t,
+		nw:                newNetwork(),
+		stores:            make(map[string]*MemoryLogStore),
+		applied:           make(map[string][]string),
+		snapshotThreshold: snapshotThreshold,
+	}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range c.ids {
+		c.stores[id] = NewMemoryLogStore()
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, id := range c.ids {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+// start starts id from whatever its store holds, with nothing applied
+func (c *cluster) start(id string) *Node {
+	var peers []string
+	for _, other := range c.ids {
+		if other != id {
+			peers = append(peers, other)
+		}
+	}
+
+	c.mu.Lock()
+	c.applied[id] = nil
+	c.mu.Unlock()
+
+	cfg := Config{
+		ID:        id,
+		Peers:     peers,
+		Transport: transport{nw: c.nw, from: id},
+		Store:     c.stores[id],
+		Apply: func(e Entry) any {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.applied[id] = append(c.applied[id], string(e.Command))
+			return fmt.Sprintf("%s@%d", e.Command, e.Index)
+		},
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeatInterval,
+	}
+	if c.snapshotThreshold > 0 {
+		cfg.SnapshotThreshold = c.snapshotThreshold
+		cfg.Snapshot = func() ([]byte, error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return []byte(strings.Join(c.applied[id], ",")), nil
+		}
+		cfg.Restore = func(data []byte) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.applied[id] = nil
+			if len(data) > 0 {
+				c.applied[id] = strings.Split(string(data), ",")
+			}
+			return nil
+		}
+	}
+	node, err := Start(cfg)
+	if err != nil {
+		c.t.Fatalf("Start() error = %v", err)
+	}
+	c.nw.mu.Lock()
+	c.nw.nodes[id] = node
+	c.nw.mu.Unlock()
+	return node
+}
+
+func (c *cluster) stop(id string) {
+	c.nw.mu.Lock()
+	node := c.nw.nodes[id]
+	delete(c.nw.nodes, id)
+	c.nw.mu.Unlock()
+	if node != nil {
+		node.Stop()
+	}
+}
+
+func (c *cluster) node(id string) *Node {
+	c.nw.mu.Lock()
+	defer c.nw.mu.Unlock()
+	return c.nw.nodes[id]
+}
+
+// leader waits until exactly one of the running nodes not in except leads
+// in the highest term among them, and returns it
+func (c *cluster) leader(except ...string) *Node {
+	c.t.Helper()
+	var found *Node
+	waitFor(c.t, "a single leader", func() bool {
+		found = nil
+		var term uint64
+		leaders := 0
+		for _, id := range c.ids {
+			node := c.node(id)
+			if node == nil || contains(except, id) {
+				continue
+			}
+			st := node.Status()
+			switch {
+			case st.State == Leader && st.Term > term:
+				found, term, leaders = node, st.Term, 1
+			case st.State == Leader && st.Term == term:
+				leaders++
+			}
+		}
+		return leaders == 1
+	})
+	return found
+}
+
+// propose proposes cmd to the current leader, retrying while leadership
+// changes, and returns the result. Proposals are not retried after a
+// timeout, which would risk committing them twice.
+func (c *cluster) propose(cmd string, except ...string) string {
+	c.t.Helper()
+	for attempt := 0; attempt < 20; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		value, err := c.leader(except...).Propose(ctx, []byte(cmd))
+		cancel()
+		var notLeader *NotLeaderError
+		switch {
+		case err == nil:
+			return value.(string)
+		case !errors.As(err, &notLeader) && !errors.Is(err, ErrDropped):
+			c.t.Fatalf("Propose(%q) error = %v", cmd, err)
+		}
+	}
+	c.t.Fatalf("could not commit %q", cmd)
+	return ""
+}
+
+// waitApplied waits until id applied exactly want
+func (c *cluster) waitApplied(id string, want []string) {
+	c.t.Helper()
+	waitFor(c.t, id+" to apply "+fmt.Sprint(want), func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return fmt.Sprint(c.applied[id]) == fmt.Sprint(want)
+	})
+}
+
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFor polls cond until it holds, failing the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStateString(t *testing.T) {
+	for state, want := range map[State]string{Follower: "follower", Candidate: "candidate", Leader: "leader", State(7): "State(7)"} {
+		if got := state.String(); got != want {
+			t.Errorf("State(%d).String() = %q, want %q", int(state), got, want)
+		}
+	}
+}
+
+func TestNotLeaderError(t *testing.T) {
+	if got := (&NotLeaderError{}).Error(); got != "no leader elected" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := (&NotLeaderError{Leader: "n1"}).Error(); got != "not the leader, the leader is n1" {
+		t.Errorf("Error() = %q", got)
+	}
+}
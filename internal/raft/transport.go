@@ -0,0 +1,294 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// TCPTransport sends requests to peers as RAFT commands over RESP, so nodes
+// talk to each other on the port they serve clients on. Peer IDs are their
+// host:port addresses. Requests and replies are arrays of bulk strings:
+//
+//	RAFT VOTE <term> <candidate> <last-index> <last-term>
+//	    -> <term> <granted>
+//	RAFT APPEND <term> <leader> <prev-index> <prev-term> <leader-commit> [<index> <term> <command>]...
+//	    -> <term> <success> <conflict-index>
+//	RAFT SNAPSHOT <term> <leader> <index> <last-term> <data>
+//	    -> <term>
+//
+// Booleans are sent as 0 or 1. Connections are pooled per peer.
+type TCPTransport struct {
+	user     string
+	password string
+
+	mu   sync.Mutex
+	idle map[string][]*peerConn
+}
+
+// peerConn is a connection to a peer, authenticated if needed
+type peerConn struct {
+	conn   net.Conn
+	reader *protocol.RESPReader
+	writer *protocol.RESPWriter
+}
+
+// maxIdle is the most idle connections kept per peer
+const maxIdle = 4
+
+// NewTCPTransport returns a transport that authenticates to peers with
+// password, as user if set, or does not authenticate if password is empty
+func NewTCPTransport(user, password string) *TCPTransport {
+	return &TCPTransport{user: user, password: password, idle: make(map[string][]*peerConn)}
+}
+
+// Close closes the idle connections
+func (t *TCPTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for peer, conns := range t.idle {
+		for _, pc := range conns {
+			_ = pc.conn.Close()
+		}
+		delete(t.idle, peer)
+	}
+}
+
+func (t *TCPTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	args := []string{"RAFT", "VOTE", u64(req.Term), req.Candidate, u64(req.LastLogIndex), u64(req.LastLogTerm)}
+	var resp VoteResponse
+	err := t.call(ctx, peer, args, nil, func(reply [][]byte) error {
+		if len(reply) != 2 {
+			return fmt.Errorf("%w: vote reply has %d fields", errProtocol, len(reply))
+		}
+		var err error
+		resp.Term, err = parseU64(reply[0])
+		resp.Granted = string(reply[1]) == "1"
+		return err
+	})
+	return resp, err
+}
+
+func (t *TCPTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	args := []string{"RAFT", "APPEND", u64(req.Term), req.Leader, u64(req.PrevLogIndex), u64(req.PrevLogTerm), u64(req.LeaderCommit)}
+	var resp AppendResponse
+	err := t.call(ctx, peer, args, req.Entries, func(reply [][]byte) error {
+		if len(reply) != 3 {
+			return fmt.Errorf("%w: append reply has %d fields", errProtocol, len(reply))
+		}
+		var err1, err2 error
+		resp.Term, err1 = parseU64(reply[0])
+		resp.Success = string(reply[1]) == "1"
+		resp.ConflictIndex, err2 = parseU64(reply[2])
+		return errors.Join(err1, err2)
+	})
+	return resp, err
+}
+
+func (t *TCPTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	snap := req.Snapshot
+	args := []string{"RAFT", "SNAPSHOT", u64(req.Term), req.Leader, u64(snap.Index), u64(snap.Term), string(snap.Data)}
+	var resp SnapshotResponse
+	err := t.call(ctx, peer, args, nil, func(reply [][]byte) error {
+		if len(reply) != 1 {
+			return fmt.Errorf("%w: snapshot reply has %d fields", errProtocol, len(reply))
+		}
+		var err error
+		resp.Term, err = parseU64(reply[0])
+		return err
+	})
+	return resp, err
+}
+
+// call sends args followed by entries to peer and parses the reply. The
+// connection is only reused if the exchange succeeded.
+func (t *TCPTransport) call(ctx context.Context, peer string, args []string, entries []Entry, parse func(reply [][]byte) error) error {
+	pc, err := t.get(ctx, peer)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = pc.conn.SetDeadline(deadline)
+	} else {
+		_ = pc.conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { _ = pc.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	_ = pc.writer.WriteArrayHeader(len(args) + 3*len(entries))
+	for _, arg := range args {
+		_ = pc.writer.WriteBulkString(arg)
+	}
+	for _, e := range entries {
+		_ = pc.writer.WriteBulkString(u64(e.Index))
+		_ = pc.writer.WriteBulkString(u64(e.Term))
+		_ = pc.writer.WriteBulkBytes(e.Command)
+	}
+	err = pc.writer.Flush()
+	if err == nil {
+		var reply [][]byte
+		if reply, err = pc.reader.ReadCommandBytes(); err == nil {
+			err = parse(reply)
+		}
+	}
+	if err != nil {
+		_ = pc.conn.Close()
+		return err
+	}
+
+	t.put(peer, pc)
+	return nil
+}
+
+// get returns an idle connection to peer or dials a new one
+func (t *TCPTransport) get(ctx context.Context, peer string) (*peerConn, error) {
+	t.mu.Lock()
+	if conns := t.idle[peer]; len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		t.idle[peer] = conns[:len(conns)-1]
+		t.mu.Unlock()
+		return pc, nil
+	}
+	t.mu.Unlock()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", peer)
+	if err != nil {
+		return nil, err
+	}
+	pc := &peerConn{conn: conn, reader: protocol.NewRESPReader(conn), writer: protocol.NewRESPWriter(conn)}
+	if t.password != "" {
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		args := []string{"AUTH", t.password}
+		if t.user != "" {
+			args = []string{"AUTH", t.user, t.password}
+		}
+		_ = pc.writer.WriteArray(args)
+		err := pc.writer.Flush()
+		if err == nil {
+			_, err = pc.reader.ReadCommandBytes()
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("authenticating to %s: %w", peer, err)
+		}
+	}
+	return pc, nil
+}
+
+func (t *TCPTransport) put(peer string, pc *peerConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.idle[peer]) >= maxIdle {
+		_ = pc.conn.Close()
+		return
+	}
+	t.idle[peer] = append(t.idle[peer], pc)
+}
+
+// errProtocol is returned for a message that doesn't follow the protocol
+var errProtocol = errors.New("raft protocol error")
+
+// HandleCommand runs the arguments of a RAFT command received from a peer
+// against n and returns the reply fields. The arguments may point into the
+// reader's buffer; whatever is kept is copied.
+func HandleCommand(n *Node, args [][]byte) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: missing subcommand", errProtocol)
+	}
+	switch sub := strings.ToUpper(string(args[0])); {
+	case sub == "VOTE" && len(args) == 5:
+		var req VoteRequest
+		var err1, err2, err3 error
+		req.Term, err1 = parseU64(args[1])
+		req.Candidate = string(args[2])
+		req.LastLogIndex, err2 = parseU64(args[3])
+		req.LastLogTerm, err3 = parseU64(args[4])
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, err
+		}
+		resp := n.HandleVote(req)
+		return []string{u64(resp.Term), flag(resp.Granted)}, nil
+
+	case sub == "APPEND" && len(args) >= 6 && (len(args)-6)%3 == 0:
+		var req AppendRequest
+		var err1, err2, err3, err4 error
+		req.Term, err1 = parseU64(args[1])
+		req.Leader = string(args[2])
+		req.PrevLogIndex, err2 = parseU64(args[3])
+		req.PrevLogTerm, err3 = parseU64(args[4])
+		req.LeaderCommit, err4 = parseU64(args[5])
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return nil, err
+		}
+		for i := 6; i < len(args); i += 3 {
+			index, err1 := parseU64(args[i])
+			term, err2 := parseU64(args[i+1])
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, err
+			}
+			if index != req.PrevLogIndex+uint64(len(req.Entries))+1 {
+				return nil, fmt.Errorf("%w: entry %d out of order", errProtocol, index)
+			}
+			req.Entries = append(req.Entries, Entry{Index: index, Term: term, Command: append([]byte(nil), args[i+2]...)})
+		}
+		resp := n.HandleAppend(req)
+		return []string{u64(resp.Term), flag(resp.Success), u64(resp.ConflictIndex)}, nil
+
+	case sub == "SNAPSHOT" && len(args) == 6:
+		var req SnapshotRequest
+		var err1, err2, err3 error
+		req.Term, err1 = parseU64(args[1])
+		req.Leader = string(args[2])
+		req.Snapshot.Index, err2 = parseU64(args[3])
+		req.Snapshot.Term, err3 = parseU64(args[4])
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return nil, err
+		}
+		req.Snapshot.Data = append([]byte(nil), args[5]...)
+		resp := n.HandleSnapshot(req)
+		return []string{u64(resp.Term)}, nil
+	}
+	return nil, fmt.Errorf("%w: malformed RAFT %s", errProtocol, args[0])
+}
+
+func u64(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func parseU64(b []byte) (uint64, error) {
+	n, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid number %q", errProtocol, b)
+	}
+	return n, nil
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,248 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// serveRaft answers RAFT commands on l with whatever node holds, and AUTH
+// with password
+func serveRaft(t *testing.T, l net.Listener, password string, node func() *Node) {
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = l.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { _ = conn.Close() }()
+				reader := protocol.NewRESPReader(conn)
+				writer := protocol.NewRESPWriter(conn)
+				authed := password == ""
+				for {
+					cmd, err := reader.ReadCommandBytes()
+					if err != nil {
+						return
+					}
+					switch {
+					case string(cmd[0]) == "AUTH":
+						authed = string(cmd[len(cmd)-1]) == password
+						if authed {
+							_ = writer.WriteSimpleString("OK")
+						} else {
+							_ = writer.WriteErrorCode("WRONGPASS", "invalid password")
+						}
+					case !authed:
+						_ = writer.WriteErrorCode("NOAUTH", "Authentication required.")
+					case node() == nil:
+						_ = writer.WriteError("not started")
+					default:
+						reply, err := HandleCommand(node(), cmd[1:])
+						if err != nil {
+							_ = writer.WriteError(err.Error())
+						} else {
+							_ = writer.WriteArray(reply)
+						}
+					}
+					if writer.Flush() != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+func TestTCPTransport(t *testing.T) {
+	const size = 3
+	listeners := make([]net.Listener, size)
+	ids := make([]string, size)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i], ids[i] = l, l.Addr().String()
+	}
+
+	var mu sync.Mutex
+	nodes := make([]*Node, size)
+	applied := make([][]string, size)
+	for i := range nodes {
+		i := i
+		serveRaft(t, listeners[i], "secret", func() *Node {
+			mu.Lock()
+			defer mu.Unlock()
+			return nodes[i]
+		})
+	}
+	for i := range nodes {
+		i := i
+		var peers []string
+		for j, id := range ids {
+			if j != i {
+				peers = append(peers, id)
+			}
+		}
+		transport := NewTCPTransport("", "secret")
+		t.Cleanup(transport.Close)
+		node, err := Start(Config{
+			ID:        ids[i],
+			Peers:     peers,
+			Transport: transport,
+			Store:     NewMemoryLogStore(),
+			Apply: func(e Entry) any {
+				mu.Lock()
+				defer mu.Unlock()
+				applied[i] = append(applied[i], string(e.Command))
+				return nil
+			},
+			ElectionTimeout:   testElectionTimeout,
+			HeartbeatInterval: testHeartbeatInterval,
+		})
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		t.Cleanup(node.Stop)
+		mu.Lock()
+		nodes[i] = node
+		mu.Unlock()
+	}
+
+	var leader *Node
+	waitFor(t, "a leader", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, node := range nodes {
+			if node.Status().State == Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	for _, cmd := range []string{"a", "b", "c"} {
+		if _, err := leader.Propose(context.Background(), []byte(cmd)); err != nil {
+			t.Fatalf("Propose() error = %v", err)
+		}
+	}
+	for i := range nodes {
+		waitFor(t, ids[i]+" to apply the commands", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(applied[i]) == 3 && applied[i][2] == "c"
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		transport := NewTCPTransport("", "wrong")
+		defer transport.Close()
+		if _, err := transport.RequestVote(context.Background(), ids[0], VoteRequest{}); err == nil {
+			t.Error("RequestVote() with a wrong password should fail")
+		}
+	})
+
+	t.Run("unreachable peer", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		_ = l.Close()
+
+		transport := NewTCPTransport("", "")
+		if _, err := transport.AppendEntries(context.Background(), addr, AppendRequest{}); err == nil {
+			t.Error("AppendEntries() to a closed port should fail")
+		}
+	})
+}
+
+func TestHandleCommand(t *testing.T) {
+	var restored string
+	node, err := Start(Config{
+		ID:                "n0",
+		Peers:             []string{"n1"},
+		Store:             NewMemoryLogStore(),
+		Apply:             func(Entry) any { return nil },
+		Restore:           func(data []byte) error { restored = string(data); return nil },
+		ElectionTimeout:   testElectionTimeout * 100,
+		HeartbeatInterval: testHeartbeatInterval,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+
+	args := func(s ...string) [][]byte {
+		var out [][]byte
+		for _, arg := range s {
+			out = append(out, []byte(arg))
+		}
+		return out
+	}
+
+	reply, err := HandleCommand(node, args("APPEND", "2", "n1", "0", "0", "1", "1", "2", "x"))
+	if err != nil || len(reply) != 3 || reply[1] != "1" {
+		t.Fatalf("HandleCommand(APPEND) = %v, %v, want success", reply, err)
+	}
+	if st := node.Status(); st.Leader != "n1" || st.LastIndex != 1 || st.CommitIndex != 1 {
+		t.Errorf("Status() = %+v after APPEND", st)
+	}
+
+	reply, err = HandleCommand(node, args("VOTE", "3", "n1", "1", "2"))
+	if err != nil || len(reply) != 2 || reply[0] != "3" || reply[1] != "1" {
+		t.Errorf("HandleCommand(VOTE) = %v, %v, want vote granted in term 3", reply, err)
+	}
+
+	reply, err = HandleCommand(node, args("SNAPSHOT", "3", "n1", "5", "3", "data\r\n"))
+	if err != nil || len(reply) != 1 || reply[0] != "3" {
+		t.Errorf("HandleCommand(SNAPSHOT) = %v, %v, want term 3", reply, err)
+	}
+	if st := node.Status(); restored != "data\r\n" || st.LastIndex != 5 || st.CommitIndex != 5 {
+		t.Errorf("Status() = %+v, restored %q after SNAPSHOT", st, restored)
+	}
+
+	for _, bad := range [][]string{
+		{},
+		{"VOTE", "1"},
+		{"VOTE", "x", "n1", "0", "0"},
+		{"APPEND", "3", "n1", "0", "0", "0", "5", "3"},
+		{"APPEND", "3", "n1", "0", "0", "0", "5", "3", "x"},
+		{"SNAPSHOT", "3", "n1", "5", "3"},
+		{"SNAPSHOT", "3", "n1", "x", "3", "data"},
+		{"NOPE"},
+	} {
+		if _, err := HandleCommand(node, args(bad...)); !errors.Is(err, errProtocol) {
+			t.Errorf("HandleCommand(%q) error = %v, want a protocol error", bad, err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uzqw/vex/internal/changelog"
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// streamBatch is the number of changes read from the backlog at a time
+const streamBatch = 1024
+
+// SyncRequest is a replica's PSYNC request
+type SyncRequest struct {
+	// ID and Offset are the leader ID and offset the replica last saw, or
+	// empty and 0 if it has never synced
+	ID     string
+	Offset uint64
+
+	// ListeningPort is the port the replica serves clients on, as sent
+	// with REPLCONF listening-port, or 0
+	ListeningPort int
+}
+
+// ReplicaInfo describes a connected replica
+type ReplicaInfo struct {
+	Addr    string // Address the replica connected from
+	Port    int    // Port it serves clients on, 0 if it didn't say
+	State   string // "sync" while the snapshot is sent, then "online"
+	Offset  uint64 // Last offset the replica acknowledged
+	LastAck time.Time
+}
+
+// follower is the leader's view of a connected replica
+type follower struct {
+	addr    string
+	port    int
+	online  atomic.Bool
+	offset  atomic.Uint64
+	lastAck atomic.Int64 // Unix nanoseconds
+}
+
+// Leader streams the changes made to a storage to its replicas. Its Record
+// method must be registered with the storage's OnChange hook.
+type Leader struct {
+	store       *storage.Storage
+	id          string
+	backlogSize int64
+
+	// backlog holds the recent changes replicas stream from. It is created
+	// when the first replica syncs, so a server without replicas keeps no
+	// backlog.
+	backlog atomic.Pointer[changelog.Log]
+
+	mu        sync.Mutex
+	followers map[*follower]struct{}
+
+	fullSyncs    atomic.Uint64
+	partialSyncs atomic.Uint64
+}
+
+// NewLeader creates a leader for store whose backlog keeps up to
+// backlogSize bytes of changes for replicas that reconnect
+func NewLeader(store *storage.Storage, backlogSize int64) *Leader {
+	id := make([]byte, 20)
+	_, _ = rand.Read(id)
+	return &Leader{
+		store:       store,
+		id:          hex.EncodeToString(id),
+		backlogSize: backlogSize,
+		followers:   make(map[*follower]struct{}),
+	}
+}
+
+// Record adds a change to the backlog, if there is one
+func (l *Leader) Record(c storage.Change) {
+	if b := l.backlog.Load(); b != nil {
+		b.Append(c)
+	}
+}
+
+// ID returns the leader's replication ID, which replicas send back to
+// resume their stream
+func (l *Leader) ID() string {
+	return l.id
+}
+
+// Offset returns the offset of the latest change replicas can be sent
+func (l *Leader) Offset() uint64 {
+	if b := l.backlog.Load(); b != nil {
+		return b.LastSeq()
+	}
+	return 0
+}
+
+// Backlog returns the backlog, or nil if no replica has synced yet
+func (l *Leader) Backlog() *changelog.Log {
+	return l.backlog.Load()
+}
+
+// SyncCounts returns the number of full and partial syncs served
+func (l *Leader) SyncCounts() (full, partial uint64) {
+	return l.fullSyncs.Load(), l.partialSyncs.Load()
+}
+
+// Replicas returns the connected replicas, ordered by address
+func (l *Leader) Replicas() []ReplicaInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	infos := make([]ReplicaInfo, 0, len(l.followers))
+	for f := range l.followers {
+		info := ReplicaInfo{Addr: f.addr, Port: f.port, State: "sync", Offset: f.offset.Load()}
+		if f.online.Load() {
+			info.State = "online"
+		}
+		if at := f.lastAck.Load(); at != 0 {
+			info.LastAck = time.Unix(0, at)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Addr != infos[j].Addr {
+			return infos[i].Addr < infos[j].Addr
+		}
+		return infos[i].Port < infos[j].Port
+	})
+	return infos
+}
+
+// Serve answers a replica's PSYNC request on conn and then streams changes
+// to it until the connection fails or ctx is cancelled. r and w must be the
+// connection's reader and writer; from now on the leader reads the
+// replica's acknowledgements from r. The caller closes conn afterwards.
+func (l *Leader) Serve(ctx context.Context, conn net.Conn, r *protocol.RESPReader, w *protocol.RESPWriter, req SyncRequest) error {
+	f := &follower{addr: conn.RemoteAddr().String(), port: req.ListeningPort}
+	if host, _, err := net.SplitHostPort(f.addr); err == nil {
+		f.addr = host
+	}
+	l.mu.Lock()
+	l.followers[f] = struct{}{}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.followers, f)
+		l.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		cancel(readAcks(conn, r, f))
+	}()
+
+	b, after, err := l.sync(conn, w, req)
+	if err != nil {
+		return err
+	}
+	f.online.Store(true)
+	return l.stream(ctx, conn, w, b, after)
+}
+
+// sync sends the reply to PSYNC, followed by a snapshot unless the replica
+// can continue from its offset. It returns the backlog and the offset to
+// stream from.
+func (l *Leader) sync(conn net.Conn, w *protocol.RESPWriter, req SyncRequest) (*changelog.Log, uint64, error) {
+	b := l.backlog.Load()
+	if b != nil && req.ID == l.id && req.Offset+1 >= b.FirstSeq() && req.Offset <= b.LastSeq() {
+		l.partialSyncs.Add(1)
+		_ = w.WriteSimpleString("CONTINUE " + l.id)
+		return b, req.Offset, nil
+	}
+
+	// With every shard locked no change can slip between the snapshot and
+	// the offset the stream continues from
+	var offset uint64
+	var snapshot []storage.Change
+	l.store.Atomically(func(tx *storage.Tx) {
+		if b = l.backlog.Load(); b == nil {
+			b = changelog.New(0, l.backlogSize)
+			l.backlog.Store(b)
+		}
+		offset = b.LastSeq()
+		snapshot = tx.Dump()
+	})
+	l.fullSyncs.Add(1)
+
+	_ = w.WriteSimpleString(fmt.Sprintf("FULLRESYNC %s %d", l.id, offset))
+	_ = w.WriteArray([]string{"SNAPSHOT", strconv.Itoa(len(snapshot))})
+	for i, c := range snapshot {
+		// The writer flushes as its buffer fills, so keep the deadline
+		// moving while a large snapshot is sent
+		if i%streamBatch == 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		writeChange(w, 0, c)
+	}
+	if err := flush(conn, w); err != nil {
+		return nil, 0, err
+	}
+	return b, offset, nil
+}
+
+// stream sends the changes after offset as they are made, pinging the
+// replica when there are none. It stops when ctx is done, returning its
+// cause.
+func (l *Leader) stream(ctx context.Context, conn net.Conn, w *protocol.RESPWriter, b *changelog.Log, after uint64) error {
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		records, err := b.Read(after, streamBatch)
+		if errors.Is(err, changelog.ErrTrimmed) {
+			return errors.New("replica fell behind the backlog")
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			if err := flush(conn, w); err != nil {
+				return err
+			}
+			wait, cancel := context.WithTimeout(ctx, heartbeatInterval)
+			err := b.Wait(wait, after)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				_ = w.WriteArray([]string{"PING", strconv.FormatUint(after, 10)})
+			}
+			continue
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+		for _, rec := range records {
+			writeChange(w, rec.Seq, rec.Change)
+		}
+		after = records[len(records)-1].Seq
+	}
+}
+
+// readAcks records the offsets the replica acknowledges until the
+// connection fails or the replica goes quiet, and returns why it stopped
+func readAcks(conn net.Conn, r *protocol.RESPReader, f *follower) error {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := r.ReadCommandBytes()
+		if err != nil {
+			return fmt.Errorf("reading from replica: %w", err)
+		}
+		if len(msg) == 3 && strings.EqualFold(string(msg[0]), "REPLCONF") && strings.EqualFold(string(msg[1]), "ACK") {
+			if offset, err := strconv.ParseUint(string(msg[2]), 10, 64); err == nil {
+				f.offset.Store(offset)
+				f.lastAck.Store(time.Now().UnixNano())
+			}
+		}
+	}
+}
+
+// flush flushes w, giving up if the peer doesn't read for too long
+func flush(conn net.Conn, w *protocol.RESPWriter) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	return w.Flush()
+}
@@ -0,0 +1,190 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+func init() {
+	heartbeatInterval = 20 * time.Millisecond
+	ackInterval = 20 * time.Millisecond
+	retryInterval = 20 * time.Millisecond
+}
+
+// testLeader serves a Leader on a loopback port, answering REPLCONF and
+// PSYNC the way vex-server does
+type testLeader struct {
+	*Leader
+	store *storage.Storage
+	ln    net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// startLeader starts a leader for a new storage
+func startLeader(t *testing.T, backlogSize int64) *testLeader {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.New()
+	l := &testLeader{Leader: NewLeader(store, backlogSize), store: store, ln: ln}
+	store.OnChange(l.Record)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		_ = ln.Close()
+		l.drop()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			l.mu.Lock()
+			l.conns = append(l.conns, conn)
+			l.mu.Unlock()
+			go l.serve(ctx, conn)
+		}
+	}()
+	return l
+}
+
+// serve handles one replica connection
+func (l *testLeader) serve(ctx context.Context, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := protocol.NewRESPReader(conn)
+	w := protocol.NewRESPWriter(conn)
+	var req SyncRequest
+	for {
+		msg, err := r.ReadCommandBytes()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(string(msg[0])) {
+		case "REPLCONF":
+			req.ListeningPort, _ = strconv.Atoi(string(msg[2]))
+			_ = w.WriteSimpleString("OK")
+			_ = w.Flush()
+		case "PSYNC":
+			req.ID = string(msg[1])
+			req.Offset, _ = strconv.ParseUint(string(msg[2]), 10, 64)
+			_ = l.Serve(ctx, conn, r, w, req)
+			return
+		}
+	}
+}
+
+// drop closes every replica connection
+func (l *testLeader) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+// port returns the port the leader listens on
+func (l *testLeader) port() string {
+	return strconv.Itoa(l.ln.Addr().(*net.TCPAddr).Port)
+}
+
+// psync sends PSYNC to the leader on a new connection and returns the reply
+func (l *testLeader) psync(t *testing.T, id string, offset uint64) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	w := protocol.NewRESPWriter(conn)
+	_ = w.WriteArray([]string{"PSYNC", id, strconv.FormatUint(offset, 10)})
+	_ = w.Flush()
+	reply, err := protocol.NewRESPReader(conn).ReadCommandBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply[0])
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLeaderSync(t *testing.T) {
+	l := startLeader(t, 1<<20)
+	_, _ = l.store.Set("a", []float32{1, 0})
+
+	if l.Backlog() != nil {
+		t.Error("backlog created before any replica synced")
+	}
+	if reply := l.psync(t, "?", 0); reply != "FULLRESYNC "+l.ID()+" 0" {
+		t.Errorf("PSYNC ? 0 = %q, want a full resync at offset 0", reply)
+	}
+	if l.Backlog() == nil {
+		t.Fatal("no backlog after a full sync")
+	}
+
+	_, _ = l.store.Set("b", []float32{1, 0})
+	_, _ = l.store.Set("c", []float32{1, 0})
+	if reply := l.psync(t, l.ID(), 1); reply != "CONTINUE "+l.ID() {
+		t.Errorf("PSYNC id 1 = %q, want CONTINUE", reply)
+	}
+	if reply := l.psync(t, "other", 1); !strings.HasPrefix(reply, "FULLRESYNC") {
+		t.Errorf("PSYNC with another ID = %q, want a full resync", reply)
+	}
+	if full, partial := l.SyncCounts(); full != 2 || partial != 1 {
+		t.Errorf("SyncCounts() = %d, %d, want 2, 1", full, partial)
+	}
+}
+
+func TestLeaderBacklogTrimmed(t *testing.T) {
+	l := startLeader(t, 1)
+	l.psync(t, "?", 0)
+	for i := 0; i < 5; i++ {
+		_, _ = l.store.Set("k", []float32{1, 0})
+	}
+
+	// Only the newest change is kept, so offset 3 can't be continued from
+	if reply := l.psync(t, l.ID(), 3); reply != "FULLRESYNC "+l.ID()+" 5" {
+		t.Errorf("PSYNC id 3 = %q, want a full resync at offset 5", reply)
+	}
+	if reply := l.psync(t, l.ID(), 4); reply != "CONTINUE "+l.ID() {
+		t.Errorf("PSYNC id 4 = %q, want CONTINUE", reply)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/pkg/logger"
+)
+
+// Replica states, as reported by Status
+const (
+	StateConnecting = "connecting" // Connecting to the leader, or waiting to retry
+	StateSync       = "sync"       // Receiving a snapshot
+	StateConnected  = "connected"  // Streaming changes
+)
+
+// Config configures a replica
+type Config struct {
+	// Host and Port are the leader's address
+	Host string
+	Port string
+
+	// User and Password authenticate to the leader if Password is set.
+	// An empty User authenticates as the default user.
+	User     string
+	Password string
+
+	// ListeningPort is reported to the leader as the port this server
+	// serves clients on
+	ListeningPort int
+
+	Logger *logger.Logger
+}
+
+// Status describes a replica's link to its leader
+type Status struct {
+	Host  string
+	Port  string
+	State string
+
+	// LeaderID is the ID of the leader whose stream Offset refers to
+	LeaderID string
+	// Offset is the offset of the last change applied
+	Offset uint64
+	// LeaderOffset is the latest offset the leader reported
+	LeaderOffset uint64
+	// LastIO is when the leader was last heard from, zero if never
+	LastIO time.Time
+}
+
+// target is what changes are applied to: the storage, or a transaction
+// view while a snapshot is loaded
+type target interface {
+	SetWithTTL(key string, values []float32, ttl time.Duration) (bool, error)
+	Delete(key string) bool
+	Expire(key string, ttl time.Duration) bool
+	Persist(key string) bool
+	Clear()
+}
+
+// Replica keeps a storage in sync with a leader, reconnecting whenever the
+// link fails, until it is stopped
+type Replica struct {
+	store  *storage.Storage
+	cfg    Config
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Start starts replicating the leader in cfg into store. Writes to store
+// other than the replica's should be refused while it runs.
+func Start(store *storage.Storage, cfg Config) *Replica {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Replica{
+		store:  store,
+		cfg:    cfg,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: Status{Host: cfg.Host, Port: cfg.Port, State: StateConnecting},
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Stop disconnects from the leader and waits for the replica to stop. The
+// data already replicated is kept.
+func (r *Replica) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// Status returns the state of the link to the leader
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// update changes the status under the lock
+func (r *Replica) update(fn func(s *Status)) {
+	r.mu.Lock()
+	fn(&r.status)
+	r.mu.Unlock()
+}
+
+// run syncs with the leader until ctx is cancelled, retrying after errors
+func (r *Replica) run(ctx context.Context) {
+	defer close(r.done)
+	addr := net.JoinHostPort(r.cfg.Host, r.cfg.Port)
+	for {
+		err := r.sync(ctx, addr)
+		r.update(func(s *Status) { s.State = StateConnecting })
+		if ctx.Err() != nil {
+			return
+		}
+		r.cfg.Logger.Warn("replication link down", slog.String("leader", addr), slog.String("error", err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// sync connects to the leader, resumes or reloads the data and applies the
+// streamed changes until the connection fails or ctx is cancelled
+func (r *Replica) sync(ctx context.Context, addr string) error {
+	conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	reader := protocol.NewRESPReader(conn)
+	writer := protocol.NewRESPWriter(conn)
+	call := func(args ...string) ([][]byte, error) {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		_ = writer.WriteArray(args)
+		if err := writer.Flush(); err != nil {
+			return nil, err
+		}
+		return reader.ReadCommandBytes()
+	}
+
+	if r.cfg.Password != "" {
+		args := []string{"AUTH", r.cfg.Password}
+		if r.cfg.User != "" {
+			args = []string{"AUTH", r.cfg.User, r.cfg.Password}
+		}
+		if _, err := call(args...); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if _, err := call("REPLCONF", "listening-port", strconv.Itoa(r.cfg.ListeningPort)); err != nil {
+		return fmt.Errorf("sending REPLCONF: %w", err)
+	}
+
+	status := r.Status()
+	id := status.LeaderID
+	if id == "" {
+		id = "?"
+	}
+	reply, err := call("PSYNC", id, strconv.FormatUint(status.Offset, 10))
+	if err != nil {
+		return fmt.Errorf("sending PSYNC: %w", err)
+	}
+	fields := strings.Fields(string(reply[0]))
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errProtocol, reply[0])
+		}
+		r.update(func(s *Status) { s.State = StateSync })
+		if err := r.load(conn, reader); err != nil {
+			return fmt.Errorf("loading snapshot: %w", err)
+		}
+		r.update(func(s *Status) { s.LeaderID, s.Offset, s.LeaderOffset = fields[1], offset, offset })
+		r.cfg.Logger.Info("full sync with leader complete", slog.String("leader", addr), slog.Uint64("offset", offset))
+	case len(fields) == 2 && fields[0] == "CONTINUE" && fields[1] == status.LeaderID:
+		r.cfg.Logger.Info("resumed sync with leader", slog.String("leader", addr), slog.Uint64("offset", status.Offset))
+	default:
+		return fmt.Errorf("%w: unexpected PSYNC reply %q", errProtocol, reply[0])
+	}
+	r.update(func(s *Status) { s.State, s.LastIO = StateConnected, time.Now() })
+
+	// Acknowledge the offset applied until the stream ends
+	acks, stopAcks := context.WithCancel(ctx)
+	defer stopAcks()
+	go r.ack(acks, conn)
+
+	return r.apply(conn, reader)
+}
+
+// load reads a snapshot and replaces the stored data with it. The whole
+// snapshot is read before it is applied, in one step, so clients never
+// see it half loaded.
+func (r *Replica) load(conn net.Conn, reader *protocol.RESPReader) error {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	header, err := reader.ReadCommandBytes()
+	if err != nil {
+		return err
+	}
+	if len(header) != 2 || string(header[0]) != "SNAPSHOT" {
+		return fmt.Errorf("%w: expected SNAPSHOT", errProtocol)
+	}
+	n, err := strconv.Atoi(string(header[1]))
+	if err != nil || n < 0 {
+		return fmt.Errorf("%w: invalid snapshot size %q", errProtocol, header[1])
+	}
+
+	snapshot := make([]storage.Change, n)
+	for i := range snapshot {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := reader.ReadCommandBytes()
+		if err != nil {
+			return err
+		}
+		if len(msg) == 0 || string(msg[0]) != "CHANGE" {
+			return fmt.Errorf("%w: expected CHANGE", errProtocol)
+		}
+		if _, snapshot[i], err = parseChange(msg); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	r.store.Atomically(func(tx *storage.Tx) {
+		tx.Clear()
+		for _, c := range snapshot {
+			if err := applyChange(tx, c); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// apply applies the streamed changes until the connection fails
+func (r *Replica) apply(conn net.Conn, reader *protocol.RESPReader) error {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := reader.ReadCommandBytes()
+		if err != nil {
+			return err
+		}
+		if len(msg) == 0 {
+			return fmt.Errorf("%w: empty message", errProtocol)
+		}
+
+		switch string(msg[0]) {
+		case "CHANGE":
+			seq, c, err := parseChange(msg)
+			if err != nil {
+				return err
+			}
+			if err := applyChange(r.store, c); err != nil {
+				return fmt.Errorf("applying change %d: %w", seq, err)
+			}
+			r.update(func(s *Status) {
+				s.Offset, s.LeaderOffset, s.LastIO = seq, max(s.LeaderOffset, seq), time.Now()
+			})
+		case "PING":
+			if len(msg) != 2 {
+				return fmt.Errorf("%w: PING has %d fields", errProtocol, len(msg))
+			}
+			offset, err := strconv.ParseUint(string(msg[1]), 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errProtocol, err)
+			}
+			r.update(func(s *Status) { s.LeaderOffset, s.LastIO = offset, time.Now() })
+		default:
+			return fmt.Errorf("%w: unexpected message %q", errProtocol, msg[0])
+		}
+	}
+}
+
+// ack sends the applied offset to the leader every ackInterval until ctx
+// is done. It is the only writer once the stream has started.
+func (r *Replica) ack(ctx context.Context, conn net.Conn) {
+	writer := protocol.NewRESPWriter(conn)
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			offset := r.Status().Offset
+			_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+			_ = writer.WriteArray([]string{"REPLCONF", "ACK", strconv.FormatUint(offset, 10)})
+			if err := writer.Flush(); err != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// applyChange applies a change made on the leader to t. Expiry times are
+// absolute, so a key whose TTL has already passed here is deleted.
+func applyChange(t target, c storage.Change) error {
+	switch c.Op {
+	case storage.OpSet:
+		var ttl time.Duration
+		if c.ExpiresAt != 0 {
+			if ttl = time.Until(time.Unix(0, c.ExpiresAt)); ttl <= 0 {
+				t.Delete(c.Key)
+				return nil
+			}
+		}
+		_, err := t.SetWithTTL(c.Key, c.Vector, ttl)
+		return err
+	case storage.OpExpire:
+		if c.ExpiresAt == 0 {
+			t.Persist(c.Key)
+		} else {
+			// A TTL that already passed deletes the key
+			t.Expire(c.Key, time.Until(time.Unix(0, c.ExpiresAt)))
+		}
+	case storage.OpDelete, storage.OpExpired, storage.OpEvicted:
+		t.Delete(c.Key)
+	case storage.OpClear:
+		t.Clear()
+	default:
+		return fmt.Errorf("%w: unknown operation %v", errProtocol, c.Op)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+	"github.com/uzqw/vex/pkg/logger"
+)
+
+// startReplica replicates l into a new storage
+func startReplica(t *testing.T, l *testLeader) (*Replica, *storage.Storage) {
+	t.Helper()
+	store := storage.New()
+	r := Start(store, Config{
+		Host:          "127.0.0.1",
+		Port:          l.port(),
+		ListeningPort: 7000,
+		Logger:        logger.New(logger.Config{Level: slog.LevelError}),
+	})
+	t.Cleanup(r.Stop)
+	return r, store
+}
+
+// caughtUp reports whether r has applied every change l has made
+func caughtUp(r *Replica, l *testLeader) bool {
+	s := r.Status()
+	return s.State == StateConnected && s.Offset == l.Offset()
+}
+
+// assertSameData fails unless both storages hold the same vectors and TTLs
+func assertSameData(t *testing.T, want, got *storage.Storage) {
+	t.Helper()
+	dump := func(s *storage.Storage) map[string]storage.Change {
+		m := make(map[string]storage.Change)
+		s.Atomically(func(tx *storage.Tx) {
+			for _, c := range tx.Dump() {
+				m[c.Key] = c
+			}
+		})
+		return m
+	}
+	w, g := dump(want), dump(got)
+	if len(w) != len(g) {
+		t.Fatalf("replica has %d keys, want %d", len(g), len(w))
+	}
+	for key, wc := range w {
+		gc, ok := g[key]
+		if !ok {
+			t.Errorf("replica is missing %q", key)
+			continue
+		}
+		for i := range wc.Vector {
+			if math.Abs(float64(wc.Vector[i]-gc.Vector[i])) > 1e-6 {
+				t.Errorf("replica %q = %v, want %v", key, gc.Vector, wc.Vector)
+				break
+			}
+		}
+		// The replica sets TTLs relative to its own clock
+		if (wc.ExpiresAt == 0) != (gc.ExpiresAt == 0) || math.Abs(float64(wc.ExpiresAt-gc.ExpiresAt)) > float64(time.Second) {
+			t.Errorf("replica %q expires at %d, want %d", key, gc.ExpiresAt, wc.ExpiresAt)
+		}
+	}
+}
+
+func TestReplicaSync(t *testing.T) {
+	l := startLeader(t, 1<<20)
+	_, _ = l.store.Set("a", []float32{3, 4})
+	_, _ = l.store.SetWithTTL("b", []float32{1, 0}, time.Hour)
+
+	r, replica := startReplica(t, l)
+	waitFor(t, "full sync", func() bool { return caughtUp(r, l) })
+	assertSameData(t, l.store, replica)
+
+	t.Run("stream", func(t *testing.T) {
+		_, _ = l.store.Set("c", []float32{0, 1})
+		_, _ = l.store.Set("a", []float32{1, 1})
+		l.store.Expire("c", time.Hour)
+		l.store.Persist("b")
+		_ = l.store.Rename("a", "d")
+		l.store.Delete("c")
+		_, _ = l.store.SetMany([]string{"e", "f"}, [][]float32{{1, 2}, {2, 1}})
+		waitFor(t, "changes", func() bool { return caughtUp(r, l) })
+		assertSameData(t, l.store, replica)
+
+		results, err := replica.Search([]float32{1, 1}, 1)
+		if err != nil || len(results) != 1 || results[0].Key != "d" {
+			t.Errorf("replica Search() = %v, %v, want d", results, err)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		l.store.Clear()
+		_, _ = l.store.Set("g", []float32{1, 0, 0})
+		waitFor(t, "clear", func() bool { return caughtUp(r, l) })
+		assertSameData(t, l.store, replica)
+	})
+
+	t.Run("acknowledged", func(t *testing.T) {
+		waitFor(t, "ack", func() bool {
+			infos := l.Replicas()
+			return len(infos) == 1 && infos[0].Offset == l.Offset()
+		})
+		info := l.Replicas()[0]
+		if info.Addr != "127.0.0.1" || info.Port != 7000 || info.State != "online" || info.LastAck.IsZero() {
+			t.Errorf("Replicas() = %+v", info)
+		}
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		before := r.Status().LastIO
+		waitFor(t, "ping", func() bool { return r.Status().LastIO.After(before) })
+		if s := r.Status(); s.LeaderOffset != l.Offset() || s.LeaderID != l.ID() {
+			t.Errorf("Status() = %+v", s)
+		}
+	})
+}
+
+func TestReplicaResume(t *testing.T) {
+	l := startLeader(t, 1<<20)
+	_, _ = l.store.Set("a", []float32{1, 0})
+	r, replica := startReplica(t, l)
+	waitFor(t, "full sync", func() bool { return caughtUp(r, l) })
+
+	l.drop()
+	_, _ = l.store.Set("b", []float32{0, 1})
+	l.store.Delete("a")
+	waitFor(t, "resume", func() bool { return caughtUp(r, l) })
+	assertSameData(t, l.store, replica)
+
+	if full, partial := l.SyncCounts(); full != 1 || partial != 1 {
+		t.Errorf("SyncCounts() = %d, %d, want 1 full and 1 partial", full, partial)
+	}
+}
+
+func TestReplicaStop(t *testing.T) {
+	l := startLeader(t, 1<<20)
+	_, _ = l.store.Set("a", []float32{1, 0})
+	r, replica := startReplica(t, l)
+	waitFor(t, "full sync", func() bool { return caughtUp(r, l) })
+
+	r.Stop()
+	if s := r.Status(); s.State != StateConnecting {
+		t.Errorf("State = %q after Stop(), want %q", s.State, StateConnecting)
+	}
+	_, _ = l.store.Set("b", []float32{1, 0})
+	time.Sleep(50 * time.Millisecond)
+	if replica.Exists("b") || !replica.Exists("a") {
+		t.Error("replica changed after Stop(), or lost its data")
+	}
+	waitFor(t, "leader to drop the replica", func() bool { return len(l.Replicas()) == 0 })
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication copies a storage to replicas. A replica connects to
+// its leader and sends PSYNC with the leader ID and offset it last saw. The
+// leader either continues from that offset, if its backlog still holds the
+// changes after it, or sends a snapshot of every key followed by the
+// changes made since. Changes then stream as they happen, and the replica
+// acknowledges its offset every second.
+//
+// Everything is sent as RESP arrays of bulk strings:
+//
+//	+FULLRESYNC <id> <offset>      reply to PSYNC before a snapshot
+//	+CONTINUE <id>                 reply to PSYNC resuming the stream
+//	SNAPSHOT <count>               followed by count CHANGE entries with seq 0
+//	CHANGE <seq> <op> <key> <vector> <expires-at>
+//	PING <offset>                  sent by the leader when idle
+//	REPLCONF ACK <offset>          sent by the replica every second
+//
+// Vectors are sent as little-endian float32s and expiry times as Unix
+// nanoseconds, 0 for none. Offsets are sequence numbers in the leader's
+// backlog, so they count changes rather than bytes.
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// These are variables so tests can shorten them
+var (
+	// heartbeatInterval is how often an idle leader pings its replicas
+	heartbeatInterval = time.Second
+
+	// ackInterval is how often a replica acknowledges its offset
+	ackInterval = time.Second
+
+	// retryInterval is how long a replica waits before reconnecting
+	retryInterval = time.Second
+
+	// timeout is how long either side waits on a silent or blocked peer
+	timeout = 30 * time.Second
+)
+
+// errProtocol is returned for a message that doesn't follow the protocol
+var errProtocol = errors.New("replication protocol error")
+
+// writeChange writes a CHANGE message
+func writeChange(w *protocol.RESPWriter, seq uint64, c storage.Change) {
+	_ = w.WriteArrayHeader(6)
+	_ = w.WriteBulkString("CHANGE")
+	_ = w.WriteBulkString(strconv.FormatUint(seq, 10))
+	_ = w.WriteBulkString(strconv.Itoa(int(c.Op)))
+	_ = w.WriteBulkString(c.Key)
+	_ = w.WriteBulkBytes(encodeVector(c.Vector))
+	_ = w.WriteBulkString(strconv.FormatInt(c.ExpiresAt, 10))
+}
+
+// parseChange parses the arguments of a CHANGE message
+func parseChange(msg [][]byte) (uint64, storage.Change, error) {
+	if len(msg) != 6 {
+		return 0, storage.Change{}, fmt.Errorf("%w: CHANGE has %d fields", errProtocol, len(msg))
+	}
+	seq, err1 := strconv.ParseUint(string(msg[1]), 10, 64)
+	op, err2 := strconv.ParseUint(string(msg[2]), 10, 8)
+	at, err3 := strconv.ParseInt(string(msg[5]), 10, 64)
+	vec, err4 := decodeVector(msg[4])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return 0, storage.Change{}, fmt.Errorf("%w: %v", errProtocol, err)
+	}
+	return seq, storage.Change{Op: storage.Op(op), Key: string(msg[3]), Vector: vec, ExpiresAt: at}, nil
+}
+
+// encodeVector encodes a vector as little-endian float32s
+func encodeVector(vec []float32) []byte {
+	b := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return b
+}
+
+// decodeVector decodes a vector encoded by encodeVector, returning nil for
+// an empty one
+func decodeVector(b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("vector of %d bytes", len(b))
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	vec := make([]float32, len(b)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return vec, nil
+}
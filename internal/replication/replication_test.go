@@ -0,0 +1,68 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/uzqw/vex/internal/protocol"
+	"github.com/uzqw/vex/internal/storage"
+)
+
+func TestChangeRoundTrip(t *testing.T) {
+	changes := []storage.Change{
+		{Op: storage.OpSet, Key: "doc:1", Vector: []float32{0.6, -0.8}, ExpiresAt: 1735689600000000000},
+		{Op: storage.OpDelete, Key: "doc:2"},
+		{Op: storage.OpClear},
+	}
+
+	var buf bytes.Buffer
+	w := protocol.NewRESPWriter(&buf)
+	for i, c := range changes {
+		writeChange(w, uint64(i+1), c)
+	}
+	_ = w.Flush()
+
+	r := protocol.NewRESPReader(&buf)
+	for i, want := range changes {
+		msg, err := r.ReadCommandBytes()
+		if err != nil {
+			t.Fatalf("ReadCommandBytes() error = %v", err)
+		}
+		seq, got, err := parseChange(msg)
+		if err != nil {
+			t.Fatalf("parseChange() error = %v", err)
+		}
+		if seq != uint64(i+1) || got.Op != want.Op || got.Key != want.Key ||
+			!slices.Equal(got.Vector, want.Vector) || got.ExpiresAt != want.ExpiresAt {
+			t.Errorf("change %d = %d %+v, want %+v", i, seq, got, want)
+		}
+	}
+}
+
+func TestParseChangeInvalid(t *testing.T) {
+	for _, msg := range [][][]byte{
+		{[]byte("CHANGE"), []byte("1")},
+		{[]byte("CHANGE"), []byte("x"), []byte("1"), []byte("k"), nil, []byte("0")},
+		{[]byte("CHANGE"), []byte("1"), []byte("1"), []byte("k"), []byte("abc"), []byte("0")},
+	} {
+		if _, _, err := parseChange(msg); !errors.Is(err, errProtocol) {
+			t.Errorf("parseChange(%q) error = %v, want errProtocol", msg, err)
+		}
+	}
+}
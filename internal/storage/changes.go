@@ -0,0 +1,82 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "fmt"
+
+// Op is the kind of change made to the stored vectors
+type Op uint8
+
+const (
+	// OpSet stores a vector under a key, new or replacing another
+	OpSet Op = iota + 1
+	// OpDelete removes a key, including the source of a rename
+	OpDelete
+	// OpExpired removes a key whose TTL passed
+	OpExpired
+	// OpEvicted removes a key to stay under the memory limit
+	OpEvicted
+	// OpClear removes every key
+	OpClear
+	// OpExpire sets or, with ExpiresAt 0, removes a key's TTL
+	OpExpire
+)
+
+var opNames = [...]string{
+	OpSet:     "set",
+	OpDelete:  "del",
+	OpExpired: "expired",
+	OpEvicted: "evicted",
+	OpClear:   "clear",
+	OpExpire:  "expire",
+}
+
+// String returns the name of the operation, such as "set"
+func (op Op) String() string {
+	if op == 0 || int(op) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", uint8(op))
+	}
+	return opNames[op]
+}
+
+// Change describes a single change to the stored vectors
+type Change struct {
+	Op  Op
+	Key string // Empty for OpClear
+
+	// Vector is the normalized vector stored by OpSet, nil otherwise. It
+	// is shared with the storage and must not be modified.
+	Vector []float32
+
+	// ExpiresAt is when the key expires for OpSet and OpExpire, in Unix
+	// nanoseconds, or 0 if it has no TTL
+	ExpiresAt int64
+}
+
+// OnChange registers fn to be called for every change to the stored
+// vectors and their TTLs, whatever caused it. fn runs with the affected
+// shard locked, so calls for the same key are made in the order the changes
+// were applied. Like OnExpire, it must be quick, must not call back into
+// Storage and should be set before the storage is shared between goroutines.
+func (s *Storage) OnChange(fn func(Change)) {
+	s.onChange = fn
+}
+
+// changed reports a change to the OnChange hook, if any
+func (s *Storage) changed(op Op, key string, vec []float32, expiresAt int64) {
+	if s.onChange != nil {
+		s.onChange(Change{Op: op, Key: key, Vector: vec, ExpiresAt: expiresAt})
+	}
+}
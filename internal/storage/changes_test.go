@@ -0,0 +1,116 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOnChange(t *testing.T) {
+	s, clock := newTestStorage()
+	var changes []Change
+	s.OnChange(func(c Change) { changes = append(changes, c) })
+
+	vec := []float32{1, 0, 0}
+	_, _ = s.Set("a", vec)
+	_, _ = s.SetWithTTL("b", vec, time.Second)
+	s.Expire("a", time.Minute)
+	s.Persist("a")
+	_ = s.Rename("a", "c")
+	_, _ = s.Copy("c", "d", false)
+	s.Delete("d")
+	s.Expire("c", 0)
+	clock.Add(int64(time.Second))
+	_, _ = s.Get("b")
+	_, _ = s.SetMany([]string{"e"}, [][]float32{vec})
+	s.Clear()
+
+	want := []struct {
+		op  Op
+		key string
+	}{
+		{OpSet, "a"},
+		{OpSet, "b"},
+		{OpExpire, "a"},
+		{OpExpire, "a"},
+		{OpDelete, "a"},
+		{OpSet, "c"},
+		{OpSet, "d"},
+		{OpDelete, "d"},
+		{OpDelete, "c"},
+		{OpExpired, "b"},
+		{OpSet, "e"},
+		{OpClear, ""},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes %v, want %d", len(changes), changes, len(want))
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Op != w.op || c.Key != w.key {
+			t.Errorf("change %d = %v %q, want %v %q", i, c.Op, c.Key, w.op, w.key)
+		}
+		if (c.Op == OpSet) != (c.Vector != nil) {
+			t.Errorf("change %d: %v with vector %v", i, c.Op, c.Vector)
+		}
+	}
+	if !slices.Equal(changes[0].Vector, vec) {
+		t.Errorf("set vector = %v, want %v", changes[0].Vector, vec)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	if changes[0].ExpiresAt != 0 || changes[1].ExpiresAt != start+int64(time.Second) {
+		t.Errorf("set ExpiresAt = %d, %d, want 0, %d", changes[0].ExpiresAt, changes[1].ExpiresAt, start+int64(time.Second))
+	}
+	if changes[2].ExpiresAt != start+int64(time.Minute) || changes[3].ExpiresAt != 0 {
+		t.Errorf("expire ExpiresAt = %d, %d, want %d, 0", changes[2].ExpiresAt, changes[3].ExpiresAt, start+int64(time.Minute))
+	}
+
+	t.Run("failed writes are not reported", func(t *testing.T) {
+		changes = nil
+		_, _ = s.Set("k", []float32{1, 0})
+		_, _ = s.Set("bad", []float32{1, 0, 0})
+		_, _ = s.SetWithOptions("k", []float32{0, 1}, SetOptions{NX: true})
+		s.Delete("missing")
+		if len(changes) != 1 {
+			t.Errorf("got changes %v, want only the first set", changes)
+		}
+	})
+
+	t.Run("evictions", func(t *testing.T) {
+		changes = nil
+		s.Clear()
+		s.SetMaxMemory(entrySize("k0", 2)*2, AllKeysLRU)
+		for _, key := range []string{"k0", "k1", "k2"} {
+			_, _ = s.Set(key, []float32{1, 0})
+		}
+		evicted := 0
+		for _, c := range changes {
+			if c.Op == OpEvicted {
+				evicted++
+			}
+		}
+		if evicted == 0 {
+			t.Errorf("no eviction reported in %v", changes)
+		}
+	})
+}
+
+func TestOpString(t *testing.T) {
+	if OpExpired.String() != "expired" || Op(0).String() != "Op(0)" {
+		t.Errorf("String() = %q, %q", OpExpired.String(), Op(0).String())
+	}
+}
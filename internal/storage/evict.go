@@ -0,0 +1,384 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// EvictionPolicy selects which keys are removed when the memory limit is reached
+type EvictionPolicy int32
+
+const (
+	// NoEviction rejects writes that would exceed the limit
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU evicts the least recently used keys
+	AllKeysLRU
+	// AllKeysLFU evicts the least frequently used keys
+	AllKeysLFU
+	// VolatileTTL evicts the keys with a TTL that expire soonest
+	VolatileTTL
+	// AllKeysRandom evicts random keys
+	AllKeysRandom
+)
+
+var policyNames = [...]string{
+	NoEviction:    "noeviction",
+	AllKeysLRU:    "allkeys-lru",
+	AllKeysLFU:    "allkeys-lfu",
+	VolatileTTL:   "volatile-ttl",
+	AllKeysRandom: "allkeys-random",
+}
+
+// String returns the policy name as used in configuration
+func (p EvictionPolicy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("EvictionPolicy(%d)", int32(p))
+	}
+	return policyNames[p]
+}
+
+// ParseEvictionPolicy parses a policy name such as "allkeys-lru"
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	for p, n := range policyNames {
+		if n == name {
+			return EvictionPolicy(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+// ErrOutOfMemory is returned by writes that would exceed the memory limit
+// when nothing can be evicted to make room
+var ErrOutOfMemory = errors.New("command not allowed when used memory > 'maxmemory'")
+
+const (
+	// entryOverhead approximates the bytes an entry needs beyond its key and
+	// vector data: the map slot, the entry struct and allocator rounding
+	entryOverhead = 96
+
+	// evictionSamples is the number of keys sampled per shard when choosing
+	// what to evict, like Redis' maxmemory-samples
+	evictionSamples = 5
+
+	// evictionShards is the number of non-empty shards sampled per eviction
+	evictionShards = 4
+
+	// lfuInitVal is the frequency given to new keys so they aren't evicted
+	// before they have had a chance to be accessed
+	lfuInitVal = 5
+
+	// lfuLogFactor controls how many accesses it takes to saturate the
+	// frequency counter; with 10, about a million
+	lfuLogFactor = 10
+
+	// lfuDecayTime is how long a key must go unaccessed for its frequency
+	// counter to drop by one
+	lfuDecayTime = time.Minute
+)
+
+// entrySize estimates the memory held by a stored vector
+func entrySize(key string, dim int) int64 {
+	return int64(len(key) + 4*dim + entryOverhead)
+}
+
+// touch records an access for the LRU and LFU policies. Concurrent readers
+// may race on the counter, which only makes it slightly less precise.
+func (e *entry) touch(now int64) {
+	freq := e.frequency(now)
+	if freq < math.MaxUint8 {
+		base := max(int(freq)-lfuInitVal, 0)
+		if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+	e.freq.Store(freq)
+	e.access.Store(now)
+}
+
+// frequency returns the LFU counter, decayed for the time since the last access
+func (e *entry) frequency(now int64) uint32 {
+	freq := e.freq.Load()
+	decay := uint32((now - e.access.Load()) / int64(lfuDecayTime))
+	if decay >= freq {
+		return 0
+	}
+	return freq - decay
+}
+
+// SetMaxMemory limits the memory held by stored vectors, as estimated by
+// MemoryUsage. Writes that would exceed the limit evict keys according to
+// policy. A limit of 0 disables it.
+func (s *Storage) SetMaxMemory(bytes int64, policy EvictionPolicy) {
+	s.policy.Store(int32(policy))
+	s.maxMemory.Store(bytes)
+}
+
+// MaxMemory returns the memory limit and eviction policy
+func (s *Storage) MaxMemory() (int64, EvictionPolicy) {
+	return s.maxMemory.Load(), EvictionPolicy(s.policy.Load())
+}
+
+// MemoryUsage returns the estimated bytes held by stored vectors, summed
+// from per-shard accounting. It does not include Go runtime overhead.
+func (s *Storage) MemoryUsage() int64 {
+	var used int64
+	for i := 0; i < len(s.shards); i++ {
+		used += s.shards[i].used.Load()
+	}
+	return used
+}
+
+// OnEvict registers fn to be called for each key removed to stay under the
+// memory limit. Like OnExpire, fn runs with the shard lock held and should
+// be set before the storage is shared between goroutines.
+func (s *Storage) OnEvict(fn func(key string)) {
+	s.onEvict = fn
+}
+
+// reserve evicts keys until size more bytes fit under the memory limit.
+// Writes that replace keys pass the change in size, from growth, so that a
+// write that does not grow the data never evicts or fails. Either the caller
+// holds no shard lock, or locked is set and it holds all of them. A passive
+// storage never evicts, leaving it to the caller through Victims and Evict.
+func (s *Storage) reserve(size int64, locked bool) error {
+	limit := s.maxMemory.Load()
+	if limit <= 0 || size <= 0 || s.passive.Load() {
+		return nil
+	}
+
+	for s.MemoryUsage()+size > limit {
+		policy := EvictionPolicy(s.policy.Load())
+		if policy == NoEviction || !s.evictOne(policy, locked) {
+			return ErrOutOfMemory
+		}
+	}
+	return nil
+}
+
+// growth returns how many bytes storing entries adds to MemoryUsage, net of
+// the entries they replace. Shard locks are taken as needed unless locked is
+// set.
+func (s *Storage) growth(locked bool, entries ...*entry) int64 {
+	var size int64
+	stored := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		old, ok := stored[e.key]
+		if !ok {
+			old = s.sizeOf(e.key, locked)
+		}
+		size += e.size - old
+		stored[e.key] = e.size
+	}
+	return size
+}
+
+// Growth returns how many bytes writing vectors of the current dimension
+// under keys adds to MemoryUsage, net of the vectors they replace
+func (s *Storage) Growth(keys ...string) int64 {
+	dim := int(s.dim.Load())
+	if dim == 0 {
+		return 0
+	}
+	entries := make([]*entry, len(keys))
+	for i, key := range keys {
+		entries[i] = &entry{key: key, size: entrySize(key, dim)}
+	}
+	return s.growth(false, entries...)
+}
+
+// sizeOf returns the bytes accounted to key, or 0 if it is not stored
+func (s *Storage) sizeOf(key string, locked bool) int64 {
+	sh := s.getShard(key)
+	if !locked {
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+	}
+	if e, ok := sh.data[key]; ok {
+		return e.size
+	}
+	return 0
+}
+
+// evictOne removes the best candidate for eviction among keys sampled from
+// a few shards. Like Redis, it approximates the policy rather than keeping
+// the keys ordered. It returns false if there was nothing to evict. Shard
+// locks are taken as needed unless locked is set.
+func (s *Storage) evictOne(policy EvictionPolicy, locked bool) bool {
+	best, bestKey, bestEntry, _ := s.evictionVictim(policy, locked, nil)
+	if best == nil {
+		return false
+	}
+
+	if !locked {
+		best.mu.Lock()
+		defer best.mu.Unlock()
+	}
+
+	// The key may have been replaced or removed since it was sampled; either
+	// way memory has changed, so the caller re-checks the limit
+	if best.data[bestKey] == bestEntry {
+		s.evict(best, bestKey)
+	}
+	return true
+}
+
+// evict removes key and reports it as evicted. The shard write lock must be
+// held.
+func (s *Storage) evict(sh *shard, key string) {
+	sh.remove(key)
+	s.changed(OpEvicted, key, nil, 0)
+	if s.onEvict != nil {
+		s.onEvict(key)
+	}
+}
+
+// Victims returns the keys to evict, with their versions, for size more
+// bytes to fit under the memory limit, chosen by the eviction policy as a
+// write would but without removing them. It returns ErrOutOfMemory if the
+// policy is NoEviction or there is not enough to evict.
+func (s *Storage) Victims(size int64) ([]KeyVersion, error) {
+	limit := s.maxMemory.Load()
+	if limit <= 0 || size <= 0 {
+		return nil, nil
+	}
+	excess := s.MemoryUsage() + size - limit
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	policy := EvictionPolicy(s.policy.Load())
+	if policy == NoEviction {
+		return nil, ErrOutOfMemory
+	}
+	var victims []KeyVersion
+	chosen := make(map[string]bool)
+	for excess > 0 {
+		sh, key, e, version := s.evictionVictim(policy, false, chosen)
+		if sh == nil {
+			return nil, ErrOutOfMemory
+		}
+		chosen[key] = true
+		victims = append(victims, KeyVersion{Key: key, Version: version})
+		excess -= e.size
+	}
+	return victims, nil
+}
+
+// Evict removes key as evicted if it still has the given version, as
+// returned by Victims
+func (s *Storage) Evict(key string, version uint64) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if e, ok := shard.data[key]; !ok || e.version != version {
+		return false
+	}
+	s.evict(shard, key)
+	return true
+}
+
+// evictionVictim returns the best candidate for eviction among keys sampled
+// from a few shards, leaving out those in skip, along with its version at
+// the time, or a nil shard if there is none. Shard locks are taken as needed
+// unless locked is set.
+func (s *Storage) evictionVictim(policy EvictionPolicy, locked bool, skip map[string]bool) (*shard, string, *entry, uint64) {
+	var (
+		best        *shard
+		bestKey     string
+		bestEntry   *entry
+		bestVersion uint64
+		bestScore   int64 = math.MinInt64
+	)
+
+	now := s.now()
+	start := s.evictCursor.Add(1)
+	sampled := 0
+	for i := 0; i < len(s.shards) && sampled < evictionShards; i++ {
+		sh := s.shards[(start+uint32(i))%uint32(len(s.shards))]
+
+		if !locked {
+			sh.mu.RLock()
+		}
+		key, e, score, ok := sh.evictionCandidate(policy, now, skip)
+		if ok && score > bestScore {
+			best, bestKey, bestEntry, bestVersion, bestScore = sh, key, e, e.version, score
+		}
+		if !locked {
+			sh.mu.RUnlock()
+		}
+
+		if ok {
+			sampled++
+		}
+	}
+	return best, bestKey, bestEntry, bestVersion
+}
+
+// evictionCandidate samples the shard for the key the policy would evict
+// first, scoring it so candidates from different shards can be compared.
+// Higher scores are evicted first; keys in skip are left out. The shard lock
+// must be held.
+func (sh *shard) evictionCandidate(policy EvictionPolicy, now int64, skip map[string]bool) (key string, e *entry, score int64, ok bool) {
+	score = math.MinInt64
+	sampled := 0
+
+	if policy == VolatileTTL {
+		for k, at := range sh.expires {
+			if sampled == evictionSamples {
+				break
+			}
+			if skip[k] {
+				continue
+			}
+			sampled++
+			// The sooner a key expires, the higher its score
+			if s := -at; s > score {
+				key, e, score, ok = k, sh.data[k], s, true
+			}
+		}
+		return key, e, score, ok
+	}
+
+	// Map iteration order is randomized, which makes this a sample
+	for k, candidate := range sh.data {
+		if sampled == evictionSamples {
+			break
+		}
+		if skip[k] {
+			continue
+		}
+		sampled++
+
+		var s int64
+		switch policy {
+		case AllKeysLRU:
+			s = now - candidate.access.Load()
+		case AllKeysLFU:
+			s = -int64(candidate.frequency(now))
+		case AllKeysRandom:
+			return k, candidate, 0, true
+		}
+		if s > score {
+			key, e, score, ok = k, candidate, s, true
+		}
+	}
+	return key, e, score, ok
+}
@@ -0,0 +1,313 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, name := range []string{"noeviction", "allkeys-lru", "allkeys-lfu", "volatile-ttl", "allkeys-random"} {
+		p, err := ParseEvictionPolicy(name)
+		if err != nil {
+			t.Errorf("ParseEvictionPolicy(%q) error = %v", name, err)
+		}
+		if p.String() != name {
+			t.Errorf("ParseEvictionPolicy(%q).String() = %q", name, p.String())
+		}
+	}
+	if _, err := ParseEvictionPolicy("volatile-lru"); err == nil {
+		t.Error("ParseEvictionPolicy() accepted an unsupported policy")
+	}
+}
+
+func TestStorageMemoryUsage(t *testing.T) {
+	s := New()
+	vec := []float32{0.1, 0.2, 0.3}
+	size := entrySize("key", len(vec))
+
+	_, _ = s.Set("key", vec)
+	if got := s.MemoryUsage(); got != size {
+		t.Errorf("MemoryUsage() = %d, want %d", got, size)
+	}
+
+	_, _ = s.Set("key", vec)
+	if got := s.MemoryUsage(); got != size {
+		t.Errorf("MemoryUsage() after overwrite = %d, want %d", got, size)
+	}
+
+	s.Delete("key")
+	if got := s.MemoryUsage(); got != 0 {
+		t.Errorf("MemoryUsage() after Delete() = %d, want 0", got)
+	}
+
+	_, _ = s.Set("key", vec)
+	s.Clear()
+	if got := s.MemoryUsage(); got != 0 {
+		t.Errorf("MemoryUsage() after Clear() = %d, want 0", got)
+	}
+}
+
+// fill stores n keys and sets the memory limit to exactly what they use
+func fill(t *testing.T, s *Storage, n int, policy EvictionPolicy) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := s.Set(fmt.Sprintf("key%03d", i), []float32{1, float32(i)}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	s.SetMaxMemory(s.MemoryUsage(), policy)
+}
+
+func TestStorageEviction(t *testing.T) {
+	const n = 200
+	vec := []float32{1, 0}
+
+	t.Run("noeviction", func(t *testing.T) {
+		s := New()
+		fill(t, s, n, NoEviction)
+
+		if _, err := s.Set("new", vec); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("Set() error = %v, want ErrOutOfMemory", err)
+		}
+		if s.Count() != n {
+			t.Errorf("Count() = %d, want %d", s.Count(), n)
+		}
+	})
+
+	t.Run("allkeys-lru", func(t *testing.T) {
+		s, clock := newTestStorage()
+		fill(t, s, n, AllKeysLRU)
+
+		// Touch the first half so the second half is least recently used
+		clock.Add(int64(time.Second))
+		for i := 0; i < n/2; i++ {
+			s.Get(fmt.Sprintf("key%03d", i))
+		}
+
+		var evicted []string
+		s.OnEvict(func(key string) { evicted = append(evicted, key) })
+		for i := 0; i < n/4; i++ {
+			if _, err := s.Set(fmt.Sprintf("new%03d", i), vec); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+		}
+
+		if len(evicted) == 0 {
+			t.Fatal("no keys were evicted")
+		}
+		for _, key := range evicted {
+			var i int
+			if _, err := fmt.Sscanf(key, "key%03d", &i); err == nil && i < n/2 {
+				t.Errorf("evicted recently used key %s", key)
+			}
+		}
+		limit, _ := s.MaxMemory()
+		if s.MemoryUsage() > limit {
+			t.Errorf("MemoryUsage() = %d, over the %d limit", s.MemoryUsage(), limit)
+		}
+	})
+
+	t.Run("allkeys-lfu", func(t *testing.T) {
+		s, _ := newTestStorage()
+		fill(t, s, n, AllKeysLFU)
+
+		// Access the first half often enough to raise their counters
+		for round := 0; round < 50; round++ {
+			for i := 0; i < n/2; i++ {
+				s.Get(fmt.Sprintf("key%03d", i))
+			}
+		}
+
+		var evicted []string
+		s.OnEvict(func(key string) { evicted = append(evicted, key) })
+		for i := 0; i < n/4; i++ {
+			_, _ = s.Set(fmt.Sprintf("new%03d", i), vec)
+		}
+
+		if len(evicted) == 0 {
+			t.Fatal("no keys were evicted")
+		}
+		for _, key := range evicted {
+			var i int
+			if _, err := fmt.Sscanf(key, "key%03d", &i); err == nil && i < n/2 {
+				t.Errorf("evicted frequently used key %s", key)
+			}
+		}
+	})
+
+	t.Run("volatile-ttl", func(t *testing.T) {
+		s := New()
+		fill(t, s, n, VolatileTTL)
+		s.SetMaxMemory(0, VolatileTTL)
+		_, _ = s.SetWithTTL("soon", vec, time.Minute)
+		_, _ = s.SetWithTTL("later", vec, time.Hour)
+		s.SetMaxMemory(s.MemoryUsage(), VolatileTTL)
+
+		var evicted []string
+		s.OnEvict(func(key string) { evicted = append(evicted, key) })
+
+		if _, err := s.Set("new1", vec); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if _, err := s.Set("new2", vec); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if len(evicted) != 2 || evicted[0] != "soon" || evicted[1] != "later" {
+			t.Errorf("evicted %v, want [soon later]", evicted)
+		}
+
+		// Only keys with a TTL may be evicted
+		if _, err := s.Set("new3", vec); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("Set() error = %v, want ErrOutOfMemory", err)
+		}
+	})
+
+	t.Run("allkeys-random", func(t *testing.T) {
+		s := New()
+		fill(t, s, n, AllKeysRandom)
+
+		evicted := 0
+		s.OnEvict(func(string) { evicted++ })
+		for i := 0; i < n; i++ {
+			if _, err := s.Set(fmt.Sprintf("new%03d", i), vec); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+		}
+		if evicted == 0 || s.Count() > n {
+			t.Errorf("evicted %d keys leaving %d, want at most %d", evicted, s.Count(), n)
+		}
+	})
+}
+
+func TestStorageEvictionOverwrite(t *testing.T) {
+	const n = 50
+	vec := []float32{0, 1}
+
+	for _, policy := range []EvictionPolicy{NoEviction, AllKeysLRU} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s := New()
+			fill(t, s, n, policy)
+
+			evicted := 0
+			s.OnEvict(func(string) { evicted++ })
+
+			// Replacing keys at the limit does not grow the data
+			if _, err := s.Set("key000", vec); err != nil {
+				t.Errorf("Set() error = %v", err)
+			}
+			if _, err := s.SetWithOptions("key001", vec, SetOptions{XX: true}); err != nil {
+				t.Errorf("SetWithOptions() error = %v", err)
+			}
+			if _, err := s.SetMany([]string{"key002", "key003", "key002"}, [][]float32{vec, vec, vec}); err != nil {
+				t.Errorf("SetMany() error = %v", err)
+			}
+			if res := s.SetBatch([]Write{{Key: "key004", Values: vec}}); res[0].Err != nil {
+				t.Errorf("SetBatch() error = %v", res[0].Err)
+			}
+			if _, err := s.Copy("key005", "key006", true); err != nil {
+				t.Errorf("Copy() error = %v", err)
+			}
+			s.Atomically(func(tx *Tx) {
+				if _, err := tx.Set("key007", vec); err != nil {
+					t.Errorf("Tx.Set() error = %v", err)
+				}
+			})
+
+			if evicted != 0 || s.Count() != n {
+				t.Errorf("evicted %d keys leaving %d, want none evicted", evicted, s.Count())
+			}
+
+			// A batch that adds a key still needs room for it
+			_, err := s.SetMany([]string{"key000", "new"}, [][]float32{vec, vec})
+			if policy == NoEviction && !errors.Is(err, ErrOutOfMemory) {
+				t.Errorf("SetMany() with a new key error = %v, want ErrOutOfMemory", err)
+			}
+			if policy != NoEviction && (err != nil || evicted == 0) {
+				t.Errorf("SetMany() with a new key error = %v, evicted %d, want an eviction", err, evicted)
+			}
+		})
+	}
+}
+
+func TestStorageVictims(t *testing.T) {
+	const n = 100
+	vec := []float32{1, 0}
+	size := entrySize("new000", len(vec))
+
+	t.Run("noeviction", func(t *testing.T) {
+		s := New()
+		fill(t, s, n, NoEviction)
+		if _, err := s.Victims(size); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("Victims() error = %v, want ErrOutOfMemory", err)
+		}
+	})
+
+	t.Run("allkeys-random", func(t *testing.T) {
+		s := New()
+		s.SetPassive(true)
+		fill(t, s, n, AllKeysRandom)
+		var evicted []string
+		s.OnEvict(func(key string) { evicted = append(evicted, key) })
+
+		if victims, err := s.Victims(0); err != nil || len(victims) != 0 {
+			t.Errorf("Victims(0) = %v, %v, want none", victims, err)
+		}
+		victims, err := s.Victims(3 * size)
+		if err != nil {
+			t.Fatalf("Victims() error = %v", err)
+		}
+		if len(victims) != 3 {
+			t.Fatalf("Victims() = %v, want 3 keys", victims)
+		}
+		if s.Count() != n {
+			t.Errorf("Count() = %d, Victims() should not remove keys", s.Count())
+		}
+
+		// A key written since it was chosen is kept
+		_, _ = s.Set(victims[0].Key, vec)
+		for _, v := range victims {
+			s.Evict(v.Key, v.Version)
+		}
+		if len(evicted) != 2 || s.Count() != n-2 {
+			t.Errorf("evicted %v, Count() = %d, want the 2 unchanged keys evicted", evicted, s.Count())
+		}
+	})
+
+	t.Run("too much", func(t *testing.T) {
+		s := New()
+		fill(t, s, n, AllKeysLRU)
+		if _, err := s.Victims(int64(n+1) * size); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("Victims() error = %v, want ErrOutOfMemory", err)
+		}
+	})
+
+	t.Run("Growth", func(t *testing.T) {
+		s := New()
+		if g := s.Growth("new"); g != 0 {
+			t.Errorf("Growth() of an empty storage = %d, want 0", g)
+		}
+		fill(t, s, 1, NoEviction)
+		if g := s.Growth("key000"); g != 0 {
+			t.Errorf("Growth() of an overwrite = %d, want 0", g)
+		}
+		if g := s.Growth("new000", "new000"); g != size {
+			t.Errorf("Growth() = %d, want %d", g, size)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// expireSampleSize is the number of keys with a TTL the sweeper checks
+	// per round, as in Redis' active expiry cycle
+	expireSampleSize = 20
+
+	// expireMaxRounds bounds how long a sweep holds on to a shard when most
+	// sampled keys keep turning out to be expired
+	expireMaxRounds = 16
+)
+
+// expired reports whether key has a TTL that passed at or before now.
+// The shard lock must be held.
+func (sh *shard) expired(key string, now int64) bool {
+	at, ok := sh.expires[key]
+	return ok && at <= now
+}
+
+// removeExpired deletes an expired key and reports it. The shard write lock
+// must be held.
+func (s *Storage) removeExpired(sh *shard, key string) {
+	sh.remove(key)
+	s.changed(OpExpired, key, nil, 0)
+	if s.onExpire != nil {
+		s.onExpire(key)
+	}
+}
+
+// lapsed reports whether a write should treat key as gone because its TTL
+// passed, which a passive storage never does. The shard lock must be held.
+func (s *Storage) lapsed(sh *shard, key string, now int64) bool {
+	return !s.passive.Load() && sh.expired(key, now)
+}
+
+// expireKey removes key if it is still expired once the write lock is held.
+// Readers find expired keys under the read lock and call this afterwards.
+func (s *Storage) expireKey(sh *shard, key string) {
+	if s.passive.Load() {
+		return
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.expired(key, s.now()) {
+		s.removeExpired(sh, key)
+	}
+}
+
+// OnExpire registers fn to be called for each key removed because its TTL
+// passed, whether found lazily on access or by the sweeper. fn runs with the
+// shard lock held, so it must be quick and must not call back into Storage.
+// It should be set before the storage is shared between goroutines.
+func (s *Storage) OnExpire(fn func(key string)) {
+	s.onExpire = fn
+}
+
+// SetPassive makes the storage leave keys whose TTL passed in place for
+// RemoveExpired, and stop evicting, leaving that to Evict. Reads still hide
+// expired keys, but writes act on them as if they had not expired, so that
+// applying the same writes in the same order gives the same data whatever
+// the clock says. A replicated log uses it to expire and evict through the
+// log, and a replica to leave expiry and eviction to its leader. It may be
+// changed at any time.
+func (s *Storage) SetPassive(passive bool) {
+	s.passive.Store(passive)
+}
+
+// KeyVersion is a key as of one of its versions
+type KeyVersion struct {
+	Key     string
+	Version uint64
+}
+
+// Expired returns up to limit keys whose TTL has passed, along with their
+// versions, sampling each shard's keys with a TTL like the sweeper. It is
+// meant for passive storages, which leave such keys for RemoveExpired.
+func (s *Storage) Expired(limit int) []KeyVersion {
+	now := s.now()
+	var found []KeyVersion
+	for i := 0; i < len(s.shards) && len(found) < limit; i++ {
+		sh := s.shards[i]
+		sh.mu.RLock()
+		sampled := 0
+		for key, at := range sh.expires {
+			if sampled == expireSampleSize*expireMaxRounds || len(found) == limit {
+				break
+			}
+			sampled++
+			if at <= now {
+				found = append(found, KeyVersion{Key: key, Version: sh.data[key].version})
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return found
+}
+
+// ExpiredVersion returns the version of key if it is stored but its TTL
+// has passed
+func (s *Storage) ExpiredVersion(key string) (uint64, bool) {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	if e, ok := shard.data[key]; ok && shard.expired(key, s.now()) {
+		return e.version, true
+	}
+	return 0, false
+}
+
+// RemoveExpired removes key as expired if it still has the given version,
+// as found by Expired. The TTL is not checked again, so replaying the call
+// later removes the same key regardless of the clock.
+func (s *Storage) RemoveExpired(key string, version uint64) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if e, ok := shard.data[key]; !ok || e.version != version {
+		return false
+	}
+	s.removeExpired(shard, key)
+	return true
+}
+
+// Expire sets a TTL on an existing key. A non-positive ttl deletes the key
+// immediately. It returns false if the key does not exist.
+func (s *Storage) Expire(key string, ttl time.Duration) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.expire(shard, key, ttl)
+}
+
+// expire implements Expire with the shard write lock held
+func (s *Storage) expire(sh *shard, key string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return s.delete(sh, key)
+	}
+	return s.expireAt(sh, key, s.now()+int64(ttl))
+}
+
+// ExpireAt makes an existing key expire at the given time, deleting it
+// immediately if that has passed; a passive storage keeps it as expired
+// instead. It returns false if the key does not exist.
+func (s *Storage) ExpireAt(key string, at time.Time) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.expireAt(shard, key, deadline(at))
+}
+
+// expireAt implements ExpireAt with the shard write lock held, taking the
+// time as Unix nanoseconds
+func (s *Storage) expireAt(sh *shard, key string, at int64) bool {
+	now := s.now()
+	e, ok := s.target(sh, key, now)
+	if !ok {
+		return false
+	}
+
+	if at <= now && !s.passive.Load() {
+		sh.remove(key)
+		s.changed(OpDelete, key, nil, 0)
+		return true
+	}
+	sh.expires[key] = at
+	sh.bump(e)
+	s.changed(OpExpire, key, nil, at)
+	return true
+}
+
+// deadline converts an expiry time to Unix nanoseconds, keeping times at or
+// before the epoch above 0, which means no TTL
+func deadline(at time.Time) int64 {
+	return max(at.UnixNano(), 1)
+}
+
+// TTL returns the remaining time to live of key. The duration is negative if
+// the key exists but has no TTL; ok is false if the key does not exist.
+func (s *Storage) TTL(key string) (ttl time.Duration, ok bool) {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	_, ok = shard.data[key]
+	at, hasTTL := shard.expires[key]
+	shard.mu.RUnlock()
+
+	if !ok {
+		return 0, false
+	}
+	if !hasTTL {
+		return -1, true
+	}
+
+	remaining := time.Duration(at - s.now())
+	if remaining <= 0 {
+		s.expireKey(shard, key)
+		return 0, false
+	}
+	return remaining, true
+}
+
+// Persist removes the TTL from key. It returns false if the key does not
+// exist or has no TTL.
+func (s *Storage) Persist(key string) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.persist(shard, key)
+}
+
+// persist implements Persist with the shard write lock held
+func (s *Storage) persist(sh *shard, key string) bool {
+	if _, ok := sh.expires[key]; !ok {
+		return false
+	}
+	e, ok := s.target(sh, key, s.now())
+	if !ok {
+		return false
+	}
+	delete(sh.expires, key)
+	sh.bump(e)
+	s.changed(OpExpire, key, nil, 0)
+	return true
+}
+
+// RunExpirySweeper actively reclaims expired keys until ctx is cancelled,
+// so keys that are never accessed again don't hold on to memory. Each shard
+// gets its own goroutine that wakes up every interval.
+func (s *Storage) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	for i := 0; i < len(s.shards); i++ {
+		go func(sh *shard) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					s.sweep(sh)
+				}
+			}
+		}(s.shards[i])
+	}
+}
+
+// sweep samples keys with a TTL and removes the expired ones. Like Redis, it
+// keeps going while more than a quarter of a sample was expired, since that
+// suggests many more are waiting. The lock is released between rounds so
+// writers are not starved.
+func (s *Storage) sweep(sh *shard) int {
+	if s.passive.Load() {
+		return 0
+	}
+
+	removed := 0
+	for round := 0; round < expireMaxRounds; round++ {
+		sh.mu.Lock()
+		now := s.now()
+		sampled, expired := 0, 0
+		// Map iteration order is randomized, which makes this a sample
+		for key, at := range sh.expires {
+			if sampled == expireSampleSize {
+				break
+			}
+			sampled++
+			if at <= now {
+				s.removeExpired(sh, key)
+				expired++
+			}
+		}
+		sh.mu.Unlock()
+
+		removed += expired
+		if sampled < expireSampleSize || expired*4 <= sampled {
+			break
+		}
+	}
+	return removed
+}
@@ -0,0 +1,281 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestStorage returns a Storage whose clock only moves when advanced
+func newTestStorage() (*Storage, *atomic.Int64) {
+	s := New()
+	var clock atomic.Int64
+	clock.Store(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	s.now = clock.Load
+	return s, &clock
+}
+
+func TestStorageExpiry(t *testing.T) {
+	s, clock := newTestStorage()
+	var expired []string
+	s.OnExpire(func(key string) { expired = append(expired, key) })
+
+	vec := []float32{0.1, 0.2, 0.3}
+	_, _ = s.SetWithTTL("session", vec, time.Minute)
+	_, _ = s.Set("doc", vec)
+
+	t.Run("TTL", func(t *testing.T) {
+		if ttl, ok := s.TTL("session"); !ok || ttl != time.Minute {
+			t.Errorf("TTL(session) = %v, %v, want 1m, true", ttl, ok)
+		}
+		if ttl, ok := s.TTL("doc"); !ok || ttl >= 0 {
+			t.Errorf("TTL(doc) = %v, %v, want negative, true", ttl, ok)
+		}
+		if _, ok := s.TTL("missing"); ok {
+			t.Error("TTL(missing) returned ok = true")
+		}
+	})
+
+	t.Run("search excludes expired keys before they are reclaimed", func(t *testing.T) {
+		clock.Add(int64(time.Minute))
+		results, err := s.Search(vec, 10)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if len(results) != 1 || results[0].Key != "doc" {
+			t.Errorf("Search() = %v, want only doc", results)
+		}
+		if s.Count() != 2 {
+			t.Errorf("Count() = %d, want 2 until the key is reclaimed", s.Count())
+		}
+	})
+
+	t.Run("lazy expiry on access", func(t *testing.T) {
+		if _, ok := s.Get("session"); ok {
+			t.Error("Get() returned an expired key")
+		}
+		if s.Count() != 1 {
+			t.Errorf("Count() = %d, want 1", s.Count())
+		}
+		if len(expired) != 1 || expired[0] != "session" {
+			t.Errorf("OnExpire saw %v, want [session]", expired)
+		}
+	})
+
+	t.Run("Set clears TTL", func(t *testing.T) {
+		_, _ = s.SetWithTTL("k", vec, time.Second)
+		_, _ = s.Set("k", vec)
+		clock.Add(int64(time.Hour))
+		if _, ok := s.Get("k"); !ok {
+			t.Error("Set() did not remove the TTL")
+		}
+	})
+
+	t.Run("Expire and Persist", func(t *testing.T) {
+		if s.Expire("missing", time.Second) {
+			t.Error("Expire(missing) returned true")
+		}
+		if !s.Expire("doc", time.Second) {
+			t.Fatal("Expire(doc) returned false")
+		}
+		if !s.Persist("doc") {
+			t.Error("Persist(doc) returned false")
+		}
+		if s.Persist("doc") {
+			t.Error("Persist() of a key without TTL returned true")
+		}
+		clock.Add(int64(time.Hour))
+		if _, ok := s.Get("doc"); !ok {
+			t.Error("persisted key expired")
+		}
+
+		if !s.Expire("doc", 0) {
+			t.Error("Expire(doc, 0) returned false")
+		}
+		if _, ok := s.Get("doc"); ok {
+			t.Error("Expire() with a non-positive TTL should delete the key")
+		}
+	})
+
+	t.Run("Delete of expired key", func(t *testing.T) {
+		_, _ = s.SetWithTTL("gone", vec, time.Second)
+		clock.Add(int64(time.Second))
+		if s.Delete("gone") {
+			t.Error("Delete() of an expired key returned true")
+		}
+	})
+}
+
+func TestStorageExpirySweeper(t *testing.T) {
+	s, clock := newTestStorage()
+	var expired atomic.Int64
+	s.OnExpire(func(string) { expired.Add(1) })
+
+	const n = 2000
+	for i := 0; i < n; i++ {
+		ttl := time.Second
+		if i%2 == 0 {
+			ttl = time.Hour
+		}
+		_, _ = s.SetWithTTL(fmt.Sprintf("key%d", i), []float32{1, float32(i)}, ttl)
+	}
+	clock.Add(int64(time.Minute))
+
+	t.Run("sweep", func(t *testing.T) {
+		removed := 0
+		for i := 0; i < len(s.shards); i++ {
+			removed += s.sweep(s.shards[i])
+		}
+		if removed == 0 {
+			t.Fatal("sweep() removed nothing")
+		}
+		if removed > n/2 {
+			t.Errorf("sweep() removed %d keys, only %d had expired", removed, n/2)
+		}
+	})
+
+	t.Run("background", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.RunExpirySweeper(ctx, time.Millisecond)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for s.Count() > n/2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Count() = %d after sweeping, want %d", s.Count(), n/2)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if expired.Load() != n/2 {
+			t.Errorf("OnExpire called %d times, want %d", expired.Load(), n/2)
+		}
+	})
+}
+
+func TestStorageExpireAt(t *testing.T) {
+	s, clock := newTestStorage()
+	vec := []float32{0.1, 0.2, 0.3}
+	now := time.Unix(0, clock.Load())
+
+	_, _ = s.Set("doc", vec)
+	if s.ExpireAt("missing", now.Add(time.Minute)) {
+		t.Error("ExpireAt(missing) returned true")
+	}
+	if !s.ExpireAt("doc", now.Add(time.Minute)) {
+		t.Fatal("ExpireAt(doc) returned false")
+	}
+	if ttl, ok := s.TTL("doc"); !ok || ttl != time.Minute {
+		t.Errorf("TTL(doc) = %v, %v, want 1m, true", ttl, ok)
+	}
+
+	if !s.ExpireAt("doc", now) {
+		t.Error("ExpireAt() of a passed time returned false")
+	}
+	if s.Count() != 0 {
+		t.Errorf("Count() = %d, want 0 after ExpireAt() of a passed time", s.Count())
+	}
+
+	res, err := s.SetWithOptions("session", vec, SetOptions{ExpireAt: now.Add(time.Second)})
+	if err != nil || !res.Written {
+		t.Fatalf("SetWithOptions() = %+v, %v", res, err)
+	}
+	if ttl, ok := s.TTL("session"); !ok || ttl != time.Second {
+		t.Errorf("TTL(session) = %v, %v, want 1s, true", ttl, ok)
+	}
+	clock.Add(int64(time.Second))
+	if _, ok := s.Get("session"); ok {
+		t.Error("Get() returned a key past its ExpireAt")
+	}
+}
+
+func TestStoragePassive(t *testing.T) {
+	s, clock := newTestStorage()
+	s.SetPassive(true)
+	var expired []string
+	s.OnExpire(func(key string) { expired = append(expired, key) })
+
+	vec := []float32{0.1, 0.2, 0.3}
+	_, _ = s.SetWithTTL("session", vec, time.Second)
+	_, _ = s.SetWithTTL("other", vec, time.Hour)
+	clock.Add(int64(time.Minute))
+
+	t.Run("reads hide expired keys without removing them", func(t *testing.T) {
+		if _, ok := s.Get("session"); ok {
+			t.Error("Get() returned an expired key")
+		}
+		if s.Exists("session") {
+			t.Error("Exists() returned true for an expired key")
+		}
+		if _, ok := s.TTL("session"); ok {
+			t.Error("TTL() returned ok = true for an expired key")
+		}
+		for i := 0; i < len(s.shards); i++ {
+			s.sweep(s.shards[i])
+		}
+		if s.Count() != 2 || len(expired) != 0 {
+			t.Errorf("Count() = %d with %v expired, want the key kept", s.Count(), expired)
+		}
+	})
+
+	t.Run("writes act on expired keys", func(t *testing.T) {
+		if res, _ := s.SetWithOptions("session", vec, SetOptions{NX: true}); res.Written {
+			t.Error("SetWithOptions(NX) wrote over a key that is still stored")
+		}
+		if ok, _ := s.Copy("session", "copy", false); !ok {
+			t.Error("Copy() of an expired but stored key failed")
+		}
+		s.Delete("copy")
+	})
+
+	t.Run("ExpireAt of a passed time keeps the key", func(t *testing.T) {
+		if !s.ExpireAt("other", time.Unix(0, clock.Load())) {
+			t.Fatal("ExpireAt(other) returned false")
+		}
+		if s.Count() != 2 {
+			t.Errorf("Count() = %d, want 2", s.Count())
+		}
+	})
+
+	t.Run("RemoveExpired", func(t *testing.T) {
+		found := s.Expired(10)
+		if len(found) != 2 {
+			t.Fatalf("Expired() = %v, want both keys", found)
+		}
+		version, ok := s.ExpiredVersion("session")
+		if !ok {
+			t.Fatal("ExpiredVersion(session) returned false")
+		}
+		if s.RemoveExpired("session", version+1) {
+			t.Error("RemoveExpired() of another version returned true")
+		}
+		if !s.RemoveExpired("session", version) {
+			t.Error("RemoveExpired() returned false")
+		}
+		if s.Count() != 1 || len(expired) != 1 || expired[0] != "session" {
+			t.Errorf("Count() = %d with %v expired, want session removed", s.Count(), expired)
+		}
+	})
+
+	t.Run("no eviction", func(t *testing.T) {
+		s.SetMaxMemory(1, NoEviction)
+		if _, err := s.Set("big", vec); err != nil {
+			t.Errorf("Set() error = %v, want the limit left to the caller", err)
+		}
+	})
+}
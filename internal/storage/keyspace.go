@@ -0,0 +1,187 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+)
+
+// ErrNoSuchKey is returned by operations whose source key does not exist
+var ErrNoSuchKey = errors.New("no such key")
+
+// Exists reports whether key exists and has not expired
+func (s *Storage) Exists(key string) bool {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	_, ok := shard.data[key]
+	expired := ok && shard.expired(key, s.now())
+	shard.mu.RUnlock()
+
+	if expired {
+		s.expireKey(shard, key)
+		return false
+	}
+	return ok
+}
+
+// Rename moves the vector stored at src to dst, along with its TTL,
+// replacing any vector at dst. Both keys' shards are locked together, so the
+// vector is never visible under both keys or under neither.
+func (s *Storage) Rename(src, dst string) error {
+	from, to := s.shardIndex(src), s.shardIndex(dst)
+	s.lockPair(from, to)
+	defer s.unlockPair(from, to)
+
+	return s.rename(s.shards[from], s.shards[to], src, dst)
+}
+
+// rename implements Rename with both shards write-locked
+func (s *Storage) rename(fromShard, toShard *shard, src, dst string) error {
+	e, ok := s.target(fromShard, src, s.now())
+	if !ok {
+		return ErrNoSuchKey
+	}
+	if src == dst {
+		return nil
+	}
+
+	at, hasTTL := fromShard.expires[src]
+	fromShard.remove(src)
+	s.changed(OpDelete, src, nil, 0)
+
+	moved := &entry{key: dst, vec: e.vec, size: entrySize(dst, len(e.vec))}
+	moved.access.Store(e.access.Load())
+	moved.freq.Store(e.freq.Load())
+	toShard.put(moved)
+	if hasTTL {
+		toShard.expires[dst] = at
+	} else {
+		delete(toShard.expires, dst)
+		at = 0
+	}
+	s.changed(OpSet, dst, moved.vec, at)
+	return nil
+}
+
+// Copy stores a copy of the vector at src, along with its TTL, under dst.
+// If dst already exists it is only overwritten when replace is set; the
+// result reports whether the copy was made.
+func (s *Storage) Copy(src, dst string, replace bool) (bool, error) {
+	// Make room before taking the locks, since eviction may pick these shards
+	if dim := int(s.dim.Load()); dim != 0 {
+		if err := s.reserve(entrySize(dst, dim)-s.sizeOf(dst, false), false); err != nil {
+			return false, err
+		}
+	}
+
+	from, to := s.shardIndex(src), s.shardIndex(dst)
+	s.lockPair(from, to)
+	defer s.unlockPair(from, to)
+
+	return s.copy(s.shards[from], s.shards[to], src, dst, replace)
+}
+
+// copy implements Copy with both shards write-locked and room reserved
+func (s *Storage) copy(fromShard, toShard *shard, src, dst string, replace bool) (bool, error) {
+	now := s.now()
+	e, ok := s.target(fromShard, src, now)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	if _, exists := toShard.data[dst]; exists && !s.lapsed(toShard, dst, now) && !replace {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+
+	at, hasTTL := fromShard.expires[src]
+	copied := &entry{key: dst, vec: slices.Clone(e.vec), size: entrySize(dst, len(e.vec))}
+	copied.access.Store(now)
+	copied.freq.Store(lfuInitVal)
+	toShard.put(copied)
+	if hasTTL {
+		toShard.expires[dst] = at
+	} else {
+		delete(toShard.expires, dst)
+		at = 0
+	}
+	s.changed(OpSet, dst, copied.vec, at)
+	return true, nil
+}
+
+// RandomKey returns a random key for which match returns true, or any key if
+// match is nil. It returns false if there is no such key.
+func (s *Storage) RandomKey(match func(key string) bool) (string, bool) {
+	return s.randomKey(match, false)
+}
+
+// randomKey implements RandomKey, taking each shard's read lock unless
+// locked is set
+func (s *Storage) randomKey(match func(key string) bool, locked bool) (string, bool) {
+	start := rand.IntN(len(s.shards))
+	now := s.now()
+	for i := 0; i < len(s.shards); i++ {
+		shard := s.shards[(start+i)%len(s.shards)]
+
+		if !locked {
+			shard.mu.RLock()
+		}
+		key, ok := shard.randomKey(match, now)
+		if !locked {
+			shard.mu.RUnlock()
+		}
+		if ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// randomKey returns a random unexpired key in the shard for which match
+// returns true. The shard lock must be held.
+func (sh *shard) randomKey(match func(key string) bool, now int64) (string, bool) {
+	// Map iteration order is randomized, so the first key is a random one
+	for key := range sh.data {
+		if !sh.expired(key, now) && (match == nil || match(key)) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// lockPair write-locks the shards at indexes a and b, which may be equal, in
+// index order. When they differ, searches are held off too, so they see
+// both shards change together.
+func (s *Storage) lockPair(a, b int) {
+	if a == b {
+		s.shards[a].mu.Lock()
+		return
+	}
+	s.batchMu.Lock()
+	s.shards[min(a, b)].mu.Lock()
+	s.shards[max(a, b)].mu.Lock()
+}
+
+// unlockPair releases the locks taken by lockPair
+func (s *Storage) unlockPair(a, b int) {
+	s.shards[a].mu.Unlock()
+	if a != b {
+		s.shards[b].mu.Unlock()
+		s.batchMu.Unlock()
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// keysInDifferentShards returns two keys that hash to different shards
+func keysInDifferentShards() (string, string) {
+	s := New()
+	a := "key:0"
+	for i := 1; ; i++ {
+		b := fmt.Sprintf("key:%d", i)
+		if s.ShardOf(a) != s.ShardOf(b) {
+			return a, b
+		}
+	}
+}
+
+func TestStorageExists(t *testing.T) {
+	s, clock := newTestStorage()
+	_, _ = s.Set("a", []float32{1, 0})
+	_, _ = s.SetWithTTL("b", []float32{1, 0}, time.Second)
+
+	if !s.Exists("a") || !s.Exists("b") {
+		t.Error("Exists() = false for stored keys")
+	}
+	if s.Exists("missing") {
+		t.Error("Exists(missing) = true")
+	}
+	clock.Add(int64(time.Second))
+	if s.Exists("b") {
+		t.Error("Exists() = true for an expired key")
+	}
+}
+
+func TestStorageRename(t *testing.T) {
+	s, clock := newTestStorage()
+	src, dst := keysInDifferentShards()
+
+	t.Run("moves the vector and its TTL", func(t *testing.T) {
+		_, _ = s.SetWithTTL(src, []float32{1, 0}, time.Minute)
+		if err := s.Rename(src, dst); err != nil {
+			t.Fatalf("Rename() error = %v", err)
+		}
+		if s.Exists(src) {
+			t.Error("source still exists")
+		}
+		if ttl, ok := s.TTL(dst); !ok || ttl != time.Minute {
+			t.Errorf("TTL(dst) = %v, %v, want 1m, true", ttl, ok)
+		}
+		if s.MemoryUsage() != entrySize(dst, 2) {
+			t.Errorf("MemoryUsage() = %d, want %d", s.MemoryUsage(), entrySize(dst, 2))
+		}
+	})
+
+	t.Run("replaces the destination", func(t *testing.T) {
+		_, _ = s.Set(src, []float32{0, 1})
+		if err := s.Rename(src, dst); err != nil {
+			t.Fatalf("Rename() error = %v", err)
+		}
+		if vec, _ := s.Get(dst); vec[1] != 1 {
+			t.Errorf("Get(dst) = %v, want the renamed vector", vec)
+		}
+		if _, ok := s.TTL(dst); !ok {
+			t.Fatal("destination missing")
+		}
+		if ttl, _ := s.TTL(dst); ttl >= 0 {
+			t.Errorf("TTL(dst) = %v, the old destination TTL should be gone", ttl)
+		}
+		if s.Count() != 1 {
+			t.Errorf("Count() = %d, want 1", s.Count())
+		}
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		if err := s.Rename(dst, dst); err != nil {
+			t.Errorf("Rename(dst, dst) error = %v", err)
+		}
+		if !s.Exists(dst) {
+			t.Error("Rename() of a key onto itself removed it")
+		}
+	})
+
+	t.Run("missing or expired source", func(t *testing.T) {
+		if err := s.Rename("missing", "x"); !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("Rename(missing) error = %v, want ErrNoSuchKey", err)
+		}
+		_, _ = s.SetWithTTL("old", []float32{1, 0}, time.Second)
+		clock.Add(int64(time.Second))
+		if err := s.Rename("old", "x"); !errors.Is(err, ErrNoSuchKey) {
+			t.Errorf("Rename(expired) error = %v, want ErrNoSuchKey", err)
+		}
+	})
+
+	t.Run("searches see the key under exactly one name", func(t *testing.T) {
+		s := New()
+		_, _ = s.Set(src, []float32{1, 0})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 500; i++ {
+				_ = s.Rename(src, dst)
+				_ = s.Rename(dst, src)
+			}
+		}()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if results, _ := s.Search([]float32{1, 0}, 10); len(results) != 1 {
+				t.Fatalf("Search() returned %d results during a rename, want 1", len(results))
+			}
+		}
+	})
+}
+
+func TestStorageCopy(t *testing.T) {
+	s, _ := newTestStorage()
+	src, dst := keysInDifferentShards()
+	_, _ = s.SetWithTTL(src, []float32{1, 0}, time.Minute)
+
+	copied, err := s.Copy(src, dst, false)
+	if err != nil || !copied {
+		t.Fatalf("Copy() = %v, %v, want true, nil", copied, err)
+	}
+	if !s.Exists(src) || !s.Exists(dst) {
+		t.Error("Copy() should leave both keys")
+	}
+	if ttl, _ := s.TTL(dst); ttl != time.Minute {
+		t.Errorf("TTL(dst) = %v, want the source TTL", ttl)
+	}
+
+	_, _ = s.Set(src, []float32{0, 1})
+	if copied, _ := s.Copy(src, dst, false); copied {
+		t.Error("Copy() overwrote an existing key without replace")
+	}
+	if copied, _ := s.Copy(src, dst, true); !copied {
+		t.Error("Copy() with replace did not copy")
+	}
+	if vec, _ := s.Get(dst); vec[1] != 1 {
+		t.Errorf("Get(dst) = %v, want the replaced vector", vec)
+	}
+
+	if _, err := s.Copy("missing", "x", false); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("Copy(missing) error = %v, want ErrNoSuchKey", err)
+	}
+}
+
+func TestStorageRandomKey(t *testing.T) {
+	s := New()
+	if _, ok := s.RandomKey(nil); ok {
+		t.Error("RandomKey() on empty storage returned ok = true")
+	}
+
+	for i := 0; i < 100; i++ {
+		_, _ = s.Set(fmt.Sprintf("key:%d", i), []float32{1, 0})
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		key, ok := s.RandomKey(nil)
+		if !ok || !s.Exists(key) {
+			t.Fatalf("RandomKey() = %q, %v", key, ok)
+		}
+		seen[key] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("RandomKey() returned %d distinct keys in 50 calls", len(seen))
+	}
+
+	if key, ok := s.RandomKey(func(k string) bool { return k == "key:42" }); !ok || key != "key:42" {
+		t.Errorf("RandomKey() with match = %q, %v, want key:42", key, ok)
+	}
+}
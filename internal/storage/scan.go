@@ -0,0 +1,88 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// shardBits is the number of low cursor bits holding the shard index, enough
+// for MaxShards shards
+const shardBits = 10
+
+// Scan incrementally iterates over the keys, Redis SCAN style. Start with a
+// cursor of 0 and pass each returned cursor to the next call; a returned
+// cursor of 0 means the iteration is complete. count is a hint for how many
+// keys to examine per call, and match, if not nil, filters the keys
+// returned. Expired keys are skipped.
+//
+// The cursor holds a shard index and a position within that shard. Keys keep
+// their position for as long as they exist, so a key present for the whole
+// scan is returned exactly once. Keys added or removed during the scan may
+// or may not be returned. Each shard's read lock is only held while
+// examining one batch.
+func (s *Storage) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	return s.scan(cursor, count, match, false)
+}
+
+// scan implements Scan, taking each shard's read lock per batch unless
+// locked is set
+func (s *Storage) scan(cursor uint64, count int, match func(key string) bool, locked bool) ([]string, uint64) {
+	idx := int(cursor & (1<<shardBits - 1))
+	pos := int(cursor >> shardBits)
+	remaining := max(count, 1)
+
+	var keys []string
+	for ; idx < len(s.shards) && remaining > 0; idx, pos = idx+1, 0 {
+		var examined int
+		var done bool
+		sh := s.shards[idx]
+		if !locked {
+			sh.mu.RLock()
+		}
+		keys, pos, examined, done = sh.scan(keys, pos, remaining, match, s.now())
+		if !locked {
+			sh.mu.RUnlock()
+		}
+		if !done {
+			return keys, uint64(pos)<<shardBits | uint64(idx)
+		}
+		remaining -= examined
+	}
+
+	if idx >= len(s.shards) {
+		return keys, 0
+	}
+	return keys, uint64(idx)
+}
+
+// scan appends the keys found from position pos until limit entries have
+// been examined. It returns the position to resume from, the number of
+// entries examined and whether the end of the shard was reached. The shard
+// lock must be held.
+func (sh *shard) scan(keys []string, pos, limit int, match func(string) bool, now int64) ([]string, int, int, bool) {
+	examined := 0
+	for ; pos < len(sh.slots); pos++ {
+		if examined == limit {
+			return keys, pos, examined, false
+		}
+		e := sh.slots[pos]
+		if e == nil {
+			continue
+		}
+		examined++
+		if sh.expired(e.key, now) || (match != nil && !match(e.key)) {
+			continue
+		}
+		keys = append(keys, e.key)
+	}
+	return keys, pos, examined, true
+}
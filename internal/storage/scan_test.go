@@ -0,0 +1,142 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanAll runs a complete scan and counts how often each key was returned
+func scanAll(s *Storage, count int, match func(string) bool) (map[string]int, int) {
+	seen := make(map[string]int)
+	calls := 0
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor = s.Scan(cursor, count, match)
+		calls++
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			return seen, calls
+		}
+	}
+}
+
+func TestStorageScan(t *testing.T) {
+	s := New()
+
+	t.Run("empty", func(t *testing.T) {
+		keys, cursor := s.Scan(0, 10, nil)
+		if len(keys) != 0 || cursor != 0 {
+			t.Errorf("Scan() = %v, %d, want no keys and cursor 0", keys, cursor)
+		}
+	})
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		_, _ = s.Set(fmt.Sprintf("key:%d", i), []float32{1, float32(i)})
+	}
+
+	t.Run("returns every key once", func(t *testing.T) {
+		seen, calls := scanAll(s, 10, nil)
+		if len(seen) != n {
+			t.Errorf("Scan() returned %d keys, want %d", len(seen), n)
+		}
+		for key, times := range seen {
+			if times != 1 {
+				t.Errorf("key %s returned %d times", key, times)
+			}
+		}
+		if calls < n/10 {
+			t.Errorf("Scan() finished in %d calls, COUNT 10 should need at least %d", calls, n/10)
+		}
+	})
+
+	t.Run("match", func(t *testing.T) {
+		seen, _ := scanAll(s, 100, func(key string) bool { return strings.HasSuffix(key, "7") })
+		if len(seen) != n/10 {
+			t.Errorf("Scan() with match returned %d keys, want %d", len(seen), n/10)
+		}
+	})
+
+	t.Run("skips expired keys", func(t *testing.T) {
+		s, clock := newTestStorage()
+		_, _ = s.SetWithTTL("gone", []float32{1}, time.Second)
+		_, _ = s.Set("kept", []float32{1})
+		clock.Add(int64(time.Second))
+
+		seen, _ := scanAll(s, 10, nil)
+		if len(seen) != 1 || seen["kept"] != 1 {
+			t.Errorf("Scan() = %v, want only kept", seen)
+		}
+	})
+
+	t.Run("invalid cursor ends the scan", func(t *testing.T) {
+		keys, cursor := s.Scan(1<<40|ShardCount-1, 10, nil)
+		if len(keys) != 0 || cursor != 0 {
+			t.Errorf("Scan() = %v, %d, want no keys and cursor 0", keys, cursor)
+		}
+	})
+}
+
+func TestStorageScanConcurrentWrites(t *testing.T) {
+	s := New()
+	const stable = 2000
+	for i := 0; i < stable; i++ {
+		_, _ = s.Set(fmt.Sprintf("stable:%d", i), []float32{1, float32(i)})
+	}
+
+	// Churn other keys while scanning so slots are freed and reused
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("churn:%d:%d", w, i%500)
+				if i%2 == 0 {
+					_, _ = s.Set(key, []float32{1, 1})
+				} else {
+					s.Delete(key)
+				}
+				if i%100 == 0 {
+					_, _ = s.Set(fmt.Sprintf("stable:%d", i%stable), []float32{1, 2})
+				}
+			}
+		}(w)
+	}
+
+	seen, _ := scanAll(s, 7, func(key string) bool { return strings.HasPrefix(key, "stable:") })
+	close(stop)
+	wg.Wait()
+
+	for i := 0; i < stable; i++ {
+		if key := fmt.Sprintf("stable:%d", i); seen[key] != 1 {
+			t.Errorf("key %s returned %d times, want 1", key, seen[key])
+		}
+	}
+}
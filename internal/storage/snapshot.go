@@ -0,0 +1,207 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// snapshotMagic starts every snapshot written by WriteSnapshot
+const snapshotMagic = "VEXSNAP1"
+
+// maxSnapshotKey bounds the key length read from a snapshot, so a corrupt
+// one can't make LoadSnapshot allocate without limit
+const maxSnapshotKey = 1 << 30
+
+// snapshotKey is a key read from a snapshot, not yet stored
+type snapshotKey struct {
+	key       string
+	vec       []float32
+	version   uint64
+	expiresAt int64
+}
+
+// WriteSnapshot writes every stored key to w with its vector, TTL and
+// version, along with the shard clocks, so that LoadSnapshot recreates the
+// storage exactly, versions included. A passive storage includes its
+// expired keys too. Writes are held off until it returns.
+func (s *Storage) WriteSnapshot(w io.Writer) error {
+	var err error
+	s.Atomically(func(tx *Tx) {
+		err = tx.s.writeSnapshot(w)
+	})
+	return err
+}
+
+// writeSnapshot does the work of WriteSnapshot. Every shard must be locked.
+func (s *Storage) writeSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf [8]byte
+	putUint32 := func(v uint32) {
+		binary.LittleEndian.PutUint32(buf[:4], v)
+		_, _ = bw.Write(buf[:4])
+	}
+	putUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, _ = bw.Write(buf[:])
+	}
+
+	now := s.now()
+	_, _ = bw.WriteString(snapshotMagic)
+	putUint32(uint32(len(s.shards)))
+	putUint32(uint32(s.dim.Load()))
+	for _, sh := range s.shards {
+		var keys []*entry
+		for key, e := range sh.data {
+			if !s.lapsed(sh, key, now) {
+				keys = append(keys, e)
+			}
+		}
+		putUint64(sh.clock)
+		putUint64(uint64(len(keys)))
+		for _, e := range keys {
+			putUint32(uint32(len(e.key)))
+			_, _ = bw.WriteString(e.key)
+			putUint64(e.version)
+			putUint64(uint64(sh.expires[e.key]))
+			for _, v := range e.vec {
+				putUint32(math.Float32bits(v))
+			}
+		}
+	}
+	// bufio.Writer keeps the first error, so checking once is enough
+	return bw.Flush()
+}
+
+// LoadSnapshot replaces everything stored with the contents of a snapshot
+// written by WriteSnapshot. The snapshot must come from a storage with the
+// same number of shards, as versions are per shard. It is read in full
+// before anything changes, so on error the storage is left as it was.
+func (s *Storage) LoadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var buf [8]byte
+	readUint32 := func() (uint32, error) {
+		_, err := io.ReadFull(br, buf[:4])
+		return binary.LittleEndian.Uint32(buf[:4]), err
+	}
+	readUint64 := func() (uint64, error) {
+		_, err := io.ReadFull(br, buf[:])
+		return binary.LittleEndian.Uint64(buf[:]), err
+	}
+	corrupt := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return corrupt(err)
+	}
+	if string(magic) != snapshotMagic {
+		return errors.New("read snapshot: not a vex snapshot")
+	}
+	shards, err := readUint32()
+	if err != nil {
+		return corrupt(err)
+	}
+	if int(shards) != len(s.shards) {
+		return fmt.Errorf("snapshot has %d shards, storage has %d", shards, len(s.shards))
+	}
+	dim, err := readUint32()
+	if err != nil {
+		return corrupt(err)
+	}
+	if dim > math.MaxInt32 || (s.fixedDim != 0 && dim != 0 && int32(dim) != s.fixedDim) {
+		return fmt.Errorf("%w: expected %d, snapshot has %d", ErrDimensionMismatch, s.fixedDim, dim)
+	}
+
+	clocks := make([]uint64, shards)
+	keys := make([][]snapshotKey, shards)
+	for i := range keys {
+		if clocks[i], err = readUint64(); err != nil {
+			return corrupt(err)
+		}
+		n, err := readUint64()
+		if err != nil {
+			return corrupt(err)
+		}
+		for ; n > 0; n-- {
+			var k snapshotKey
+			size, err := readUint32()
+			if err != nil {
+				return corrupt(err)
+			}
+			if size > maxSnapshotKey {
+				return fmt.Errorf("read snapshot: key of %d bytes", size)
+			}
+			key := make([]byte, size)
+			if _, err := io.ReadFull(br, key); err != nil {
+				return corrupt(err)
+			}
+			k.key = string(key)
+			if s.shardIndex(k.key) != i {
+				return fmt.Errorf("read snapshot: key %q in the wrong shard", k.key)
+			}
+			if k.version, err = readUint64(); err != nil {
+				return corrupt(err)
+			}
+			at, err := readUint64()
+			if err != nil {
+				return corrupt(err)
+			}
+			k.expiresAt = int64(at)
+			k.vec = make([]float32, dim)
+			for j := range k.vec {
+				bits, err := readUint32()
+				if err != nil {
+					return corrupt(err)
+				}
+				k.vec[j] = math.Float32frombits(bits)
+			}
+			keys[i] = append(keys[i], k)
+		}
+	}
+
+	s.Atomically(func(tx *Tx) {
+		tx.Clear()
+		s.dim.Store(int32(dim))
+		if dim == 0 {
+			s.dim.Store(s.fixedDim)
+		}
+		now := s.now()
+		for i, sh := range s.shards {
+			for _, k := range keys[i] {
+				e := &entry{key: k.key, vec: k.vec, size: entrySize(k.key, len(k.vec))}
+				e.freq.Store(lfuInitVal)
+				e.access.Store(now)
+				sh.put(e)
+				e.version = k.version
+				if k.expiresAt != 0 {
+					sh.expires[k.key] = k.expiresAt
+				}
+				s.changed(OpSet, k.key, k.vec, k.expiresAt)
+			}
+			sh.clock = clocks[i]
+		}
+	})
+	return nil
+}
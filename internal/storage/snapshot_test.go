@@ -0,0 +1,118 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	s, clock := newTestStorage()
+	s.SetPassive(true)
+	_, _ = s.Set("a", []float32{3, 4})
+	_, _ = s.Set("a", []float32{1, 0})
+	_, _ = s.SetWithTTL("b", []float32{0, 1}, time.Minute)
+	_, _ = s.SetWithTTL("gone", []float32{0, 1}, time.Second)
+	_, _ = s.Set("deleted", []float32{1, 1})
+	s.Delete("deleted")
+	clock.Add(int64(time.Second))
+
+	var buf bytes.Buffer
+	if err := s.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+
+	restored, restoredClock := newTestStorage()
+	restored.SetPassive(true)
+	restoredClock.Store(clock.Load())
+	_, _ = restored.Set("stale", []float32{1, 0})
+	var changes []Change
+	restored.OnChange(func(c Change) { changes = append(changes, c) })
+	if err := restored.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if len(changes) != 4 || changes[0].Op != OpClear {
+		t.Errorf("LoadSnapshot() changes = %v, want a clear and 3 sets", changes)
+	}
+
+	if _, ok := restored.Get("stale"); ok {
+		t.Error("LoadSnapshot() kept a key the snapshot doesn't have")
+	}
+	for _, key := range []string{"a", "b", "gone"} {
+		want, wantVersion, _ := s.GetWithVersion(key)
+		got, version, _ := restored.GetWithVersion(key)
+		if version != wantVersion || len(got) != len(want) {
+			t.Errorf("restored %s = %v version %d, want %v version %d", key, got, version, want, wantVersion)
+		}
+	}
+	if ttl, _ := restored.TTL("b"); ttl != time.Minute-time.Second {
+		t.Errorf("restored TTL(b) = %v, want 59s", ttl)
+	}
+	// A passive storage keeps expired keys for the caller to remove, so
+	// the snapshot does too, with the same version
+	want, _ := s.ExpiredVersion("gone")
+	if v, ok := restored.ExpiredVersion("gone"); !ok || v != want {
+		t.Errorf("restored ExpiredVersion(gone) = %d, %v, want %d", v, ok, want)
+	}
+
+	// The clocks carry over, so the next writes get the same versions
+	_, _ = s.Set("deleted", []float32{1, 1})
+	_, _ = restored.Set("deleted", []float32{1, 1})
+	if got, want := restored.Version("deleted"), s.Version("deleted"); got != want {
+		t.Errorf("version after restore = %d, want %d", got, want)
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	s, _ := newTestStorage()
+	_, _ = s.Set("a", []float32{1, 0})
+	var buf bytes.Buffer
+	if err := s.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	fewerShards, _ := NewWithConfig(Config{Shards: 4})
+	fixedDim, _ := NewWithConfig(Config{Dimension: 3})
+	tests := []struct {
+		name    string
+		s       *Storage
+		data    []byte
+		wantErr error
+	}{
+		{"truncated", New(), snapshot[:len(snapshot)-1], nil},
+		{"not a snapshot", New(), []byte("garbage that is long enough"), nil},
+		{"shard count", fewerShards, snapshot, nil},
+		{"dimension", fixedDim, snapshot, ErrDimensionMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vec := make([]float32, max(tt.s.Dimension(), 1))
+			vec[0] = 1
+			_, _ = tt.s.Set("kept", vec)
+			before := tt.s.Count()
+			err := tt.s.LoadSnapshot(bytes.NewReader(tt.data))
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("LoadSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.s.Count() != before {
+				t.Error("a failed LoadSnapshot() changed the storage")
+			}
+		})
+	}
+}
@@ -16,60 +16,401 @@ package storage
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/uzqw/vex/internal/vector"
 )
 
 const (
-	// ShardCount is the number of shards to distribute keys across
+	// ShardCount is the default number of shards to distribute keys across
 	// 32 is a good balance between concurrency and memory overhead
 	ShardCount = 32
 
+	// MaxShards is the most shards a Storage can have, bounded by the
+	// cursor bits SCAN uses for the shard index
+	MaxShards = 1 << shardBits
+
 	// CacheLineSize is typically 64 bytes on modern CPUs
 	// We pad each shard to prevent false sharing between CPU cores
 	CacheLineSize = 64
 )
 
+// ErrDimensionMismatch is returned when a vector's length differs from the
+// dimension established by the first vector stored
+var ErrDimensionMismatch = errors.New("dimension mismatch")
+
 // shard represents a single shard with its own lock
 // The padding prevents false sharing when different cores access different shards
 type shard struct {
-	mu   sync.RWMutex
-	data map[string][]float32
-	_    [CacheLineSize - 16]byte // Padding to prevent false sharing (adjust based on struct size)
+	mu      sync.RWMutex
+	data    map[string]*entry
+	expires map[string]int64         // Expiry as Unix nanoseconds, only for keys with a TTL
+	used    atomic.Int64             // Approximate bytes held by the entries, see entrySize
+	count   atomic.Int64             // Number of entries, readable without the lock
+	slots   []*entry                 // Entries by stable position, for SCAN; nil marks a free slot
+	free    []int                    // Free positions in slots, reused before growing it
+	clock   uint64                   // Last version handed out in this shard, see entry.version
+	_       [CacheLineSize - 16]byte // Padding to prevent false sharing (adjust based on struct size)
+}
+
+// entry is a stored vector along with the access statistics used for eviction
+type entry struct {
+	key     string
+	vec     []float32
+	size    int64
+	slot    int           // Position in shard.slots
+	version uint64        // Shard clock at the key's last write; only increases, even across deletes
+	access  atomic.Int64  // Last access as Unix nanoseconds, for LRU
+	freq    atomic.Uint32 // Logarithmic access counter, for LFU
 }
 
 // Storage is a sharded, thread-safe in-memory vector storage
 // Uses multiple shards with individual locks to reduce lock contention
 type Storage struct {
-	shards [ShardCount]*shard
-	dim    atomic.Int32 // Expected vector dimension (0 means not set yet), lock-free
+	shards   []*shard
+	dim      atomic.Int32 // Expected vector dimension (0 means not set yet), lock-free
+	fixedDim int32        // Dimension set by Config, kept across Clear
+
+	// batchMu is held exclusively by writes spanning several shards and
+	// shared by searches, so a search never sees part of such a write.
+	// Single-key operations don't take it.
+	batchMu sync.RWMutex
+
+	now      func() int64     // Current time as Unix nanoseconds, replaceable in tests
+	onExpire func(key string) // Called for each key removed because its TTL passed
+	onChange func(Change)     // Called for every change to the stored vectors
+	passive  atomic.Bool      // Expiry and eviction are left to the caller, see SetPassive
+
+	maxMemory   atomic.Int64  // Memory limit in bytes, 0 for unlimited
+	policy      atomic.Int32  // EvictionPolicy applied when maxMemory is reached
+	evictCursor atomic.Uint32 // Shard the next eviction starts sampling from
+	onEvict     func(key string)
+}
+
+// Config holds the settings of a Storage made by NewWithConfig
+type Config struct {
+	// Shards is the number of shards, from 1 to MaxShards (default
+	// ShardCount)
+	Shards int
+
+	// Dimension fixes the vector dimension up front, including after
+	// Clear. 0 takes it from the first vector stored.
+	Dimension int
 }
 
-// New creates a new Storage instance
+// New creates a new Storage instance with the default settings
 func New() *Storage {
-	s := &Storage{}
-	for i := 0; i < ShardCount; i++ {
+	s, _ := NewWithConfig(Config{})
+	return s
+}
+
+// NewWithConfig creates a new Storage instance with the given settings
+func NewWithConfig(cfg Config) (*Storage, error) {
+	if cfg.Shards == 0 {
+		cfg.Shards = ShardCount
+	}
+	if cfg.Shards < 0 || cfg.Shards > MaxShards {
+		return nil, fmt.Errorf("shard count must be between 1 and %d, got %d", MaxShards, cfg.Shards)
+	}
+	if cfg.Dimension < 0 || cfg.Dimension > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid dimension %d", cfg.Dimension)
+	}
+
+	s := &Storage{
+		shards:   make([]*shard, cfg.Shards),
+		fixedDim: int32(cfg.Dimension),
+		now:      func() int64 { return time.Now().UnixNano() },
+	}
+	s.dim.Store(s.fixedDim)
+	for i := range s.shards {
 		s.shards[i] = &shard{
-			data: make(map[string][]float32),
+			data:    make(map[string]*entry),
+			expires: make(map[string]int64),
 		}
 	}
-	return s
+	return s, nil
 }
 
 // getShard returns the shard for a given key
 func (s *Storage) getShard(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+// Shards returns the number of shards
+func (s *Storage) Shards() int {
+	return len(s.shards)
+}
+
+// ShardOf returns the index, from 0 to Shards()-1, of the shard that holds
+// key
+func (s *Storage) ShardOf(key string) int {
+	return s.shardIndex(key)
+}
+
+// shardIndex returns the index of the shard holding key
+func (s *Storage) shardIndex(key string) int {
 	h := fnv.New32a()
 	h.Write([]byte(key))
-	return s.shards[h.Sum32()%ShardCount]
+	return int(h.Sum32() % uint32(len(s.shards)))
 }
 
-// Set stores a vector with the given key
+// Set stores a vector with the given key, removing any TTL it had, and
+// reports whether the key was inserted rather than updated
 // Automatically normalizes the vector for optimized cosine similarity computation
-func (s *Storage) Set(key string, values []float32) error {
+func (s *Storage) Set(key string, values []float32) (bool, error) {
+	return s.SetWithTTL(key, values, 0)
+}
+
+// SetWithTTL stores a vector that expires after ttl. A ttl of zero stores it
+// without expiry, like Set.
+func (s *Storage) SetWithTTL(key string, values []float32, ttl time.Duration) (bool, error) {
+	e, err := s.newEntry(key, values)
+	if err != nil {
+		return false, err
+	}
+
+	// Make room before taking the lock, since eviction may pick this shard
+	if err := s.reserve(s.growth(false, e), false); err != nil {
+		return false, err
+	}
+
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.store(shard, e, s.deadline(ttl)), nil
+}
+
+// SetOptions holds the optional parts of a write made with SetWithOptions.
+// The zero value writes unconditionally without a TTL.
+type SetOptions struct {
+	// TTL is the time to live of the key, or zero for none
+	TTL time.Duration
+
+	// ExpireAt is when the key expires, used instead of TTL if set
+	ExpireAt time.Time
+
+	// NX only writes the key if it does not exist; XX only if it does
+	NX, XX bool
+
+	// When CheckVersion is set the key is only written if its version is
+	// Version, with 0 meaning that the key must not exist
+	CheckVersion bool
+	Version      uint64
+}
+
+// SetResult is the outcome of SetWithOptions
+type SetResult struct {
+	// Written is false if a condition in the options was not met, in
+	// which case nothing changed
+	Written bool
+
+	// Inserted reports whether the key was created rather than updated
+	Inserted bool
+
+	// Version is the key's version after the call, 0 if it does not exist
+	Version uint64
+}
+
+// SetWithOptions stores a vector if the conditions in opts hold, checking
+// them and writing under the same lock, so concurrent writers can use
+// versions to avoid overwriting each other's data.
+func (s *Storage) SetWithOptions(key string, values []float32, opts SetOptions) (SetResult, error) {
+	e, err := s.newEntry(key, values)
+	if err != nil {
+		return SetResult{}, err
+	}
+	if err := s.reserve(s.growth(false, e), false); err != nil {
+		return SetResult{}, err
+	}
+
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return s.storeIf(shard, e, opts), nil
+}
+
+// storeIf puts e into sh if the conditions in opts hold. The shard write
+// lock must be held.
+func (s *Storage) storeIf(sh *shard, e *entry, opts SetOptions) SetResult {
+	var current uint64
+	if old, ok := s.target(sh, e.key, s.now()); ok {
+		current = old.version
+	}
+	exists := current != 0
+	if (opts.NX && exists) || (opts.XX && !exists) || (opts.CheckVersion && current != opts.Version) {
+		return SetResult{Version: current}
+	}
+
+	at := s.deadline(opts.TTL)
+	if !opts.ExpireAt.IsZero() {
+		at = deadline(opts.ExpireAt)
+	}
+	inserted := s.store(sh, e, at)
+	return SetResult{Written: true, Inserted: inserted, Version: e.version}
+}
+
+// deadline returns when a key written now with ttl expires, as Unix
+// nanoseconds, or 0 if ttl is zero
+func (s *Storage) deadline(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return s.now() + int64(ttl)
+}
+
+// store puts e into sh to expire at the given Unix nanoseconds, or never if
+// at is 0, and reports whether the key was inserted rather than updated. The
+// shard write lock must be held.
+func (s *Storage) store(sh *shard, e *entry, at int64) bool {
+	now := s.now()
+	e.access.Store(now)
+	// Overwriting an expired key counts as an insert
+	inserted := sh.put(e) || s.lapsed(sh, e.key, now)
+	if at != 0 {
+		sh.expires[e.key] = at
+	} else {
+		delete(sh.expires, e.key)
+	}
+	s.changed(OpSet, e.key, e.vec, at)
+	return inserted
+}
+
+// SetMany stores several vectors at once, removing any TTLs they had. Every
+// vector is validated before anything is stored, so either all of them are
+// stored or, on error, none are. The affected shards are all locked, each
+// once, while the writes are applied, and a search never sees part of a batch.
+// It returns the number of keys inserted rather than updated.
+func (s *Storage) SetMany(keys []string, values [][]float32) (int, error) {
+	entries, err := s.newEntries(keys, values)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.reserve(s.growth(false, entries...), false); err != nil {
+		return 0, err
+	}
+
+	// Group the writes by shard
+	byShard := make([][]*entry, len(s.shards))
+	for _, e := range entries {
+		idx := s.shardIndex(e.key)
+		byShard[idx] = append(byShard[idx], e)
+	}
+
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	// Lock in index order so concurrent batches cannot deadlock
+	for i := range byShard {
+		if len(byShard[i]) > 0 {
+			s.shards[i].mu.Lock()
+		}
+	}
+
+	inserted := 0
+	for i, batch := range byShard {
+		for _, e := range batch {
+			if s.store(s.shards[i], e, 0) {
+				inserted++
+			}
+		}
+	}
+
+	for i := range byShard {
+		if len(byShard[i]) > 0 {
+			s.shards[i].mu.Unlock()
+		}
+	}
+	return inserted, nil
+}
+
+// Write is one of the writes given to SetBatch
+type Write struct {
+	Key    string
+	Values []float32
+
+	// TTL is the time to live of the key, or zero for none
+	TTL time.Duration
+}
+
+// WriteResult is the outcome of one of the writes given to SetBatch
+type WriteResult struct {
+	// Inserted reports whether the key was created rather than updated
+	Inserted bool
+
+	// Err is set if the write failed, in which case nothing changed
+	Err error
+}
+
+// SetBatch stores independent writes, locking each shard they touch once
+// for all of its writes rather than once per write. Unlike SetMany, each
+// write succeeds or fails on its own and a search may see some of the
+// writes before the others. Writes to the same key are applied in order.
+func (s *Storage) SetBatch(writes []Write) []WriteResult {
+	results := make([]WriteResult, len(writes))
+	entries := make([]*entry, len(writes))
+	byShard := make([][]int, len(s.shards))
+	for i, w := range writes {
+		e, err := s.newEntry(w.Key, w.Values)
+		if err == nil {
+			err = s.reserve(s.growth(false, e), false)
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		entries[i] = e
+		idx := s.shardIndex(w.Key)
+		byShard[idx] = append(byShard[idx], i)
+	}
+
+	for idx, batch := range byShard {
+		if len(batch) == 0 {
+			continue
+		}
+		sh := s.shards[idx]
+		sh.mu.Lock()
+		for _, i := range batch {
+			results[i].Inserted = s.store(sh, entries[i], s.deadline(writes[i].TTL))
+		}
+		sh.mu.Unlock()
+	}
+	return results
+}
+
+// newEntries validates and normalizes a batch of vectors
+func (s *Storage) newEntries(keys []string, values [][]float32) ([]*entry, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("got %d keys but %d vectors", len(keys), len(values))
+	}
+
+	// Check the batch agrees with itself before it can set the dimension
+	for i := range values {
+		if len(values[i]) != len(values[0]) {
+			return nil, fmt.Errorf("vector for key %q: %w: expected %d, got %d", keys[i], ErrDimensionMismatch, len(values[0]), len(values[i]))
+		}
+	}
+
+	entries := make([]*entry, len(keys))
+	for i, key := range keys {
+		e, err := s.newEntry(key, values[i])
+		if err != nil {
+			return nil, fmt.Errorf("vector for key %q: %w", key, err)
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// newEntry validates and normalizes a vector for storage under key
+func (s *Storage) newEntry(key string, values []float32) (*entry, error) {
 	// Check dimension consistency using atomic operations (lock-free)
 	dim := int(s.dim.Load())
 	if dim == 0 {
@@ -78,31 +419,96 @@ func (s *Storage) Set(key string, values []float32) error {
 		dim = int(s.dim.Load())
 	}
 	if len(values) != dim {
-		return fmt.Errorf("dimension mismatch: expected %d, got %d", dim, len(values))
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, dim, len(values))
 	}
 
 	// Normalize the vector for optimized cosine similarity
 	normalized, err := vector.Normalize(values)
 	if err != nil {
-		return fmt.Errorf("failed to normalize vector: %w", err)
+		return nil, fmt.Errorf("failed to normalize vector: %w", err)
 	}
 
-	shard := s.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-
-	shard.data[key] = normalized
-	return nil
+	e := &entry{key: key, vec: normalized, size: entrySize(key, len(normalized))}
+	e.freq.Store(lfuInitVal)
+	return e, nil
 }
 
 // Get retrieves a vector by key
 func (s *Storage) Get(key string) ([]float32, bool) {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	now := s.now()
+	e, ok := shard.data[key]
+	expired := ok && shard.expired(key, now)
+	if ok && !expired {
+		e.touch(now)
+	}
+	shard.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	if expired {
+		s.expireKey(shard, key)
+		return nil, false
+	}
+	return e.vec, true
+}
+
+// GetWithVersion is like Get but also returns the key's version, read
+// together with the vector
+func (s *Storage) GetWithVersion(key string) ([]float32, uint64, bool) {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	now := s.now()
+	e, ok := shard.data[key]
+	expired := ok && shard.expired(key, now)
+	var vec []float32
+	var version uint64
+	if ok && !expired {
+		e.touch(now)
+		vec, version = e.vec, e.version
+	}
+	shard.mu.RUnlock()
+
+	if expired {
+		s.expireKey(shard, key)
+		return nil, 0, false
+	}
+	return vec, version, ok
+}
+
+// Version returns the version of key, which changes on every write to it,
+// or 0 if the key does not exist. Versions of a key only ever increase, so
+// comparing against an earlier Version tells whether it was modified since.
+func (s *Storage) Version(key string) uint64 {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	return shard.version(key, s.now())
+}
+
+// Stamp returns the version of key, as Version does, together with the
+// clock of its shard. The clock advances on every write to a key of the
+// shard, so while key is absent an unchanged clock shows that it was not
+// created since, even if it was deleted again.
+func (s *Storage) Stamp(key string) (version, clock uint64) {
 	shard := s.getShard(key)
 	shard.mu.RLock()
 	defer shard.mu.RUnlock()
 
-	val, ok := shard.data[key]
-	return val, ok
+	return shard.version(key, s.now()), shard.clock
+}
+
+// version returns the version of key, or 0 if it does not exist or has
+// expired. The shard lock must be held.
+func (sh *shard) version(key string, now int64) uint64 {
+	e, ok := sh.data[key]
+	if !ok || sh.expired(key, now) {
+		return 0
+	}
+	return e.version
 }
 
 // Delete removes a vector by key
@@ -111,28 +517,125 @@ func (s *Storage) Delete(key string) bool {
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	_, exists := shard.data[key]
+	return s.delete(shard, key)
+}
+
+// delete removes key from sh, reporting whether it existed and had not
+// expired. The shard write lock must be held.
+func (s *Storage) delete(sh *shard, key string) bool {
+	if _, ok := s.target(sh, key, s.now()); !ok {
+		return false
+	}
+	sh.remove(key)
+	s.changed(OpDelete, key, nil, 0)
+	return true
+}
+
+// live returns the entry for key if it exists and has not expired, removing
+// it if it has, unless the storage is passive. The shard write lock must be
+// held.
+func (s *Storage) live(sh *shard, key string, now int64) (*entry, bool) {
+	e, ok := sh.data[key]
+	if !ok {
+		return nil, false
+	}
+	if sh.expired(key, now) {
+		if !s.passive.Load() {
+			s.removeExpired(sh, key)
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+// target returns the entry a write to key acts on: the live one, or in a
+// passive storage whichever is stored, expired or not. The shard write lock
+// must be held.
+func (s *Storage) target(sh *shard, key string, now int64) (*entry, bool) {
+	if s.passive.Load() {
+		e, ok := sh.data[key]
+		return e, ok
+	}
+	return s.live(sh, key, now)
+}
+
+// put stores e under e.key, replacing any existing entry in place so that
+// a SCAN in progress keeps its position, and reports whether the key is new.
+// The shard write lock must be held.
+func (sh *shard) put(e *entry) bool {
+	old, exists := sh.data[e.key]
 	if exists {
-		delete(shard.data, key)
+		sh.used.Add(-old.size)
+		e.slot = old.slot
+	} else if n := len(sh.free); n > 0 {
+		e.slot = sh.free[n-1]
+		sh.free = sh.free[:n-1]
+	} else {
+		e.slot = len(sh.slots)
+		sh.slots = append(sh.slots, nil)
+	}
+	sh.slots[e.slot] = e
+	sh.data[e.key] = e
+	sh.bump(e)
+	sh.used.Add(e.size)
+	if !exists {
+		sh.count.Add(1)
 	}
-	return exists
+	return !exists
 }
 
-// Count returns the total number of vectors stored
+// bump gives e the shard's next version. The shard write lock must be held.
+func (sh *shard) bump(e *entry) {
+	sh.clock++
+	e.version = sh.clock
+}
+
+// remove deletes key and its TTL from the shard, releasing its accounted
+// memory. The shard write lock must be held.
+func (sh *shard) remove(key string) {
+	if e, ok := sh.data[key]; ok {
+		sh.used.Add(-e.size)
+		sh.slots[e.slot] = nil
+		sh.free = append(sh.free, e.slot)
+		sh.count.Add(-1)
+		delete(sh.data, key)
+	}
+	delete(sh.expires, key)
+}
+
+// Count returns the total number of vectors stored, summed from per-shard
+// counters without taking any lock. Expired keys are counted until they are
+// reclaimed, as with Redis' DBSIZE.
 func (s *Storage) Count() int {
-	count := 0
-	for i := 0; i < ShardCount; i++ {
-		shard := s.shards[i]
-		shard.mu.RLock()
-		count += len(shard.data)
-		shard.mu.RUnlock()
+	var count int64
+	for i := 0; i < len(s.shards); i++ {
+		count += s.shards[i].count.Load()
 	}
-	return count
+	return int(count)
 }
 
 // Search finds the top-K most similar vectors to the query vector
 // Uses concurrent scanning across shards for better performance
 func (s *Storage) Search(query []float32, k int) ([]vector.SearchResult, error) {
+	return s.SearchFiltered(query, k, nil)
+}
+
+// SearchFiltered is like Search but only considers keys for which filter
+// returns true. A nil filter considers every key.
+func (s *Storage) SearchFiltered(query []float32, k int, filter func(key string) bool) ([]vector.SearchResult, error) {
+	s.batchMu.RLock()
+	defer s.batchMu.RUnlock()
+
+	return s.search(query, k, filter, false)
+}
+
+// search scans every shard concurrently for the top-K matches. Unless
+// locked is set, each shard's read lock is taken while it is scanned.
+func (s *Storage) search(query []float32, k int, filter func(key string) bool, locked bool) ([]vector.SearchResult, error) {
+	if dim := int(s.dim.Load()); dim != 0 && len(query) != dim {
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, dim, len(query))
+	}
+
 	// Normalize query vector for optimized comparison with stored normalized vectors
 	normalizedQuery, err := vector.Normalize(query)
 	if err != nil {
@@ -144,35 +647,24 @@ func (s *Storage) Search(query []float32, k int) ([]vector.SearchResult, error)
 		results []vector.SearchResult
 		err     error
 	}
-	resultChan := make(chan shardResult, ShardCount)
+	resultChan := make(chan shardResult, len(s.shards))
+	now := s.now()
 
 	// Launch concurrent search across all shards
 	var wg sync.WaitGroup
-	for i := 0; i < ShardCount; i++ {
+	for i := 0; i < len(s.shards); i++ {
 		wg.Add(1)
 		go func(shardIdx int) {
 			defer wg.Done()
 
 			shard := s.shards[shardIdx]
-			shard.mu.RLock()
-			defer shard.mu.RUnlock()
-
-			var results []vector.SearchResult
-			for key, vec := range shard.data {
-				// Since both vectors are normalized, dot product = cosine similarity
-				similarity, err := vector.DotProduct(normalizedQuery, vec)
-				if err != nil {
-					resultChan <- shardResult{err: err}
-					return
-				}
-
-				results = append(results, vector.SearchResult{
-					Key:        key,
-					Similarity: similarity,
-				})
+			if !locked {
+				shard.mu.RLock()
+				defer shard.mu.RUnlock()
 			}
 
-			resultChan <- shardResult{results: results}
+			results, err := shard.similar(normalizedQuery, filter, now)
+			resultChan <- shardResult{results: results, err: err}
 		}(i)
 	}
 
@@ -192,34 +684,132 @@ func (s *Storage) Search(query []float32, k int) ([]vector.SearchResult, error)
 		}
 
 		for _, res := range result.results {
-			if h.Len() < k {
-				heap.Push(h, res)
-			} else if res.Similarity > (*h)[0].Similarity {
-				// Replace the minimum if we found a better match
-				heap.Pop(h)
-				heap.Push(h, res)
-			}
+			pushTopK(h, res, k)
+		}
+	}
+
+	return popTopK(h), nil
+}
+
+// SearchShards is like SearchFiltered but only scans the listed shards, one
+// after the other in the calling goroutine, keeping a local top-K. Callers
+// that spread the shards over their own workers merge the partial results,
+// and should search from within Consistent with SearchShardsLocked so that
+// the workers together see each atomic write entirely or not at all.
+func (s *Storage) SearchShards(query []float32, k int, filter func(key string) bool, shards []int) ([]vector.SearchResult, error) {
+	s.batchMu.RLock()
+	defer s.batchMu.RUnlock()
+
+	return s.SearchShardsLocked(query, k, filter, shards)
+}
+
+// Consistent runs fn while no write spanning several shards (SetMany,
+// Atomically or a Rename between shards) can commit. Searches made by fn with
+// SearchShardsLocked, from any number of goroutines, see each such write
+// entirely or not at all. fn must not call methods that take the batch lock
+// themselves, such as SearchShards, SetMany or Atomically.
+func (s *Storage) Consistent(fn func()) {
+	s.batchMu.RLock()
+	defer s.batchMu.RUnlock()
+
+	fn()
+}
+
+// SearchShardsLocked is SearchShards for callers within Consistent. It only
+// takes the read locks of the shards it scans.
+func (s *Storage) SearchShardsLocked(query []float32, k int, filter func(key string) bool, shards []int) ([]vector.SearchResult, error) {
+	if dim := int(s.dim.Load()); dim != 0 && len(query) != dim {
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, dim, len(query))
+	}
+
+	normalizedQuery, err := vector.Normalize(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize query: %w", err)
+	}
+
+	now := s.now()
+	h := &vector.TopKHeap{}
+	for _, i := range shards {
+		shard := s.shards[i]
+		shard.mu.RLock()
+		results, err := shard.similar(normalizedQuery, filter, now)
+		shard.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			pushTopK(h, res, k)
+		}
+	}
+
+	return popTopK(h), nil
+}
+
+// similar scores every live key in the shard accepted by filter against the
+// normalized query. The shard lock must be held.
+func (sh *shard) similar(query []float32, filter func(key string) bool, now int64) ([]vector.SearchResult, error) {
+	var results []vector.SearchResult
+	for key, e := range sh.data {
+		if filter != nil && !filter(key) {
+			continue
+		}
+		// Expired keys may not have been reclaimed yet
+		if sh.expired(key, now) {
+			continue
+		}
+
+		// Since both vectors are normalized, dot product = cosine similarity
+		similarity, err := vector.DotProduct(query, e.vec)
+		if err != nil {
+			return nil, err
 		}
+
+		results = append(results, vector.SearchResult{
+			Key:        key,
+			Similarity: similarity,
+		})
 	}
+	return results, nil
+}
+
+// pushTopK adds res to the min-heap h, keeping at most k results
+func pushTopK(h *vector.TopKHeap, res vector.SearchResult, k int) {
+	if h.Len() < k {
+		heap.Push(h, res)
+	} else if k > 0 && res.Similarity > (*h)[0].Similarity {
+		// Replace the minimum if we found a better match
+		heap.Pop(h)
+		heap.Push(h, res)
+	}
+}
 
-	// Extract results and sort in descending order of similarity
+// popTopK empties h into a slice sorted by descending similarity
+func popTopK(h *vector.TopKHeap) []vector.SearchResult {
 	results := make([]vector.SearchResult, h.Len())
 	for i := len(results) - 1; i >= 0; i-- {
 		results[i] = heap.Pop(h).(vector.SearchResult)
 	}
-
-	return results, nil
+	return results
 }
 
-// Clear removes all vectors from storage
+// Clear removes all vectors from storage. Every shard is locked at once, so
+// no write lands between the shards being emptied.
 func (s *Storage) Clear() {
-	for i := 0; i < ShardCount; i++ {
-		shard := s.shards[i]
-		shard.mu.Lock()
-		shard.data = make(map[string][]float32)
-		shard.mu.Unlock()
-	}
-	s.dim.Store(0)
+	s.Atomically(func(tx *Tx) {
+		tx.Clear()
+	})
+}
+
+// reset removes every key from the shard. The clock is kept so
+// that versions keep increasing for keys written again later. The shard
+// write lock must be held.
+func (sh *shard) reset() {
+	sh.data = make(map[string]*entry)
+	sh.expires = make(map[string]int64)
+	sh.used.Store(0)
+	sh.count.Store(0)
+	sh.slots = nil
+	sh.free = nil
 }
 
 // Dimension returns the expected vector dimension (0 if no vectors stored yet)
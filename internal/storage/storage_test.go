@@ -15,16 +15,20 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/vector"
 )
 
 func TestStorageBasicOperations(t *testing.T) {
 	s := New()
 
 	t.Run("Set and Get", func(t *testing.T) {
-		err := s.Set("key1", []float32{0.1, 0.2, 0.3})
+		_, err := s.Set("key1", []float32{0.1, 0.2, 0.3})
 		if err != nil {
 			t.Fatalf("Set() error = %v", err)
 		}
@@ -46,7 +50,7 @@ func TestStorageBasicOperations(t *testing.T) {
 	})
 
 	t.Run("Delete", func(t *testing.T) {
-		_ = s.Set("to-delete", []float32{0.1, 0.2, 0.3})
+		_, _ = s.Set("to-delete", []float32{0.1, 0.2, 0.3})
 		deleted := s.Delete("to-delete")
 		if !deleted {
 			t.Error("Delete() returned false, want true")
@@ -66,25 +70,81 @@ func TestStorageBasicOperations(t *testing.T) {
 	})
 }
 
+func TestStorageConfig(t *testing.T) {
+	for _, shards := range []int{-1, MaxShards + 1} {
+		if _, err := NewWithConfig(Config{Shards: shards}); err == nil {
+			t.Errorf("NewWithConfig(Shards: %d) succeeded, want an error", shards)
+		}
+	}
+
+	s, err := NewWithConfig(Config{Shards: MaxShards, Dimension: 2})
+	if err != nil {
+		t.Fatalf("NewWithConfig() error = %v", err)
+	}
+	if s.Shards() != MaxShards || s.Dimension() != 2 {
+		t.Fatalf("Shards() = %d, Dimension() = %d, want %d and 2", s.Shards(), s.Dimension(), MaxShards)
+	}
+	if _, err := s.Set("key", []float32{1, 2, 3}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Set() with the wrong dimension error = %v, want ErrDimensionMismatch", err)
+	}
+
+	// Every key is found again across all the shards
+	for i := 0; i < 2000; i++ {
+		if _, err := s.Set(fmt.Sprintf("key%d", i), []float32{1, float32(i)}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for cursor := uint64(0); ; {
+		keys, cursor = s.Scan(cursor, 100, nil)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 2000 {
+		t.Errorf("Scan() found %d keys, want 2000", len(seen))
+	}
+	results, err := s.Search([]float32{1, 0}, 1)
+	if err != nil || len(results) != 1 || results[0].Key != "key0" {
+		t.Errorf("Search() = %v, %v, want key0", results, err)
+	}
+
+	// The configured dimension outlives Clear
+	s.Clear()
+	if s.Dimension() != 2 {
+		t.Errorf("Dimension() after Clear() = %d, want 2", s.Dimension())
+	}
+}
+
 func TestStorageDimensionConsistency(t *testing.T) {
 	s := New()
 
 	// First vector sets the dimension
-	err := s.Set("key1", []float32{0.1, 0.2, 0.3})
+	_, err := s.Set("key1", []float32{0.1, 0.2, 0.3})
 	if err != nil {
 		t.Fatalf("First Set() error = %v", err)
 	}
 
 	// Same dimension should work
-	err = s.Set("key2", []float32{0.4, 0.5, 0.6})
+	_, err = s.Set("key2", []float32{0.4, 0.5, 0.6})
 	if err != nil {
 		t.Fatalf("Second Set() with same dim error = %v", err)
 	}
 
 	// Different dimension should fail
-	err = s.Set("key3", []float32{0.1, 0.2})
-	if err == nil {
-		t.Error("Set() with different dimension should return error")
+	_, err = s.Set("key3", []float32{0.1, 0.2})
+	if !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Set() with different dimension error = %v, want ErrDimensionMismatch", err)
+	}
+
+	// Queries are held to the same dimension
+	_, err = s.Search([]float32{0.1, 0.2}, 1)
+	if !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Search() with different dimension error = %v, want ErrDimensionMismatch", err)
 	}
 }
 
@@ -95,8 +155,8 @@ func TestStorageCount(t *testing.T) {
 		t.Errorf("Empty storage Count() = %d, want 0", s.Count())
 	}
 
-	_ = s.Set("key1", []float32{0.1, 0.2, 0.3})
-	_ = s.Set("key2", []float32{0.4, 0.5, 0.6})
+	_, _ = s.Set("key1", []float32{0.1, 0.2, 0.3})
+	_, _ = s.Set("key2", []float32{0.4, 0.5, 0.6})
 
 	if s.Count() != 2 {
 		t.Errorf("Count() = %d, want 2", s.Count())
@@ -108,11 +168,99 @@ func TestStorageCount(t *testing.T) {
 	}
 }
 
+func TestStorageSetReportsInsert(t *testing.T) {
+	s, clock := newTestStorage()
+
+	if inserted, _ := s.Set("key", []float32{1, 0}); !inserted {
+		t.Error("Set() of a new key reported an update")
+	}
+	if inserted, _ := s.Set("key", []float32{0, 1}); inserted {
+		t.Error("Set() of an existing key reported an insert")
+	}
+	if s.Count() != 1 {
+		t.Errorf("Count() after overwrite = %d, want 1", s.Count())
+	}
+
+	_, _ = s.SetWithTTL("key", []float32{1, 0}, time.Second)
+	clock.Add(int64(time.Second))
+	if inserted, _ := s.Set("key", []float32{1, 0}); !inserted {
+		t.Error("Set() over an expired key reported an update")
+	}
+
+	n, err := s.SetMany([]string{"key", "a", "b", "a"}, [][]float32{{1, 0}, {1, 0}, {1, 0}, {1, 0}})
+	if err != nil || n != 2 {
+		t.Errorf("SetMany() = %d, %v, want 2 inserts", n, err)
+	}
+	if s.Count() != 3 {
+		t.Errorf("Count() = %d, want 3", s.Count())
+	}
+}
+
+func TestStorageSetWithOptions(t *testing.T) {
+	s, clock := newTestStorage()
+	vec := []float32{1, 0}
+
+	res, err := s.SetWithOptions("key", vec, SetOptions{XX: true})
+	if err != nil || res.Written || res.Version != 0 {
+		t.Errorf("XX on a missing key = %+v, %v, want not written", res, err)
+	}
+
+	res, _ = s.SetWithOptions("key", vec, SetOptions{NX: true, TTL: time.Second})
+	if !res.Written || !res.Inserted || res.Version == 0 {
+		t.Fatalf("NX on a missing key = %+v, want inserted with a version", res)
+	}
+	v1 := res.Version
+
+	res, _ = s.SetWithOptions("key", vec, SetOptions{NX: true})
+	if res.Written || res.Version != v1 {
+		t.Errorf("NX on an existing key = %+v, want not written at version %d", res, v1)
+	}
+
+	t.Run("IFVERSION", func(t *testing.T) {
+		res, _ := s.SetWithOptions("key", []float32{0, 1}, SetOptions{CheckVersion: true, Version: v1 + 100})
+		if res.Written {
+			t.Error("write with a stale version succeeded")
+		}
+		if got, _, _ := s.GetWithVersion("key"); got[0] != 1 {
+			t.Error("failed conditional write changed the vector")
+		}
+
+		res, _ = s.SetWithOptions("key", []float32{0, 1}, SetOptions{CheckVersion: true, Version: v1})
+		if !res.Written || res.Inserted || res.Version <= v1 {
+			t.Errorf("write with the current version = %+v, want an update to a newer version", res)
+		}
+		got, version, ok := s.GetWithVersion("key")
+		if !ok || got[1] != 1 || version != res.Version {
+			t.Errorf("GetWithVersion() = %v, %d, %v, want the new vector at version %d", got, version, ok, res.Version)
+		}
+	})
+
+	t.Run("version 0 means missing", func(t *testing.T) {
+		if res, _ := s.SetWithOptions("key", vec, SetOptions{CheckVersion: true}); res.Written {
+			t.Error("IFVERSION 0 overwrote an existing key")
+		}
+		if res, _ := s.SetWithOptions("other", vec, SetOptions{CheckVersion: true}); !res.Written {
+			t.Error("IFVERSION 0 did not create a missing key")
+		}
+	})
+
+	t.Run("expired keys are missing", func(t *testing.T) {
+		_, _ = s.SetWithTTL("temp", vec, time.Second)
+		clock.Add(int64(time.Second))
+		if _, _, ok := s.GetWithVersion("temp"); ok {
+			t.Error("GetWithVersion() found an expired key")
+		}
+		if res, _ := s.SetWithOptions("temp", vec, SetOptions{NX: true}); !res.Written || !res.Inserted {
+			t.Errorf("NX over an expired key = %+v, want inserted", res)
+		}
+	})
+}
+
 func TestStorageClear(t *testing.T) {
 	s := New()
 
-	_ = s.Set("key1", []float32{0.1, 0.2, 0.3})
-	_ = s.Set("key2", []float32{0.4, 0.5, 0.6})
+	_, _ = s.Set("key1", []float32{0.1, 0.2, 0.3})
+	_, _ = s.Set("key2", []float32{0.4, 0.5, 0.6})
 
 	s.Clear()
 
@@ -129,10 +277,10 @@ func TestStorageSearch(t *testing.T) {
 	s := New()
 
 	// Insert some vectors
-	_ = s.Set("vec1", []float32{1.0, 0.0, 0.0})
-	_ = s.Set("vec2", []float32{0.9, 0.1, 0.0})
-	_ = s.Set("vec3", []float32{0.0, 1.0, 0.0})
-	_ = s.Set("vec4", []float32{0.0, 0.0, 1.0})
+	_, _ = s.Set("vec1", []float32{1.0, 0.0, 0.0})
+	_, _ = s.Set("vec2", []float32{0.9, 0.1, 0.0})
+	_, _ = s.Set("vec3", []float32{0.0, 1.0, 0.0})
+	_, _ = s.Set("vec4", []float32{0.0, 0.0, 1.0})
 
 	t.Run("search similar vectors", func(t *testing.T) {
 		query := []float32{1.0, 0.0, 0.0}
@@ -151,6 +299,18 @@ func TestStorageSearch(t *testing.T) {
 		}
 	})
 
+	t.Run("search with filter", func(t *testing.T) {
+		query := []float32{1.0, 0.0, 0.0}
+		results, err := s.SearchFiltered(query, 2, func(key string) bool { return key != "vec1" })
+		if err != nil {
+			t.Fatalf("SearchFiltered() error = %v", err)
+		}
+
+		if len(results) != 2 || results[0].Key != "vec2" {
+			t.Errorf("SearchFiltered() = %v, want vec2 first and vec1 excluded", results)
+		}
+	})
+
 	t.Run("search with k larger than data", func(t *testing.T) {
 		query := []float32{1.0, 0.0, 0.0}
 		results, err := s.Search(query, 10)
@@ -162,6 +322,36 @@ func TestStorageSearch(t *testing.T) {
 			t.Errorf("Search() returned %d results, want 4 (all vectors)", len(results))
 		}
 	})
+
+	t.Run("search selected shards", func(t *testing.T) {
+		query := []float32{1.0, 0.0, 0.0}
+		only := []int{s.ShardOf("vec2"), s.ShardOf("vec3")}
+		results, err := s.SearchShards(query, 10, nil, only)
+		if err != nil {
+			t.Fatalf("SearchShards() error = %v", err)
+		}
+
+		for _, res := range results {
+			if i := s.ShardOf(res.Key); i != only[0] && i != only[1] {
+				t.Errorf("SearchShards() returned %s from shard %d", res.Key, i)
+			}
+		}
+		if len(results) == 0 || results[0].Key != "vec2" {
+			t.Errorf("SearchShards() = %v, want vec2 first", results)
+		}
+
+		var locked []vector.SearchResult
+		s.Consistent(func() {
+			locked, err = s.SearchShardsLocked(query, 10, nil, only)
+		})
+		if err != nil || len(locked) != len(results) {
+			t.Errorf("SearchShardsLocked() = %v, %v, want %v", locked, err, results)
+		}
+
+		if _, err := s.SearchShards([]float32{1, 0}, 1, nil, only); err == nil {
+			t.Error("SearchShards() with wrong dimension should fail")
+		}
+	})
 }
 
 func TestStorageConcurrency(t *testing.T) {
@@ -177,7 +367,7 @@ func TestStorageConcurrency(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < opsPerGoroutine; j++ {
 				key := fmt.Sprintf("key-%d-%d", id, j)
-				_ = s.Set(key, []float32{float32(id), float32(j), 0.5})
+				_, _ = s.Set(key, []float32{float32(id), float32(j), 0.5})
 			}
 		}(i)
 	}
@@ -216,7 +406,7 @@ func BenchmarkStorageSet(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = s.Set(fmt.Sprintf("key-%d", i), vec)
+		_, _ = s.Set(fmt.Sprintf("key-%d", i), vec)
 	}
 }
 
@@ -229,7 +419,7 @@ func BenchmarkStorageGet(b *testing.B) {
 
 	// Populate storage
 	for i := 0; i < 10000; i++ {
-		_ = s.Set(fmt.Sprintf("key-%d", i), vec)
+		_, _ = s.Set(fmt.Sprintf("key-%d", i), vec)
 	}
 
 	b.ResetTimer()
@@ -247,7 +437,7 @@ func BenchmarkStorageSearch(b *testing.B) {
 
 	// Populate storage
 	for i := 0; i < 1000; i++ {
-		_ = s.Set(fmt.Sprintf("key-%d", i), vec)
+		_, _ = s.Set(fmt.Sprintf("key-%d", i), vec)
 	}
 
 	query := make([]float32, 128)
@@ -260,3 +450,119 @@ func BenchmarkStorageSearch(b *testing.B) {
 		_, _ = s.Search(query, 10)
 	}
 }
+
+func TestStorageSetMany(t *testing.T) {
+	t.Run("stores every vector", func(t *testing.T) {
+		s := New()
+		keys := []string{"a", "b", "c", "a"}
+		values := [][]float32{{1, 0}, {0, 1}, {1, 1}, {2, 0}}
+		if _, err := s.SetMany(keys, values); err != nil {
+			t.Fatalf("SetMany() error = %v", err)
+		}
+		if s.Count() != 3 {
+			t.Errorf("Count() = %d, want 3", s.Count())
+		}
+		if _, ok := s.Get("c"); !ok {
+			t.Error("Get(c) returned ok = false")
+		}
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		s := New()
+		_, _ = s.Set("existing", []float32{1, 0, 0})
+
+		_, err := s.SetMany([]string{"a", "b"}, [][]float32{{1, 0, 0}, {1, 0}})
+		if !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("SetMany() error = %v, want ErrDimensionMismatch", err)
+		}
+		_, err = s.SetMany([]string{"a", "b"}, [][]float32{{1, 0, 0}, {0, 0, 0}})
+		if err == nil {
+			t.Error("SetMany() accepted a zero vector")
+		}
+		if s.Count() != 1 {
+			t.Errorf("Count() = %d, want 1 after failed batches", s.Count())
+		}
+	})
+
+	t.Run("first batch agrees with itself", func(t *testing.T) {
+		s := New()
+		_, err := s.SetMany([]string{"a", "b"}, [][]float32{{1, 0}, {1, 0, 0}})
+		if !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("SetMany() error = %v, want ErrDimensionMismatch", err)
+		}
+		if s.Dimension() != 0 {
+			t.Errorf("Dimension() = %d, want 0 after a rejected batch", s.Dimension())
+		}
+	})
+
+	t.Run("readers never see part of a batch", func(t *testing.T) {
+		s := New()
+		keys := make([]string, 64)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key%d", i)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for v := float32(1); v < 200; v++ {
+				values := make([][]float32, len(keys))
+				for i := range values {
+					values[i] = []float32{v, 1}
+				}
+				_, _ = s.SetMany(keys, values)
+			}
+		}()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			results, err := s.Search([]float32{1, 0}, len(keys))
+			if err != nil || len(results) == 0 {
+				continue
+			}
+			for _, r := range results[1:] {
+				if r.Similarity != results[0].Similarity {
+					t.Fatalf("Search() saw a partially applied batch: %v vs %v", r.Similarity, results[0].Similarity)
+				}
+			}
+		}
+	})
+}
+
+func TestStorageSetBatch(t *testing.T) {
+	s := New()
+	_, _ = s.Set("b", []float32{1, 0})
+
+	results := s.SetBatch([]Write{
+		{Key: "a", Values: []float32{1, 0}},
+		{Key: "b", Values: []float32{0, 1}},
+		{Key: "c", Values: []float32{1, 0, 0}},
+		{Key: "d", Values: []float32{0, 0}},
+		{Key: "a", Values: []float32{0, 2}, TTL: time.Hour},
+	})
+
+	if !results[0].Inserted || results[1].Inserted {
+		t.Errorf("Inserted = %v, %v, want true, false", results[0].Inserted, results[1].Inserted)
+	}
+	if !errors.Is(results[2].Err, ErrDimensionMismatch) {
+		t.Errorf("write of a wrong dimension: error = %v, want ErrDimensionMismatch", results[2].Err)
+	}
+	if results[3].Err == nil {
+		t.Error("write of a zero vector succeeded")
+	}
+	if s.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", s.Count())
+	}
+
+	// Writes to one key apply in order, the last with its TTL
+	if v, _ := s.Get("a"); v[1] != 1 {
+		t.Errorf("Get(a) = %v, want the last write", v)
+	}
+	if ttl, _ := s.TTL("a"); ttl <= 0 {
+		t.Errorf("TTL(a) = %v, want the last write's TTL", ttl)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/uzqw/vex/internal/vector"
+)
+
+// Tx is a view of the storage with every shard locked, handed to the
+// function passed to Atomically. Its methods behave like the Storage
+// methods of the same name.
+type Tx struct {
+	s *Storage
+}
+
+// Atomically runs fn with every shard write-locked and searches held off,
+// so other goroutines see the writes fn makes through tx all at once or not
+// at all. fn must not use the Storage directly, which would deadlock, nor
+// keep tx after returning.
+func (s *Storage) Atomically(fn func(tx *Tx)) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	for i := 0; i < len(s.shards); i++ {
+		s.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := len(s.shards) - 1; i >= 0; i-- {
+			s.shards[i].mu.Unlock()
+		}
+	}()
+
+	fn(&Tx{s: s})
+}
+
+// Version returns the version of key, or 0 if it does not exist
+func (tx *Tx) Version(key string) uint64 {
+	return tx.s.getShard(key).version(key, tx.s.now())
+}
+
+// Stamp returns the version of key and the clock of its shard
+func (tx *Tx) Stamp(key string) (version, clock uint64) {
+	sh := tx.s.getShard(key)
+	return sh.version(key, tx.s.now()), sh.clock
+}
+
+// Set stores a vector under key, removing any TTL it had
+func (tx *Tx) Set(key string, values []float32) (bool, error) {
+	return tx.SetWithTTL(key, values, 0)
+}
+
+// SetWithTTL stores a vector that expires after ttl, or never if ttl is zero
+func (tx *Tx) SetWithTTL(key string, values []float32, ttl time.Duration) (bool, error) {
+	e, err := tx.s.newEntry(key, values)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.s.reserve(tx.s.growth(true, e), true); err != nil {
+		return false, err
+	}
+	return tx.s.store(tx.s.getShard(key), e, tx.s.deadline(ttl)), nil
+}
+
+// SetWithOptions stores a vector if the conditions in opts hold
+func (tx *Tx) SetWithOptions(key string, values []float32, opts SetOptions) (SetResult, error) {
+	e, err := tx.s.newEntry(key, values)
+	if err != nil {
+		return SetResult{}, err
+	}
+	if err := tx.s.reserve(tx.s.growth(true, e), true); err != nil {
+		return SetResult{}, err
+	}
+	return tx.s.storeIf(tx.s.getShard(key), e, opts), nil
+}
+
+// SetMany stores several vectors, all of them or, on error, none
+func (tx *Tx) SetMany(keys []string, values [][]float32) (int, error) {
+	entries, err := tx.s.newEntries(keys, values)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.s.reserve(tx.s.growth(true, entries...), true); err != nil {
+		return 0, err
+	}
+
+	inserted := 0
+	for _, e := range entries {
+		if tx.s.store(tx.s.getShard(e.key), e, 0) {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// Get retrieves a vector by key
+func (tx *Tx) Get(key string) ([]float32, bool) {
+	now := tx.s.now()
+	e, ok := tx.s.live(tx.s.getShard(key), key, now)
+	if !ok {
+		return nil, false
+	}
+	e.touch(now)
+	return e.vec, true
+}
+
+// GetWithVersion retrieves a vector by key along with its version
+func (tx *Tx) GetWithVersion(key string) ([]float32, uint64, bool) {
+	now := tx.s.now()
+	e, ok := tx.s.live(tx.s.getShard(key), key, now)
+	if !ok {
+		return nil, 0, false
+	}
+	e.touch(now)
+	return e.vec, e.version, true
+}
+
+// Delete removes a vector by key
+func (tx *Tx) Delete(key string) bool {
+	return tx.s.delete(tx.s.getShard(key), key)
+}
+
+// Exists reports whether key exists and has not expired
+func (tx *Tx) Exists(key string) bool {
+	_, ok := tx.s.live(tx.s.getShard(key), key, tx.s.now())
+	return ok
+}
+
+// Expire sets a TTL on an existing key, deleting it if ttl is not positive
+func (tx *Tx) Expire(key string, ttl time.Duration) bool {
+	return tx.s.expire(tx.s.getShard(key), key, ttl)
+}
+
+// ExpireAt makes an existing key expire at the given time
+func (tx *Tx) ExpireAt(key string, at time.Time) bool {
+	return tx.s.expireAt(tx.s.getShard(key), key, deadline(at))
+}
+
+// TTL returns the remaining time to live of key, negative if it has none
+func (tx *Tx) TTL(key string) (time.Duration, bool) {
+	sh := tx.s.getShard(key)
+	now := tx.s.now()
+	if _, ok := tx.s.live(sh, key, now); !ok {
+		return 0, false
+	}
+	at, ok := sh.expires[key]
+	if !ok {
+		return -1, true
+	}
+	return time.Duration(at - now), true
+}
+
+// Persist removes the TTL from key
+func (tx *Tx) Persist(key string) bool {
+	return tx.s.persist(tx.s.getShard(key), key)
+}
+
+// Rename moves the vector stored at src to dst, along with its TTL
+func (tx *Tx) Rename(src, dst string) error {
+	return tx.s.rename(tx.s.getShard(src), tx.s.getShard(dst), src, dst)
+}
+
+// Copy stores a copy of the vector at src under dst
+func (tx *Tx) Copy(src, dst string, replace bool) (bool, error) {
+	if dim := int(tx.s.dim.Load()); dim != 0 {
+		if err := tx.s.reserve(entrySize(dst, dim)-tx.s.sizeOf(dst, true), true); err != nil {
+			return false, err
+		}
+	}
+	return tx.s.copy(tx.s.getShard(src), tx.s.getShard(dst), src, dst, replace)
+}
+
+// Scan incrementally iterates over the keys, see Storage.Scan
+func (tx *Tx) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	return tx.s.scan(cursor, count, match, true)
+}
+
+// RandomKey returns a random key for which match returns true
+func (tx *Tx) RandomKey(match func(key string) bool) (string, bool) {
+	return tx.s.randomKey(match, true)
+}
+
+// Dump returns an OpSet change for every live key, with its vector and
+// TTL, which replayed into an empty storage recreate the stored vectors. A
+// passive storage includes its expired keys too.
+func (tx *Tx) Dump() []Change {
+	now := tx.s.now()
+	changes := make([]Change, 0, tx.s.Count())
+	for i := 0; i < len(tx.s.shards); i++ {
+		sh := tx.s.shards[i]
+		for key, e := range sh.data {
+			if tx.s.lapsed(sh, key, now) {
+				continue
+			}
+			changes = append(changes, Change{Op: OpSet, Key: key, Vector: e.vec, ExpiresAt: sh.expires[key]})
+		}
+	}
+	return changes
+}
+
+// Count returns the total number of vectors stored
+func (tx *Tx) Count() int {
+	return tx.s.Count()
+}
+
+// Search finds the top-K most similar vectors to the query vector
+func (tx *Tx) Search(query []float32, k int) ([]vector.SearchResult, error) {
+	return tx.SearchFiltered(query, k, nil)
+}
+
+// SearchFiltered is like Search but only considers keys for which filter
+// returns true
+func (tx *Tx) SearchFiltered(query []float32, k int, filter func(key string) bool) ([]vector.SearchResult, error) {
+	return tx.s.search(query, k, filter, true)
+}
+
+// Clear removes all vectors
+func (tx *Tx) Clear() {
+	for i := 0; i < len(tx.s.shards); i++ {
+		tx.s.shards[i].reset()
+	}
+	tx.s.dim.Store(tx.s.fixedDim)
+	tx.s.changed(OpClear, "", nil, 0)
+}
@@ -0,0 +1,217 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDump(t *testing.T) {
+	s, clock := newTestStorage()
+	_, _ = s.Set("a", []float32{3, 4})
+	_, _ = s.SetWithTTL("b", []float32{1, 0}, time.Minute)
+	_, _ = s.SetWithTTL("gone", []float32{1, 0}, time.Second)
+	clock.Add(int64(time.Second))
+
+	var dump []Change
+	s.Atomically(func(tx *Tx) { dump = tx.Dump() })
+	if len(dump) != 2 {
+		t.Fatalf("Dump() = %v, want a and b", dump)
+	}
+
+	// Replaying the dump recreates the vectors and TTLs
+	replica, replicaClock := newTestStorage()
+	replicaClock.Store(clock.Load())
+	for _, c := range dump {
+		if c.Op != OpSet {
+			t.Errorf("Dump() op = %v, want set", c.Op)
+		}
+		ttl := time.Duration(0)
+		if c.ExpiresAt != 0 {
+			ttl = time.Duration(c.ExpiresAt - replicaClock.Load())
+		}
+		_, _ = replica.SetWithTTL(c.Key, c.Vector, ttl)
+	}
+	if v, _ := replica.Get("a"); len(v) != 2 || v[0] != 0.6 {
+		t.Errorf("replayed a = %v, want [0.6 0.8]", v)
+	}
+	if ttl, _ := replica.TTL("b"); ttl != time.Minute-time.Second {
+		t.Errorf("replayed TTL(b) = %v, want 59s", ttl)
+	}
+}
+
+func TestStorageVersion(t *testing.T) {
+	s, clock := newTestStorage()
+
+	if v := s.Version("a"); v != 0 {
+		t.Errorf("Version(missing) = %d, want 0", v)
+	}
+
+	_, _ = s.Set("a", []float32{1, 0})
+	v1 := s.Version("a")
+	if v1 == 0 {
+		t.Fatal("Version() = 0 for a stored key")
+	}
+
+	s.Get("a")
+	if v := s.Version("a"); v != v1 {
+		t.Errorf("Version() changed on read: %d -> %d", v1, v)
+	}
+
+	steps := []struct {
+		name string
+		op   func()
+	}{
+		{"set", func() { _, _ = s.Set("a", []float32{0, 1}) }},
+		{"expire", func() { s.Expire("a", time.Minute) }},
+		{"persist", func() { s.Persist("a") }},
+		{"delete and set", func() { s.Delete("a"); _, _ = s.Set("a", []float32{1, 0}) }},
+		{"clear and set", func() { s.Clear(); _, _ = s.Set("a", []float32{1, 0}) }},
+		{"rename onto", func() { _, _ = s.Set("b", []float32{1, 0}); _ = s.Rename("b", "a") }},
+	}
+	prev := v1
+	for _, step := range steps {
+		step.op()
+		v := s.Version("a")
+		if v <= prev {
+			t.Errorf("after %s: Version() = %d, want more than %d", step.name, v, prev)
+		}
+		prev = v
+	}
+
+	s.Expire("a", time.Second)
+	clock.Add(int64(time.Second))
+	if v := s.Version("a"); v != 0 {
+		t.Errorf("Version(expired) = %d, want 0", v)
+	}
+}
+
+func TestStorageStamp(t *testing.T) {
+	s, _ := newTestStorage()
+
+	version, clock := s.Stamp("a")
+	if version != 0 {
+		t.Errorf("Stamp(missing) version = %d, want 0", version)
+	}
+
+	// Creating and deleting a key leaves it absent, but not unchanged
+	_, _ = s.Set("a", []float32{1, 0})
+	s.Delete("a")
+	v, c := s.Stamp("a")
+	if v != 0 || c == clock {
+		t.Errorf("Stamp() after set and delete = %d, %d, want version 0 and a clock past %d", v, c, clock)
+	}
+
+	_, _ = s.Set("a", []float32{1, 0})
+	s.Atomically(func(tx *Tx) {
+		if v, c := tx.Stamp("a"); v != s.getShard("a").clock || c != v {
+			t.Errorf("Tx.Stamp() = %d, %d, want the key's version and the shard clock", v, c)
+		}
+	})
+}
+
+func TestAtomically(t *testing.T) {
+	s, _ := newTestStorage()
+	_, _ = s.Set("a", []float32{1, 0})
+
+	s.Atomically(func(tx *Tx) {
+		if _, ok := tx.Get("a"); !ok {
+			t.Error("Get(a) = false inside the transaction")
+		}
+		if _, err := tx.SetMany([]string{"b", "c"}, [][]float32{{0, 1}, {1, 1}}); err != nil {
+			t.Errorf("SetMany() error = %v", err)
+		}
+		if err := tx.Rename("a", "d"); err != nil {
+			t.Errorf("Rename() error = %v", err)
+		}
+		if tx.Count() != 3 || tx.Version("d") == 0 {
+			t.Errorf("Count() = %d, Version(d) = %d, want 3 and non-zero", tx.Count(), tx.Version("d"))
+		}
+		results, err := tx.Search([]float32{1, 0}, 1)
+		if err != nil || len(results) != 1 || results[0].Key != "d" {
+			t.Errorf("Search() = %v, %v, want d", results, err)
+		}
+		if keys, _ := tx.Scan(0, 10, nil); len(keys) != 3 {
+			t.Errorf("Scan() = %v, want 3 keys", keys)
+		}
+	})
+
+	if s.Exists("a") || !s.Exists("b") || !s.Exists("d") {
+		t.Error("writes made in the transaction are not visible afterwards")
+	}
+}
+
+func TestAtomicallyEvicts(t *testing.T) {
+	s, _ := newTestStorage()
+	s.SetMaxMemory(2*entrySize("k0", 2), AllKeysLRU)
+
+	// Eviction inside a transaction must not try to take the shard locks
+	s.Atomically(func(tx *Tx) {
+		for _, key := range []string{"k0", "k1", "k2"} {
+			if _, err := tx.Set(key, []float32{1, 0}); err != nil {
+				t.Fatalf("Set(%s) error = %v", key, err)
+			}
+		}
+	})
+	if n := s.Count(); n != 2 {
+		t.Errorf("Count() = %d, want 2", n)
+	}
+}
+
+// TestAtomicallyIsolation checks that searches see a transaction either
+// fully applied or not at all
+func TestAtomicallyIsolation(t *testing.T) {
+	s := New()
+	a, b := keysInDifferentShards()
+	_, _ = s.Set(a, []float32{1, 0})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Move the only key back and forth between two shards
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			from, to := a, b
+			if i%2 == 1 {
+				from, to = b, a
+			}
+			s.Atomically(func(tx *Tx) {
+				vec, _ := tx.Get(from)
+				tx.Delete(from)
+				_, _ = tx.Set(to, vec)
+			})
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		results, err := s.Search([]float32{1, 0}, 10)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("Search() returned %d results, want exactly 1", len(results))
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlsconfig holds the TLS client flags shared by the command line
+// tools and builds the client configuration from them.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Flags are the TLS options of a command line client
+type Flags struct {
+	Enabled    *bool
+	Cert       *string
+	Key        *string
+	CACert     *string
+	ServerName *string
+	SkipVerify *bool
+}
+
+// AddFlags defines the TLS flags on fs: -tls, -tls-cert, -tls-key,
+// -tls-ca-cert, -tls-server-name and -tls-skip-verify
+func AddFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		Enabled:    fs.Bool("tls", false, "Connect using TLS"),
+		Cert:       fs.String("tls-cert", "", "Client certificate file (PEM) for mutual TLS"),
+		Key:        fs.String("tls-key", "", "Client private key file (PEM) for mutual TLS"),
+		CACert:     fs.String("tls-ca-cert", "", "CA certificate file (PEM) used to verify the server"),
+		ServerName: fs.String("tls-server-name", "", "Server name to verify (defaults to -host)"),
+		SkipVerify: fs.Bool("tls-skip-verify", false, "Skip server certificate verification (testing only)"),
+	}
+}
+
+// Config creates the client TLS configuration for connecting to host, or
+// returns nil if -tls is not set
+func (f *Flags) Config(host string) (*tls.Config, error) {
+	if !*f.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         *f.ServerName,
+		InsecureSkipVerify: *f.SkipVerify,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	if *f.CACert != "" {
+		pem, err := os.ReadFile(*f.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *f.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if *f.Cert != "" || *f.Key != "" {
+		cert, err := tls.LoadX509KeyPair(*f.Cert, *f.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
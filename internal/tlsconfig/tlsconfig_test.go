@@ -0,0 +1,117 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate and its key to dir, returning
+// their paths
+func writeCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vex"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir)
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "disabled", args: []string{"-tls-ca-cert", garbage}},
+		{name: "server name from host", args: []string{"-tls"}},
+		{name: "server name", args: []string{"-tls", "-tls-server-name", "vex.example"}},
+		{name: "ca and client certificate", args: []string{"-tls", "-tls-ca-cert", certFile, "-tls-cert", certFile, "-tls-key", keyFile}},
+		{name: "missing ca", args: []string{"-tls", "-tls-ca-cert", filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "ca without certificates", args: []string{"-tls", "-tls-ca-cert", garbage}, wantErr: true},
+		{name: "cert without key", args: []string{"-tls", "-tls-cert", certFile}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f := AddFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := f.Config("db.local")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			switch {
+			case err != nil:
+			case !*f.Enabled:
+				if cfg != nil {
+					t.Error("Config() without -tls should be nil")
+				}
+			case *f.ServerName == "" && cfg.ServerName != "db.local":
+				t.Errorf("ServerName = %q, want the host", cfg.ServerName)
+			case *f.ServerName != "" && cfg.ServerName != *f.ServerName:
+				t.Errorf("ServerName = %q, want %q", cfg.ServerName, *f.ServerName)
+			case *f.CACert != "" && cfg.RootCAs == nil:
+				t.Error("RootCAs not set from -tls-ca-cert")
+			case *f.Cert != "" && len(cfg.Certificates) != 1:
+				t.Errorf("%d client certificates, want 1", len(cfg.Certificates))
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a Go client for the Vex server.
+//
+// A Client keeps a small pool of connections per server and pipelines
+// concurrent calls on them, so many goroutines can share one Client
+// without waiting for each other's round trips:
+//
+//	c, err := client.New(client.Options{Addr: "localhost:6379"})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	if err := c.Set(ctx, "doc:1", []float32{0.6, 0.8}); err != nil {
+//		return err
+//	}
+//	results, err := c.Search(ctx, []float32{0.6, 0.8}, 10, client.SearchOptions{WithScores: true})
+//
+// Calls that fail because of the connection, or with a TRYAGAIN reply, are
+// retried on a new connection. MOVED and ASK redirects from a cluster and
+// REDIRECT replies from a Raft follower are followed. Other error replies
+// are returned as *Error and can be matched with errors.Is against the
+// sentinel errors of this package.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uzqw/vex/internal/cluster"
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// maxRedirects is the most redirects followed for one call
+const maxRedirects = 5
+
+// Options configures a Client
+type Options struct {
+	// Addr is the server's address (default "localhost:6379")
+	Addr string
+
+	// Username and Password authenticate each connection with AUTH when
+	// Password is set; Username may be empty for the default user
+	Username string
+	Password string
+
+	// PoolSize is the number of connections kept per server (default 4).
+	// Concurrent calls are spread over them and pipelined.
+	PoolSize int
+
+	// DialTimeout bounds connecting and authenticating (default 5s)
+	DialTimeout time.Duration
+
+	// MaxRetries is the number of times a call is retried after a
+	// connection error or a TRYAGAIN reply (default 3; -1 disables retries).
+	// Writes are only retried after a connection error if they were never
+	// sent, as the server may have run them.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// further one up to one second (default 50ms)
+	RetryBackoff time.Duration
+
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+}
+
+// Client is a Vex client, safe for concurrent use
+type Client struct {
+	opts Options
+
+	mu     sync.RWMutex
+	addr   string // Where calls go unless a key's slot is known
+	pools  map[string]*pool
+	slots  map[int]string // Slot owners learned from MOVED redirects
+	closed bool
+}
+
+// New returns a client for the server at opts.Addr. Connections are made
+// when first needed.
+func New(opts Options) (*Client, error) {
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	switch {
+	case opts.MaxRetries == 0:
+		opts.MaxRetries = 3
+	case opts.MaxRetries < 0:
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 50 * time.Millisecond
+	}
+	if opts.Username != "" && opts.Password == "" {
+		return nil, errors.New("vex: username set without a password")
+	}
+
+	return &Client{
+		opts:  opts,
+		addr:  opts.Addr,
+		pools: make(map[string]*pool),
+		slots: make(map[int]string),
+	}, nil
+}
+
+// Close closes the client's connections. Calls in flight fail with
+// ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	pools := c.pools
+	c.pools = nil
+	c.mu.Unlock()
+
+	for _, p := range pools {
+		p.close()
+	}
+	return nil
+}
+
+// Do sends a command and returns its reply: a string for a simple or bulk
+// string, an int64 for an integer, a []any for an array and nil for a
+// null. An error reply is returned as *Error.
+//
+// Do routes the command by its first argument after the name, which suits
+// the single-key commands; others go to the default server.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	if len(args) == 0 {
+		return nil, errors.New("vex: empty command")
+	}
+	var key []string
+	if len(args) > 1 {
+		key = args[1:2]
+	}
+	reply, err := c.call(ctx, key, args)
+	if err != nil {
+		return nil, err
+	}
+	return replyValue(reply), nil
+}
+
+// replyValue converts a reply to the types Do returns
+func replyValue(r protocol.Reply) any {
+	switch {
+	case r.Null:
+		return nil
+	case r.Type == ':':
+		return r.Int
+	case r.Type == '*':
+		values := make([]any, len(r.Array))
+		for i, elem := range r.Array {
+			values[i] = replyValue(elem)
+		}
+		return values
+	default:
+		return r.Str
+	}
+}
+
+// call sends one command, routed by key if it has one, and returns its
+// reply, with an error reply as *Error
+func (c *Client) call(ctx context.Context, key []string, cmd []string) (protocol.Reply, error) {
+	replies, err := c.do(ctx, key, [][]string{cmd})
+	if err != nil {
+		return protocol.Reply{}, err
+	}
+	return replies[0], nil
+}
+
+// do sends cmds together, retrying after connection errors and TRYAGAIN
+// replies. A redirect is followed when it is the reply to a single command.
+// An error reply to the last command is returned as *Error.
+func (c *Client) do(ctx context.Context, key []string, cmds [][]string) ([]protocol.Reply, error) {
+	addr := c.route(key)
+	asking := false
+	retries, redirects := 0, 0
+	for {
+		send := cmds
+		if asking {
+			send = append([][]string{{"ASKING"}}, cmds...)
+		}
+		replies, err := c.send(ctx, addr, send)
+		if asking && err == nil {
+			replies = replies[1:]
+		}
+		asking = false
+
+		var reply protocol.Reply
+		if err == nil {
+			reply = replies[len(replies)-1]
+			if reply.Type == '-' {
+				err = parseError(reply.Str)
+			}
+		}
+		if err == nil {
+			return replies, nil
+		}
+
+		var replyErr *Error
+		if errors.As(err, &replyErr) {
+			if len(cmds) == 1 && redirects < maxRedirects {
+				if to, ok := c.redirect(replyErr); ok {
+					redirects++
+					addr = to
+					asking = replyErr.Code == "ASK"
+					continue
+				}
+			}
+			if !errors.Is(err, ErrTryAgain) {
+				return nil, err
+			}
+		} else if !retryable(err, cmds) {
+			return nil, err
+		}
+
+		if retries >= c.opts.MaxRetries {
+			return nil, err
+		}
+		if err := sleep(ctx, c.backoff(retries)); err != nil {
+			return nil, err
+		}
+		retries++
+	}
+}
+
+// idempotent holds the commands that have the same effect run twice as
+// once, which are retried even if the connection failed after they were sent
+var idempotent = map[string]bool{
+	"PING": true, "ECHO": true, "VGET": true, "VSEARCH": true, "EXISTS": true,
+	"TTL": true, "PTTL": true, "STATS": true, "INFO": true, "SCAN": true,
+}
+
+// retryable reports whether cmds, whose call failed with err, may be
+// retried: if they never reached the server, or if they are all idempotent
+func retryable(err error, cmds [][]string) bool {
+	var ce *connError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	if !ce.sent {
+		return true
+	}
+	for _, cmd := range cmds {
+		if !idempotent[strings.ToUpper(cmd[0])] {
+			return false
+		}
+	}
+	return true
+}
+
+// backoff returns the wait before retry n
+func (c *Client) backoff(n int) time.Duration {
+	d := c.opts.RetryBackoff << n
+	if d > time.Second || d <= 0 {
+		d = time.Second
+	}
+	return d
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// route returns the server for a call with the given key, if any
+func (c *Client) route(key []string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(key) > 0 && len(c.slots) > 0 {
+		if addr, ok := c.slots[cluster.KeySlot([]byte(key[0]))]; ok {
+			return addr
+		}
+	}
+	return c.addr
+}
+
+// redirect interprets a MOVED, ASK or REDIRECT reply, remembering where
+// MOVED slots and the Raft leader are, and returns the address to go to
+func (c *Client) redirect(e *Error) (string, bool) {
+	fields := strings.Fields(e.Message)
+	switch {
+	case (e.Code == "MOVED" || e.Code == "ASK") && len(fields) == 2:
+		slot, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return "", false
+		}
+		if e.Code == "MOVED" {
+			c.mu.Lock()
+			c.slots[slot] = fields[1]
+			c.mu.Unlock()
+		}
+		return fields[1], true
+	case e.Code == "REDIRECT" && len(fields) == 1:
+		c.mu.Lock()
+		c.addr = fields[0]
+		c.mu.Unlock()
+		return fields[0], true
+	}
+	return "", false
+}
+
+// send sends cmds to addr as one request and waits for their replies
+func (c *Client) send(ctx context.Context, addr string, cmds [][]string) ([]protocol.Reply, error) {
+	p, err := c.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{ctx: ctx, cmds: cmds, done: make(chan struct{})}
+	if err := cn.send(req); err != nil {
+		return nil, err
+	}
+	select {
+	case <-req.done:
+		var ce *connError
+		if req.written && errors.As(req.err, &ce) {
+			return nil, &connError{err: ce.err, sent: true}
+		}
+		return req.replies, req.err
+	case <-ctx.Done():
+		// The reply is still read, keeping the connection in step, but
+		// nobody waits for it
+		return nil, ctx.Err()
+	}
+}
+
+// pool returns the connection pool for addr
+func (c *Client) pool(addr string) (*pool, error) {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	closed := c.closed
+	c.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+	if closed {
+		return nil, ErrClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if p, ok := c.pools[addr]; ok {
+		return p, nil
+	}
+	p = newPool(&c.opts, addr)
+	c.pools[addr] = p
+	return p, nil
+}
+
+// pool is the connections to one server, used round-robin and redialed
+// when they fail
+type pool struct {
+	opts  *Options
+	addr  string
+	slots []poolSlot
+	next  atomic.Uint32
+}
+
+type poolSlot struct {
+	mu     sync.Mutex
+	conn   *conn
+	closed bool
+}
+
+func newPool(opts *Options, addr string) *pool {
+	return &pool{opts: opts, addr: addr, slots: make([]poolSlot, opts.PoolSize)}
+}
+
+// get returns the next connection, dialing it if needed
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	s := &p.slots[int(p.next.Add(1)-1)%len(p.slots)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.conn == nil || s.conn.broken() {
+		cn, err := dial(ctx, p.opts, p.addr)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = cn
+	}
+	return s.conn, nil
+}
+
+// close closes the pool's connections
+func (p *pool) close() {
+	for i := range p.slots {
+		s := &p.slots[i]
+		s.mu.Lock()
+		s.closed = true
+		if s.conn != nil {
+			s.conn.close()
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/cluster"
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// handler answers one command, returning false to close the connection
+type handler func(cmd []string, w *protocol.RESPWriter) bool
+
+// serve accepts connections on a new listener, answering each with a
+// handler from newConn, and returns its address
+func serve(t *testing.T, newConn func() handler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		_ = l.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { _ = conn.Close() }()
+				handle := newConn()
+				reader := protocol.NewRESPReader(conn)
+				writer := protocol.NewRESPWriter(conn)
+				for {
+					cmd, err := reader.ReadCommand()
+					if err != nil || !handle(cmd, writer) {
+						return
+					}
+					if err := writer.Flush(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// echo answers PING with PONG and ECHO with its argument
+func echo(cmd []string, w *protocol.RESPWriter) bool {
+	switch strings.ToUpper(cmd[0]) {
+	case "PING":
+		_ = w.WriteSimpleString("PONG")
+	case "ECHO":
+		_ = w.WriteBulkString(cmd[1])
+	default:
+		_ = w.WriteError(fmt.Sprintf("unknown command '%s'", cmd[0]))
+	}
+	return true
+}
+
+func newClient(t *testing.T, opts Options) *Client {
+	c, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestDo(t *testing.T) {
+	addr := serve(t, func() handler {
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			switch cmd[0] {
+			case "INT":
+				_ = w.WriteInteger(42)
+			case "ARRAY":
+				_ = w.WriteArrayHeader(2)
+				_ = w.WriteBulkString("a")
+				_ = w.WriteNull()
+			case "NULL":
+				_ = w.WriteNull()
+			case "FAIL":
+				_ = w.WriteErrorCode("NOPERM", "User alice has no permissions to run the 'fail' command")
+			default:
+				return echo(cmd, w)
+			}
+			return true
+		}
+	})
+	c := newClient(t, Options{Addr: addr})
+	ctx := context.Background()
+
+	tests := []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ECHO", "hello"}, "hello"},
+		{[]string{"INT"}, "42"},
+		{[]string{"ARRAY"}, "[a <nil>]"},
+		{[]string{"NULL"}, "<nil>"},
+	}
+	for _, tt := range tests {
+		got, err := c.Do(ctx, tt.cmd...)
+		if err != nil {
+			t.Fatalf("Do(%v): %v", tt.cmd, err)
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("Do(%v) = %s, want %s", tt.cmd, s, tt.want)
+		}
+	}
+
+	_, err := c.Do(ctx, "FAIL")
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "NOPERM" || !errors.Is(err, ErrNoPerm) {
+		t.Errorf("Do(FAIL) error = %v, want a NOPERM *Error", err)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	var conns atomic.Int32
+	addr := serve(t, func() handler {
+		conns.Add(1)
+		return echo
+	})
+	c := newClient(t, Options{Addr: addr, PoolSize: 2})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprint(i)
+			got, err := c.Do(context.Background(), "ECHO", want)
+			if err != nil {
+				errs <- err
+			} else if got != want {
+				errs <- fmt.Errorf("ECHO %s = %v", want, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := conns.Load(); n > 2 {
+		t.Errorf("%d connections for a pool of 2", n)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls atomic.Int32
+	addr := serve(t, func() handler {
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			switch calls.Add(1) {
+			case 1:
+				return false // Drop the connection
+			case 2:
+				_ = w.WriteErrorCode("TRYAGAIN", "no leader elected yet")
+				return true
+			}
+			return echo(cmd, w)
+		}
+	})
+
+	c := newClient(t, Options{Addr: addr, RetryBackoff: time.Millisecond})
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping with retries: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server saw %d attempts, want 3", n)
+	}
+
+	calls.Store(0)
+	c = newClient(t, Options{Addr: addr, MaxRetries: -1})
+	var ce *connError
+	if err := c.Ping(context.Background()); !errors.As(err, &ce) {
+		t.Errorf("Ping without retries = %v, want a connection error", err)
+	}
+	if err := c.Ping(context.Background()); !errors.Is(err, ErrTryAgain) {
+		t.Errorf("Ping without retries = %v, want TRYAGAIN", err)
+	}
+
+	// A write that may have run before the connection dropped is not sent
+	// again
+	calls.Store(0)
+	c = newClient(t, Options{Addr: addr, RetryBackoff: time.Millisecond})
+	if _, err := c.Do(context.Background(), "VDEL", "k"); !errors.As(err, &ce) {
+		t.Errorf("VDEL on a dropped connection = %v, want a connection error", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("server saw %d attempts of VDEL, want 1", n)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	failed := errors.New("connection reset")
+	tests := []struct {
+		name string
+		err  error
+		cmds [][]string
+		want bool
+	}{
+		{"unsent write", &connError{err: failed}, [][]string{{"VSET", "k", "[1]"}}, true},
+		{"sent write", &connError{err: failed, sent: true}, [][]string{{"VSET", "k", "[1]"}}, false},
+		{"sent read", &connError{err: failed, sent: true}, [][]string{{"vget", "k"}, {"VSEARCH", "[1]", "3"}}, true},
+		{"sent read and write", &connError{err: failed, sent: true}, [][]string{{"VGET", "k"}, {"VDEL", "k"}}, false},
+		{"error reply", ErrNoPerm, [][]string{{"VGET", "k"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err, tt.cmds); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	const key = "doc:1"
+	slot := cluster.KeySlot([]byte(key))
+
+	var targetCalls atomic.Int32
+	target := serve(t, func() handler {
+		asking := false
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			targetCalls.Add(1)
+			switch {
+			case cmd[0] == "ASKING":
+				asking = true
+				_ = w.WriteSimpleString("OK")
+			case cmd[0] == "ECHO" && cmd[1] == "ask" && !asking:
+				_ = w.WriteErrorCode("MOVED", "0 elsewhere:1")
+			default:
+				asking = false
+				return echo(cmd, w)
+			}
+			return true
+		}
+	})
+
+	var (
+		originCalls atomic.Int32
+		originAddr  atomic.Value
+	)
+	origin := serve(t, func() handler {
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			originCalls.Add(1)
+			switch cmd[1] {
+			case key:
+				_ = w.WriteErrorCode("MOVED", fmt.Sprintf("%d %s", slot, target))
+			case "ask":
+				_ = w.WriteErrorCode("ASK", fmt.Sprintf("%d %s", cluster.KeySlot([]byte("ask")), target))
+			case "leader":
+				_ = w.WriteErrorCode("REDIRECT", target)
+			case "loop":
+				_ = w.WriteErrorCode("MOVED", fmt.Sprintf("%d %s", cluster.KeySlot([]byte("loop")), originAddr.Load()))
+			}
+			return true
+		}
+	})
+	originAddr.Store(origin)
+
+	c := newClient(t, Options{Addr: origin})
+	ctx := context.Background()
+
+	// MOVED is followed and the slot's owner remembered
+	for i := 0; i < 2; i++ {
+		if got, err := c.Do(ctx, "ECHO", key); err != nil || got != key {
+			t.Fatalf("ECHO after MOVED = %v, %v", got, err)
+		}
+	}
+	if n := originCalls.Load(); n != 1 {
+		t.Errorf("origin saw %d calls, want 1 before the slot was learned", n)
+	}
+
+	// ASK is followed once, preceded by ASKING
+	if got, err := c.Do(ctx, "ECHO", "ask"); err != nil || got != "ask" {
+		t.Fatalf("ECHO after ASK = %v, %v", got, err)
+	}
+
+	// Redirects are only followed so far
+	if _, err := c.Do(ctx, "ECHO", "loop"); !errors.Is(err, ErrMoved) {
+		t.Errorf("ECHO redirected in a loop = %v, want MOVED", err)
+	}
+
+	// REDIRECT moves the default server
+	if got, err := c.Do(ctx, "ECHO", "leader"); err != nil || got != "leader" {
+		t.Fatalf("ECHO after REDIRECT = %v, %v", got, err)
+	}
+	before := originCalls.Load()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if originCalls.Load() != before {
+		t.Error("PING went to the old default server after REDIRECT")
+	}
+}
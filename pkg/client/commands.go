@@ -0,0 +1,281 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// SetOptions are the conditions and options of SetWithOptions
+type SetOptions struct {
+	// TTL expires the key after the given time, rounded to milliseconds
+	TTL time.Duration
+
+	// NX only writes a new key and XX only overwrites an existing one
+	NX bool
+	XX bool
+
+	// CheckVersion only writes if the key's version is Version, where 0
+	// means the key doesn't exist
+	CheckVersion bool
+	Version      uint64
+
+	// Async queues the write to be applied in a batch. It can't be
+	// combined with the conditions above, and its errors are not
+	// reported; Flush waits for queued writes to be applied.
+	Async bool
+}
+
+// SetResult is the outcome of SetWithOptions
+type SetResult struct {
+	// Written is false if a condition skipped the write
+	Written bool
+
+	// Version is the key's new version; it is not known for asynchronous
+	// writes
+	Version uint64
+}
+
+// SearchOptions are the options of Search
+type SearchOptions struct {
+	// WithScores fills in each result's Score
+	WithScores bool
+
+	// WithVectors fills in each result's Vector, fetched in one pipelined
+	// round trip after the search. A key deleted in between is left out.
+	WithVectors bool
+}
+
+// SearchResult is one match of Search
+type SearchResult struct {
+	Key    string
+	Score  float32 // Cosine similarity, with WithScores
+	Vector []float32
+}
+
+// Stats is the server's STATS report
+type Stats struct {
+	Goroutines        int     `json:"goroutines"`
+	TotalCommands     uint64  `json:"total_commands"`
+	ActiveConnections int64   `json:"active_connections"`
+	TotalKeys         uint64  `json:"total_keys"`
+	Inserts           uint64  `json:"inserts"`
+	Updates           uint64  `json:"updates"`
+	Deletes           uint64  `json:"deletes"`
+	Clears            uint64  `json:"clears"`
+	MemoryUsageMB     float64 `json:"memory_usage_mb"`
+	DatasetMemoryMB   float64 `json:"dataset_memory_mb"`
+	AuthFailures      uint64  `json:"auth_failures"`
+	ExpiredKeys       uint64  `json:"expired_keys"`
+	EvictedKeys       uint64  `json:"evicted_keys"`
+	AsyncQueueDepth   int64   `json:"async_queue_depth"`
+	AsyncWrites       uint64  `json:"async_writes"`
+	AsyncErrors       uint64  `json:"async_errors"`
+	AsyncBatches      uint64  `json:"async_batches"`
+	AsyncWaitMs       float64 `json:"async_wait_ms"`
+	AsyncApplyMs      float64 `json:"async_apply_ms"`
+	SearchesCoalesced uint64  `json:"searches_coalesced"`
+	CoalesceTimeouts  uint64  `json:"search_coalesce_timeouts"`
+	Uptime            string  `json:"uptime"`
+	QPS               float64 `json:"qps"`
+}
+
+// Ping checks that the server answers
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, nil, []string{"PING"})
+	return err
+}
+
+// Set stores a vector under key
+func (c *Client) Set(ctx context.Context, key string, vector []float32) error {
+	_, err := c.call(ctx, []string{key}, []string{"VSET", key, formatVector(vector)})
+	return err
+}
+
+// SetWithOptions stores a vector under key with a TTL, conditions or as an
+// asynchronous write
+func (c *Client) SetWithOptions(ctx context.Context, key string, vector []float32, opts SetOptions) (SetResult, error) {
+	cmd := []string{"VSET", key, formatVector(vector)}
+	if opts.TTL > 0 {
+		ms := opts.TTL.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		cmd = append(cmd, "PX", strconv.FormatInt(ms, 10))
+	}
+	if opts.NX {
+		cmd = append(cmd, "NX")
+	}
+	if opts.XX {
+		cmd = append(cmd, "XX")
+	}
+	if opts.CheckVersion {
+		cmd = append(cmd, "IFVERSION", strconv.FormatUint(opts.Version, 10))
+	}
+	if opts.Async {
+		cmd = append(cmd, "ASYNC")
+	} else {
+		cmd = append(cmd, "WITHVERSION")
+	}
+
+	reply, err := c.call(ctx, []string{key}, cmd)
+	switch {
+	case err != nil:
+		return SetResult{}, err
+	case reply.Null:
+		return SetResult{}, nil
+	}
+	return SetResult{Written: true, Version: uint64(reply.Int)}, nil
+}
+
+// Get returns the vector stored under key, or ErrNotFound
+func (c *Client) Get(ctx context.Context, key string) ([]float32, error) {
+	reply, err := c.call(ctx, []string{key}, []string{"VGET", key})
+	if err != nil {
+		return nil, err
+	}
+	return parseVector(reply)
+}
+
+// Delete deletes key, reporting whether it existed
+func (c *Client) Delete(ctx context.Context, key string) (bool, error) {
+	reply, err := c.call(ctx, []string{key}, []string{"VDEL", key})
+	if err != nil {
+		return false, err
+	}
+	return reply.Int == 1, nil
+}
+
+// Search returns the k keys whose vectors are most similar to query, most
+// similar first
+func (c *Client) Search(ctx context.Context, query []float32, k int, opts SearchOptions) ([]SearchResult, error) {
+	cmd := []string{"VSEARCH", formatVector(query), strconv.Itoa(k)}
+	if opts.WithScores {
+		cmd = append(cmd, "WITHSCORES")
+	}
+	reply, err := c.call(ctx, nil, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type != '*' {
+		return nil, fmt.Errorf("vex: unexpected VSEARCH reply type %q", reply.Type)
+	}
+
+	step := 1
+	if opts.WithScores {
+		step = 2
+	}
+	if len(reply.Array)%step != 0 {
+		return nil, fmt.Errorf("vex: malformed VSEARCH reply")
+	}
+	results := make([]SearchResult, 0, len(reply.Array)/step)
+	for i := 0; i < len(reply.Array); i += step {
+		res := SearchResult{Key: reply.Array[i].Str}
+		if opts.WithScores {
+			score, err := strconv.ParseFloat(reply.Array[i+1].Str, 32)
+			if err != nil {
+				return nil, fmt.Errorf("vex: malformed VSEARCH score: %w", err)
+			}
+			res.Score = float32(score)
+		}
+		results = append(results, res)
+	}
+
+	if opts.WithVectors && len(results) > 0 {
+		return c.fillVectors(ctx, results)
+	}
+	return results, nil
+}
+
+// fillVectors fetches the vectors of search results from the server that
+// ran the search, dropping keys deleted since
+func (c *Client) fillVectors(ctx context.Context, results []SearchResult) ([]SearchResult, error) {
+	cmds := make([][]string, len(results))
+	for i, res := range results {
+		cmds[i] = []string{"VGET", res.Key}
+	}
+	replies, err := c.do(ctx, nil, cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	filled := results[:0]
+	for i, reply := range replies {
+		vector, err := parseVector(reply)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[i].Vector = vector
+		filled = append(filled, results[i])
+	}
+	return filled, nil
+}
+
+// Stats returns the server's statistics
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	reply, err := c.call(ctx, nil, []string{"STATS"})
+	if err != nil {
+		return nil, err
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(reply.Str), &stats); err != nil {
+		return nil, fmt.Errorf("vex: malformed STATS reply: %w", err)
+	}
+	return &stats, nil
+}
+
+// Flush waits until the asynchronous writes queued before it have been
+// applied
+func (c *Client) Flush(ctx context.Context) error {
+	_, err := c.call(ctx, nil, []string{"VFLUSH"})
+	return err
+}
+
+// parseVector parses a VGET reply
+func parseVector(reply protocol.Reply) ([]float32, error) {
+	switch {
+	case reply.Type == '-':
+		return nil, parseError(reply.Str)
+	case reply.Null || reply.Str == "":
+		return nil, ErrNotFound
+	}
+	vector, err := protocol.ParseVector([]byte(reply.Str))
+	if err != nil {
+		return nil, fmt.Errorf("vex: malformed vector: %w", err)
+	}
+	return vector, nil
+}
+
+// formatVector formats a vector as a command argument, exactly enough to
+// parse back to the same float32 values
+func formatVector(values []float32) string {
+	b := []byte{'['}
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, float64(v), 'g', -1, 32)
+	}
+	return string(append(b, ']'))
+}
@@ -0,0 +1,234 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// fakeVex serves VSET, VGET, VDEL, VSEARCH and STATS from a map. VSEARCH
+// returns every key, sorted, with a score of 0.5.
+func fakeVex(t *testing.T) (addr string, args func(key string) []string) {
+	var (
+		mu      sync.Mutex
+		vectors = make(map[string]string)
+		lastSet = make(map[string][]string)
+		version int64
+	)
+	addr = serve(t, func() handler {
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			switch cmd[0] {
+			case "VSET":
+				if _, err := protocol.ParseVector([]byte(cmd[2])); err != nil {
+					_ = w.WriteError(err.Error())
+					break
+				}
+				lastSet[cmd[1]] = cmd[3:]
+				_, exists := vectors[cmd[1]]
+				if exists && contains(cmd, "NX") {
+					_ = w.WriteNull()
+					break
+				}
+				vectors[cmd[1]] = cmd[2]
+				version++
+				if contains(cmd, "WITHVERSION") {
+					_ = w.WriteInteger(version)
+					break
+				}
+				_ = w.WriteSimpleString("OK")
+			case "VGET":
+				v, ok := vectors[cmd[1]]
+				if !ok {
+					_ = w.WriteNull()
+					break
+				}
+				_ = w.WriteBulkString(v)
+			case "VDEL":
+				_, ok := vectors[cmd[1]]
+				delete(vectors, cmd[1])
+				if ok {
+					_ = w.WriteInteger(1)
+				} else {
+					_ = w.WriteInteger(0)
+				}
+			case "VSEARCH":
+				keys := make([]string, 0, len(vectors))
+				for key := range vectors {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				var reply []string
+				for _, key := range keys {
+					reply = append(reply, key)
+					if contains(cmd, "WITHSCORES") {
+						reply = append(reply, "0.5")
+					}
+				}
+				_ = w.WriteArray(reply)
+			case "STATS":
+				_ = w.WriteBulkString(fmt.Sprintf(`{"total_keys":%d,"qps":12.5,"uptime":"1m0s"}`, len(vectors)))
+			default:
+				return echo(cmd, w)
+			}
+			return true
+		}
+	})
+	return addr, func(key string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return lastSet[key]
+	}
+}
+
+func contains(cmd []string, arg string) bool {
+	for _, a := range cmd {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetGetDelete(t *testing.T) {
+	addr, _ := fakeVex(t)
+	c := newClient(t, Options{Addr: addr})
+	ctx := context.Background()
+
+	vector := []float32{0.1, 0.2, 1.0 / 3}
+	if err := c.Set(ctx, "a", vector); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, vector) {
+		t.Errorf("Get = %v, want %v", got, vector)
+	}
+
+	if ok, err := c.Delete(ctx, "a"); err != nil || !ok {
+		t.Errorf("Delete = %v, %v, want true", ok, err)
+	}
+	if ok, err := c.Delete(ctx, "a"); err != nil || ok {
+		t.Errorf("Delete of a missing key = %v, %v, want false", ok, err)
+	}
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	addr, args := fakeVex(t)
+	c := newClient(t, Options{Addr: addr})
+	ctx := context.Background()
+
+	res, err := c.SetWithOptions(ctx, "a", []float32{1, 0}, SetOptions{
+		TTL:          1500 * time.Millisecond,
+		NX:           true,
+		CheckVersion: true,
+	})
+	if err != nil || !res.Written || res.Version == 0 {
+		t.Fatalf("SetWithOptions = %+v, %v", res, err)
+	}
+	want := []string{"PX", "1500", "NX", "IFVERSION", "0", "WITHVERSION"}
+	if got := args("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("VSET options = %v, want %v", got, want)
+	}
+
+	res, err = c.SetWithOptions(ctx, "a", []float32{1, 0}, SetOptions{NX: true})
+	if err != nil || res.Written {
+		t.Errorf("SetWithOptions NX on an existing key = %+v, %v, want not written", res, err)
+	}
+
+	if _, err := c.SetWithOptions(ctx, "b", []float32{1, 0}, SetOptions{Async: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := args("b"); !reflect.DeepEqual(got, []string{"ASYNC"}) {
+		t.Errorf("VSET options = %v, want [ASYNC]", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	addr, _ := fakeVex(t)
+	c := newClient(t, Options{Addr: addr})
+	ctx := context.Background()
+
+	for i, key := range []string{"a", "b", "c"} {
+		if err := c.Set(ctx, key, []float32{float32(i), 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := c.Search(ctx, []float32{1, 1}, 3, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Key != "a" || results[0].Score != 0 || results[0].Vector != nil {
+		t.Errorf("Search = %+v", results)
+	}
+
+	results, err = c.Search(ctx, []float32{1, 1}, 3, SearchOptions{WithScores: true, WithVectors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		want := SearchResult{Key: string(rune('a' + i)), Score: 0.5, Vector: []float32{float32(i), 1}}
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("result %d = %+v, want %+v", i, res, want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	addr, _ := fakeVex(t)
+	c := newClient(t, Options{Addr: addr})
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "a", []float32{1}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalKeys != 1 || stats.QPS != 12.5 || stats.Uptime != "1m0s" {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
+
+func TestFormatVector(t *testing.T) {
+	vector := []float32{0.1, -2.5e-8, 1.0 / 3, 100}
+	got, err := protocol.ParseVector([]byte(formatVector(vector)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, vector) {
+		t.Errorf("formatVector round trip = %v, want %v", got, vector)
+	}
+	if s := formatVector([]float32{1, 0.5}); s != "[1,0.5]" {
+		t.Errorf("formatVector = %s", s)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+// maxPipeline is the most requests sent in one write
+const maxPipeline = 256
+
+// request is one or more commands sent together, whose replies are read
+// together
+type request struct {
+	ctx     context.Context
+	cmds    [][]string
+	replies []protocol.Reply
+	err     error
+	done    chan struct{}
+
+	// written is set by the writer before it writes the request, after
+	// which the server may have run it even if no reply comes back
+	written bool
+}
+
+// finish completes the request with err, or with its replies if err is nil
+func (r *request) finish(err error) {
+	r.err = err
+	close(r.done)
+}
+
+// conn is a connection on which concurrent requests are pipelined: a writer
+// goroutine sends whatever requests are waiting in one write, and a reader
+// goroutine matches the replies to them in order
+type conn struct {
+	nc     net.Conn
+	reqs   chan *request // Unbuffered, so a request is either taken by the writer or not at all
+	closed chan struct{} // Closed once the connection fails or is closed
+
+	mu      sync.Mutex
+	pending []*request    // Requests sent and waiting for their replies, in order
+	ready   chan struct{} // Wakes the reader when pending is no longer empty
+	err     error
+}
+
+// dial connects to addr and authenticates if a password is set
+func dial(ctx context.Context, opts *Options, addr string) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.DialTimeout)
+	defer cancel()
+
+	d := &net.Dialer{}
+	var nc net.Conn
+	var err error
+	if opts.TLSConfig != nil {
+		nc, err = (&tls.Dialer{NetDialer: d, Config: opts.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, &connError{err: err}
+	}
+
+	reader := protocol.NewRESPReader(nc)
+	writer := protocol.NewRESPWriter(nc)
+	if opts.Password != "" {
+		args := []string{"AUTH", opts.Password}
+		if opts.Username != "" {
+			args = []string{"AUTH", opts.Username, opts.Password}
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = nc.SetDeadline(deadline)
+		}
+		_ = writer.WriteArray(args)
+		err := writer.Flush()
+		var reply protocol.Reply
+		if err == nil {
+			reply, err = reader.ReadReply()
+		}
+		_ = nc.SetDeadline(time.Time{})
+		switch {
+		case err != nil:
+			_ = nc.Close()
+			return nil, &connError{err: err}
+		case reply.Type == '-':
+			_ = nc.Close()
+			return nil, parseError(reply.Str)
+		}
+	}
+
+	c := &conn{
+		nc:     nc,
+		reqs:   make(chan *request),
+		closed: make(chan struct{}),
+		ready:  make(chan struct{}, 1),
+	}
+	go c.writeLoop(writer)
+	go c.readLoop(reader)
+	return c, nil
+}
+
+// send hands req to the writer. The reply is awaited on req.done.
+func (c *conn) send(req *request) error {
+	select {
+	case c.reqs <- req:
+		return nil
+	case <-c.closed:
+		return c.failure()
+	case <-req.ctx.Done():
+		return req.ctx.Err()
+	}
+}
+
+// broken reports whether the connection has failed or been closed
+func (c *conn) broken() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// failure returns the error the connection failed with
+func (c *conn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// close closes the connection, failing the requests still waiting
+func (c *conn) close() {
+	c.fail(ErrClosed)
+}
+
+// fail closes the connection because of err and fails the requests waiting
+// for replies
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	if err != ErrClosed {
+		err = &connError{err: err}
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	close(c.closed)
+	c.mu.Unlock()
+
+	_ = c.nc.Close()
+	for _, req := range pending {
+		req.finish(err)
+	}
+}
+
+// writeLoop sends requests, batching those that are waiting together
+func (c *conn) writeLoop(w *protocol.RESPWriter) {
+	batch := make([]*request, 0, maxPipeline)
+	for {
+		select {
+		case req := <-c.reqs:
+			batch = append(batch[:0], req)
+		case <-c.closed:
+			return
+		}
+	drain:
+		for len(batch) < maxPipeline {
+			select {
+			case req := <-c.reqs:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		// Requests given up on while waiting are not sent
+		sent := batch[:0]
+		for _, req := range batch {
+			if err := req.ctx.Err(); err != nil {
+				req.finish(err)
+				continue
+			}
+			req.written = true
+			for _, cmd := range req.cmds {
+				_ = w.WriteArray(cmd)
+			}
+			sent = append(sent, req)
+		}
+		if len(sent) == 0 {
+			continue
+		}
+
+		// The reader only reads replies for requests it has been given, so
+		// they can be queued after writing starts
+		if !c.enqueue(sent) {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// enqueue queues sent requests for the reader. It fails them and returns
+// false if the connection has failed.
+func (c *conn) enqueue(reqs []*request) bool {
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		for _, req := range reqs {
+			req.finish(err)
+		}
+		return false
+	}
+	c.pending = append(c.pending, reqs...)
+	c.mu.Unlock()
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// readLoop reads the replies of the sent requests in order
+func (c *conn) readLoop(r *protocol.RESPReader) {
+	for {
+		req := c.next()
+		if req == nil {
+			return
+		}
+		replies := make([]protocol.Reply, len(req.cmds))
+		for i := range replies {
+			reply, err := r.ReadReply()
+			if err != nil {
+				c.fail(err)
+				req.finish(c.failure())
+				return
+			}
+			replies[i] = reply
+		}
+		req.replies = replies
+		req.finish(nil)
+	}
+}
+
+// next waits for the next sent request, returning nil once the connection
+// has failed
+func (c *conn) next() *request {
+	for {
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			req := c.pending[0]
+			c.pending[0] = nil
+			c.pending = c.pending[1:]
+			c.mu.Unlock()
+			return req
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.ready:
+		case <-c.closed:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uzqw/vex/internal/protocol"
+)
+
+func dialTest(t *testing.T, opts Options, addr string) *conn {
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = time.Second
+	}
+	c, err := dial(context.Background(), &opts, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+func newRequest(ctx context.Context, cmds ...[]string) *request {
+	return &request{ctx: ctx, cmds: cmds, done: make(chan struct{})}
+}
+
+func TestConnOrder(t *testing.T) {
+	c := dialTest(t, Options{}, serve(t, func() handler { return echo }))
+
+	// Replies are matched to requests in order, including requests of
+	// several commands
+	reqs := make([]*request, 50)
+	for i := range reqs {
+		reqs[i] = newRequest(context.Background(), []string{"ECHO", fmt.Sprint(i)}, []string{"PING"})
+		if err := c.send(reqs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, req := range reqs {
+		<-req.done
+		if req.err != nil {
+			t.Fatal(req.err)
+		}
+		if len(req.replies) != 2 || req.replies[0].Str != fmt.Sprint(i) || req.replies[1].Str != "PONG" {
+			t.Errorf("request %d got %+v", i, req.replies)
+		}
+	}
+}
+
+func TestConnFailure(t *testing.T) {
+	var seen atomic.Int32
+	c := dialTest(t, Options{}, serve(t, func() handler {
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			// Answer the first command, then drop the connection
+			if seen.Add(1) > 1 {
+				return false
+			}
+			return echo(cmd, w)
+		}
+	}))
+
+	first := newRequest(context.Background(), []string{"PING"})
+	second := newRequest(context.Background(), []string{"PING"})
+	for _, req := range []*request{first, second} {
+		if err := c.send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-first.done
+	<-second.done
+	if first.err != nil {
+		t.Errorf("first request: %v", first.err)
+	}
+	var ce *connError
+	if !errors.As(second.err, &ce) {
+		t.Errorf("second request error = %v, want a connection error", second.err)
+	}
+	if !c.broken() {
+		t.Error("connection not broken after failing")
+	}
+	if err := c.send(newRequest(context.Background(), []string{"PING"})); !errors.As(err, &ce) {
+		t.Errorf("send on a broken connection = %v, want a connection error", err)
+	}
+}
+
+func TestConnCanceled(t *testing.T) {
+	c := dialTest(t, Options{}, serve(t, func() handler { return echo }))
+
+	// A request given up on before it is sent is dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := newRequest(ctx, []string{"PING"})
+	err := c.send(req)
+	if err == nil {
+		<-req.done
+		err = req.err
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled request error = %v", err)
+	}
+
+	req = newRequest(context.Background(), []string{"ECHO", "next"})
+	if err := c.send(req); err != nil {
+		t.Fatal(err)
+	}
+	<-req.done
+	if req.err != nil || req.replies[0].Str != "next" {
+		t.Errorf("request after a canceled one = %+v, %v", req.replies, req.err)
+	}
+}
+
+func TestConnAuth(t *testing.T) {
+	addr := serve(t, func() handler {
+		authed := false
+		return func(cmd []string, w *protocol.RESPWriter) bool {
+			switch {
+			case cmd[0] == "AUTH" && len(cmd) == 3 && cmd[1] == "alice" && cmd[2] == "secret":
+				authed = true
+				_ = w.WriteSimpleString("OK")
+			case cmd[0] == "AUTH":
+				_ = w.WriteErrorCode("WRONGPASS", "invalid username-password pair or user is disabled.")
+			case !authed:
+				_ = w.WriteErrorCode("NOAUTH", "Authentication required.")
+			default:
+				return echo(cmd, w)
+			}
+			return true
+		}
+	})
+
+	opts := Options{Username: "alice", Password: "wrong", DialTimeout: time.Second}
+	if _, err := dial(context.Background(), &opts, addr); !errors.Is(err, ErrWrongPass) {
+		t.Errorf("dial with a wrong password = %v, want WRONGPASS", err)
+	}
+
+	c := dialTest(t, Options{Username: "alice", Password: "secret"}, addr)
+	req := newRequest(context.Background(), []string{"PING"})
+	if err := c.send(req); err != nil {
+		t.Fatal(err)
+	}
+	<-req.done
+	if req.err != nil || req.replies[0].Str != "PONG" {
+		t.Errorf("PING after AUTH = %+v, %v", req.replies, req.err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is an error reply from the server. Code is its first word, such as
+// ERR, NOPERM or OOM, and Message the rest.
+//
+// errors.Is matches an Error against the sentinel errors below by code,
+// and for those with a message, by that text appearing in the message.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + " " + e.Message
+}
+
+// Is reports whether target is an *Error with the same code whose message,
+// if any, appears in e's
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && strings.Contains(e.Message, t.Message)
+}
+
+var (
+	// ErrNotFound is returned when the key doesn't exist
+	ErrNotFound = errors.New("vex: key not found")
+
+	// ErrClosed is returned once the client has been closed
+	ErrClosed = errors.New("vex: client is closed")
+
+	// ErrDimensionMismatch is returned for a vector whose dimension differs
+	// from the stored ones
+	ErrDimensionMismatch = &Error{Code: "ERR", Message: "dimension mismatch"}
+
+	// ErrNoAuth and ErrWrongPass are returned when the connection isn't
+	// authenticated or the credentials are rejected
+	ErrNoAuth    = &Error{Code: "NOAUTH"}
+	ErrWrongPass = &Error{Code: "WRONGPASS"}
+
+	// ErrNoPerm is returned when the user may not run the command or use
+	// its keys
+	ErrNoPerm = &Error{Code: "NOPERM"}
+
+	// ErrOutOfMemory is returned when a write would exceed the server's
+	// memory limit
+	ErrOutOfMemory = &Error{Code: "OOM"}
+
+	// ErrReadOnly is returned by a replica for a write
+	ErrReadOnly = &Error{Code: "READONLY"}
+
+	// ErrTryAgain is returned when the server can't serve the command for
+	// now, such as a Raft group without a leader; it is retried
+	ErrTryAgain = &Error{Code: "TRYAGAIN"}
+
+	// ErrTimeout is returned when a write to a Raft group is not committed
+	// in time, although it may still be applied
+	ErrTimeout = &Error{Code: "TIMEOUT"}
+
+	// ErrClusterDown and ErrCrossSlot are returned in cluster mode when no
+	// node serves a key's slot, or when the keys of a command are in
+	// different slots
+	ErrClusterDown = &Error{Code: "CLUSTERDOWN"}
+	ErrCrossSlot   = &Error{Code: "CROSSSLOT"}
+
+	// ErrMoved, ErrAsk and ErrRedirect are returned when the server sends
+	// the client elsewhere more times in a row than the client follows
+	ErrMoved    = &Error{Code: "MOVED"}
+	ErrAsk      = &Error{Code: "ASK"}
+	ErrRedirect = &Error{Code: "REDIRECT"}
+)
+
+// parseError parses an error reply
+func parseError(s string) *Error {
+	code, msg, _ := strings.Cut(s, " ")
+	return &Error{Code: code, Message: msg}
+}
+
+// connError is a failure of the connection rather than an error reply. A
+// request that failed before it was written can be retried; once sent is
+// set, the server may have run it.
+type connError struct {
+	err  error
+	sent bool
+}
+
+func (e *connError) Error() string { return "vex: connection failed: " + e.err.Error() }
+
+func (e *connError) Unwrap() error { return e.err }
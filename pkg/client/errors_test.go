@@ -0,0 +1,51 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		reply  string
+		target error
+		want   bool
+	}{
+		{"ERR dimension mismatch: expected 3, got 2", ErrDimensionMismatch, true},
+		{"ERR syntax error", ErrDimensionMismatch, false},
+		{"NOPERM User alice has no permissions to run the 'vset' command", ErrNoPerm, true},
+		{"NOPERM User alice has no permissions to run the 'vset' command", ErrNoAuth, false},
+		{"OOM command not allowed when used memory > 'maxmemory'", ErrOutOfMemory, true},
+		{"MOVED 3999 127.0.0.1:7001", ErrMoved, true},
+		{"TRYAGAIN", ErrTryAgain, true},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", parseError(tt.reply))
+		if got := errors.Is(err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%q, %v) = %v, want %v", tt.reply, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	for _, reply := range []string{"ERR syntax error", "TRYAGAIN"} {
+		if got := parseError(reply).Error(); got != reply {
+			t.Errorf("parseError(%q).Error() = %q", reply, got)
+		}
+	}
+}
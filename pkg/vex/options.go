@@ -0,0 +1,133 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vex
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+// Option configures a DB opened with Open
+type Option func(*config) error
+
+// config holds the settings collected from the options
+type config struct {
+	storage       storage.Config
+	maxMemory     int64
+	policy        storage.EvictionPolicy
+	sweepInterval time.Duration
+	logger        *slog.Logger
+	onExpire      func(key string)
+	onEvict       func(key string)
+}
+
+func defaultConfig() config {
+	return config{
+		sweepInterval: 100 * time.Millisecond,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// WithShards sets the number of shards keys are spread across, from 1 to
+// 1024 (default 32). More shards mean less lock contention between writers
+// and more parallelism in searches, at a small cost in memory.
+func WithShards(n int) Option {
+	return func(c *config) error {
+		if n < 1 || n > storage.MaxShards {
+			return fmt.Errorf("vex: shard count must be between 1 and %d, got %d", storage.MaxShards, n)
+		}
+		c.storage.Shards = n
+		return nil
+	}
+}
+
+// WithDimension fixes the vector dimension up front. Without it, the
+// dimension is taken from the first vector stored, and forgotten by Clear.
+func WithDimension(dim int) Option {
+	return func(c *config) error {
+		if dim < 1 {
+			return fmt.Errorf("vex: dimension must be positive, got %d", dim)
+		}
+		c.storage.Dimension = dim
+		return nil
+	}
+}
+
+// WithMaxMemory bounds the memory held by stored vectors to bytes, with
+// policy deciding what happens when a write would exceed it: "noeviction"
+// (the default) rejects the write with ErrOutOfMemory, while
+// "allkeys-lru", "allkeys-lfu", "volatile-ttl" and "allkeys-random" evict
+// keys to make room.
+func WithMaxMemory(bytes int64, policy string) Option {
+	return func(c *config) error {
+		if bytes < 0 {
+			return fmt.Errorf("vex: negative memory limit %d", bytes)
+		}
+		p, err := storage.ParseEvictionPolicy(strings.ToLower(policy))
+		if err != nil {
+			return fmt.Errorf("vex: %w", err)
+		}
+		c.maxMemory, c.policy = bytes, p
+		return nil
+	}
+}
+
+// WithExpirySweep sets how often expired keys are looked for in the
+// background (default 100ms). Expired keys are never returned either way;
+// the sweep only reclaims the memory of keys nobody reads again.
+func WithExpirySweep(interval time.Duration) Option {
+	return func(c *config) error {
+		if interval <= 0 {
+			return fmt.Errorf("vex: sweep interval must be positive, got %v", interval)
+		}
+		c.sweepInterval = interval
+		return nil
+	}
+}
+
+// WithLogger logs the DB's lifecycle at info level, and expired and
+// evicted keys at debug level, to logger. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) error {
+		if logger == nil {
+			return fmt.Errorf("vex: nil logger")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithOnExpire calls fn with each key removed because its TTL passed. fn
+// runs with the key's shard locked, so it must not call into the DB.
+func WithOnExpire(fn func(key string)) Option {
+	return func(c *config) error {
+		c.onExpire = fn
+		return nil
+	}
+}
+
+// WithOnEvict calls fn with each key evicted to stay under the memory
+// limit. Like WithOnExpire's, fn must not call into the DB.
+func WithOnEvict(fn func(key string)) Option {
+	return func(c *config) error {
+		c.onEvict = fn
+		return nil
+	}
+}
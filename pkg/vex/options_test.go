@@ -0,0 +1,105 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestOptionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"no shards", WithShards(0)},
+		{"too many shards", WithShards(1025)},
+		{"zero dimension", WithDimension(0)},
+		{"negative memory", WithMaxMemory(-1, "noeviction")},
+		{"unknown policy", WithMaxMemory(1<<20, "sometimes")},
+		{"zero sweep", WithExpirySweep(0)},
+		{"nil logger", WithLogger(nil)},
+	}
+	for _, tt := range tests {
+		if db, err := Open(tt.opt); err == nil {
+			_ = db.Close()
+			t.Errorf("Open with %s succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestWithDimension(t *testing.T) {
+	db := openTest(t, WithDimension(3))
+
+	if dim, _ := db.Dimension(); dim != 3 {
+		t.Errorf("Dimension = %d, want 3", dim)
+	}
+	if err := db.Set("a", []float32{1, 0}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Set with 2 values = %v, want ErrDimensionMismatch", err)
+	}
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if dim, _ := db.Dimension(); dim != 3 {
+		t.Errorf("Dimension after Clear = %d, want 3", dim)
+	}
+}
+
+func TestWithMaxMemory(t *testing.T) {
+	var evicted []string
+	db := openTest(t, WithMaxMemory(2000, "allkeys-lru"), WithOnEvict(func(key string) {
+		evicted = append(evicted, key)
+	}))
+	for i := 0; i < 100; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), []float32{1, 2, 3, 4}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used, _ := db.MemoryUsage(); used > 2000 {
+		t.Errorf("MemoryUsage = %d, want at most 2000", used)
+	}
+	if len(evicted) == 0 {
+		t.Error("no keys evicted")
+	}
+
+	strict := openTest(t, WithMaxMemory(100, "noeviction"))
+	if err := strict.Set("key", []float32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Set over the limit = %v, want ErrOutOfMemory", err)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db, err := Open(WithLogger(logger), WithShards(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "vex database opened") || !strings.Contains(out, "shards=8") {
+		t.Errorf("log lacks the open message: %s", out)
+	}
+	if !strings.Contains(out, "vex database closed") {
+		t.Errorf("log lacks the close message: %s", out)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vex embeds the Vex vector store in a Go program, without a
+// server or network hop. It runs the same sharded storage engine as
+// vex-server:
+//
+//	db, err := vex.Open(vex.WithDimension(384), vex.WithShards(64))
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//
+//	if err := db.Set("doc:1", embedding); err != nil {
+//		return err
+//	}
+//	results, err := db.Search(query, 10)
+//
+// A DB is safe for concurrent use. Vectors are normalized when stored, so
+// Get returns the unit vector with the direction of the one stored, and
+// searches rank keys by cosine similarity.
+package vex
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/uzqw/vex/internal/storage"
+)
+
+var (
+	// ErrNotFound is returned when the key doesn't exist
+	ErrNotFound = errors.New("vex: key not found")
+
+	// ErrClosed is returned once the DB has been closed
+	ErrClosed = errors.New("vex: database is closed")
+
+	// ErrDimensionMismatch is returned for a vector whose dimension differs
+	// from the stored ones
+	ErrDimensionMismatch = storage.ErrDimensionMismatch
+
+	// ErrOutOfMemory is returned when a write would exceed the memory limit
+	// and the eviction policy doesn't allow making room
+	ErrOutOfMemory = storage.ErrOutOfMemory
+)
+
+// DB is an embedded vector store
+type DB struct {
+	store  *storage.Storage
+	logger *slog.Logger
+	cancel context.CancelFunc
+
+	// mu is held shared by every operation and exclusively by Close, so
+	// nothing runs on a closed DB
+	mu     sync.RWMutex
+	closed bool
+}
+
+// SetOptions holds the optional parts of a write made with SetWithOptions.
+// The zero value writes unconditionally without a TTL.
+type SetOptions struct {
+	// TTL is the time to live of the key, or zero for none
+	TTL time.Duration
+
+	// NX only writes the key if it does not exist; XX only if it does
+	NX, XX bool
+
+	// When CheckVersion is set the key is only written if its version is
+	// Version, with 0 meaning that the key must not exist
+	CheckVersion bool
+	Version      uint64
+}
+
+// SetResult is the outcome of SetWithOptions
+type SetResult struct {
+	// Written is false if a condition in the options was not met
+	Written bool
+
+	// Inserted reports whether the key was created rather than updated
+	Inserted bool
+
+	// Version is the key's version after the call, 0 if it doesn't exist
+	Version uint64
+}
+
+// SearchResult is one match of a search
+type SearchResult struct {
+	Key   string
+	Score float32 // Cosine similarity to the query
+}
+
+// Open creates an empty DB
+func Open(opts ...Option) (*DB, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := storage.NewWithConfig(cfg.storage)
+	if err != nil {
+		return nil, err
+	}
+	store.SetMaxMemory(cfg.maxMemory, cfg.policy)
+
+	logger := cfg.logger
+	store.OnExpire(func(key string) {
+		logger.Debug("key expired", slog.String("key", key))
+		if cfg.onExpire != nil {
+			cfg.onExpire(key)
+		}
+	})
+	store.OnEvict(func(key string) {
+		logger.Debug("key evicted", slog.String("key", key))
+		if cfg.onEvict != nil {
+			cfg.onEvict(key)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store.RunExpirySweeper(ctx, cfg.sweepInterval)
+
+	logger.Info("vex database opened",
+		slog.Int("shards", store.Shards()),
+		slog.Int("dimension", store.Dimension()),
+		slog.Int64("max_memory", cfg.maxMemory),
+		slog.String("policy", cfg.policy.String()))
+	return &DB{store: store, logger: logger, cancel: cancel}, nil
+}
+
+// Close stops the background expiry sweep and releases the stored vectors.
+// Later calls fail with ErrClosed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+	db.cancel()
+	keys := db.store.Count()
+	db.store = nil
+	db.logger.Info("vex database closed", slog.Int("keys", keys))
+	return nil
+}
+
+// use runs fn with the storage unless the DB is closed
+func (db *DB) use(fn func(s *storage.Storage) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return fn(db.store)
+}
+
+// Set stores a vector under key, removing any TTL it had
+func (db *DB) Set(key string, vector []float32) error {
+	return db.use(func(s *storage.Storage) error {
+		_, err := s.Set(key, vector)
+		return err
+	})
+}
+
+// SetWithOptions stores a vector under key with a TTL or conditions
+func (db *DB) SetWithOptions(key string, vector []float32, opts SetOptions) (SetResult, error) {
+	var res storage.SetResult
+	err := db.use(func(s *storage.Storage) error {
+		var err error
+		res, err = s.SetWithOptions(key, vector, storage.SetOptions{
+			TTL:          opts.TTL,
+			NX:           opts.NX,
+			XX:           opts.XX,
+			CheckVersion: opts.CheckVersion,
+			Version:      opts.Version,
+		})
+		return err
+	})
+	return SetResult{Written: res.Written, Inserted: res.Inserted, Version: res.Version}, err
+}
+
+// Get returns a copy of the (normalized) vector stored under key, or
+// ErrNotFound
+func (db *DB) Get(key string) ([]float32, error) {
+	var vector []float32
+	err := db.use(func(s *storage.Storage) error {
+		var ok bool
+		if vector, ok = s.Get(key); !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	// The storage shares its vectors with searches running concurrently
+	return slices.Clone(vector), err
+}
+
+// Delete deletes key, reporting whether it existed
+func (db *DB) Delete(key string) (bool, error) {
+	var deleted bool
+	err := db.use(func(s *storage.Storage) error {
+		deleted = s.Delete(key)
+		return nil
+	})
+	return deleted, err
+}
+
+// Exists reports whether key exists
+func (db *DB) Exists(key string) (bool, error) {
+	var exists bool
+	err := db.use(func(s *storage.Storage) error {
+		exists = s.Exists(key)
+		return nil
+	})
+	return exists, err
+}
+
+// Expire sets a TTL on key; a non-positive ttl deletes it. It returns
+// ErrNotFound if the key doesn't exist.
+func (db *DB) Expire(key string, ttl time.Duration) error {
+	return db.use(func(s *storage.Storage) error {
+		if !s.Expire(key, ttl) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// TTL returns the time key has left to live, negative if it has no TTL.
+// It returns ErrNotFound if the key doesn't exist.
+func (db *DB) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := db.use(func(s *storage.Storage) error {
+		var ok bool
+		if ttl, ok = s.TTL(key); !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// Persist removes the TTL from key, reporting whether it had one. It
+// returns ErrNotFound if the key doesn't exist.
+func (db *DB) Persist(key string) (bool, error) {
+	var persisted bool
+	err := db.use(func(s *storage.Storage) error {
+		if persisted = s.Persist(key); !persisted && !s.Exists(key) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return persisted, err
+}
+
+// Search returns the k keys whose vectors are most similar to query, most
+// similar first
+func (db *DB) Search(query []float32, k int) ([]SearchResult, error) {
+	return db.SearchFiltered(query, k, nil)
+}
+
+// SearchFiltered is like Search but only considers the keys for which
+// filter returns true. filter runs with a shard locked, so it must not call
+// into the DB.
+func (db *DB) SearchFiltered(query []float32, k int, filter func(key string) bool) ([]SearchResult, error) {
+	var results []SearchResult
+	err := db.use(func(s *storage.Storage) error {
+		found, err := s.SearchFiltered(query, k, filter)
+		if err != nil {
+			return err
+		}
+		results = make([]SearchResult, len(found))
+		for i, res := range found {
+			results[i] = SearchResult{Key: res.Key, Score: res.Similarity}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// Scan iterates over the keys a batch at a time, starting from a cursor of
+// 0 and continuing from each returned cursor until it is 0 again. count
+// hints how many keys to examine per call, and match, if not nil, filters
+// the keys returned. A key present for the whole iteration is returned
+// exactly once.
+func (db *DB) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64, error) {
+	var keys []string
+	err := db.use(func(s *storage.Storage) error {
+		keys, cursor = s.Scan(cursor, count, match)
+		return nil
+	})
+	return keys, cursor, err
+}
+
+// Count returns the number of keys
+func (db *DB) Count() (int, error) {
+	var n int
+	err := db.use(func(s *storage.Storage) error {
+		n = s.Count()
+		return nil
+	})
+	return n, err
+}
+
+// Dimension returns the vector dimension, 0 if it isn't set yet
+func (db *DB) Dimension() (int, error) {
+	var dim int
+	err := db.use(func(s *storage.Storage) error {
+		dim = s.Dimension()
+		return nil
+	})
+	return dim, err
+}
+
+// MemoryUsage returns the approximate bytes held by the stored vectors
+func (db *DB) MemoryUsage() (int64, error) {
+	var used int64
+	err := db.use(func(s *storage.Storage) error {
+		used = s.MemoryUsage()
+		return nil
+	})
+	return used, err
+}
+
+// Clear removes every key at once
+func (db *DB) Clear() error {
+	return db.use(func(s *storage.Storage) error {
+		s.Clear()
+		return nil
+	})
+}
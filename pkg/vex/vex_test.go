@@ -0,0 +1,205 @@
+// Copyright 2025 uzqw
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vex
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T, opts ...Option) *DB {
+	db, err := Open(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestSetGetDelete(t *testing.T) {
+	db := openTest(t)
+
+	if err := db.Set("a", []float32{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 0.6 || got[1] != 0.8 {
+		t.Errorf("Get = %v, want the normalized [0.6 0.8]", got)
+	}
+
+	// The vector returned is the caller's to change
+	got[0] = 0
+	if again, _ := db.Get("a"); again[0] != 0.6 {
+		t.Errorf("Get after changing the previous result = %v, want [0.6 0.8]", again)
+	}
+
+	if err := db.Set("b", []float32{1, 2, 3}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Set with another dimension = %v, want ErrDimensionMismatch", err)
+	}
+
+	if ok, err := db.Delete("a"); err != nil || !ok {
+		t.Errorf("Delete = %v, %v, want true", ok, err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if ok, err := db.Delete("a"); err != nil || ok {
+		t.Errorf("Delete of a missing key = %v, %v, want false", ok, err)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	db := openTest(t)
+
+	res, err := db.SetWithOptions("a", []float32{1, 0}, SetOptions{NX: true, TTL: time.Hour})
+	if err != nil || !res.Written || !res.Inserted || res.Version == 0 {
+		t.Fatalf("SetWithOptions NX = %+v, %v", res, err)
+	}
+	if ttl, err := db.TTL("a"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL = %v, %v, want up to an hour", ttl, err)
+	}
+
+	again, err := db.SetWithOptions("a", []float32{0, 1}, SetOptions{NX: true})
+	if err != nil || again.Written {
+		t.Errorf("SetWithOptions NX on an existing key = %+v, %v, want not written", again, err)
+	}
+
+	next, err := db.SetWithOptions("a", []float32{0, 1}, SetOptions{CheckVersion: true, Version: res.Version})
+	if err != nil || !next.Written || next.Version <= res.Version {
+		t.Errorf("SetWithOptions IFVERSION = %+v, %v", next, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		expired []string
+	)
+	db := openTest(t, WithExpirySweep(time.Millisecond), WithOnExpire(func(key string) {
+		mu.Lock()
+		expired = append(expired, key)
+		mu.Unlock()
+	}))
+
+	if err := db.Set("a", []float32{1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("a", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("missing", time.Second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expire of a missing key = %v, want ErrNotFound", err)
+	}
+
+	// The sweeper removes the key without it being read
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(expired)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("key not expired by the sweeper")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n, _ := db.Count(); n != 0 {
+		t.Errorf("Count after expiry = %d, want 0", n)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := openTest(t, WithShards(4))
+	for i := 0; i < 100; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), []float32{1, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := db.Search([]float32{1, 0}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Key != "key0" || results[0].Score < 0.999 {
+		t.Errorf("Search = %+v, want key0 first", results)
+	}
+
+	odd := func(key string) bool { return key[len(key)-1]%2 == 1 }
+	results, err = db.SearchFiltered([]float32{1, 0}, 2, odd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Key != "key1" || results[1].Key != "key3" {
+		t.Errorf("SearchFiltered = %+v, want key1 and key3", results)
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := openTest(t, WithShards(7))
+	for i := 0; i < 50; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), []float32{1, float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		keys, next, err := db.Scan(cursor, 10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("Scan found %d keys, want 50", len(seen))
+	}
+}
+
+func TestClose(t *testing.T) {
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("a", []float32{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Set("a", []float32{1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set after Close = %v, want ErrClosed", err)
+	}
+	if _, err := db.Search([]float32{1}, 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("Search after Close = %v, want ErrClosed", err)
+	}
+	if err := db.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close = %v, want ErrClosed", err)
+	}
+}